@@ -135,6 +135,8 @@ type MountInput struct {
 	Local       bool              `json:"local"`
 	SealWrap    bool              `json:"seal_wrap" mapstructure:"seal_wrap"`
 	Options     map[string]string `json:"options"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	NodeLocal   bool              `json:"node_local,omitempty" mapstructure:"node_local"`
 
 	// Deprecated: Newer server responses should be returning this information in the
 	// Type field (json: "type") instead.
@@ -165,6 +167,8 @@ type MountOutput struct {
 	Options     map[string]string `json:"options"`
 	Local       bool              `json:"local"`
 	SealWrap    bool              `json:"seal_wrap" mapstructure:"seal_wrap"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	NodeLocal   bool              `json:"node_local,omitempty" mapstructure:"node_local"`
 }
 
 type MountConfigOutput struct {
@@ -44,6 +44,13 @@ type LogInput struct {
 	OuterErr            error
 	NonHMACReqDataKeys  []string
 	NonHMACRespDataKeys []string
+
+	// RequestBodyHash is the backend's salted hash of the start of the raw
+	// request body, if request body auditing is enabled; the body itself is
+	// never passed to backends. RequestBodyTruncated is set when the body
+	// was longer than the configured limit and only its start was hashed.
+	RequestBodyHash      string
+	RequestBodyTruncated bool
 }
 
 // BackendConfig contains configuration parameters used in the factory func to
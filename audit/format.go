@@ -15,6 +15,10 @@ import (
 	"github.com/mitchellh/copystructure"
 )
 
+// AuditFormatWriter writes audit entries in a particular format. Each entry
+// is passed to the writer in a single call to Write, so backends whose sink
+// turns every write into a message, such as syslog, can write to it
+// directly.
 type AuditFormatWriter interface {
 	WriteRequest(io.Writer, *AuditRequestEntry) error
 	WriteResponse(io.Writer, *AuditResponseEntry) error
@@ -159,6 +163,9 @@ func (f *AuditFormatter) FormatRequest(ctx context.Context, w io.Writer, config
 		reqEntry.Request.WrapTTL = int(req.WrapInfo.TTL / time.Second)
 	}
 
+	reqEntry.Request.BodyHash = in.RequestBodyHash
+	reqEntry.Request.BodyTruncated = in.RequestBodyTruncated
+
 	if !config.OmitTime {
 		reqEntry.Time = time.Now().UTC().Format(time.RFC3339Nano)
 	}
@@ -365,6 +372,8 @@ func (f *AuditFormatter) FormatResponse(ctx context.Context, w io.Writer, config
 			RemoteAddr:         getRemoteAddr(req),
 			ReplicationCluster: req.ReplicationCluster,
 			Headers:            req.Headers,
+			BodyHash:           in.RequestBodyHash,
+			BodyTruncated:      in.RequestBodyTruncated,
 		},
 
 		Response: AuditResponse{
@@ -419,6 +428,8 @@ type AuditRequest struct {
 	RemoteAddr          string                 `json:"remote_address"`
 	WrapTTL             int                    `json:"wrap_ttl"`
 	Headers             map[string][]string    `json:"headers"`
+	BodyHash            string                 `json:"body_hash,omitempty"`
+	BodyTruncated       bool                   `json:"body_truncated,omitempty"`
 }
 
 type AuditResponse struct {
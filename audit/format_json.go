@@ -1,6 +1,7 @@
 package audit
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -21,15 +22,16 @@ func (f *JSONFormatWriter) WriteRequest(w io.Writer, req *AuditRequestEntry) err
 		return fmt.Errorf("request entry was nil, cannot encode")
 	}
 
-	if len(f.Prefix) > 0 {
-		_, err := w.Write([]byte(f.Prefix))
-		if err != nil {
-			return err
-		}
+	// Write the prefix and the entry together so that each entry is a
+	// single write
+	var buf bytes.Buffer
+	buf.WriteString(f.Prefix)
+	if err := json.NewEncoder(&buf).Encode(req); err != nil {
+		return err
 	}
 
-	enc := json.NewEncoder(w)
-	return enc.Encode(req)
+	_, err := w.Write(buf.Bytes())
+	return err
 }
 
 func (f *JSONFormatWriter) WriteResponse(w io.Writer, resp *AuditResponseEntry) error {
@@ -37,15 +39,16 @@ func (f *JSONFormatWriter) WriteResponse(w io.Writer, resp *AuditResponseEntry)
 		return fmt.Errorf("response entry was nil, cannot encode")
 	}
 
-	if len(f.Prefix) > 0 {
-		_, err := w.Write([]byte(f.Prefix))
-		if err != nil {
-			return err
-		}
+	// Write the prefix and the entry together so that each entry is a
+	// single write
+	var buf bytes.Buffer
+	buf.WriteString(f.Prefix)
+	if err := json.NewEncoder(&buf).Encode(resp); err != nil {
+		return err
 	}
 
-	enc := json.NewEncoder(w)
-	return enc.Encode(resp)
+	_, err := w.Write(buf.Bytes())
+	return err
 }
 
 func (f *JSONFormatWriter) Salt(ctx context.Context) (*salt.Salt, error) {
@@ -141,3 +141,66 @@ func TestFormatJSON_formatRequest(t *testing.T) {
 
 const testFormatJSONReqBasicStrFmt = `{"time":"2015-08-05T13:45:46Z","type":"request","auth":{"client_token":"%s","accessor":"bar","display_name":"testtoken","policies":["root"],"metadata":null,"entity_id":"","token_type":"service"},"request":{"operation":"update","path":"/foo","data":null,"wrap_ttl":60,"remote_address":"127.0.0.1","headers":{"foo":["bar"]}},"error":"this is an error"}
 `
+
+func TestFormatJSON_formatRequestBodyHash(t *testing.T) {
+	salter, err := salt.NewSalt(context.Background(), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	formatter := AuditFormatter{
+		AuditFormatWriter: &JSONFormatWriter{
+			SaltFunc: func(context.Context) (*salt.Salt, error) {
+				return salter, nil
+			},
+		},
+	}
+
+	cases := map[string]struct {
+		Hash      string
+		Truncated bool
+	}{
+		"no body":   {"", false},
+		"body":      {salter.GetIdentifiedHMAC(`{"foo":"bar"}`), false},
+		"truncated": {salter.GetIdentifiedHMAC(`{"foo"`), true},
+	}
+
+	for name, tc := range cases {
+		for _, raw := range []bool{false, true} {
+			in := &LogInput{
+				Request: &logical.Request{
+					Operation: logical.UpdateOperation,
+					Path:      "/foo",
+				},
+				RequestBodyHash:      tc.Hash,
+				RequestBodyTruncated: tc.Truncated,
+			}
+
+			// The hash is recorded as given, in both the request and the
+			// response entry
+			var buf bytes.Buffer
+			if err := formatter.FormatRequest(namespace.RootContext(nil), &buf, FormatterConfig{Raw: raw}, in); err != nil {
+				t.Fatalf("bad: %s\nerr: %s", name, err)
+			}
+			var reqEntry AuditRequestEntry
+			if err := jsonutil.DecodeJSON(buf.Bytes(), &reqEntry); err != nil {
+				t.Fatalf("bad json: %s", err)
+			}
+			if reqEntry.Request.BodyHash != tc.Hash || reqEntry.Request.BodyTruncated != tc.Truncated {
+				t.Fatalf("bad: %s (raw %t)\nbody_hash: %q, body_truncated: %t", name, raw, reqEntry.Request.BodyHash, reqEntry.Request.BodyTruncated)
+			}
+
+			buf.Reset()
+			in.Response = &logical.Response{}
+			if err := formatter.FormatResponse(namespace.RootContext(nil), &buf, FormatterConfig{Raw: raw}, in); err != nil {
+				t.Fatalf("bad: %s\nerr: %s", name, err)
+			}
+			var respEntry AuditResponseEntry
+			if err := jsonutil.DecodeJSON(buf.Bytes(), &respEntry); err != nil {
+				t.Fatalf("bad json: %s", err)
+			}
+			if respEntry.Request.BodyHash != tc.Hash || respEntry.Request.BodyTruncated != tc.Truncated {
+				t.Fatalf("bad: %s (raw %t)\nresponse body_hash: %q, body_truncated: %t", name, raw, respEntry.Request.BodyHash, respEntry.Request.BodyTruncated)
+			}
+		}
+	}
+}
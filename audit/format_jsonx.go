@@ -22,13 +22,6 @@ func (f *JSONxFormatWriter) WriteRequest(w io.Writer, req *AuditRequestEntry) er
 		return fmt.Errorf("request entry was nil, cannot encode")
 	}
 
-	if len(f.Prefix) > 0 {
-		_, err := w.Write([]byte(f.Prefix))
-		if err != nil {
-			return err
-		}
-	}
-
 	jsonBytes, err := json.Marshal(req)
 	if err != nil {
 		return err
@@ -39,7 +32,9 @@ func (f *JSONxFormatWriter) WriteRequest(w io.Writer, req *AuditRequestEntry) er
 		return err
 	}
 
-	_, err = w.Write(xmlBytes)
+	// Write the prefix and the entry together so that each entry is a
+	// single write
+	_, err = w.Write(append([]byte(f.Prefix), xmlBytes...))
 	return err
 }
 
@@ -48,13 +43,6 @@ func (f *JSONxFormatWriter) WriteResponse(w io.Writer, resp *AuditResponseEntry)
 		return fmt.Errorf("response entry was nil, cannot encode")
 	}
 
-	if len(f.Prefix) > 0 {
-		_, err := w.Write([]byte(f.Prefix))
-		if err != nil {
-			return err
-		}
-	}
-
 	jsonBytes, err := json.Marshal(resp)
 	if err != nil {
 		return err
@@ -65,7 +53,9 @@ func (f *JSONxFormatWriter) WriteResponse(w io.Writer, resp *AuditResponseEntry)
 		return err
 	}
 
-	_, err = w.Write(xmlBytes)
+	// Write the prefix and the entry together so that each entry is a
+	// single write
+	_, err = w.Write(append([]byte(f.Prefix), xmlBytes...))
 	return err
 }
 
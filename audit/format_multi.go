@@ -0,0 +1,114 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/hashicorp/vault/helper/salt"
+)
+
+// MultiFormatWriter is an AuditFormatWriter implementation that writes each
+// entry once per underlying writer. The entry has already been hashed by the
+// time it gets here, so every format shares the same salted values. Each
+// format is passed on in its own call to Write, terminated by a newline, so
+// no single write mixes formats.
+type MultiFormatWriter struct {
+	Writers  []AuditFormatWriter
+	SaltFunc func(context.Context) (*salt.Salt, error)
+}
+
+func (f *MultiFormatWriter) WriteRequest(w io.Writer, req *AuditRequestEntry) error {
+	if req == nil {
+		return fmt.Errorf("request entry was nil, cannot encode")
+	}
+
+	return f.write(w, func(fw AuditFormatWriter, buf io.Writer) error {
+		return fw.WriteRequest(buf, req)
+	})
+}
+
+func (f *MultiFormatWriter) WriteResponse(w io.Writer, resp *AuditResponseEntry) error {
+	if resp == nil {
+		return fmt.Errorf("response entry was nil, cannot encode")
+	}
+
+	return f.write(w, func(fw AuditFormatWriter, buf io.Writer) error {
+		return fw.WriteResponse(buf, resp)
+	})
+}
+
+func (f *MultiFormatWriter) Salt(ctx context.Context) (*salt.Salt, error) {
+	return f.SaltFunc(ctx)
+}
+
+func (f *MultiFormatWriter) write(w io.Writer, writeFunc func(AuditFormatWriter, io.Writer) error) error {
+	var buf bytes.Buffer
+	for _, fw := range f.Writers {
+		buf.Reset()
+		if err := writeFunc(fw, &buf); err != nil {
+			return err
+		}
+		if buf.Len() > 0 && buf.Bytes()[buf.Len()-1] != '\n' {
+			buf.WriteByte('\n')
+		}
+
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// EntryWriterFunc is an io.Writer that passes each write on to the function.
+// AuditFormatWriters write each entry, in each format, with a single call to
+// Write, so backends use it to send every entry to their sink on its own.
+type EntryWriterFunc func([]byte) error
+
+func (f EntryWriterFunc) Write(p []byte) (int, error) {
+	if err := f(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// NewAuditFormatWriter returns the format writer for the given format
+// configuration. The format may be a comma-separated list, e.g. "json,jsonx",
+// in which case every entry is written in each of the formats in turn.
+func NewAuditFormatWriter(format, prefix string, saltFunc func(context.Context) (*salt.Salt, error)) (AuditFormatWriter, error) {
+	var writers []AuditFormatWriter
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(format, ",") {
+		name = strings.TrimSpace(name)
+		if seen[name] {
+			return nil, fmt.Errorf("format type %q given more than once", name)
+		}
+		seen[name] = true
+
+		switch name {
+		case "json":
+			writers = append(writers, &JSONFormatWriter{
+				Prefix:   prefix,
+				SaltFunc: saltFunc,
+			})
+		case "jsonx":
+			writers = append(writers, &JSONxFormatWriter{
+				Prefix:   prefix,
+				SaltFunc: saltFunc,
+			})
+		default:
+			return nil, fmt.Errorf("unknown format type %q", name)
+		}
+	}
+
+	if len(writers) == 1 {
+		return writers[0], nil
+	}
+	return &MultiFormatWriter{
+		Writers:  writers,
+		SaltFunc: saltFunc,
+	}, nil
+}
@@ -0,0 +1,69 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/helper/salt"
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestFormatMulti_writePerFormat(t *testing.T) {
+	salter, err := salt.NewSalt(context.Background(), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saltFunc := func(context.Context) (*salt.Salt, error) {
+		return salter, nil
+	}
+
+	prefix := "@cee: "
+	fw, err := NewAuditFormatWriter("json,jsonx", prefix, saltFunc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	formatter := AuditFormatter{
+		AuditFormatWriter: fw,
+	}
+
+	// Record every write separately, as a sink like syslog would
+	var writes []string
+	w := EntryWriterFunc(func(p []byte) error {
+		writes = append(writes, string(p))
+		return nil
+	})
+
+	in := &LogInput{
+		Request: &logical.Request{
+			Operation:   logical.UpdateOperation,
+			Path:        "secret/foo",
+			ClientToken: "sometoken",
+		},
+	}
+	if err := formatter.FormatRequest(namespace.RootContext(nil), w, FormatterConfig{}, in); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(writes) != 2 {
+		t.Fatalf("expected one write per format, got %q", writes)
+	}
+	for _, write := range writes {
+		if !strings.HasPrefix(write, prefix) || !strings.HasSuffix(write, "\n") {
+			t.Fatalf("write is not a single prefixed entry: %q", write)
+		}
+	}
+
+	var entry AuditRequestEntry
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(writes[0], prefix)), &entry); err != nil {
+		t.Fatalf("first write is not a JSON entry: %v", err)
+	}
+	if entry.Request.ClientToken != salter.GetIdentifiedHMAC("sometoken") {
+		t.Fatalf("bad client token in JSON entry: %q", entry.Request.ClientToken)
+	}
+	if !strings.HasPrefix(strings.TrimPrefix(writes[1], prefix), "<json:object") || strings.Contains(writes[1], "{") {
+		t.Fatalf("second write is not a JSONx entry: %q", writes[1])
+	}
+}
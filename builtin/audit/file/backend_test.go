@@ -2,13 +2,16 @@ package file
 
 import (
 	"context"
+	"encoding/json"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/hashicorp/vault/audit"
+	"github.com/hashicorp/vault/helper/namespace"
 	"github.com/hashicorp/vault/helper/salt"
 	"github.com/hashicorp/vault/logical"
 )
@@ -90,3 +93,82 @@ func TestAuditFile_fileModeExisting(t *testing.T) {
 		t.Fatalf("File mode does not match.")
 	}
 }
+
+func TestAuditFile_multipleFormats(t *testing.T) {
+	f, err := ioutil.TempFile("", "test")
+	if err != nil {
+		t.Fatalf("Failure to create test file.")
+	}
+	defer os.Remove(f.Name())
+	f.Close()
+
+	config := map[string]string{
+		"path":   f.Name(),
+		"format": "json,jsonx",
+	}
+
+	b, err := Factory(context.Background(), &audit.BackendConfig{
+		Config:     config,
+		SaltConfig: &salt.Config{},
+		SaltView:   &logical.InmemStorage{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := namespace.ContextWithNamespace(context.Background(), namespace.RootNamespace)
+	err = b.LogRequest(ctx, &audit.LogInput{
+		Request: &logical.Request{
+			Operation:   logical.UpdateOperation,
+			Path:        "secret/foo",
+			ClientToken: "sometoken",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(contents)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected one entry per format, got: %q", contents)
+	}
+
+	hashedToken, err := b.GetHash(context.Background(), "sometoken")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var entry audit.AuditRequestEntry
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("first entry is not JSON: %v", err)
+	}
+	if entry.Request.ClientToken != hashedToken {
+		t.Fatalf("bad client token in JSON entry: %q", entry.Request.ClientToken)
+	}
+	if !strings.HasPrefix(lines[1], "<json:object") {
+		t.Fatalf("second entry is not JSONx: %q", lines[1])
+	}
+	if !strings.Contains(lines[1], hashedToken) {
+		t.Fatalf("JSONx entry doesn't share the hashed client token: %q", lines[1])
+	}
+}
+
+func TestAuditFile_unknownFormat(t *testing.T) {
+	for _, format := range []string{"xml", "json,xml", "json,json"} {
+		_, err := Factory(context.Background(), &audit.BackendConfig{
+			Config: map[string]string{
+				"path":   "discard",
+				"format": format,
+			},
+			SaltConfig: &salt.Config{},
+			SaltView:   &logical.InmemStorage{},
+		})
+		if err == nil {
+			t.Fatalf("expected an error for format %q", format)
+		}
+	}
+}
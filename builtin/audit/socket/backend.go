@@ -1,9 +1,9 @@
 package socket
 
 import (
-	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"net"
 	"strconv"
 	"sync"
@@ -47,12 +47,6 @@ func Factory(ctx context.Context, conf *audit.BackendConfig) (audit.Backend, err
 	if !ok {
 		format = "json"
 	}
-	switch format {
-	case "json", "jsonx":
-	default:
-		return nil, fmt.Errorf("unknown format type %q", format)
-	}
-
 	// Check if hashing of accessor is disabled
 	hmacAccessor := true
 	if hmacAccessorRaw, ok := conf.Config["hmac_accessor"]; ok {
@@ -86,18 +80,11 @@ func Factory(ctx context.Context, conf *audit.BackendConfig) (audit.Backend, err
 		socketType:    socketType,
 	}
 
-	switch format {
-	case "json":
-		b.formatter.AuditFormatWriter = &audit.JSONFormatWriter{
-			Prefix:   conf.Config["prefix"],
-			SaltFunc: b.Salt,
-		}
-	case "jsonx":
-		b.formatter.AuditFormatWriter = &audit.JSONxFormatWriter{
-			Prefix:   conf.Config["prefix"],
-			SaltFunc: b.Salt,
-		}
+	formatWriter, err := audit.NewAuditFormatWriter(format, conf.Config["prefix"], b.Salt)
+	if err != nil {
+		return nil, err
 	}
+	b.formatter.AuditFormatWriter = formatWriter
 
 	return b, nil
 }
@@ -132,49 +119,35 @@ func (b *Backend) GetHash(ctx context.Context, data string) (string, error) {
 }
 
 func (b *Backend) LogRequest(ctx context.Context, in *audit.LogInput) error {
-	var buf bytes.Buffer
-	if err := b.formatter.FormatRequest(ctx, &buf, b.formatConfig, in); err != nil {
-		return err
-	}
-
 	b.Lock()
 	defer b.Unlock()
 
-	err := b.write(ctx, buf.Bytes())
-	if err != nil {
-		rErr := b.reconnect(ctx)
-		if rErr != nil {
-			err = multierror.Append(err, rErr)
-		} else {
-			// Try once more after reconnecting
-			err = b.write(ctx, buf.Bytes())
-		}
-	}
-
-	return err
+	return b.formatter.FormatRequest(ctx, b.entryWriter(ctx), b.formatConfig, in)
 }
 
 func (b *Backend) LogResponse(ctx context.Context, in *audit.LogInput) error {
-	var buf bytes.Buffer
-	if err := b.formatter.FormatResponse(ctx, &buf, b.formatConfig, in); err != nil {
-		return err
-	}
-
 	b.Lock()
 	defer b.Unlock()
 
-	err := b.write(ctx, buf.Bytes())
-	if err != nil {
-		rErr := b.reconnect(ctx)
-		if rErr != nil {
-			err = multierror.Append(err, rErr)
-		} else {
-			// Try once more after reconnecting
-			err = b.write(ctx, buf.Bytes())
-		}
-	}
+	return b.formatter.FormatResponse(ctx, b.entryWriter(ctx), b.formatConfig, in)
+}
 
-	return err
+// entryWriter returns a writer that sends each formatted entry to the socket
+// in a write of its own. The caller must hold the lock.
+func (b *Backend) entryWriter(ctx context.Context) io.Writer {
+	return audit.EntryWriterFunc(func(entry []byte) error {
+		err := b.write(ctx, entry)
+		if err != nil {
+			rErr := b.reconnect(ctx)
+			if rErr != nil {
+				err = multierror.Append(err, rErr)
+			} else {
+				// Try once more after reconnecting
+				err = b.write(ctx, entry)
+			}
+		}
+		return err
+	})
 }
 
 func (b *Backend) write(ctx context.Context, buf []byte) error {
@@ -1,7 +1,6 @@
 package syslog
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 	"strconv"
@@ -37,12 +36,6 @@ func Factory(ctx context.Context, conf *audit.BackendConfig) (audit.Backend, err
 	if !ok {
 		format = "json"
 	}
-	switch format {
-	case "json", "jsonx":
-	default:
-		return nil, fmt.Errorf("unknown format type %q", format)
-	}
-
 	// Check if hashing of accessor is disabled
 	hmacAccessor := true
 	if hmacAccessorRaw, ok := conf.Config["hmac_accessor"]; ok {
@@ -79,18 +72,11 @@ func Factory(ctx context.Context, conf *audit.BackendConfig) (audit.Backend, err
 		},
 	}
 
-	switch format {
-	case "json":
-		b.formatter.AuditFormatWriter = &audit.JSONFormatWriter{
-			Prefix:   conf.Config["prefix"],
-			SaltFunc: b.Salt,
-		}
-	case "jsonx":
-		b.formatter.AuditFormatWriter = &audit.JSONxFormatWriter{
-			Prefix:   conf.Config["prefix"],
-			SaltFunc: b.Salt,
-		}
+	formatWriter, err := audit.NewAuditFormatWriter(format, conf.Config["prefix"], b.Salt)
+	if err != nil {
+		return nil, err
 	}
+	b.formatter.AuditFormatWriter = formatWriter
 
 	return b, nil
 }
@@ -119,24 +105,17 @@ func (b *Backend) GetHash(ctx context.Context, data string) (string, error) {
 }
 
 func (b *Backend) LogRequest(ctx context.Context, in *audit.LogInput) error {
-	var buf bytes.Buffer
-	if err := b.formatter.FormatRequest(ctx, &buf, b.formatConfig, in); err != nil {
-		return err
-	}
-
-	// Write out to syslog
-	_, err := b.logger.Write(buf.Bytes())
-	return err
+	// Write out to syslog, one message per format
+	return b.formatter.FormatRequest(ctx, audit.EntryWriterFunc(b.writeEntry), b.formatConfig, in)
 }
 
 func (b *Backend) LogResponse(ctx context.Context, in *audit.LogInput) error {
-	var buf bytes.Buffer
-	if err := b.formatter.FormatResponse(ctx, &buf, b.formatConfig, in); err != nil {
-		return err
-	}
+	// Write out to syslog, one message per format
+	return b.formatter.FormatResponse(ctx, audit.EntryWriterFunc(b.writeEntry), b.formatConfig, in)
+}
 
-	// Write out to syslog
-	_, err := b.logger.Write(buf.Bytes())
+func (b *Backend) writeEntry(entry []byte) error {
+	_, err := b.logger.Write(entry)
 	return err
 }
 
@@ -542,7 +542,11 @@ func (c *ServerCommand) Run(args []string) int {
 		DisableMlock:              config.DisableMlock,
 		MaxLeaseTTL:               config.MaxLeaseTTL,
 		DefaultLeaseTTL:           config.DefaultLeaseTTL,
+		ManualStepDownSleepPeriod: config.ManualStepDownSleepPeriod,
+		LeaderLookupCacheTTL:      config.LeaderLookupCacheTTL,
+		RequestTimeout:            config.RequestTimeout,
 		ClusterName:               config.ClusterName,
+		NodeID:                    config.NodeID,
 		CacheSize:                 config.CacheSize,
 		PluginDirectory:           config.PluginDirectory,
 		EnableUI:                  config.EnableUI,
@@ -682,7 +686,9 @@ func (c *ServerCommand) Run(args []string) int {
 		if err != nil {
 			// This sucks, as it's a const in the function but not exported in the package
 			if strings.Contains(err.Error(), "missing port in address") {
-				host = u.Host
+				// Hostname strips the brackets from IPv6 literals, which
+				// JoinHostPort adds back below
+				host = u.Hostname()
 				port = "443"
 			} else {
 				c.UI.Error(fmt.Sprintf("Error parsing api address: %v", err))
@@ -1593,10 +1599,9 @@ func (c *ServerCommand) detectRedirect(detect physical.RedirectDetect,
 		return "", err
 	}
 
-	// set [] for ipv6 addresses
-	if strings.Contains(host, ":") && !strings.Contains(host, "]") {
-		host = "[" + host + "]"
-	}
+	// Strip any [] around ipv6 addresses; JoinHostPort adds them back when
+	// building the URL
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
 
 	// Default the port and scheme
 	scheme := "https"
@@ -1635,7 +1640,7 @@ func (c *ServerCommand) detectRedirect(detect physical.RedirectDetect,
 		if err != nil {
 			continue
 		}
-		if hostStr == "127.0.0.1" {
+		if hostStr == "127.0.0.1" || hostStr == "::1" {
 			host = hostStr
 		}
 
@@ -1650,7 +1655,7 @@ func (c *ServerCommand) detectRedirect(detect physical.RedirectDetect,
 	// Build a URL
 	url := &url.URL{
 		Scheme: scheme,
-		Host:   fmt.Sprintf("%s:%d", host, port),
+		Host:   net.JoinHostPort(host, strconv.Itoa(port)),
 	}
 
 	// Return the URL string
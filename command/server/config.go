@@ -48,9 +48,20 @@ type Config struct {
 	DefaultMaxRequestDuration    time.Duration `hcl:"-"`
 	DefaultMaxRequestDurationRaw interface{}   `hcl:"default_max_request_duration"`
 
+	RequestTimeout    time.Duration `hcl:"-"`
+	RequestTimeoutRaw interface{}   `hcl:"request_timeout"`
+
+	ManualStepDownSleepPeriod    time.Duration `hcl:"-"`
+	ManualStepDownSleepPeriodRaw interface{}   `hcl:"manual_step_down_sleep_period"`
+
+	LeaderLookupCacheTTL    time.Duration `hcl:"-"`
+	LeaderLookupCacheTTLRaw interface{}   `hcl:"leader_lookup_cache_ttl"`
+
 	ClusterName         string `hcl:"cluster_name"`
 	ClusterCipherSuites string `hcl:"cluster_cipher_suites"`
 
+	NodeID string `hcl:"node_id"`
+
 	PluginDirectory string `hcl:"plugin_directory"`
 
 	LogLevel string `hcl:"log_level"`
@@ -305,6 +316,21 @@ func (c *Config) Merge(c2 *Config) *Config {
 		result.DefaultMaxRequestDuration = c2.DefaultMaxRequestDuration
 	}
 
+	result.RequestTimeout = c.RequestTimeout
+	if c2.RequestTimeout != 0 {
+		result.RequestTimeout = c2.RequestTimeout
+	}
+
+	result.ManualStepDownSleepPeriod = c.ManualStepDownSleepPeriod
+	if c2.ManualStepDownSleepPeriod != 0 {
+		result.ManualStepDownSleepPeriod = c2.ManualStepDownSleepPeriod
+	}
+
+	result.LeaderLookupCacheTTL = c.LeaderLookupCacheTTL
+	if c2.LeaderLookupCacheTTL != 0 {
+		result.LeaderLookupCacheTTL = c2.LeaderLookupCacheTTL
+	}
+
 	result.LogLevel = c.LogLevel
 	if c2.LogLevel != "" {
 		result.LogLevel = c2.LogLevel
@@ -315,6 +341,11 @@ func (c *Config) Merge(c2 *Config) *Config {
 		result.ClusterName = c2.ClusterName
 	}
 
+	result.NodeID = c.NodeID
+	if c2.NodeID != "" {
+		result.NodeID = c2.NodeID
+	}
+
 	result.ClusterCipherSuites = c.ClusterCipherSuites
 	if c2.ClusterCipherSuites != "" {
 		result.ClusterCipherSuites = c2.ClusterCipherSuites
@@ -412,6 +443,24 @@ func ParseConfig(d string, logger log.Logger) (*Config, error) {
 		}
 	}
 
+	if result.RequestTimeoutRaw != nil {
+		if result.RequestTimeout, err = parseutil.ParseDurationSecond(result.RequestTimeoutRaw); err != nil {
+			return nil, err
+		}
+	}
+
+	if result.ManualStepDownSleepPeriodRaw != nil {
+		if result.ManualStepDownSleepPeriod, err = parseutil.ParseDurationSecond(result.ManualStepDownSleepPeriodRaw); err != nil {
+			return nil, err
+		}
+	}
+
+	if result.LeaderLookupCacheTTLRaw != nil {
+		if result.LeaderLookupCacheTTL, err = parseutil.ParseDurationSecond(result.LeaderLookupCacheTTLRaw); err != nil {
+			return nil, err
+		}
+	}
+
 	if result.EnableUIRaw != nil {
 		if result.EnableUI, err = parseutil.ParseBool(result.EnableUIRaw); err != nil {
 			return nil, err
@@ -11,4 +11,9 @@ const (
 
 	// AuthHeaderName is the name of the header containing the token.
 	AuthHeaderName = "X-Vault-Token"
+
+	// HealthForwardedHeaderName is set on a health check response that a
+	// sealed node got from the active node, so that callers can tell the node
+	// they asked is sealed itself.
+	HealthForwardedHeaderName = "X-Vault-Health-Forwarded"
 )
@@ -2,6 +2,7 @@ package forwarding
 
 import (
 	"bytes"
+	"compress/gzip"
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
@@ -12,10 +13,37 @@ import (
 	"os"
 
 	"github.com/golang/protobuf/proto"
+	uuid "github.com/hashicorp/go-uuid"
 	"github.com/hashicorp/vault/helper/compressutil"
 	"github.com/hashicorp/vault/helper/jsonutil"
 )
 
+// ErrRequestTooLarge is returned by GenerateForwardedRequest when the request
+// body is larger than the max_request_size set in the request context.
+var ErrRequestTooLarge = errors.New("request body exceeds the maximum request size")
+
+// ErrDecompressedTooLarge is returned when a compressed forwarded request or
+// response body would decompress to more than the allowed size.
+var ErrDecompressedTooLarge = errors.New("decompressed body exceeds the maximum size")
+
+// DefaultMaxDecompressedSize bounds decompressed bodies when no tighter limit
+// is given
+const DefaultMaxDecompressedSize = 32 * 1024 * 1024
+
+// CompressionHeaderName marks a forwarded request whose sender negotiated
+// compression: its body, if any, is gzipped and it accepts a gzipped
+// response, which is marked the same way. GenerateForwardedRequest strips it
+// from the original request, so clients can't make either side decompress a
+// body of their choosing.
+const CompressionHeaderName = "X-Vault-Forwarding-Compression"
+
+// IdempotencyKeyHeaderName is the header identifying a forwarded request, so
+// that the receiving node can recognize a retry of a request it has already
+// handled and answer it without handling it again. Only the forwarding node
+// sets it, using SetIdempotencyKey; GenerateForwardedRequest drops any that
+// the client sent.
+const IdempotencyKeyHeaderName = "X-Vault-Forwarding-Idempotency-Key"
+
 type bufCloser struct {
 	*bytes.Buffer
 }
@@ -60,15 +88,19 @@ func GenerateForwardedHTTPRequest(req *http.Request, addr string) (*http.Request
 
 func GenerateForwardedRequest(req *http.Request) (*Request, error) {
 	var reader io.Reader = req.Body
+	var max int64
 	ctx := req.Context()
 	maxRequestSize := ctx.Value("max_request_size")
 	if maxRequestSize != nil {
-		max, ok := maxRequestSize.(int64)
+		var ok bool
+		max, ok = maxRequestSize.(int64)
 		if !ok {
 			return nil, errors.New("could not parse max_request_size from request context")
 		}
 		if max > 0 {
-			reader = io.LimitReader(req.Body, max)
+			// Read one byte past the limit so that oversized bodies are
+			// rejected rather than silently truncated
+			reader = io.LimitReader(req.Body, max+1)
 		}
 	}
 
@@ -76,6 +108,9 @@ func GenerateForwardedRequest(req *http.Request) (*Request, error) {
 	if err != nil {
 		return nil, err
 	}
+	if max > 0 && int64(len(body)) > max {
+		return nil, ErrRequestTooLarge
+	}
 
 	fq := Request{
 		Method:        req.Method,
@@ -101,6 +136,8 @@ func GenerateForwardedRequest(req *http.Request) (*Request, error) {
 			Values: v,
 		}
 	}
+	delete(fq.HeaderEntries, CompressionHeaderName)
+	delete(fq.HeaderEntries, IdempotencyKeyHeaderName)
 
 	if req.TLS != nil && req.TLS.PeerCertificates != nil && len(req.TLS.PeerCertificates) > 0 {
 		fq.PeerCertificates = make([][]byte, len(req.TLS.PeerCertificates))
@@ -109,6 +146,21 @@ func GenerateForwardedRequest(req *http.Request) (*Request, error) {
 		}
 	}
 
+	// If asked, compress the body and tell the other side we can handle a
+	// compressed response
+	if compress, ok := ctx.Value("forwarding_compression").(bool); ok && compress {
+		if len(fq.Body) > 0 {
+			compressed, err := gzipBytes(fq.Body)
+			if err != nil {
+				return nil, err
+			}
+			fq.Body = compressed
+		}
+		fq.HeaderEntries[CompressionHeaderName] = &HeaderEntry{
+			Values: []string{"gzip"},
+		}
+	}
+
 	return &fq, nil
 }
 
@@ -133,20 +185,33 @@ func ParseForwardedHTTPRequest(req *http.Request) (*http.Request, error) {
 		return nil, err
 	}
 
-	return ParseForwardedRequest(fq)
+	return ParseForwardedRequest(fq, 0)
 }
 
-func ParseForwardedRequest(fq *Request) (*http.Request, error) {
+// ParseForwardedRequest turns a forwarded request back into an http.Request.
+// A body compressed by the sender is decompressed, up to maxSize bytes or
+// DefaultMaxDecompressedSize if maxSize is not positive.
+func ParseForwardedRequest(fq *Request, maxSize int64) (*http.Request, error) {
+	body := fq.Body
+	if isCompressed(fq.HeaderEntries) && len(body) > 0 {
+		var err error
+		body, err = gunzipBytes(body, maxSize)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	buf := bufCloser{
-		Buffer: bytes.NewBuffer(fq.Body),
+		Buffer: bytes.NewBuffer(body),
 	}
 
 	ret := &http.Request{
-		Method:     fq.Method,
-		Header:     make(map[string][]string, len(fq.HeaderEntries)),
-		Body:       buf,
-		Host:       fq.Host,
-		RemoteAddr: fq.RemoteAddr,
+		Method:        fq.Method,
+		Header:        make(map[string][]string, len(fq.HeaderEntries)),
+		Body:          buf,
+		ContentLength: int64(len(body)),
+		Host:          fq.Host,
+		RemoteAddr:    fq.RemoteAddr,
 	}
 
 	ret.URL = &url.URL{
@@ -162,6 +227,13 @@ func ParseForwardedRequest(fq *Request) (*http.Request, error) {
 	for k, v := range fq.HeaderEntries {
 		ret.Header[k] = v.Values
 	}
+	// The idempotency key is meant for the receiving node, which reads it
+	// from fq, rather than for whatever handles the request
+	ret.Header.Del(IdempotencyKeyHeaderName)
+	if isCompressed(fq.HeaderEntries) {
+		ret.Header.Del(CompressionHeaderName)
+		ret.Header.Del("Content-Length")
+	}
 
 	if fq.PeerCertificates != nil && len(fq.PeerCertificates) > 0 {
 		ret.TLS = &tls.ConnectionState{
@@ -179,6 +251,118 @@ func ParseForwardedRequest(fq *Request) (*http.Request, error) {
 	return ret, nil
 }
 
+// IdempotencyKey returns the idempotency key of the forwarded request, or an
+// empty string if it has none.
+func IdempotencyKey(fq *Request) string {
+	entry := fq.HeaderEntries[IdempotencyKeyHeaderName]
+	if entry == nil || len(entry.Values) == 0 {
+		return ""
+	}
+	return entry.Values[0]
+}
+
+// SetIdempotencyKey gives the forwarded request a new idempotency key, which
+// retries of it should be sent with unchanged.
+func SetIdempotencyKey(fq *Request) error {
+	key, err := uuid.GenerateUUID()
+	if err != nil {
+		return err
+	}
+	if fq.HeaderEntries == nil {
+		fq.HeaderEntries = make(map[string]*HeaderEntry, 1)
+	}
+	fq.HeaderEntries[IdempotencyKeyHeaderName] = &HeaderEntry{
+		Values: []string{key},
+	}
+	return nil
+}
+
+// AcceptsCompression returns whether the sender of the forwarded request
+// negotiated compression and so is able to handle a gzip-compressed response.
+func AcceptsCompression(fq *Request) bool {
+	return isCompressed(fq.HeaderEntries)
+}
+
+// CompressResponse gzips the body of the response and marks it as such. It is
+// a no-op for empty bodies.
+func CompressResponse(resp *Response) error {
+	if len(resp.Body) == 0 {
+		return nil
+	}
+	compressed, err := gzipBytes(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.HeaderEntries == nil {
+		resp.HeaderEntries = make(map[string]*HeaderEntry, 1)
+	}
+	resp.Body = compressed
+	resp.HeaderEntries[CompressionHeaderName] = &HeaderEntry{
+		Values: []string{"gzip"},
+	}
+	delete(resp.HeaderEntries, "Content-Length")
+	return nil
+}
+
+// DecompressResponse reverses CompressResponse, allowing up to maxSize bytes
+// or DefaultMaxDecompressedSize if maxSize is not positive. Responses that
+// were not compressed are left untouched. It must only be called on responses
+// to requests that negotiated compression.
+func DecompressResponse(resp *Response, maxSize int64) error {
+	if !isCompressed(resp.HeaderEntries) {
+		return nil
+	}
+	body, err := gunzipBytes(resp.Body, maxSize)
+	if err != nil {
+		return err
+	}
+	resp.Body = body
+	delete(resp.HeaderEntries, CompressionHeaderName)
+	return nil
+}
+
+func isCompressed(entries map[string]*HeaderEntry) bool {
+	entry := entries[CompressionHeaderName]
+	return entry != nil && len(entry.Values) == 1 && entry.Values[0] == "gzip"
+}
+
+func gzipBytes(in []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(in); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// gunzipBytes decompresses in, returning ErrDecompressedTooLarge rather than
+// decompressing more than max bytes
+func gunzipBytes(in []byte, max int64) ([]byte, error) {
+	if max <= 0 {
+		max = DefaultMaxDecompressedSize
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(in))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	// Read one byte past the limit so that oversized bodies are rejected
+	// rather than silently truncated
+	out, err := ioutil.ReadAll(io.LimitReader(r, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(out)) > max {
+		return nil, ErrDecompressedTooLarge
+	}
+	return out, nil
+}
+
 type RPCResponseWriter struct {
 	statusCode int
 	header     http.Header
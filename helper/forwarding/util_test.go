@@ -3,9 +3,12 @@ package forwarding
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -13,6 +16,200 @@ func Test_ForwardedRequest_GenerateParse(t *testing.T) {
 	testForwardedRequestGenerateParse(t)
 }
 
+func Test_ForwardedRequest_MaxRequestSize(t *testing.T) {
+	body := []byte(`{ "foo": "bar" }`)
+	for _, tc := range []struct {
+		max int64
+		err error
+	}{
+		{0, nil},
+		{int64(len(body)), nil},
+		{int64(len(body)) - 1, ErrRequestTooLarge},
+	} {
+		req, err := http.NewRequest("PUT", "https://pushit.real.good:9281/snicketysnack", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req = req.WithContext(context.WithValue(req.Context(), "max_request_size", tc.max))
+		freq, err := GenerateForwardedRequest(req)
+		if err != tc.err {
+			t.Fatalf("max %d: expected error %v, got %v", tc.max, tc.err, err)
+		}
+		if err == nil && !bytes.Equal(freq.Body, body) {
+			t.Fatalf("max %d: bad body: %q", tc.max, freq.Body)
+		}
+	}
+}
+
+func Test_ForwardedRequest_Compression(t *testing.T) {
+	body := []byte(`{ "foo": "` + strings.Repeat("bar", 4096) + `" }`)
+	req, err := http.NewRequest("PUT", "https://pushit.real.good:9281/snicketysnack", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = req.WithContext(context.WithValue(req.Context(), "forwarding_compression", true))
+
+	freq, err := GenerateForwardedRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(freq.Body) >= len(body) {
+		t.Fatalf("expected compressed body smaller than %d bytes, got %d", len(body), len(freq.Body))
+	}
+	if !AcceptsCompression(freq) {
+		t.Fatal("expected request to accept compressed responses")
+	}
+
+	parsed, err := ParseForwardedRequest(freq, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := parsed.Header.Get(CompressionHeaderName); v != "" {
+		t.Fatalf("expected compression marker to be removed, got %q", v)
+	}
+	if parsed.ContentLength != int64(len(body)) {
+		t.Fatalf("bad content length %d", parsed.ContentLength)
+	}
+	parsedBody, err := ioutil.ReadAll(parsed.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(parsedBody, body) {
+		t.Fatal("request body did not survive compression")
+	}
+
+	resp := &Response{
+		StatusCode: 200,
+		Body:       body,
+	}
+	if err := CompressResponse(resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Body) >= len(body) {
+		t.Fatalf("expected compressed response smaller than %d bytes, got %d", len(body), len(resp.Body))
+	}
+	if err := DecompressResponse(resp, 0); err != nil {
+		t.Fatal(err)
+	}
+	if resp.HeaderEntries[CompressionHeaderName] != nil {
+		t.Fatal("expected compression marker to be removed from response")
+	}
+	if !bytes.Equal(resp.Body, body) {
+		t.Fatal("response body did not survive compression")
+	}
+}
+
+func Test_ForwardedRequest_CompressionLimits(t *testing.T) {
+	body := []byte(`{ "foo": "` + strings.Repeat("bar", 4096) + `" }`)
+	newRequest := func() *http.Request {
+		req, err := http.NewRequest("PUT", "https://pushit.real.good:9281/snicketysnack", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return req
+	}
+
+	// Decompression stops at the limit
+	req := newRequest()
+	req = req.WithContext(context.WithValue(req.Context(), "forwarding_compression", true))
+	freq, err := GenerateForwardedRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseForwardedRequest(freq, int64(len(body))-1); err != ErrDecompressedTooLarge {
+		t.Fatalf("expected ErrDecompressedTooLarge, got %v", err)
+	}
+	if _, err := ParseForwardedRequest(freq, int64(len(body))); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := &Response{
+		StatusCode: 200,
+		Body:       body,
+	}
+	if err := CompressResponse(resp); err != nil {
+		t.Fatal(err)
+	}
+	if err := DecompressResponse(resp, int64(len(body))-1); err != ErrDecompressedTooLarge {
+		t.Fatalf("expected ErrDecompressedTooLarge, got %v", err)
+	}
+
+	// A client can't mark its own body as compressed
+	compressed, err := gzipBytes(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err = http.NewRequest("PUT", "https://pushit.real.good:9281/snicketysnack", bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(CompressionHeaderName, "gzip")
+	freq, err = GenerateForwardedRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if AcceptsCompression(freq) {
+		t.Fatal("expected client compression marker to be dropped")
+	}
+	parsed, err := ParseForwardedRequest(freq, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsedBody, err := ioutil.ReadAll(parsed.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(parsedBody, compressed) {
+		t.Fatal("expected client body to be passed on as is")
+	}
+}
+
+func Test_ForwardedRequest_IdempotencyKey(t *testing.T) {
+	newRequest := func() *http.Request {
+		req, err := http.NewRequest("PUT", "https://pushit.real.good:9281/snicketysnack", bytes.NewReader([]byte(`{}`)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return req
+	}
+
+	// A key sent by the client is dropped
+	req := newRequest()
+	req.Header.Set(IdempotencyKeyHeaderName, "client-key")
+	freq1, err := GenerateForwardedRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key := IdempotencyKey(freq1); key != "" {
+		t.Fatalf("expected client key to be dropped, got %q", key)
+	}
+
+	// Each forwarded request gets its own key
+	freq2, err := GenerateForwardedRequest(newRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SetIdempotencyKey(freq1); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetIdempotencyKey(freq2); err != nil {
+		t.Fatal(err)
+	}
+	key := IdempotencyKey(freq1)
+	if key == "" || key == IdempotencyKey(freq2) {
+		t.Fatalf("bad idempotency keys: %q, %q", key, IdempotencyKey(freq2))
+	}
+
+	// And it is not passed on to the handler
+	parsed, err := ParseForwardedRequest(freq1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := parsed.Header.Get(IdempotencyKeyHeaderName); v != "" {
+		t.Fatalf("idempotency key left on parsed request: %q", v)
+	}
+}
+
 func Benchmark_ForwardedRequest_GenerateParse_JSON(b *testing.B) {
 	os.Setenv("VAULT_MESSAGE_TYPE", "json")
 	var totalSize int64
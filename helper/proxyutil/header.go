@@ -0,0 +1,282 @@
+package proxyutil
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	sockaddr "github.com/hashicorp/go-sockaddr"
+)
+
+const (
+	// proxyV1MaxHeaderLen is the longest a version 1 header can be, per the
+	// PROXY protocol spec
+	proxyV1MaxHeaderLen = 107
+
+	// proxyV2HeaderLen is the length of the fixed part of a version 2 header
+	proxyV2HeaderLen = 16
+)
+
+var (
+	proxyV1Prefix    = []byte("PROXY ")
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+	// ErrInvalidProxyHeader is returned when a connection starts with what
+	// looks like a PROXY protocol header but it can't be parsed
+	ErrInvalidProxyHeader = errors.New("invalid PROXY protocol header")
+
+	// ErrUnauthorizedProxyHeader is returned when a connection from a peer
+	// that is not an authorized proxy starts with a PROXY protocol header
+	ErrUnauthorizedProxyHeader = errors.New("PROXY protocol header from unauthorized address")
+)
+
+// ReadProxyHeader reads a version 1 or 2 PROXY protocol header from the start
+// of r, if there is one, and returns the source address it carries. It
+// returns a nil address when r doesn't start with a header, or when the
+// header carries no usable address, as for version 2 LOCAL commands.
+func ReadProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	switch first[0] {
+	case proxyV1Prefix[0]:
+		if !hasPrefix(r, proxyV1Prefix) {
+			return nil, nil
+		}
+		return readProxyV1Header(r)
+
+	case proxyV2Signature[0]:
+		if !hasPrefix(r, proxyV2Signature) {
+			return nil, nil
+		}
+		return readProxyV2Header(r)
+	}
+
+	return nil, nil
+}
+
+// startsWithProxyHeader reports whether the buffered input starts with a
+// version 1 or 2 PROXY protocol header, without consuming it
+func startsWithProxyHeader(r *bufio.Reader) bool {
+	first, err := r.Peek(1)
+	if err != nil {
+		return false
+	}
+	switch first[0] {
+	case proxyV1Prefix[0]:
+		return hasPrefix(r, proxyV1Prefix)
+	case proxyV2Signature[0]:
+		return hasPrefix(r, proxyV2Signature)
+	}
+	return false
+}
+
+// hasPrefix reports whether the buffered input starts with prefix, without
+// consuming it
+func hasPrefix(r *bufio.Reader, prefix []byte) bool {
+	buf, _ := r.Peek(len(prefix))
+	return bytes.Equal(buf, prefix)
+}
+
+func readProxyV1Header(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < proxyV1MaxHeaderLen {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, fmt.Errorf("%v: header line not terminated", ErrInvalidProxyHeader)
+	}
+
+	// PROXY <proto> <src addr> <dst addr> <src port> <dst port>
+	parts := strings.Split(string(line[:len(line)-2]), " ")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("%v: malformed header line", ErrInvalidProxyHeader)
+	}
+	switch parts[1] {
+	case "TCP4", "TCP6":
+	case "UNKNOWN":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("%v: unknown protocol %q", ErrInvalidProxyHeader, parts[1])
+	}
+	if len(parts) != 6 {
+		return nil, fmt.Errorf("%v: malformed header line", ErrInvalidProxyHeader)
+	}
+
+	ip := net.ParseIP(parts[2])
+	if ip == nil {
+		return nil, fmt.Errorf("%v: invalid source address %q", ErrInvalidProxyHeader, parts[2])
+	}
+	port, err := strconv.ParseUint(parts[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("%v: invalid source port %q", ErrInvalidProxyHeader, parts[4])
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+func readProxyV2Header(r *bufio.Reader) (net.Addr, error) {
+	var header [proxyV2HeaderLen]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+
+	verCmd, family := header[12], header[13]
+	if verCmd>>4 != 2 {
+		return nil, fmt.Errorf("%v: unsupported version %d", ErrInvalidProxyHeader, verCmd>>4)
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	switch verCmd & 0xf {
+	case 0x0:
+		// LOCAL, e.g. a health check from the proxy itself
+		return nil, nil
+	case 0x1:
+		// PROXY
+	default:
+		return nil, fmt.Errorf("%v: unknown command %d", ErrInvalidProxyHeader, verCmd&0xf)
+	}
+
+	// Only stream transports carry an address that means anything here
+	if family&0xf != 0x1 {
+		return nil, nil
+	}
+
+	var ipLen int
+	switch family >> 4 {
+	case 0x1:
+		ipLen = net.IPv4len
+	case 0x2:
+		ipLen = net.IPv6len
+	default:
+		return nil, nil
+	}
+
+	// Source and destination addresses, followed by their ports
+	if len(payload) < 2*ipLen+4 {
+		return nil, fmt.Errorf("%v: address block too short", ErrInvalidProxyHeader)
+	}
+	ip := make(net.IP, ipLen)
+	copy(ip, payload[:ipLen])
+	port := binary.BigEndian.Uint16(payload[2*ipLen:])
+
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// ProxyProtoConn is a connection that may start with a PROXY protocol header,
+// version 1 or 2. The header is read on the first call to Read or RemoteAddr
+// rather than on accept, so that a slow client can't hold up the accept loop;
+// a deadline set on the connection beforehand applies to reading it.
+// Connections that don't start with a header are passed through unchanged.
+// A header is only honored if the peer is one of the authorized addresses;
+// from anyone else it fails the connection with ErrUnauthorizedProxyHeader,
+// so that clients can't claim to be someone else.
+type ProxyProtoConn struct {
+	net.Conn
+
+	authorizedAddrs []*sockaddr.SockAddrMarshaler
+	reader          *bufio.Reader
+	once            sync.Once
+	srcAddr         net.Addr
+	err             error
+}
+
+// NewProxyProtoConn wraps the given connection, honoring PROXY protocol
+// headers if its peer is one of authorizedAddrs
+func NewProxyProtoConn(conn net.Conn, authorizedAddrs []*sockaddr.SockAddrMarshaler) *ProxyProtoConn {
+	return &ProxyProtoConn{
+		Conn:            conn,
+		authorizedAddrs: authorizedAddrs,
+		reader:          bufio.NewReader(conn),
+	}
+}
+
+func (c *ProxyProtoConn) readHeader() {
+	c.once.Do(func() {
+		if c.authorized() {
+			c.srcAddr, c.err = ReadProxyHeader(c.reader)
+		} else if startsWithProxyHeader(c.reader) {
+			c.err = fmt.Errorf("%v: %s", ErrUnauthorizedProxyHeader, c.Conn.RemoteAddr())
+		}
+		if c.err != nil {
+			c.Conn.Close()
+		}
+	})
+}
+
+// authorized returns whether the peer may send a PROXY protocol header
+func (c *ProxyProtoConn) authorized() bool {
+	sa, err := sockaddr.NewSockAddr(c.Conn.RemoteAddr().String())
+	if err != nil {
+		return false
+	}
+	for _, authorizedAddr := range c.authorizedAddrs {
+		if authorizedAddr.Contains(sa) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *ProxyProtoConn) Read(b []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns the source address from the PROXY protocol header, or
+// the address of the peer if there is none
+func (c *ProxyProtoConn) RemoteAddr() net.Addr {
+	c.readHeader()
+	if c.srcAddr != nil {
+		return c.srcAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// ProxyProtoListener wraps a listener so that the connections it accepts are
+// ProxyProtoConns
+type ProxyProtoListener struct {
+	net.Listener
+
+	authorizedAddrs []*sockaddr.SockAddrMarshaler
+}
+
+// NewProxyProtoListener wraps the given listener, honoring PROXY protocol
+// headers only on connections from authorizedAddrs
+func NewProxyProtoListener(ln net.Listener, authorizedAddrs []*sockaddr.SockAddrMarshaler) *ProxyProtoListener {
+	return &ProxyProtoListener{
+		Listener:        ln,
+		authorizedAddrs: authorizedAddrs,
+	}
+}
+
+func (l *ProxyProtoListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return NewProxyProtoConn(conn, l.authorizedAddrs), nil
+}
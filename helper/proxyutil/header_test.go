@@ -0,0 +1,194 @@
+package proxyutil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	sockaddr "github.com/hashicorp/go-sockaddr"
+)
+
+func proxyV2Header(cmd, family byte, src, dst net.IP, srcPort, dstPort uint16) []byte {
+	var addrs []byte
+	addrs = append(addrs, src...)
+	addrs = append(addrs, dst...)
+	addrs = append(addrs, 0, 0, 0, 0)
+	binary.BigEndian.PutUint16(addrs[len(addrs)-4:], srcPort)
+	binary.BigEndian.PutUint16(addrs[len(addrs)-2:], dstPort)
+
+	header := append([]byte{}, proxyV2Signature...)
+	header = append(header, 0x20|cmd, family, 0, 0)
+	binary.BigEndian.PutUint16(header[14:], uint16(len(addrs)))
+	return append(header, addrs...)
+}
+
+func testAuthorizedAddrs(t *testing.T, addrs ...string) []*sockaddr.SockAddrMarshaler {
+	var out []*sockaddr.SockAddrMarshaler
+	for _, addr := range addrs {
+		sa, err := sockaddr.NewSockAddr(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, &sockaddr.SockAddrMarshaler{SockAddr: sa})
+	}
+	return out
+}
+
+func TestProxyProtoListener(t *testing.T) {
+	cases := map[string]struct {
+		header   []byte
+		expected string
+	}{
+		"v1 tcp4": {
+			[]byte("PROXY TCP4 10.1.2.3 10.0.0.1 51234 8201\r\n"),
+			"10.1.2.3:51234",
+		},
+		"v1 tcp6": {
+			[]byte("PROXY TCP6 2001:db8::1 2001:db8::2 51234 8201\r\n"),
+			"[2001:db8::1]:51234",
+		},
+		"v1 unknown": {
+			[]byte("PROXY UNKNOWN\r\n"),
+			"",
+		},
+		"v2 tcp4": {
+			proxyV2Header(0x1, 0x11, net.ParseIP("10.1.2.3").To4(), net.ParseIP("10.0.0.1").To4(), 51234, 8201),
+			"10.1.2.3:51234",
+		},
+		"v2 tcp6": {
+			proxyV2Header(0x1, 0x21, net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2"), 51234, 8201),
+			"[2001:db8::1]:51234",
+		},
+		"v2 local": {
+			proxyV2Header(0x0, 0x11, net.ParseIP("10.1.2.3").To4(), net.ParseIP("10.0.0.1").To4(), 51234, 8201),
+			"",
+		},
+		"no header": {
+			nil,
+			"",
+		},
+	}
+
+	for name, tc := range cases {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		pln := NewProxyProtoListener(ln, testAuthorizedAddrs(t, "127.0.0.0/8"))
+
+		client, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		payload := []byte("hello")
+		if _, err := client.Write(append(append([]byte{}, tc.header...), payload...)); err != nil {
+			t.Fatal(err)
+		}
+		client.Close()
+
+		conn, err := pln.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		expected := tc.expected
+		if expected == "" {
+			expected = client.LocalAddr().String()
+		}
+		if addr := conn.RemoteAddr().String(); addr != expected {
+			t.Fatalf("%s: bad remote address: expected %q, got %q", name, expected, addr)
+		}
+
+		// The header must not be passed on to the reader
+		data, err := ioutil.ReadAll(conn)
+		if err != nil {
+			t.Fatalf("%s: err: %v", name, err)
+		}
+		if !bytes.Equal(data, payload) {
+			t.Fatalf("%s: bad data: %q", name, data)
+		}
+
+		conn.Close()
+		pln.Close()
+	}
+}
+
+func TestProxyProtoListener_InvalidHeader(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pln := NewProxyProtoListener(ln, testAuthorizedAddrs(t, "127.0.0.0/8"))
+	defer pln.Close()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err := client.Write([]byte("PROXY TCP4 not-an-ip 10.0.0.1 51234 8201\r\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := pln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected error reading past an invalid header")
+	}
+}
+
+func TestProxyProtoListener_UnauthorizedPeer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pln := NewProxyProtoListener(ln, testAuthorizedAddrs(t, "10.0.0.0/8"))
+	defer pln.Close()
+
+	accept := func(data []byte) net.Conn {
+		t.Helper()
+		client, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		client.Close()
+
+		conn, err := pln.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		return conn
+	}
+
+	// A client that isn't an authorized proxy can't claim another address
+	conn := accept([]byte("PROXY TCP4 10.1.2.3 10.0.0.1 51234 8201\r\nhello"))
+	defer conn.Close()
+	if addr := conn.RemoteAddr().String(); addr == "10.1.2.3:51234" {
+		t.Fatal("spoofed source address was honored")
+	}
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected error reading past an unauthorized header")
+	}
+
+	// But is still served without a header
+	conn2 := accept([]byte("hello"))
+	defer conn2.Close()
+	data, err := ioutil.ReadAll(conn2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello" {
+		t.Fatalf("bad data: %q", data)
+	}
+}
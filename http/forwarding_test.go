@@ -582,3 +582,145 @@ func TestHTTP_Forwarding_HelpOperation(t *testing.T) {
 	testHelp(cores[0].Client)
 	testHelp(cores[1].Client)
 }
+
+func TestHTTP_Forwarding_RequestLimiter(t *testing.T) {
+	cluster := vault.NewTestCluster(t, &vault.CoreConfig{
+		RequestLimiter: vault.NewTokenBucketRequestLimiter(0.001, 2),
+	}, &vault.TestClusterOptions{
+		HandlerFunc: Handler,
+	})
+	cluster.Start()
+	defer cluster.Cleanup()
+	cores := cluster.Cores
+
+	vault.TestWaitActive(t, cores[0].Core)
+
+	write := func(client *api.Client, path string) int {
+		req := client.NewRequest("PUT", "/v1/"+path)
+		if err := req.SetJSONBody(map[string]interface{}{"foo": "bar"}); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.RawRequest(req)
+		if resp == nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// Requests made directly to the active node and requests forwarded by a
+	// standby are both limited
+	for _, path := range []string{"secret/active", "secret/forwarded"} {
+		client := cores[0].Client
+		if path == "secret/forwarded" {
+			client = cores[1].Client
+		}
+
+		for i := 0; i < 2; i++ {
+			if code := write(client, path); code != http.StatusNoContent {
+				t.Fatalf("%s: expected request %d to succeed, got status %d", path, i, code)
+			}
+		}
+		if code := write(client, path); code != http.StatusTooManyRequests {
+			t.Fatalf("%s: expected status %d, got %d", path, http.StatusTooManyRequests, code)
+		}
+	}
+}
+
+func TestHTTP_Forwarding_Maintenance(t *testing.T) {
+	cluster := vault.NewTestCluster(t, nil, &vault.TestClusterOptions{
+		HandlerFunc: Handler,
+	})
+	cluster.Start()
+	defer cluster.Cleanup()
+
+	active := cluster.Cores[0]
+	vault.TestWaitActive(t, active.Core)
+	client := cluster.Cores[1].Client
+
+	if _, err := client.Logical().Write("secret/foo", map[string]interface{}{"value": "bar"}); err != nil {
+		t.Fatal(err)
+	}
+
+	active.EnterMaintenance()
+
+	// Writes forwarded by the standby are turned away
+	_, err := client.Logical().Write("secret/foo", map[string]interface{}{"value": "baz"})
+	if err == nil || !strings.Contains(err.Error(), "Code: 503") {
+		t.Fatalf("expected maintenance response, got %v", err)
+	}
+
+	// Reads and health checks are still served
+	secret, err := client.Logical().Read("secret/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret == nil || secret.Data["value"] != "bar" {
+		t.Fatalf("bad: %#v", secret)
+	}
+	if _, err := active.Client.Sys().Health(); err != nil {
+		t.Fatal(err)
+	}
+
+	active.ExitMaintenance()
+	if _, err := client.Logical().Write("secret/foo", map[string]interface{}{"value": "baz"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHTTP_Forwarding_NeverForwardPaths(t *testing.T) {
+	cluster := vault.NewTestCluster(t, &vault.CoreConfig{
+		NeverForwardPaths: []string{"secret/local/*"},
+	}, &vault.TestClusterOptions{
+		HandlerFunc: Handler,
+	})
+	cluster.Start()
+	defer cluster.Cleanup()
+
+	active := cluster.Cores[0]
+	vault.TestWaitActive(t, active.Core)
+	standby := cluster.Cores[1]
+
+	transport := cleanhttp.DefaultTransport()
+	transport.TLSClientConfig = standby.TLSConfig
+	if err := http2.ConfigureTransport(transport); err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	write := func(path string) int {
+		req, err := http.NewRequest("PUT", fmt.Sprintf("https://127.0.0.1:%d/v1/%s", standby.Listeners[0].Address.Port, path),
+			bytes.NewBufferString(`{"value": "bar"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(consts.AuthHeaderName, cluster.RootToken)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// Other paths are forwarded as usual, so the connection is up
+	if code := write("secret/foo"); code != http.StatusNoContent {
+		t.Fatalf("expected the write to be forwarded, got status %d", code)
+	}
+
+	// The standby handles denylisted paths itself, and can't serve a write
+	if code := write("secret/local/foo"); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected the standby to reject the write, got status %d", code)
+	}
+	secret, err := active.Client.Logical().Read("secret/local/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret != nil {
+		t.Fatalf("denylisted write reached the active node: %#v", secret)
+	}
+}
@@ -161,6 +161,9 @@ func wrapGenericHandler(core *vault.Core, h http.Handler, maxRequestSize int64,
 		// by Vault
 		w.Header().Set("Cache-Control", "no-store")
 
+		// Only TestForward may mark a request as a forwarding test
+		r.Header.Del(vault.IntForwardingTestHeaderName)
+
 		// Start with the request context
 		ctx := r.Context()
 		var cancelFunc context.CancelFunc
@@ -491,10 +494,9 @@ func handleRequestForwarding(core *vault.Core, handler http.Handler) http.Handle
 			}
 		}
 
-		// Note: in an HA setup, this call will also ensure that connections to
-		// the leader are set up, as that happens once the advertised cluster
-		// values are read during this function
-		isLeader, leaderAddr, _, err := core.Leader()
+		// This also makes sure the connection to the leader is set up before
+		// forwarding
+		isLeader, leaderAddr, err := core.LeaderForForwarding()
 		if err != nil {
 			if err == vault.ErrHANotEnabled {
 				// Standalone node, serve request normally
@@ -515,6 +517,18 @@ func handleRequestForwarding(core *vault.Core, handler http.Handler) http.Handle
 			return
 		}
 
+		// Some requests must only ever be handled by the node they were sent
+		// to, whichever node is active
+		ns, err := namespace.FromContext(r.Context())
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+		if core.NeverForward(ns.TrimmedPath(r.URL.Path[len("/v1/"):])) {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
 		forwardRequest(core, w, r)
 		return
 	})
@@ -533,14 +547,49 @@ func forwardRequest(core *vault.Core, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Upgrade requests need the connection itself proxied rather than a
+	// single request and response
+	if vault.IsUpgradeRequest(r) {
+		err := core.ForwardUpgradeRequest(w, r)
+		if err == nil {
+			return
+		}
+		if err == vault.ErrForwardingClusterMismatch {
+			// Redirecting would send the client to the wrong cluster as well
+			core.Logger().Error("forward upgrade request error", "error", err)
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if err == vault.ErrCannotForward {
+			core.Logger().Debug("cannot forward upgrade request, falling back")
+		} else {
+			core.Logger().Error("forward upgrade request error", "error", err)
+		}
+		respondStandby(core, w, r.URL)
+		return
+	}
+
 	// Attempt forwarding the request. If we cannot forward -- perhaps it's
 	// been disabled on the active node -- this will return with an
 	// ErrCannotForward and we simply fall back
 	statusCode, header, retBytes, err := core.ForwardRequest(r)
+	if err == vault.ErrForwardedRequestTooLarge {
+		respondError(w, http.StatusRequestEntityTooLarge, err)
+		return
+	}
+	if err == vault.ErrForwardingClusterMismatch {
+		// Redirecting would send the client to the wrong cluster as well
+		core.Logger().Error("forward request error", "error", err)
+		respondError(w, http.StatusInternalServerError, err)
+		return
+	}
 	if err != nil {
-		if err == vault.ErrCannotForward {
+		switch err {
+		case vault.ErrCannotForward:
 			core.Logger().Debug("cannot forward request (possibly disabled on active node), falling back")
-		} else {
+		case vault.ErrForwardingNodeNotActive:
+			core.Logger().Debug("node forwarded to is no longer active, falling back")
+		default:
 			core.Logger().Error("forward request error", "error", err)
 		}
 
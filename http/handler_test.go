@@ -219,6 +219,23 @@ func TestHandler_CacheControlNoStore(t *testing.T) {
 	}
 }
 
+func TestHandler_ForwardingTestHeaderStripped(t *testing.T) {
+	core, _, _ := vault.TestCoreUnsealed(t)
+
+	var got string
+	h := wrapGenericHandler(core, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get(vault.IntForwardingTestHeaderName)
+	}), 0, 0)
+
+	req := httptest.NewRequest("PUT", "/v1/secret/foo", strings.NewReader(`{"foo":"bar"}`))
+	req.Header.Set(vault.IntForwardingTestHeaderName, "true")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got != "" {
+		t.Fatalf("forwarding test header was passed on: %q", got)
+	}
+}
+
 func TestHandler_Accepted(t *testing.T) {
 	core, _, token := vault.TestCoreUnsealed(t)
 	ln, addr := TestServer(t, core)
@@ -1,6 +1,7 @@
 package http
 
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -13,6 +14,7 @@ import (
 
 	"github.com/hashicorp/errwrap"
 	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/helper/consts"
 	"github.com/hashicorp/vault/helper/namespace"
 	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/vault"
@@ -26,6 +28,7 @@ func buildLogicalRequest(core *vault.Core, w http.ResponseWriter, r *http.Reques
 	path := ns.TrimmedPath(r.URL.Path[len("/v1/"):])
 
 	var data map[string]interface{}
+	var capture *bodyCapture
 
 	// Determine the operation
 	var op logical.Operation
@@ -77,6 +80,12 @@ func buildLogicalRequest(core *vault.Core, w http.ResponseWriter, r *http.Reques
 
 	case "POST", "PUT":
 		op = logical.UpdateOperation
+		// Keep the start of the raw body as it is read so that it can be
+		// hashed into the audit log
+		if limit := core.AuditRequestBodyLimit(); limit > 0 && r.Body != nil {
+			capture = &bodyCapture{ReadCloser: r.Body, limit: limit}
+			r.Body = capture
+		}
 		// Parse the request if we can
 		if op == logical.UpdateOperation {
 			err := parseRequest(r, w, &data)
@@ -117,9 +126,17 @@ func buildLogicalRequest(core *vault.Core, w http.ResponseWriter, r *http.Reques
 		if errwrap.Contains(err, logical.ErrPermissionDenied.Error()) {
 			return nil, http.StatusForbidden, nil
 		}
+		// Only reached on a standby for paths it never forwards
+		if errwrap.Contains(err, consts.ErrStandby.Error()) {
+			return nil, http.StatusServiceUnavailable, consts.ErrStandby
+		}
 		return nil, http.StatusBadRequest, errwrap.Wrapf("error performing token check: {{err}}", err)
 	}
 
+	if capture != nil {
+		req.SetRequestBody(capture.buf.Bytes(), capture.truncated)
+	}
+
 	req, err = requestWrapInfo(r, req)
 	if err != nil {
 		return nil, http.StatusBadRequest, errwrap.Wrapf("error parsing X-Vault-Wrap-TTL header: {{err}}", err)
@@ -138,6 +155,29 @@ func buildLogicalRequest(core *vault.Core, w http.ResponseWriter, r *http.Reques
 	return req, 0, nil
 }
 
+// bodyCapture passes a request body through unchanged while keeping a copy of
+// the first limit bytes read from it, so that only a bounded amount of the
+// body is ever held for auditing
+type bodyCapture struct {
+	io.ReadCloser
+	limit     int64
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (b *bodyCapture) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		keep := int64(n)
+		if remaining := b.limit - int64(b.buf.Len()); keep > remaining {
+			keep = remaining
+			b.truncated = true
+		}
+		b.buf.Write(p[:keep])
+	}
+	return n, err
+}
+
 func handleLogical(core *vault.Core) http.Handler {
 	return handleLogicalInternal(core, false)
 }
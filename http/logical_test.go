@@ -316,6 +316,43 @@ func TestLogical_ListSuffix(t *testing.T) {
 	}
 }
 
+func TestLogical_AuditRequestBody(t *testing.T) {
+	body := `{"foo":"bar"}`
+	cases := map[string]struct {
+		limit     int64
+		expected  string
+		truncated bool
+	}{
+		"disabled":  {0, "", false},
+		"full":      {64, body, false},
+		"truncated": {8, body[:8], true},
+	}
+
+	for name, tc := range cases {
+		core, _, rootToken := vault.TestCoreUnsealedWithConfig(t, &vault.CoreConfig{
+			AuditRequestBodyLimit: tc.limit,
+		})
+		req, _ := http.NewRequest("PUT", "http://127.0.0.1:8200/v1/secret/foo", strings.NewReader(body))
+		req = req.WithContext(namespace.RootContext(nil))
+		req.Header.Add(consts.AuthHeaderName, rootToken)
+		lreq, status, err := buildLogicalRequest(core, nil, req)
+		if err != nil {
+			t.Fatalf("%s: err: %v", name, err)
+		}
+		if status != 0 {
+			t.Fatalf("%s: got status %d", name, status)
+		}
+		if lreq.Data["foo"] != "bar" {
+			t.Fatalf("%s: bad data: %#v", name, lreq.Data)
+		}
+
+		captured, truncated := lreq.RequestBody()
+		if string(captured) != tc.expected || truncated != tc.truncated {
+			t.Fatalf("%s: bad: captured %q, truncated %t", name, captured, truncated)
+		}
+	}
+}
+
 func TestLogical_RespondWithStatusCode(t *testing.T) {
 	resp := &logical.Response{
 		Data: map[string]interface{}{
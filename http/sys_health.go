@@ -15,6 +15,12 @@ import (
 
 func handleSysHealth(core *vault.Core) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Report on the cluster rather than this node while sealed, if so
+		// configured
+		if core.Sealed() && forwardSealedHealth(core, w, r) {
+			return
+		}
+
 		switch r.Method {
 		case "GET":
 			handleSysHealthGet(core, w, r)
@@ -26,6 +32,30 @@ func handleSysHealth(core *vault.Core) http.Handler {
 	})
 }
 
+// forwardSealedHealth forwards the health check to the active node and
+// writes its response, marked as forwarded so that it isn't mistaken for
+// this node's own health. It returns false if the check could not be
+// forwarded.
+func forwardSealedHealth(core *vault.Core, w http.ResponseWriter, r *http.Request) bool {
+	statusCode, header, body, err := core.ForwardSealedHealthRequest(r)
+	if err != nil {
+		if err != vault.ErrCannotForward {
+			core.Logger().Debug("failed to forward health check while sealed, answering locally", "error", err)
+		}
+		return false
+	}
+
+	// The connection to the active node was only good for this request
+	header.Del("Connection")
+	for k, v := range header {
+		w.Header()[k] = v
+	}
+	w.Header().Set(consts.HealthForwardedHeaderName, "true")
+	w.WriteHeader(statusCode)
+	w.Write(body)
+	return true
+}
+
 func fetchStatusCode(r *http.Request, field string) (int, bool, bool) {
 	var err error
 	statusCode := http.StatusOK
@@ -152,16 +182,17 @@ func getSysHealth(core *vault.Core, r *http.Request) (int, *HealthResponse, erro
 		code = standbyCode
 	}
 
-	// Fetch the local cluster name and identifier
+	// Fetch the local cluster name and identifier. These are available while
+	// sealed too, once the cluster has been set up.
 	var clusterName, clusterID string
-	if !sealed {
-		cluster, err := core.Cluster(ctx)
-		if err != nil {
-			return http.StatusInternalServerError, nil, err
-		}
-		if cluster == nil {
-			return http.StatusInternalServerError, nil, fmt.Errorf("failed to fetch cluster details")
-		}
+	cluster, err := core.ClusterIdentity(ctx)
+	if err != nil {
+		return http.StatusInternalServerError, nil, err
+	}
+	if cluster == nil && !sealed {
+		return http.StatusInternalServerError, nil, fmt.Errorf("failed to fetch cluster details")
+	}
+	if cluster != nil {
 		clusterName = cluster.Name
 		clusterID = cluster.ID
 	}
@@ -7,7 +7,9 @@ import (
 	"net/url"
 	"reflect"
 	"testing"
+	"time"
 
+	"github.com/hashicorp/vault/api"
 	"github.com/hashicorp/vault/helper/consts"
 	"github.com/hashicorp/vault/vault"
 )
@@ -272,3 +274,60 @@ func TestSysHealth_head(t *testing.T) {
 		}
 	}
 }
+
+func TestSysHealth_forwardedWhileSealed(t *testing.T) {
+	cluster := vault.NewTestCluster(t, &vault.CoreConfig{
+		ForwardSealedHealthRequests: true,
+	}, &vault.TestClusterOptions{
+		HandlerFunc: Handler,
+	})
+	cluster.Start()
+	defer cluster.Cleanup()
+	cores := cluster.Cores
+
+	vault.TestWaitActive(t, cores[0].Core)
+	sealed := cores[0]
+	if err := sealed.Seal(cluster.RootToken); err != nil {
+		t.Fatal(err)
+	}
+
+	// Once another node has taken over, the sealed node answers with that
+	// node's health, marked as forwarded
+	var resp *api.Response
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		var err error
+		resp, err = sealed.Client.RawRequest(sealed.Client.NewRequest("GET", "/v1/sys/health"))
+		if err == nil && resp.Header.Get(consts.HealthForwardedHeaderName) == "true" {
+			break
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("health check was not forwarded: %v", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	var actual map[string]interface{}
+	testResponseStatus(t, resp.Response, 200)
+	testResponseBody(t, resp.Response, &actual)
+	if actual["sealed"] != false || actual["standby"] != false {
+		t.Fatalf("expected the active node's health, got: %#v", actual)
+	}
+
+	// Nodes that aren't sealed answer for themselves
+	for _, core := range cores[1:] {
+		resp, err := core.Client.RawRequest(core.Client.NewRequest("HEAD", "/v1/sys/health"))
+		if resp != nil {
+			resp.Body.Close()
+		}
+		if resp == nil {
+			t.Fatal(err)
+		}
+		if resp.Header.Get(consts.HealthForwardedHeaderName) != "" {
+			t.Fatal("unsealed node marked its health check as forwarded")
+		}
+	}
+}
@@ -138,19 +138,26 @@ func handleSysUnseal(core *vault.Core) http.Handler {
 			}
 		}
 
-		// Attempt the unseal
+		// Attempt the unseal, telling the unseal authorizer where it came from
+		metadata := map[string]string{
+			"remote_addr": r.RemoteAddr,
+		}
 		if core.SealAccess().RecoveryKeySupported() {
-			_, err = core.UnsealWithRecoveryKeys(key)
+			_, err = core.UnsealWithRecoveryKeysAndMetadata(key, metadata)
 		} else {
-			_, err = core.Unseal(key)
+			_, err = core.UnsealWithMetadata(key, metadata)
 		}
 		if err != nil {
 			switch {
+			case errwrap.ContainsType(err, new(vault.ErrUnsealRejected)):
+				respondError(w, http.StatusForbidden, err)
+				return
 			case errwrap.ContainsType(err, new(vault.ErrInvalidKey)):
 			case errwrap.Contains(err, vault.ErrBarrierInvalidKey.Error()):
 			case errwrap.Contains(err, vault.ErrBarrierNotInit.Error()):
 			case errwrap.Contains(err, vault.ErrBarrierSealed.Error()):
 			case errwrap.Contains(err, consts.ErrStandby.Error()):
+			case errwrap.Contains(err, vault.ErrUnsealLockedOut.Error()):
 			default:
 				respondError(w, http.StatusInternalServerError, err)
 				return
@@ -202,18 +209,19 @@ func handleSysSealStatusRaw(core *vault.Core, w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	// Fetch the local cluster name and identifier
+	// Fetch the local cluster name and identifier. These are available while
+	// sealed too, once the cluster has been set up.
 	var clusterName, clusterID string
-	if !sealed {
-		cluster, err := core.Cluster(ctx)
-		if err != nil {
-			respondError(w, http.StatusInternalServerError, err)
-			return
-		}
-		if cluster == nil {
-			respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to fetch cluster details"))
-			return
-		}
+	cluster, err := core.ClusterIdentity(ctx)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if cluster == nil && !sealed {
+		respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to fetch cluster details"))
+		return
+	}
+	if cluster != nil {
 		clusterName = cluster.Name
 		clusterID = cluster.ID
 	}
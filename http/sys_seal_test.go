@@ -58,6 +58,35 @@ func TestSysSealStatus(t *testing.T) {
 	}
 }
 
+func TestSysSealStatus_sealedClusterIdentity(t *testing.T) {
+	core, _, token := vault.TestCoreUnsealed(t)
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+
+	cluster, err := core.ClusterIdentity(namespace.RootContext(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := core.Seal(token); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Get(addr + "/v1/sys/seal-status")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var actual map[string]interface{}
+	testResponseStatus(t, resp, 200)
+	testResponseBody(t, resp, &actual)
+	if actual["sealed"] != true {
+		t.Fatalf("expected sealed status: %#v", actual)
+	}
+	if actual["cluster_name"] != cluster.Name || actual["cluster_id"] != cluster.ID {
+		t.Fatalf("bad cluster identity: %#v", actual)
+	}
+}
+
 func TestSysSealStatus_uninit(t *testing.T) {
 	core := vault.TestCore(t)
 	ln, addr := TestServer(t, core)
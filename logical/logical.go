@@ -47,7 +47,9 @@ type Backend interface {
 	// of the special path, and the value is a list of paths for this type.
 	// This is not a regular expression but is an exact match. If the path
 	// ends in '*' then it is a prefix-based match. The '*' can only appear
-	// at the end.
+	// at the end. A path segment consisting of '+' matches any single
+	// segment, e.g. "foo/+/bar" matches "foo/baz/bar". A path is special if
+	// any of the entries matches it, whatever their order.
 	SpecialPaths() *Paths
 
 	// System provides an interface to access certain system configuration
@@ -157,6 +157,12 @@ type Request struct {
 	// For replication, contains the last WAL on the remote side after handling
 	// the request, used for best-effort avoidance of stale read-after-write
 	lastRemoteWAL uint64
+
+	// The start of the raw request body as read by the HTTP layer, captured
+	// only when request body auditing is enabled, and whether the body was
+	// longer than what was captured
+	requestBody          []byte
+	requestBodyTruncated bool
 }
 
 // Get returns a data field and guards for nil Data
@@ -212,6 +218,17 @@ func (r *Request) SetLastRemoteWAL(last uint64) {
 	r.lastRemoteWAL = last
 }
 
+// RequestBody returns the captured start of the raw request body and whether
+// the body was truncated to fit the capture limit
+func (r *Request) RequestBody() ([]byte, bool) {
+	return r.requestBody, r.requestBodyTruncated
+}
+
+func (r *Request) SetRequestBody(body []byte, truncated bool) {
+	r.requestBody = body
+	r.requestBodyTruncated = truncated
+}
+
 func (r *Request) TokenEntry() *TokenEntry {
 	return r.tokenEntry
 }
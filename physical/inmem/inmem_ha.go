@@ -3,8 +3,11 @@ package inmem
 import (
 	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	log "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/helper/parseutil"
 	"github.com/hashicorp/vault/physical"
 )
 
@@ -14,6 +17,13 @@ type InmemHABackend struct {
 	l      *sync.Mutex
 	cond   *sync.Cond
 	logger log.Logger
+
+	// If set, waiting lock acquisitions poll at this interval instead of
+	// being woken as soon as the lock is released
+	retryInterval time.Duration
+	// If set, held locks are lost once they go this long without renewal
+	ttl              time.Duration
+	failLockRenewals *uint32
 }
 
 type TransactionalInmemHABackend struct {
@@ -22,32 +32,42 @@ type TransactionalInmemHABackend struct {
 }
 
 // NewInmemHA constructs a new in-memory HA backend. This is only for testing.
-func NewInmemHA(_ map[string]string, logger log.Logger) (physical.Backend, error) {
+// The lock_retry_interval and lock_ttl config values behave as they would
+// given to SetLockTiming.
+func NewInmemHA(conf map[string]string, logger log.Logger) (physical.Backend, error) {
 	be, err := NewInmem(nil, logger)
 	if err != nil {
 		return nil, err
 	}
 
 	in := &InmemHABackend{
-		Backend: be,
-		locks:   make(map[string]string),
-		logger:  logger,
-		l:       new(sync.Mutex),
+		Backend:          be,
+		locks:            make(map[string]string),
+		logger:           logger,
+		l:                new(sync.Mutex),
+		failLockRenewals: new(uint32),
+	}
+	if err := in.parseLockTiming(conf); err != nil {
+		return nil, err
 	}
 	in.cond = sync.NewCond(in.l)
 	return in, nil
 }
 
-func NewTransactionalInmemHA(_ map[string]string, logger log.Logger) (physical.Backend, error) {
+func NewTransactionalInmemHA(conf map[string]string, logger log.Logger) (physical.Backend, error) {
 	transInmem, err := NewTransactionalInmem(nil, logger)
 	if err != nil {
 		return nil, err
 	}
 	inmemHA := InmemHABackend{
-		Backend: transInmem,
-		locks:   make(map[string]string),
-		logger:  logger,
-		l:       new(sync.Mutex),
+		Backend:          transInmem,
+		locks:            make(map[string]string),
+		logger:           logger,
+		l:                new(sync.Mutex),
+		failLockRenewals: new(uint32),
+	}
+	if err := inmemHA.parseLockTiming(conf); err != nil {
+		return nil, err
 	}
 
 	in := &TransactionalInmemHABackend{
@@ -58,6 +78,43 @@ func NewTransactionalInmemHA(_ map[string]string, logger log.Logger) (physical.B
 	return in, nil
 }
 
+func (i *InmemHABackend) parseLockTiming(conf map[string]string) error {
+	var err error
+	if raw, ok := conf["lock_retry_interval"]; ok {
+		if i.retryInterval, err = parseutil.ParseDurationSecond(raw); err != nil {
+			return fmt.Errorf("invalid lock_retry_interval: %v", err)
+		}
+	}
+	if raw, ok := conf["lock_ttl"]; ok {
+		if i.ttl, err = parseutil.ParseDurationSecond(raw); err != nil {
+			return fmt.Errorf("invalid lock_ttl: %v", err)
+		}
+	}
+	return nil
+}
+
+// SetLockTiming sets how often waiting lock acquisitions check whether the
+// lock has become free, and how long a held lock survives without renewal.
+// Zero values restore the defaults of waking waiters immediately and never
+// expiring locks.
+func (i *InmemHABackend) SetLockTiming(retryInterval, ttl time.Duration) {
+	i.l.Lock()
+	defer i.l.Unlock()
+	i.retryInterval = retryInterval
+	i.ttl = ttl
+}
+
+// FailLockRenewals makes held locks fail to renew, as if the backend were
+// unreachable. Locks held for longer than the TTL without renewal are lost.
+// This has no effect unless a lock TTL is set.
+func (i *InmemHABackend) FailLockRenewals(fail bool) {
+	var val uint32
+	if fail {
+		val = 1
+	}
+	atomic.StoreUint32(i.failLockRenewals, val)
+}
+
 // LockWith is used for mutual exclusion based on the given key.
 func (i *InmemHABackend) LockWith(key, value string) (physical.Lock, error) {
 	l := &InmemLock{
@@ -104,10 +161,23 @@ func (i *InmemLock) Lock(stopCh <-chan struct{}) (<-chan struct{}, error) {
 	go func() {
 		// Wait to acquire the lock
 		i.in.l.Lock()
-		_, ok := i.in.locks[i.key]
-		for ok {
+		for {
+			if _, ok := i.in.locks[i.key]; !ok {
+				break
+			}
+			if retryInterval := i.in.retryInterval; retryInterval > 0 {
+				// Let go of the backend while waiting, and give up without
+				// taking the lock if asked to stop
+				i.in.l.Unlock()
+				select {
+				case <-time.After(retryInterval):
+				case <-stopCh:
+					return
+				}
+				i.in.l.Lock()
+				continue
+			}
 			i.in.cond.Wait()
-			_, ok = i.in.locks[i.key]
 		}
 		i.in.locks[i.key] = i.value
 		i.in.l.Unlock()
@@ -137,9 +207,58 @@ func (i *InmemLock) Lock(stopCh <-chan struct{}) (<-chan struct{}, error) {
 	// Create the leader channel
 	i.held = true
 	i.leaderCh = make(chan struct{})
+	i.in.l.Lock()
+	ttl := i.in.ttl
+	i.in.l.Unlock()
+	if ttl > 0 {
+		go i.renew(i.leaderCh, ttl)
+	}
 	return i.leaderCh, nil
 }
 
+// renew keeps the lock alive until it is unlocked, giving it up if renewals
+// fail for longer than the TTL
+func (i *InmemLock) renew(leaderCh chan struct{}, ttl time.Duration) {
+	ticker := time.NewTicker(ttl / 4)
+	defer ticker.Stop()
+
+	lastRenewed := time.Now()
+	for {
+		select {
+		case <-leaderCh:
+			return
+		case <-ticker.C:
+		}
+
+		if atomic.LoadUint32(i.in.failLockRenewals) == 0 {
+			lastRenewed = time.Now()
+			continue
+		}
+		if time.Since(lastRenewed) < ttl {
+			continue
+		}
+
+		if i.in.logger != nil {
+			i.in.logger.Warn("lock expired without renewal", "key", i.key)
+		}
+
+		i.l.Lock()
+		defer i.l.Unlock()
+		if !i.held || i.leaderCh != leaderCh {
+			return
+		}
+		close(i.leaderCh)
+		i.leaderCh = nil
+		i.held = false
+
+		i.in.l.Lock()
+		delete(i.in.locks, i.key)
+		i.in.l.Unlock()
+		i.in.cond.Broadcast()
+		return
+	}
+}
+
 func (i *InmemLock) Unlock() error {
 	i.l.Lock()
 	defer i.l.Unlock()
@@ -1,7 +1,9 @@
 package inmem
 
 import (
+	"sync"
 	"testing"
+	"time"
 
 	log "github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/vault/helper/logging"
@@ -19,3 +21,173 @@ func TestInmemHA(t *testing.T) {
 	// Use the same inmem backend to acquire the same set of locks
 	physical.ExerciseHABackend(t, inm.(physical.HABackend), inm.(physical.HABackend))
 }
+
+func TestInmemHA_LockTTL(t *testing.T) {
+	logger := logging.NewVaultLogger(log.Debug)
+
+	inm, err := NewInmemHA(map[string]string{
+		"lock_ttl": "100ms",
+	}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ha := inm.(*InmemHABackend)
+
+	lock, err := ha.LockWith("foo", "bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaderCh, err := lock.Lock(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Renewals are succeeding, so the lock outlives its TTL
+	select {
+	case <-leaderCh:
+		t.Fatal("lock lost while renewals were succeeding")
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	ha.FailLockRenewals(true)
+	select {
+	case <-leaderCh:
+	case <-time.After(2 * time.Second):
+		t.Fatal("lock not lost after renewals started failing")
+	}
+
+	held, _, err := lock.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if held {
+		t.Fatal("expired lock should not be held")
+	}
+
+	// Someone else can now take the lock
+	ha.FailLockRenewals(false)
+	lock2, err := ha.LockWith("foo", "baz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lock2.Lock(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := lock2.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInmemHA_LockRetryInterval_Churn(t *testing.T) {
+	logger := logging.NewVaultLogger(log.Debug)
+
+	// churn counts how often the lock changes hands between two nodes that
+	// each keep briefly dropping and retaking it while they hold it, as a
+	// node on a flaky backend would
+	churn := func(retryInterval time.Duration) int {
+		inm, err := NewInmemHA(nil, logger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ha := inm.(*InmemHABackend)
+		ha.SetLockTiming(retryInterval, 0)
+
+		var l sync.Mutex
+		var owner string
+		var changes int
+
+		stopCh := make(chan struct{})
+		var wg sync.WaitGroup
+		for _, name := range []string{"node1", "node2"} {
+			wg.Add(1)
+			go func(name string) {
+				defer wg.Done()
+				for {
+					lock, err := ha.LockWith("foo", name)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					leaderCh, err := lock.Lock(stopCh)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					if leaderCh == nil {
+						return
+					}
+
+					l.Lock()
+					if owner != "" && owner != name {
+						changes++
+					}
+					owner = name
+					l.Unlock()
+
+					time.Sleep(2 * time.Millisecond)
+					lock.Unlock()
+
+					// Give the other node a chance to take over. This is
+					// shorter than the time the lock is held, so that the
+					// nodes can't fall into step and take turns without ever
+					// waiting on each other.
+					time.Sleep(time.Millisecond)
+				}
+			}(name)
+		}
+
+		time.Sleep(500 * time.Millisecond)
+		close(stopCh)
+		wg.Wait()
+		return changes
+	}
+
+	fast := churn(0)
+	slow := churn(200 * time.Millisecond)
+	t.Logf("leadership changes: %d without retry interval, %d with", fast, slow)
+	if slow >= fast {
+		t.Fatalf("expected a longer retry interval to reduce churn, got %d changes vs %d", slow, fast)
+	}
+}
+
+func TestInmemHA_LockRetryInterval_Stop(t *testing.T) {
+	inm, err := NewInmemHA(nil, logging.NewVaultLogger(log.Debug))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ha := inm.(*InmemHABackend)
+	ha.SetLockTiming(time.Hour, 0)
+
+	lock1, err := ha.LockWith("foo", "node1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lock1.Lock(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// A waiting acquisition gives up promptly when stopped, rather than at
+	// its next retry
+	lock2, err := ha.LockWith("foo", "node2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stopCh := make(chan struct{})
+	time.AfterFunc(50*time.Millisecond, func() { close(stopCh) })
+	leaderCh, err := lock2.Lock(stopCh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaderCh != nil {
+		t.Fatal("expected lock not to be acquired")
+	}
+
+	// And doesn't take the lock once it is released
+	if err := lock1.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if held, _, err := lock2.Value(); err != nil || held {
+		t.Fatalf("expected lock to be free, held %v, err %v", held, err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"strings"
 	"sync"
+	"time"
 
 	log "github.com/hashicorp/go-hclog"
 )
@@ -101,6 +102,13 @@ type ServiceDiscovery interface {
 	RunServiceDiscovery(waitGroup *sync.WaitGroup, shutdownCh ShutdownChannel, redirectAddr string, activeFunc ActiveFunction, sealedFunc SealedFunction, perfStandbyFunc PerformanceStandbyFunction) error
 }
 
+// LockTimingSetter is an optional interface that an HABackend can implement
+// so that the core can tune how often lock acquisition is retried and how
+// long a held lock survives without being renewed.
+type LockTimingSetter interface {
+	SetLockTiming(retryInterval, ttl time.Duration)
+}
+
 type Lock interface {
 	// Lock is used to acquire the given lock
 	// The stopCh is optional and if closed should interrupt the lock
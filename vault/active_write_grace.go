@@ -0,0 +1,91 @@
+package vault
+
+import (
+	"errors"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+// ErrActiveWriteGrace is returned for requests that would change data while a
+// newly active node is still within its active write grace period
+var ErrActiveWriteGrace = errors.New("Vault has just become active and is not accepting writes yet, please retry")
+
+// startActiveWriteGrace keeps writes from being accepted until both the
+// active write grace period has passed and restoreDoneCh is closed, so that
+// work started in the background on becoming active, such as restoring
+// leases, is done first. A nil restoreDoneCh counts as closed. It is a no-op
+// if no grace period is configured. It is assumed that the state lock is held
+// while this is run.
+func (c *Core) startActiveWriteGrace(restoreDoneCh <-chan struct{}) {
+	if c.activeWriteGracePeriod == 0 {
+		return
+	}
+
+	c.activeWriteGraceLock.Lock()
+	defer c.activeWriteGraceLock.Unlock()
+
+	if c.activeWriteGraceStopCh != nil {
+		close(c.activeWriteGraceStopCh)
+	}
+	stopCh := make(chan struct{})
+	c.activeWriteGraceStopCh = stopCh
+	atomic.StoreUint32(c.inActiveWriteGrace, 1)
+
+	timerCh := make(chan struct{})
+	timer := c.clock.AfterFunc(c.activeWriteGracePeriod, func() {
+		close(timerCh)
+	})
+	if restoreDoneCh == nil {
+		restoreDoneCh = timerCh
+	}
+
+	go func() {
+		defer timer.Stop()
+		for _, ch := range []<-chan struct{}{timerCh, restoreDoneCh} {
+			select {
+			case <-ch:
+			case <-stopCh:
+				return
+			}
+		}
+
+		c.activeWriteGraceLock.Lock()
+		defer c.activeWriteGraceLock.Unlock()
+
+		// A grace period from a previous term as active node that ended
+		// late must not cut the current one short
+		if c.activeWriteGraceStopCh != stopCh {
+			return
+		}
+		c.activeWriteGraceStopCh = nil
+		atomic.StoreUint32(c.inActiveWriteGrace, 0)
+		c.logger.Info("active write grace period over, accepting writes")
+	}()
+
+	c.logger.Info("deferring writes until the active write grace period is over and leases are restored", "grace_period", c.activeWriteGracePeriod)
+}
+
+// stopActiveWriteGrace ends any active write grace period early. It is
+// called on giving up active duty.
+func (c *Core) stopActiveWriteGrace() {
+	c.activeWriteGraceLock.Lock()
+	defer c.activeWriteGraceLock.Unlock()
+
+	if c.activeWriteGraceStopCh != nil {
+		close(c.activeWriteGraceStopCh)
+		c.activeWriteGraceStopCh = nil
+	}
+	atomic.StoreUint32(c.inActiveWriteGrace, 0)
+}
+
+// deferredForActiveWriteGrace returns the error to reply to req with if it
+// must be turned away because the active write grace period isn't over yet,
+// or nil. The error carries a 503 so that clients retry.
+func (c *Core) deferredForActiveWriteGrace(req *logical.Request) error {
+	if atomic.LoadUint32(c.inActiveWriteGrace) == 0 || readOnlyOperation(req.Operation) {
+		return nil
+	}
+	return logical.CodedError(http.StatusServiceUnavailable, ErrActiveWriteGrace.Error())
+}
@@ -0,0 +1,93 @@
+package vault
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestCore_ActiveWriteGracePeriod(t *testing.T) {
+	clock := newTestClock(time.Now())
+	c, _, root := TestCoreUnsealedWithConfig(t, &CoreConfig{
+		Clock:                  clock,
+		ActiveWriteGracePeriod: time.Minute,
+	})
+	ctx := namespace.RootContext(nil)
+
+	write := func() error {
+		req := &logical.Request{
+			Operation:   logical.UpdateOperation,
+			Path:        "secret/foo",
+			Data:        map[string]interface{}{"value": "bar"},
+			ClientToken: root,
+		}
+		_, err := c.HandleRequest(ctx, req)
+		return err
+	}
+
+	// Writes are deferred during the grace period
+	err := write()
+	coded, ok := err.(logical.HTTPCodedError)
+	if !ok || coded.Code() != http.StatusServiceUnavailable || coded.Error() != ErrActiveWriteGrace.Error() {
+		t.Fatalf("expected active write grace error, got %v", err)
+	}
+
+	// Reads are still served
+	req := &logical.Request{
+		Operation:   logical.ReadOperation,
+		Path:        "secret/foo",
+		ClientToken: root,
+	}
+	if _, err := c.HandleRequest(ctx, req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	clock.Advance(30 * time.Second)
+	if err := write(); err == nil {
+		t.Fatal("expected write to still be deferred")
+	}
+
+	// Once the grace period is over, writes are accepted
+	clock.Advance(30 * time.Second)
+	waitForWrite := func() {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			err := write()
+			if err == nil {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("err: %v", err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitForWrite()
+
+	// While leases are still being restored, writes stay deferred past the
+	// grace period
+	restoreDoneCh := make(chan struct{})
+	c.startActiveWriteGrace(restoreDoneCh)
+	clock.Advance(time.Minute)
+	time.Sleep(50 * time.Millisecond)
+	if err := write(); err == nil {
+		t.Fatal("expected write to be deferred until leases are restored")
+	}
+	close(restoreDoneCh)
+	waitForWrite()
+
+	// Restore finishing first doesn't end the grace period early either
+	restoreDoneCh = make(chan struct{})
+	c.startActiveWriteGrace(restoreDoneCh)
+	close(restoreDoneCh)
+	time.Sleep(50 * time.Millisecond)
+	if err := write(); err == nil {
+		t.Fatal("expected write to be deferred until the grace period is over")
+	}
+	clock.Advance(time.Minute)
+	waitForWrite()
+}
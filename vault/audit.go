@@ -5,10 +5,13 @@ import (
 	"crypto/sha256"
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
 
+	"github.com/hashicorp/errwrap"
 	"github.com/hashicorp/go-uuid"
 	"github.com/hashicorp/vault/audit"
+	"github.com/hashicorp/vault/helper/consts"
 	"github.com/hashicorp/vault/helper/jsonutil"
 	"github.com/hashicorp/vault/helper/namespace"
 	"github.com/hashicorp/vault/helper/salt"
@@ -179,6 +182,44 @@ func (c *Core) disableAudit(ctx context.Context, path string, updateStorage bool
 	return true, nil
 }
 
+// EnableAudit enables an audit device of the given type at path on a running
+// core, using the factory registered for that type. The device is recorded in
+// the persisted audit table, so standbys pick it up on their next postUnseal.
+func (c *Core) EnableAudit(ctx context.Context, path, auditType string, options map[string]string) error {
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+	if c.Sealed() {
+		return consts.ErrSealed
+	}
+	if c.standby {
+		return consts.ErrStandby
+	}
+
+	entry := &MountEntry{
+		Table:   auditTableType,
+		Path:    path,
+		Type:    auditType,
+		Options: options,
+	}
+	return c.enableAudit(namespace.RootContext(ctx), entry, true)
+}
+
+// DisableAudit disables the audit device mounted at path on a running core and
+// removes it from the persisted audit table.
+func (c *Core) DisableAudit(ctx context.Context, path string) error {
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+	if c.Sealed() {
+		return consts.ErrSealed
+	}
+	if c.standby {
+		return consts.ErrStandby
+	}
+
+	_, err := c.disableAudit(namespace.RootContext(ctx), path, true)
+	return err
+}
+
 // loadAudits is invoked as part of postUnseal to load the audit table
 func (c *Core) loadAudits(ctx context.Context) error {
 	auditTable := &MountTable{}
@@ -493,6 +534,84 @@ func (c *Core) newAuditBackend(ctx context.Context, entry *MountEntry, view logi
 	return be, err
 }
 
+// AuditDeviceInfo describes an enabled audit device as recorded in the
+// persisted audit table.
+type AuditDeviceInfo struct {
+	Path        string
+	Type        string
+	Description string
+	Local       bool
+	Options     map[string]string
+}
+
+// sensitiveAuditOptionKeys are substrings of option names whose values are
+// redacted by ListAudit.
+var sensitiveAuditOptionKeys = []string{"password", "secret", "token"}
+
+// ListAudit returns the audit devices recorded in the persisted audit table,
+// sorted by path. Option values that look like credentials are redacted.
+// Since the table is read from storage this also works on standbys, as long
+// as the barrier is unsealed.
+func (c *Core) ListAudit(ctx context.Context) ([]*AuditDeviceInfo, error) {
+	if c.Sealed() {
+		return nil, consts.ErrSealed
+	}
+
+	var entries []*MountEntry
+	for _, path := range []string{coreAuditConfigPath, coreLocalAuditConfigPath} {
+		raw, err := c.barrier.Get(ctx, path)
+		if err != nil {
+			return nil, errwrap.Wrapf(fmt.Sprintf("failed to read audit table at %q: {{err}}", path), err)
+		}
+		if raw == nil {
+			continue
+		}
+
+		table := &MountTable{}
+		if err := jsonutil.DecodeJSON(raw.Value, table); err != nil {
+			return nil, errwrap.Wrapf(fmt.Sprintf("failed to decode audit table at %q: {{err}}", path), err)
+		}
+		entries = append(entries, table.Entries...)
+	}
+
+	devices := make([]*AuditDeviceInfo, 0, len(entries))
+	for _, entry := range entries {
+		devices = append(devices, &AuditDeviceInfo{
+			Path:        entry.Path,
+			Type:        entry.Type,
+			Description: entry.Description,
+			Local:       entry.Local,
+			Options:     sanitizeAuditOptions(entry.Options),
+		})
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].Path < devices[j].Path
+	})
+
+	return devices, nil
+}
+
+// sanitizeAuditOptions returns a copy of the given audit options with
+// credential-like values redacted.
+func sanitizeAuditOptions(options map[string]string) map[string]string {
+	if options == nil {
+		return nil
+	}
+
+	sanitized := make(map[string]string, len(options))
+	for k, v := range options {
+		lower := strings.ToLower(k)
+		for _, sensitive := range sensitiveAuditOptionKeys {
+			if strings.Contains(lower, sensitive) {
+				v = "<redacted>"
+				break
+			}
+		}
+		sanitized[k] = v
+	}
+	return sanitized
+}
+
 // defaultAuditTable creates a default audit table
 func defaultAuditTable() *MountTable {
 	table := &MountTable{
@@ -10,6 +10,7 @@ import (
 	log "github.com/hashicorp/go-hclog"
 	multierror "github.com/hashicorp/go-multierror"
 	"github.com/hashicorp/vault/audit"
+	"github.com/hashicorp/vault/logical"
 )
 
 type backendEntry struct {
@@ -119,6 +120,9 @@ func (a *AuditBroker) LogRequest(ctx context.Context, in *audit.LogInput, header
 		in.Request.Headers = headers
 	}()
 
+	body, bodyTruncated, restoreBody := takeAuditRequestBody(in.Request)
+	defer restoreBody()
+
 	// Ensure at least one backend logs
 	anyLogged := false
 	for name, be := range a.backends {
@@ -130,6 +134,11 @@ func (a *AuditBroker) LogRequest(ctx context.Context, in *audit.LogInput, header
 		}
 		in.Request.Headers = transHeaders
 
+		if err := setAuditRequestBodyHash(ctx, be.backend, in, body, bodyTruncated); err != nil {
+			a.logger.Error("backend failed to hash request body", "backend", name, "error", err)
+			continue
+		}
+
 		start := time.Now()
 		lrErr := be.backend.LogRequest(ctx, in)
 		metrics.MeasureSince([]string{"audit", name, "log_request"}, start)
@@ -175,6 +184,9 @@ func (a *AuditBroker) LogResponse(ctx context.Context, in *audit.LogInput, heade
 		in.Request.Headers = headers
 	}()
 
+	body, bodyTruncated, restoreBody := takeAuditRequestBody(in.Request)
+	defer restoreBody()
+
 	// Ensure at least one backend logs
 	anyLogged := false
 	for name, be := range a.backends {
@@ -186,6 +198,11 @@ func (a *AuditBroker) LogResponse(ctx context.Context, in *audit.LogInput, heade
 		}
 		in.Request.Headers = transHeaders
 
+		if err := setAuditRequestBodyHash(ctx, be.backend, in, body, bodyTruncated); err != nil {
+			a.logger.Error("backend failed to hash request body", "backend", name, "error", err)
+			continue
+		}
+
 		start := time.Now()
 		lrErr := be.backend.LogResponse(ctx, in)
 		metrics.MeasureSince([]string{"audit", name, "log_response"}, start)
@@ -211,3 +228,31 @@ func (a *AuditBroker) Invalidate(ctx context.Context, key string) {
 		be.backend.Invalidate(ctx)
 	}
 }
+
+// takeAuditRequestBody removes the start of the raw request body captured by
+// the HTTP layer from req, so that audit backends never see it, and returns
+// it along with a func that puts it back. A nil body that isn't truncated
+// means none was captured.
+func takeAuditRequestBody(req *logical.Request) ([]byte, bool, func()) {
+	body, truncated := req.RequestBody()
+	req.SetRequestBody(nil, false)
+	return body, truncated, func() {
+		req.SetRequestBody(body, truncated)
+	}
+}
+
+// setAuditRequestBodyHash sets in.RequestBodyHash to the backend's salted
+// hash of the captured request body, if any
+func setAuditRequestBodyHash(ctx context.Context, backend audit.Backend, in *audit.LogInput, body []byte, truncated bool) error {
+	in.RequestBodyHash, in.RequestBodyTruncated = "", false
+	if body == nil && !truncated {
+		return nil
+	}
+
+	hash, err := backend.GetHash(ctx, string(body))
+	if err != nil {
+		return err
+	}
+	in.RequestBodyHash, in.RequestBodyTruncated = hash, truncated
+	return nil
+}
@@ -15,6 +15,7 @@ import (
 	log "github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/go-uuid"
 	"github.com/hashicorp/vault/audit"
+	"github.com/hashicorp/vault/helper/consts"
 	"github.com/hashicorp/vault/helper/jsonutil"
 	"github.com/hashicorp/vault/helper/logging"
 	"github.com/hashicorp/vault/helper/namespace"
@@ -31,6 +32,8 @@ type NoopAudit struct {
 	ReqHeaders     []map[string][]string
 	ReqNonHMACKeys []string
 	ReqErrs        []error
+	ReqBodyHashes  []string
+	ReqBodies      [][]byte
 
 	RespErr            error
 	RespAuth           []*logical.Auth
@@ -39,6 +42,7 @@ type NoopAudit struct {
 	RespNonHMACKeys    []string
 	RespReqNonHMACKeys []string
 	RespErrs           []error
+	RespBodyHashes     []string
 
 	salt      *salt.Salt
 	saltMutex sync.RWMutex
@@ -50,6 +54,9 @@ func (n *NoopAudit) LogRequest(ctx context.Context, in *audit.LogInput) error {
 	n.ReqHeaders = append(n.ReqHeaders, in.Request.Headers)
 	n.ReqNonHMACKeys = in.NonHMACReqDataKeys
 	n.ReqErrs = append(n.ReqErrs, in.OuterErr)
+	n.ReqBodyHashes = append(n.ReqBodyHashes, in.RequestBodyHash)
+	body, _ := in.Request.RequestBody()
+	n.ReqBodies = append(n.ReqBodies, body)
 	return n.ReqErr
 }
 
@@ -58,6 +65,7 @@ func (n *NoopAudit) LogResponse(ctx context.Context, in *audit.LogInput) error {
 	n.RespReq = append(n.RespReq, in.Request)
 	n.Resp = append(n.Resp, in.Response)
 	n.RespErrs = append(n.RespErrs, in.OuterErr)
+	n.RespBodyHashes = append(n.RespBodyHashes, in.RequestBodyHash)
 
 	if in.Response != nil {
 		n.RespNonHMACKeys = in.NonHMACRespDataKeys
@@ -388,6 +396,147 @@ func TestCore_DisableAudit(t *testing.T) {
 	}
 }
 
+func TestCore_ListAudit(t *testing.T) {
+	c, _, _ := TestCoreUnsealed(t)
+	c.auditBackends["noop"] = func(ctx context.Context, config *audit.BackendConfig) (audit.Backend, error) {
+		return &NoopAudit{
+			Config: config,
+		}, nil
+	}
+
+	devices, err := c.ListAudit(namespace.RootContext(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 0 {
+		t.Fatalf("expected no audit devices, got: %#v", devices)
+	}
+
+	for _, me := range []*MountEntry{
+		&MountEntry{
+			Table:       auditTableType,
+			Path:        "foo",
+			Type:        "noop",
+			Description: "remote",
+			Options: map[string]string{
+				"format":     "json",
+				"auth_token": "s.abcd",
+			},
+		},
+		&MountEntry{
+			Table: auditTableType,
+			Path:  "bar",
+			Type:  "noop",
+			Local: true,
+		},
+	} {
+		if err := c.enableAudit(namespace.RootContext(nil), me, true); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	devices, err = c.ListAudit(namespace.RootContext(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []*AuditDeviceInfo{
+		&AuditDeviceInfo{
+			Path:  "bar/",
+			Type:  "noop",
+			Local: true,
+		},
+		&AuditDeviceInfo{
+			Path:        "foo/",
+			Type:        "noop",
+			Description: "remote",
+			Options: map[string]string{
+				"format":     "json",
+				"auth_token": "<redacted>",
+			},
+		},
+	}
+	if !reflect.DeepEqual(devices, expected) {
+		t.Fatalf("bad: expected\n%#v\ngot\n%#v", expected, devices)
+	}
+
+	// The table itself must not have been modified
+	if c.audit.Entries[0].Options["auth_token"] != "s.abcd" {
+		t.Fatalf("audit table options were modified: %#v", c.audit.Entries[0].Options)
+	}
+}
+
+func TestCore_EnableDisableAudit_Runtime(t *testing.T) {
+	c, keys, root := TestCoreUnsealed(t)
+	c.auditBackends["noop"] = func(ctx context.Context, config *audit.BackendConfig) (audit.Backend, error) {
+		return &NoopAudit{
+			Config: config,
+		}, nil
+	}
+
+	err := c.EnableAudit(context.Background(), "foo", "noop", map[string]string{"format": "json"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !c.auditBroker.IsRegistered("foo/") {
+		t.Fatalf("missing audit backend")
+	}
+
+	if err := c.EnableAudit(context.Background(), "foo", "noop", nil); err == nil {
+		t.Fatalf("expected error enabling audit device at a used path")
+	}
+	if err := c.EnableAudit(context.Background(), "bar", "missing", nil); err == nil {
+		t.Fatalf("expected error enabling unknown audit type")
+	}
+
+	devices, err := c.ListAudit(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 1 || devices[0].Path != "foo/" || devices[0].Options["format"] != "json" {
+		t.Fatalf("bad: %#v", devices)
+	}
+
+	if err := c.DisableAudit(context.Background(), "foo"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if c.auditBroker.IsRegistered("foo/") {
+		t.Fatalf("audit backend present")
+	}
+	if err := c.DisableAudit(context.Background(), "foo"); err == nil {
+		t.Fatalf("expected error disabling missing audit device")
+	}
+
+	// A core unsealed against the same storage should see the same table
+	conf := &CoreConfig{
+		Physical:     c.physical,
+		DisableMlock: true,
+	}
+	c2, err := NewCore(conf)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	for i, key := range keys {
+		unseal, err := TestCoreUnseal(c2, key)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if i+1 == len(keys) && !unseal {
+			t.Fatalf("should be unsealed")
+		}
+	}
+	if !reflect.DeepEqual(c.audit, c2.audit) {
+		t.Fatalf("mismatch:\n%#v\n%#v", c.audit, c2.audit)
+	}
+
+	if err := c.Seal(root); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := c.EnableAudit(context.Background(), "foo", "noop", nil); err != consts.ErrSealed {
+		t.Fatalf("expected sealed error, got: %v", err)
+	}
+}
+
 func TestCore_DefaultAuditTable(t *testing.T) {
 	c, keys, _ := TestCoreUnsealed(t)
 	verifyDefaultAuditTable(t, c.audit)
@@ -626,6 +775,61 @@ func TestAuditBroker_LogResponse(t *testing.T) {
 	}
 }
 
+func TestAuditBroker_RequestBodyHash(t *testing.T) {
+	l := logging.NewVaultLogger(log.Trace)
+	b := NewAuditBroker(l)
+	a1 := &NoopAudit{Config: &audit.BackendConfig{}}
+	a2 := &NoopAudit{Config: &audit.BackendConfig{}}
+	b.Register("foo", a1, nil, false)
+	b.Register("bar", a2, nil, false)
+
+	body := []byte(`{"password":"hunter2"}`)
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "secret/foo",
+	}
+	req.SetRequestBody(body, true)
+	headersConf := &AuditedHeadersConfig{
+		Headers: make(map[string]*auditedHeaderSettings),
+	}
+
+	in := &audit.LogInput{
+		Request: req,
+	}
+	if err := b.LogRequest(context.Background(), in, headersConf); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	in.Response = &logical.Response{}
+	if err := b.LogResponse(context.Background(), in, headersConf); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Each backend gets its own salted hash of the body, but never the body
+	for _, a := range []*NoopAudit{a1, a2} {
+		expected, err := a.GetHash(context.Background(), string(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.ReqBodyHashes[0] != expected || a.RespBodyHashes[0] != expected {
+			t.Fatalf("bad body hashes: %q, %q, expected %q", a.ReqBodyHashes[0], a.RespBodyHashes[0], expected)
+		}
+		if a.ReqBodies[0] != nil {
+			t.Fatalf("backend was handed the body: %q", a.ReqBodies[0])
+		}
+	}
+	if a1.ReqBodyHashes[0] == a2.ReqBodyHashes[0] {
+		t.Fatal("backends should hash with their own salts")
+	}
+	if !in.RequestBodyTruncated {
+		t.Fatal("truncation was not passed on")
+	}
+
+	// The request gets its body back afterwards
+	if got, truncated := req.RequestBody(); string(got) != string(body) || !truncated {
+		t.Fatalf("request body not restored: %q, %t", got, truncated)
+	}
+}
+
 func TestAuditBroker_AuditHeaders(t *testing.T) {
 	logger := logging.NewVaultLogger(log.Trace)
 	b := NewAuditBroker(logger)
@@ -46,6 +46,42 @@ var (
 	credentialAliases = map[string]string{"aws-ec2": "aws"}
 )
 
+// EnableAuth enables a credential backend of the given type at path on a
+// running core in the root namespace. The entry is added to the persisted
+// credential table and registered with the router under auth/.
+func (c *Core) EnableAuth(ctx context.Context, path, authType string) error {
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+	if c.Sealed() {
+		return consts.ErrSealed
+	}
+	if c.standby {
+		return consts.ErrStandby
+	}
+
+	entry := &MountEntry{
+		Table: credentialTableType,
+		Path:  path,
+		Type:  authType,
+	}
+	return c.enableCredential(namespace.RootContext(ctx), entry)
+}
+
+// DisableAuth disables the credential backend mounted at path on a running
+// core, revoking the tokens and leases it issued.
+func (c *Core) DisableAuth(ctx context.Context, path string) error {
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+	if c.Sealed() {
+		return consts.ErrSealed
+	}
+	if c.standby {
+		return consts.ErrStandby
+	}
+
+	return c.disableCredential(namespace.RootContext(ctx), path)
+}
+
 // enableCredential is used to enable a new credential backend
 func (c *Core) enableCredential(ctx context.Context, entry *MountEntry) error {
 	return c.enableCredentialInternal(ctx, entry, MountTableUpdateStorage)
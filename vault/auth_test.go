@@ -393,6 +393,64 @@ func TestCore_DisableCredential_Cleanup(t *testing.T) {
 	}
 }
 
+func TestCore_EnableDisableAuth_Runtime(t *testing.T) {
+	noop := &NoopBackend{
+		Login:       []string{"login"},
+		BackendType: logical.TypeCredential,
+	}
+	c, _, _ := TestCoreUnsealed(t)
+	c.credentialBackends["noop"] = func(context.Context, *logical.BackendConfig) (logical.Backend, error) {
+		return noop, nil
+	}
+
+	if err := c.EnableAuth(context.Background(), "foo", "noop"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	match := c.router.MatchingMount(namespace.RootContext(nil), "auth/foo/login")
+	if match != "auth/foo/" {
+		t.Fatalf("missing mount, got: %q", match)
+	}
+
+	// Generate a token through the new backend
+	noop.Response = &logical.Response{
+		Auth: &logical.Auth{
+			Policies: []string{"foo"},
+		},
+	}
+	r := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "auth/foo/login",
+	}
+	resp, err := c.HandleRequest(namespace.RootContext(nil), r)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp.Auth.ClientToken == "" {
+		t.Fatalf("bad: %#v", resp)
+	}
+
+	if err := c.DisableAuth(context.Background(), "foo"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	match = c.router.MatchingMount(namespace.RootContext(nil), "auth/foo/login")
+	if match != "" {
+		t.Fatalf("backend present")
+	}
+
+	// Token should be revoked
+	te, err := c.tokenStore.Lookup(namespace.RootContext(nil), resp.Auth.ClientToken)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if te != nil {
+		t.Fatalf("bad: %#v", te)
+	}
+
+	if err := c.DisableAuth(context.Background(), "token"); err == nil {
+		t.Fatalf("expected error disabling the token backend")
+	}
+}
+
 func TestDefaultAuthTable(t *testing.T) {
 	c, _, _ := TestCoreUnsealed(t)
 	table := c.defaultAuthTable()
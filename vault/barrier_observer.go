@@ -0,0 +1,46 @@
+package vault
+
+import "context"
+
+// BarrierObserver is notified of storage operations performed through the
+// barrier. Only keys are reported; values never reach the observer. It is
+// meant for debugging and tests and is not installed unless one is set in
+// CoreConfig.
+type BarrierObserver interface {
+	OnGet(key string)
+	OnPut(key string)
+	OnDelete(key string)
+}
+
+// observedBarrier wraps a SecurityBarrier and reports storage operations to a
+// BarrierObserver before passing them through.
+type observedBarrier struct {
+	SecurityBarrier
+	observer BarrierObserver
+}
+
+var _ SecurityBarrier = (*observedBarrier)(nil)
+
+func newObservedBarrier(barrier SecurityBarrier, observer BarrierObserver) *observedBarrier {
+	return &observedBarrier{
+		SecurityBarrier: barrier,
+		observer:        observer,
+	}
+}
+
+func (b *observedBarrier) Get(ctx context.Context, key string) (*Entry, error) {
+	b.observer.OnGet(key)
+	return b.SecurityBarrier.Get(ctx, key)
+}
+
+func (b *observedBarrier) Put(ctx context.Context, entry *Entry) error {
+	if entry != nil {
+		b.observer.OnPut(entry.Key)
+	}
+	return b.SecurityBarrier.Put(ctx, entry)
+}
+
+func (b *observedBarrier) Delete(ctx context.Context, key string) error {
+	b.observer.OnDelete(key)
+	return b.SecurityBarrier.Delete(ctx, key)
+}
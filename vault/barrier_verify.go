@@ -0,0 +1,102 @@
+package vault
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/helper/consts"
+	"github.com/hashicorp/vault/helper/strutil"
+)
+
+// barrierVerifySkipPaths are the entries in the barrier's keyspace that are
+// not encrypted by the barrier itself, and so cannot be read through it
+var barrierVerifySkipPaths = []string{
+	keyringPath,
+	barrierSealConfigPath,
+	recoverySealConfigPlaintextPath,
+	recoveryKeyPath,
+	StoredBarrierKeysPath,
+	hsmStoredIVPath,
+	coreBarrierUnsealKeysBackupPath,
+	coreRecoveryUnsealKeysBackupPath,
+	coreLocalClusterPublicInfoPath,
+	coreActiveClusterTLSPath,
+	CoreLockPath,
+}
+
+// BarrierVerifyError describes a barrier entry that could not be read back
+type BarrierVerifyError struct {
+	Key string
+	Err error
+}
+
+func (e *BarrierVerifyError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Key, e.Err)
+}
+
+// VerifyBarrier reads back every entry stored behind the barrier and returns
+// the ones that fail to decrypt, e.g. because they were corrupted or tampered
+// with in the storage backend. Entries are read one at a time and only one
+// directory listing is held at once, so memory use does not grow with the
+// size of the storage. The returned error is set if the scan itself could
+// not be completed. This method errors out when Vault is sealed or in
+// standby, and stops if the node loses leadership.
+func (c *Core) VerifyBarrier(ctx context.Context) ([]*BarrierVerifyError, error) {
+	c.stateLock.RLock()
+	if c.Sealed() {
+		c.stateLock.RUnlock()
+		return nil, consts.ErrSealed
+	}
+	if c.standby {
+		c.stateLock.RUnlock()
+		return nil, consts.ErrStandby
+	}
+	activeCtx := c.activeContext
+	c.stateLock.RUnlock()
+
+	var failed []*BarrierVerifyError
+	frontier := []string{""}
+	for len(frontier) > 0 {
+		current := frontier[len(frontier)-1]
+		frontier = frontier[:len(frontier)-1]
+
+		keys, err := c.barrier.List(ctx, current)
+		if err != nil {
+			return nil, errwrap.Wrapf(fmt.Sprintf("list failed at path %q: {{err}}", current), err)
+		}
+
+		for _, key := range keys {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-activeCtx.Done():
+				return nil, consts.ErrStandby
+			default:
+			}
+
+			fullPath := current + key
+			if strings.HasSuffix(key, "/") {
+				frontier = append(frontier, fullPath)
+				continue
+			}
+			if strutil.StrListContains(barrierVerifySkipPaths, fullPath) {
+				continue
+			}
+
+			if _, err := c.barrier.Get(ctx, fullPath); err != nil {
+				if err == ErrBarrierSealed {
+					return nil, consts.ErrSealed
+				}
+				c.logger.Warn("barrier entry failed verification", "key", fullPath, "error", err)
+				failed = append(failed, &BarrierVerifyError{
+					Key: fullPath,
+					Err: err,
+				})
+			}
+		}
+	}
+
+	return failed, nil
+}
@@ -0,0 +1,47 @@
+package vault
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/physical"
+)
+
+func TestCore_VerifyBarrier(t *testing.T) {
+	c, _, _ := TestCoreUnsealed(t)
+	ctx := context.Background()
+
+	for _, key := range []string{"test/good", "test/corrupt"} {
+		if err := c.barrier.Put(ctx, &Entry{Key: key, Value: []byte("value")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	failed, err := c.VerifyBarrier(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 0 {
+		t.Fatalf("expected a freshly unsealed core to verify, got %v", failed)
+	}
+
+	// Flip a bit in the ciphertext of one entry
+	pe, err := c.physical.Get(ctx, "test/corrupt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := make([]byte, len(pe.Value))
+	copy(value, pe.Value)
+	value[len(value)-1] ^= 0x01
+	if err := c.physical.Put(ctx, &physical.Entry{Key: pe.Key, Value: value}); err != nil {
+		t.Fatal(err)
+	}
+
+	failed, err = c.VerifyBarrier(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 1 || failed[0].Key != "test/corrupt" || failed[0].Err == nil {
+		t.Fatalf("expected only the corrupted entry to fail, got %v", failed)
+	}
+}
@@ -316,3 +316,65 @@ func TestBarrierView_Readonly(t *testing.T) {
 		t.Fatalf("key test missing")
 	}
 }
+
+func TestCore_ReadOnlyBarrierView(t *testing.T) {
+	cluster := NewTestCluster(t, nil, nil)
+	cluster.Start()
+	defer cluster.Cleanup()
+
+	active := cluster.Cores[0]
+	TestWaitActive(t, active.Core)
+	standby := cluster.Cores[1]
+	if isStandby, _ := standby.Standby(); !isStandby {
+		t.Fatal("expected a standby")
+	}
+
+	ctx := context.Background()
+	if err := active.barrier.Put(ctx, &Entry{Key: "test/foo", Value: []byte("bar")}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	view, err := standby.ReadOnlyBarrierView("test/")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Reads on the standby see what the active node wrote
+	out, err := view.Get(ctx, "foo")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out == nil || out.Key != "foo" || string(out.Value) != "bar" {
+		t.Fatalf("bad: %#v", out)
+	}
+	keys, err := view.List(ctx, "")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !reflect.DeepEqual(keys, []string{"foo"}) {
+		t.Fatalf("bad: %v", keys)
+	}
+
+	// Writes are rejected, including through sub-views
+	if err := view.Put(ctx, &logical.StorageEntry{Key: "foo", Value: []byte("baz")}); err != logical.ErrReadOnly {
+		t.Fatalf("expected read-only error, got %v", err)
+	}
+	if err := view.SubView("sub/").Put(ctx, &logical.StorageEntry{Key: "foo", Value: []byte("baz")}); err != logical.ErrReadOnly {
+		t.Fatalf("expected read-only error, got %v", err)
+	}
+	if err := view.Delete(ctx, "foo"); err != logical.ErrReadOnly {
+		t.Fatalf("expected read-only error, got %v", err)
+	}
+
+	// Later writes on the active node are seen too
+	if err := active.barrier.Put(ctx, &Entry{Key: "test/foo", Value: []byte("qux")}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	out, err = view.Get(ctx, "foo")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out == nil || string(out.Value) != "qux" {
+		t.Fatalf("bad: %#v", out)
+	}
+}
@@ -0,0 +1,47 @@
+package vault
+
+import (
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+// Clock is the source of time for time-based logic in the core, such as
+// cluster cert validity and lease expiration. It can be replaced in
+// CoreConfig so that tests can control the passage of time.
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+
+	// AfterFunc calls f in its own goroutine once d has elapsed, like
+	// time.AfterFunc
+	AfterFunc(d time.Duration, f func()) ClockTimer
+}
+
+// ClockTimer is a timer created by a Clock
+type ClockTimer interface {
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// realClock is the default Clock, backed by the time package
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) ClockTimer {
+	return time.AfterFunc(d, f)
+}
+
+// leaseExpireTime returns when a lease with the given options expires if it
+// starts at now. It is the Clock-aware version of
+// logical.LeaseOptions.ExpirationTime.
+func leaseExpireTime(now time.Time, l *logical.LeaseOptions) time.Time {
+	var expireTime time.Time
+	if l.LeaseEnabled() {
+		expireTime = now.Add(l.LeaseTotal())
+	}
+	return expireTime
+}
@@ -0,0 +1,227 @@
+package vault
+
+import (
+	"context"
+	"crypto/x509"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/logical"
+)
+
+// testClock is a Clock that only moves when advanced. Timers that come due
+// are run synchronously by Advance, so their effects are visible as soon as
+// it returns.
+type testClock struct {
+	l      sync.Mutex
+	now    time.Time
+	timers []*testClockTimer
+}
+
+type testClockTimer struct {
+	clock  *testClock
+	when   time.Time
+	f      func()
+	active bool
+}
+
+func newTestClock(now time.Time) *testClock {
+	return &testClock{now: now}
+}
+
+func (c *testClock) Now() time.Time {
+	c.l.Lock()
+	defer c.l.Unlock()
+	return c.now
+}
+
+func (c *testClock) AfterFunc(d time.Duration, f func()) ClockTimer {
+	c.l.Lock()
+	defer c.l.Unlock()
+	t := &testClockTimer{
+		clock:  c,
+		when:   c.now.Add(d),
+		f:      f,
+		active: true,
+	}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance moves the clock forward by d and runs the timers that are due, in
+// the order they come due
+func (c *testClock) Advance(d time.Duration) {
+	c.l.Lock()
+	c.now = c.now.Add(d)
+	var due, pending []*testClockTimer
+	for _, t := range c.timers {
+		switch {
+		case !t.active:
+		case !t.when.After(c.now):
+			t.active = false
+			due = append(due, t)
+		default:
+			pending = append(pending, t)
+		}
+	}
+	c.timers = pending
+	c.l.Unlock()
+
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].when.Before(due[j].when)
+	})
+	for _, t := range due {
+		t.f()
+	}
+}
+
+// hasTimer returns whether a timer that has not run or been stopped is set
+// to go off at when
+func (c *testClock) hasTimer(when time.Time) bool {
+	c.l.Lock()
+	defer c.l.Unlock()
+	for _, t := range c.timers {
+		if t.active && t.when.Equal(when) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *testClockTimer) Stop() bool {
+	t.clock.l.Lock()
+	defer t.clock.l.Unlock()
+	wasActive := t.active
+	t.active = false
+	return wasActive
+}
+
+func (t *testClockTimer) Reset(d time.Duration) bool {
+	t.clock.l.Lock()
+	defer t.clock.l.Unlock()
+	wasActive := t.active
+	t.when = t.clock.now.Add(d)
+	if !wasActive {
+		t.active = true
+		t.clock.timers = append(t.clock.timers, t)
+	}
+	return wasActive
+}
+
+func TestCore_Clock_ClusterCert(t *testing.T) {
+	start := time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+	// Cluster certs are only generated when HA is enabled
+	cluster := NewTestCluster(t, &CoreConfig{
+		Clock: newTestClock(start),
+	}, &TestClusterOptions{
+		NumCores: 1,
+	})
+	cluster.Start()
+	defer cluster.Cleanup()
+	core := cluster.Cores[0]
+	TestWaitActive(t, core.Core)
+
+	cert := core.localClusterParsedCert.Load().(*x509.Certificate)
+	if cert == nil {
+		t.Fatal("expected a cluster cert")
+	}
+	if !cert.NotBefore.Equal(start.Add(-30 * time.Second)) {
+		t.Fatalf("bad NotBefore: %v", cert.NotBefore)
+	}
+	if !cert.NotAfter.Equal(start.Add(262980 * time.Hour)) {
+		t.Fatalf("bad NotAfter: %v", cert.NotAfter)
+	}
+}
+
+func TestExpiration_Clock(t *testing.T) {
+	clock := newTestClock(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))
+	core, _, root := TestCoreUnsealedWithConfig(t, &CoreConfig{
+		Clock: clock,
+	})
+
+	noop := &NoopBackend{
+		Response: &logical.Response{
+			Secret: &logical.Secret{
+				LeaseOptions: logical.LeaseOptions{
+					TTL: time.Hour,
+				},
+			},
+		},
+	}
+	core.logicalBackends["noop"] = func(context.Context, *logical.BackendConfig) (logical.Backend, error) {
+		return noop, nil
+	}
+	if err := core.mount(namespace.RootContext(nil), &MountEntry{
+		Table:    mountTableType,
+		Path:     "prod/",
+		Type:     "noop",
+		Accessor: "noop-accessor",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	issued := clock.Now()
+	leaseID := testLeaseRevocationRead(t, core, root, "prod/foo")
+
+	le, err := core.expiration.FetchLeaseTimes(namespace.RootContext(nil), leaseID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !le.IssueTime.Equal(issued) || !le.ExpireTime.Equal(issued.Add(time.Hour)) {
+		t.Fatalf("bad lease times: %#v", le)
+	}
+
+	revoked := func() bool {
+		noop.Lock()
+		defer noop.Unlock()
+		for _, req := range noop.Requests {
+			if req.Operation == logical.RevokeOperation {
+				return true
+			}
+		}
+		return false
+	}
+
+	lookupTTL := func() int64 {
+		t.Helper()
+		req := logical.TestRequest(t, logical.UpdateOperation, "sys/leases/lookup")
+		req.ClientToken = root
+		req.Data["lease_id"] = leaseID
+		resp, err := core.HandleRequest(namespace.RootContext(nil), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("bad: %#v, %v", resp, err)
+		}
+		if resp.Data["renewable"] != false {
+			t.Fatalf("bad renewable: %#v", resp.Data["renewable"])
+		}
+		return resp.Data["ttl"].(int64)
+	}
+	if ttl := lookupTTL(); ttl != 3600 {
+		t.Fatalf("bad ttl: %d", ttl)
+	}
+
+	// Just before expiry nothing happens
+	clock.Advance(time.Hour - time.Second)
+	if revoked() {
+		t.Fatal("lease revoked before expiring")
+	}
+	if ttl := lookupTTL(); ttl != 1 {
+		t.Fatalf("bad ttl: %d", ttl)
+	}
+
+	// Once the lease expires it is revoked straight away
+	clock.Advance(time.Second)
+	if !revoked() {
+		t.Fatal("expected lease to be revoked on expiry")
+	}
+	le, err = core.expiration.FetchLeaseTimes(namespace.RootContext(nil), leaseID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if le != nil {
+		t.Fatalf("expected lease to be gone, got %#v", le)
+	}
+}
@@ -1,6 +1,7 @@
 package vault
 
 import (
+	"bytes"
 	"context"
 	"crypto/ecdsa"
 	"crypto/elliptic"
@@ -9,34 +10,128 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/json"
+	"encoding/pem"
 	"errors"
 	"fmt"
 	"math/big"
 	mathrand "math/rand"
 	"net"
 	"net/http"
+	"sort"
 	"time"
 
 	"github.com/hashicorp/errwrap"
 	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/helper/consts"
 	"github.com/hashicorp/vault/helper/jsonutil"
+	"github.com/hashicorp/vault/physical"
 )
 
 const (
 	// Storage path where the local cluster name and identifier are stored
 	coreLocalClusterInfoPath = "core/cluster/local/info"
 
+	// Storage path of a copy of the local cluster name and identifier kept
+	// outside the barrier, so that they can be read while sealed. Nothing
+	// else may be stored here.
+	coreLocalClusterPublicInfoPath = "core/cluster/local/public-info"
+
+	// Storage path where the active node keeps its cluster address and cert
+	// outside the barrier, so that sealed standbys can forward health checks
+	// to it. Nothing secret may be stored here.
+	coreActiveClusterTLSPath = "core/cluster/active/public-tls"
+
 	corePrivateKeyTypeP521    = "p521"
 	corePrivateKeyTypeED25519 = "ed25519"
 
 	// Internal so as not to log a trace message
 	IntNoForwardingHeaderName = "X-Vault-Internal-No-Request-Forwarding"
+
+	// IntForwardingTestHeaderName marks a request sent by TestForward, which
+	// the active node echoes back rather than handling. The HTTP handler
+	// strips it from client requests.
+	IntForwardingTestHeaderName = "X-Vault-Internal-Forwarding-Test"
 )
 
 var (
 	ErrCannotForward = errors.New("cannot forward request; no connection or address not known")
+
+	// ErrForwardingClusterMismatch is returned when the node a request would
+	// be forwarded to belongs to a different cluster than this one
+	ErrForwardingClusterMismatch = errors.New("cannot forward request; active node belongs to a different cluster")
+
+	// ErrForwardedRequestTooLarge is returned when a request to be forwarded
+	// has a body larger than the configured maximum request size
+	ErrForwardedRequestTooLarge = errors.New("cannot forward request; request body exceeds the maximum request size")
+
+	// ErrForwardingNodeNotActive is returned when the node a request was
+	// forwarded to is no longer active, e.g. because it is stepping down. The
+	// request can be retried once the new active node is known.
+	ErrForwardingNodeNotActive = errors.New("cannot forward request; node is not active")
+
+	// ErrClusterPeerUntrusted is returned when the forwarding connection
+	// could not be set up because the active node's cluster certificate
+	// failed verification, e.g. because it was rotated and this node has not
+	// yet picked up the new one
+	ErrClusterPeerUntrusted = errors.New("cannot forward request; cluster peer certificate is not trusted")
+
+	// ErrForwardedRequestNotCaptured is returned by ReplayForwarded when no
+	// captured request has the given ID, e.g. because it has been pushed out
+	// by newer ones
+	ErrForwardedRequestNotCaptured = errors.New("cannot replay forwarded request; no captured request with that ID")
+
+	// ErrForwardedRequestBodyNotCaptured is returned by ReplayForwarded for
+	// a request whose body was dropped when it was captured
+	ErrForwardedRequestBodyNotCaptured = errors.New("cannot replay forwarded request; its body was not captured")
+
+	// The distinct ways that setting up the cluster or loading the local
+	// cluster TLS information can fail. They are returned as the Kind of a
+	// ClusterError, which errors.Is matches against them.
+	ErrClusterInfoDecode       = errors.New("failed to decode cluster details")
+	ErrClusterInfoPersist      = errors.New("failed to store cluster details")
+	ErrClusterKeyParamsMissing = errors.New("no local cluster key params found")
+	ErrClusterKeyParse         = errors.New("failed to parse local cluster key")
+	ErrClusterKeyType          = errors.New("failed to find valid local cluster key type")
+	ErrClusterKeyGenerate      = errors.New("failed to generate local cluster key")
+	ErrClusterCertMissing      = errors.New("no local cluster cert found")
+	ErrClusterCertParse        = errors.New("failed to parse local cluster certificate")
+	ErrClusterCertGenerate     = errors.New("unable to generate local cluster certificate")
+	ErrClusterAddrsNotFound    = errors.New("cluster addresses not found")
+	ErrClusterTooManyListeners = errors.New("too many cluster listeners")
 )
 
+// ClusterError is a cluster failure of the kind given by one of the
+// ErrCluster sentinel errors, along with its underlying cause if any
+type ClusterError struct {
+	Kind error
+	Err  error
+}
+
+func (e *ClusterError) Error() string {
+	if e.Err == nil {
+		return e.Kind.Error()
+	}
+	return fmt.Sprintf("%s: %s", e.Kind, e.Err)
+}
+
+// Is reports whether target is the kind of this error
+func (e *ClusterError) Is(target error) bool {
+	return target == e.Kind
+}
+
+// Unwrap returns the underlying cause
+func (e *ClusterError) Unwrap() error {
+	return e.Err
+}
+
+// WrappedErrors returns the kind and cause for use with errwrap
+func (e *ClusterError) WrappedErrors() []error {
+	if e.Err == nil {
+		return []error{e.Kind}
+	}
+	return []error{e.Kind, e.Err}
+}
+
 type ReplicatedClusters struct {
 	DR          *ReplicatedCluster
 	Performance *ReplicatedCluster
@@ -75,7 +170,7 @@ func (c *Core) Cluster(ctx context.Context) (*Cluster, error) {
 
 	// Decode the cluster information
 	if err = jsonutil.DecodeJSON(entry.Value, &cluster); err != nil {
-		return nil, errwrap.Wrapf("failed to decode cluster details: {{err}}", err)
+		return nil, &ClusterError{Kind: ErrClusterInfoDecode, Err: err}
 	}
 
 	// Set in config file
@@ -86,13 +181,125 @@ func (c *Core) Cluster(ctx context.Context) (*Cluster, error) {
 	return &cluster, nil
 }
 
+// ClusterIdentity returns the name and identifier of the local cluster. Unlike
+// Cluster, it works while Vault is sealed, in which case they are read from a
+// copy stored outside the barrier when the cluster was last set up. Nil is
+// returned if that copy doesn't exist yet.
+func (c *Core) ClusterIdentity(ctx context.Context) (*Cluster, error) {
+	if !c.Sealed() {
+		c.stateLock.RLock()
+		if !c.Sealed() {
+			defer c.stateLock.RUnlock()
+			return c.Cluster(ctx)
+		}
+		c.stateLock.RUnlock()
+	}
+
+	entry, err := c.physical.Get(ctx, coreLocalClusterPublicInfoPath)
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to read cluster identity: {{err}}", err)
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var cluster Cluster
+	if err := jsonutil.DecodeJSON(entry.Value, &cluster); err != nil {
+		return nil, errwrap.Wrapf("failed to decode cluster identity: {{err}}", err)
+	}
+	if c.clusterName != "" {
+		cluster.Name = c.clusterName
+	}
+	return &cluster, nil
+}
+
+// persistClusterIdentity updates the copy of the cluster name and identifier
+// kept outside the barrier if it differs from the given cluster.
+func (c *Core) persistClusterIdentity(ctx context.Context, cluster *Cluster) error {
+	raw, err := json.Marshal(&Cluster{
+		Name: cluster.Name,
+		ID:   cluster.ID,
+	})
+	if err != nil {
+		return err
+	}
+
+	entry, err := c.physical.Get(ctx, coreLocalClusterPublicInfoPath)
+	if err != nil {
+		return err
+	}
+	if entry != nil && bytes.Equal(entry.Value, raw) {
+		return nil
+	}
+
+	return c.physical.Put(ctx, &physical.Entry{
+		Key:   coreLocalClusterPublicInfoPath,
+		Value: raw,
+	})
+}
+
+// ClusterInfo describes the local cluster and the certificate currently used
+// on the cluster port in a form suitable for returning from the API. The
+// certificate fields are empty if no cluster certificate has been set up.
+type ClusterInfo struct {
+	Name          string    `json:"name" structs:"name" mapstructure:"name"`
+	ID            string    `json:"id" structs:"id" mapstructure:"id"`
+	CertSubjectCN string    `json:"cert_subject_cn" structs:"cert_subject_cn" mapstructure:"cert_subject_cn"`
+	CertSANs      []string  `json:"cert_sans" structs:"cert_sans" mapstructure:"cert_sans"`
+	CertNotAfter  time.Time `json:"cert_not_after" structs:"cert_not_after" mapstructure:"cert_not_after"`
+}
+
+// ClusterInfo returns the name and identifier of the local cluster along with
+// details of the cluster certificate. This method errors out when Vault is
+// sealed.
+func (c *Core) ClusterInfo(ctx context.Context) (*ClusterInfo, error) {
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+	if c.Sealed() {
+		return nil, consts.ErrSealed
+	}
+
+	cluster, err := c.Cluster(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &ClusterInfo{
+		Name: cluster.Name,
+		ID:   cluster.ID,
+	}
+
+	cert := c.localClusterParsedCert.Load().(*x509.Certificate)
+	if cert == nil {
+		if certBytes := c.localClusterCert.Load().([]byte); len(certBytes) > 0 {
+			cert, err = x509.ParseCertificate(certBytes)
+			if err != nil {
+				return nil, &ClusterError{Kind: ErrClusterCertParse, Err: err}
+			}
+		}
+	}
+	if cert != nil {
+		info.CertSubjectCN = cert.Subject.CommonName
+		info.CertNotAfter = cert.NotAfter
+		info.CertSANs = make([]string, 0, len(cert.DNSNames)+len(cert.IPAddresses))
+		info.CertSANs = append(info.CertSANs, cert.DNSNames...)
+		for _, ip := range cert.IPAddresses {
+			info.CertSANs = append(info.CertSANs, ip.String())
+		}
+	}
+
+	return info, nil
+}
+
 // This sets our local cluster cert and private key based on the advertisement.
 // It also ensures the cert is in our local cluster cert pool.
 func (c *Core) loadLocalClusterTLS(adv activeAdvertisement) (retErr error) {
 	defer func() {
 		if retErr != nil {
 			c.localClusterCert.Store(([]byte)(nil))
+			c.localClusterParsedCertLock.Lock()
 			c.localClusterParsedCert.Store((*x509.Certificate)(nil))
+			c.localClusterParsedCertLock.Unlock()
 			c.localClusterPrivateKey.Store((*ecdsa.PrivateKey)(nil))
 
 			c.requestForwardingConnectionLock.Lock()
@@ -108,19 +315,19 @@ func (c *Core) loadLocalClusterTLS(adv activeAdvertisement) (retErr error) {
 
 	case adv.ClusterKeyParams == nil:
 		c.logger.Error("no key params found loading local cluster TLS information")
-		return fmt.Errorf("no local cluster key params found")
+		return &ClusterError{Kind: ErrClusterKeyParamsMissing}
 
 	case adv.ClusterKeyParams.X == nil, adv.ClusterKeyParams.Y == nil, adv.ClusterKeyParams.D == nil:
 		c.logger.Error("failed to parse local cluster key due to missing params")
-		return fmt.Errorf("failed to parse local cluster key")
+		return &ClusterError{Kind: ErrClusterKeyParse}
 
 	case adv.ClusterKeyParams.Type != corePrivateKeyTypeP521:
 		c.logger.Error("unknown local cluster key type", "key_type", adv.ClusterKeyParams.Type)
-		return fmt.Errorf("failed to find valid local cluster key type")
+		return &ClusterError{Kind: ErrClusterKeyType}
 
 	case adv.ClusterCert == nil || len(adv.ClusterCert) == 0:
 		c.logger.Error("no local cluster cert found")
-		return fmt.Errorf("no local cluster cert found")
+		return &ClusterError{Kind: ErrClusterCertMissing}
 
 	}
 
@@ -140,14 +347,106 @@ func (c *Core) loadLocalClusterTLS(adv activeAdvertisement) (retErr error) {
 	cert, err := x509.ParseCertificate(adv.ClusterCert)
 	if err != nil {
 		c.logger.Error("failed parsing local cluster certificate", "error", err)
-		return errwrap.Wrapf("error parsing local cluster certificate: {{err}}", err)
+		return &ClusterError{Kind: ErrClusterCertParse, Err: err}
 	}
 
-	c.localClusterParsedCert.Store(cert)
+	c.storeLocalClusterParsedCert(cert)
 
 	return nil
 }
 
+// retiredClusterCert is a previous local cluster cert along with the time at
+// which it stops being trusted.
+type retiredClusterCert struct {
+	cert      *x509.Certificate
+	expiresAt time.Time
+}
+
+// storeLocalClusterParsedCert sets the parsed local cluster cert. If a
+// different cert was in use and an overlap period is configured, the old cert
+// is retained so that nodes which have not yet picked up the new one can still
+// complete mutual TLS until the window closes.
+func (c *Core) storeLocalClusterParsedCert(cert *x509.Certificate) {
+	c.localClusterParsedCertLock.Lock()
+	defer c.localClusterParsedCertLock.Unlock()
+
+	prev := c.localClusterParsedCert.Load().(*x509.Certificate)
+	if prev != nil && c.clusterCertOverlapPeriod > 0 && (cert == nil || !prev.Equal(cert)) {
+		c.localClusterPrevParsedCert.Store(&retiredClusterCert{
+			cert:      prev,
+			expiresAt: c.clock.Now().Add(c.clusterCertOverlapPeriod),
+		})
+	}
+
+	c.localClusterParsedCert.Store(cert)
+}
+
+// clusterCertPool returns a cert pool containing the current local cluster
+// cert and, while still inside the overlap window, the previous one. Trusted
+// peer certs from CoreConfig.ClusterTrustedPeerCerts are always included.
+func (c *Core) clusterCertPool() *x509.CertPool {
+	pool := x509.NewCertPool()
+
+	if cert := c.localClusterParsedCert.Load().(*x509.Certificate); cert != nil {
+		pool.AddCert(cert)
+	}
+
+	if prev := c.localClusterPrevParsedCert.Load().(*retiredClusterCert); prev != nil && c.clock.Now().Before(prev.expiresAt) {
+		pool.AddCert(prev.cert)
+	}
+
+	for _, cert := range c.clusterTrustedPeerCerts {
+		pool.AddCert(cert)
+	}
+
+	return pool
+}
+
+// isClusterTrustedPeer returns whether cert is one of the certs of nodes
+// outside this cluster from CoreConfig.ClusterTrustedPeerCerts
+func (c *Core) isClusterTrustedPeer(cert *x509.Certificate) bool {
+	if cert == nil {
+		return false
+	}
+	for _, trusted := range c.clusterTrustedPeerCerts {
+		if cert.Equal(trusted) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseClusterPeerCerts parses the PEM-encoded certificates given in
+// CoreConfig.ClusterTrustedPeerCerts. Each entry may hold several
+// certificates.
+func parseClusterPeerCerts(pemCerts []string) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for i, pemCert := range pemCerts {
+		rest := []byte(pemCert)
+		found := false
+		for {
+			var block *pem.Block
+			block, rest = pem.Decode(rest)
+			if block == nil {
+				break
+			}
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, errwrap.Wrapf(fmt.Sprintf("failed to parse trusted peer certificate %d: {{err}}", i), err)
+			}
+			certs = append(certs, cert)
+			found = true
+		}
+		if !found {
+			return nil, fmt.Errorf("no certificate found in trusted peer certificate %d", i)
+		}
+	}
+	return certs, nil
+}
+
 // setupCluster creates storage entries for holding Vault cluster information.
 // Entries will be created only if they are not already present. If clusterName
 // is not supplied, this method will auto-generate it.
@@ -163,13 +462,13 @@ func (c *Core) setupCluster(ctx context.Context) error {
 		return err
 	}
 
-	var modified bool
-
 	if cluster == nil {
 		cluster = &Cluster{}
 	}
 
-	if cluster.Name == "" {
+	report := c.clusterSetupReport(cluster)
+
+	if report.GenerateName {
 		// If cluster name is not supplied, generate one
 		if c.clusterName == "" {
 			c.logger.Debug("cluster name not found/set, generating new")
@@ -186,10 +485,9 @@ func (c *Core) setupCluster(ctx context.Context) error {
 		if c.logger.IsDebug() {
 			c.logger.Debug("cluster name set", "name", cluster.Name)
 		}
-		modified = true
 	}
 
-	if cluster.ID == "" {
+	if report.GenerateID {
 		c.logger.Debug("cluster ID not found, generating new")
 		// Generate a clusterID
 		cluster.ID, err = uuid.GenerateUUID()
@@ -200,25 +498,24 @@ func (c *Core) setupCluster(ctx context.Context) error {
 		if c.logger.IsDebug() {
 			c.logger.Debug("cluster ID set", "id", cluster.ID)
 		}
-		modified = true
 	}
 
 	// If we're using HA, generate server-to-server parameters
 	if c.ha != nil {
 		// Create a private key
-		if c.localClusterPrivateKey.Load().(*ecdsa.PrivateKey) == nil {
+		if report.GenerateKey {
 			c.logger.Debug("generating cluster private key")
 			key, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
 			if err != nil {
 				c.logger.Error("failed to generate local cluster key", "error", err)
-				return err
+				return &ClusterError{Kind: ErrClusterKeyGenerate, Err: err}
 			}
 
 			c.localClusterPrivateKey.Store(key)
 		}
 
 		// Create a certificate
-		if c.localClusterCert.Load().([]byte) == nil {
+		if report.GenerateCert {
 			c.logger.Debug("generating local cluster certificate")
 
 			host, err := uuid.GenerateUUID()
@@ -237,9 +534,9 @@ func (c *Core) setupCluster(ctx context.Context) error {
 				},
 				KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageKeyAgreement | x509.KeyUsageCertSign,
 				SerialNumber: big.NewInt(mathrand.Int63()),
-				NotBefore:    time.Now().Add(-30 * time.Second),
+				NotBefore:    c.clock.Now().Add(-30 * time.Second),
 				// 30 years of single-active uptime ought to be enough for anybody
-				NotAfter:              time.Now().Add(262980 * time.Hour),
+				NotAfter:              c.clock.Now().Add(262980 * time.Hour),
 				BasicConstraintsValid: true,
 				IsCA:                  true,
 			}
@@ -247,21 +544,21 @@ func (c *Core) setupCluster(ctx context.Context) error {
 			certBytes, err := x509.CreateCertificate(rand.Reader, template, template, c.localClusterPrivateKey.Load().(*ecdsa.PrivateKey).Public(), c.localClusterPrivateKey.Load().(*ecdsa.PrivateKey))
 			if err != nil {
 				c.logger.Error("error generating self-signed cert", "error", err)
-				return errwrap.Wrapf("unable to generate local cluster certificate: {{err}}", err)
+				return &ClusterError{Kind: ErrClusterCertGenerate, Err: err}
 			}
 
 			parsedCert, err := x509.ParseCertificate(certBytes)
 			if err != nil {
 				c.logger.Error("error parsing self-signed cert", "error", err)
-				return errwrap.Wrapf("error parsing generated certificate: {{err}}", err)
+				return &ClusterError{Kind: ErrClusterCertParse, Err: err}
 			}
 
 			c.localClusterCert.Store(certBytes)
-			c.localClusterParsedCert.Store(parsedCert)
+			c.storeLocalClusterParsedCert(parsedCert)
 		}
 	}
 
-	if modified {
+	if report.PersistClusterInfo {
 		// Encode the cluster information into as a JSON string
 		rawCluster, err := json.Marshal(cluster)
 		if err != nil {
@@ -276,13 +573,95 @@ func (c *Core) setupCluster(ctx context.Context) error {
 		})
 		if err != nil {
 			c.logger.Error("failed to store cluster details", "error", err)
-			return err
+			return &ClusterError{Kind: ErrClusterInfoPersist, Err: err}
 		}
 	}
 
+	c.localClusterID.Store(cluster.ID)
+
+	if err := c.persistClusterIdentity(ctx, cluster); err != nil {
+		c.logger.Error("failed to store cluster identity", "error", err)
+		return err
+	}
+
 	return nil
 }
 
+// ClusterSetupReport describes what cluster setup would do given the current
+// stored cluster information and in-memory cluster parameters.
+type ClusterSetupReport struct {
+	// GenerateName is set if no cluster name is stored; the configured name
+	// is used if there is one, otherwise a random one is generated
+	GenerateName bool
+
+	// GenerateID is set if no cluster ID is stored
+	GenerateID bool
+
+	// GenerateKey and GenerateCert are set if HA is enabled and no local
+	// cluster key or certificate is loaded. GenerateCert is also set if the
+	// loaded certificate is older than the configured maximum age.
+	GenerateKey  bool
+	GenerateCert bool
+
+	// PersistClusterInfo is set if the cluster name or ID would be written
+	// to storage
+	PersistClusterInfo bool
+}
+
+// clusterSetupReport works out what setupCluster needs to do for the given
+// stored cluster information. The cluster params lock must be held.
+func (c *Core) clusterSetupReport(cluster *Cluster) *ClusterSetupReport {
+	report := &ClusterSetupReport{}
+	if cluster == nil || cluster.Name == "" {
+		report.GenerateName = true
+	}
+	if cluster == nil || cluster.ID == "" {
+		report.GenerateID = true
+	}
+	report.PersistClusterInfo = report.GenerateName || report.GenerateID
+
+	if c.ha != nil {
+		report.GenerateKey = c.localClusterPrivateKey.Load().(*ecdsa.PrivateKey) == nil
+		report.GenerateCert = c.localClusterCert.Load().([]byte) == nil
+	}
+
+	return report
+}
+
+// clusterCertMaxAgeTimer returns a channel that is closed once the local
+// cluster cert is older than CoreConfig.ClusterCertMaxAge, and a function
+// that stops the timer. The channel is nil if there is no maximum age.
+func (c *Core) clusterCertMaxAgeTimer() (<-chan struct{}, func()) {
+	if c.clusterCertMaxAge == 0 {
+		return nil, func() {}
+	}
+	cert := c.localClusterParsedCert.Load().(*x509.Certificate)
+	if cert == nil {
+		return nil, func() {}
+	}
+
+	expiredCh := make(chan struct{})
+	timer := c.clock.AfterFunc(cert.NotBefore.Add(c.clusterCertMaxAge).Sub(c.clock.Now()), func() {
+		close(expiredCh)
+	})
+	return expiredCh, func() { timer.Stop() }
+}
+
+// ValidateClusterSetup runs the same checks as cluster setup and reports what
+// it would do, without generating anything or writing to storage. The barrier
+// must be unsealed.
+func (c *Core) ValidateClusterSetup(ctx context.Context) (*ClusterSetupReport, error) {
+	c.clusterParamsLock.RLock()
+	defer c.clusterParamsLock.RUnlock()
+
+	cluster, err := c.Cluster(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.clusterSetupReport(cluster), nil
+}
+
 // startClusterListener starts cluster request listeners during postunseal. It
 // is assumed that the state lock is held while this is run. Right now this
 // only starts forwarding listeners; it's TBD whether other request types will
@@ -293,9 +672,17 @@ func (c *Core) startClusterListener(ctx context.Context) error {
 		return nil
 	}
 
-	if c.clusterListenerAddrs == nil || len(c.clusterListenerAddrs) == 0 {
+	addrs := c.clusterBindAddrs()
+	if len(addrs) == 0 {
 		c.logger.Warn("clustering not disabled but no addresses to listen on")
-		return fmt.Errorf("cluster addresses not found")
+		return &ClusterError{Kind: ErrClusterAddrsNotFound}
+	}
+	if len(addrs) > c.maxClusterListeners {
+		c.logger.Error("refusing to start cluster listeners, more addresses than allowed", "num_addrs", len(addrs), "max", c.maxClusterListeners)
+		return &ClusterError{
+			Kind: ErrClusterTooManyListeners,
+			Err:  fmt.Errorf("%d addresses given, at most %d allowed", len(addrs), c.maxClusterListeners),
+		}
 	}
 
 	c.logger.Debug("starting cluster listeners")
@@ -339,13 +726,16 @@ func (c *Core) stopClusterListener() {
 }
 
 // ClusterTLSConfig generates a TLS configuration based on the local/replicated
-// cluster key and cert.
+// cluster key and cert. The certificates presented on either side of a
+// connection are looked up at handshake time, so listeners and dialers using
+// the config pick up a rotated cert for new connections without being
+// restarted, while established connections are left alone.
 func (c *Core) ClusterTLSConfig(ctx context.Context, repClusters *ReplicatedClusters, perfStandbyCluster *ReplicatedCluster) (*tls.Config, error) {
 	// Using lookup functions allows just-in-time lookup of the current state
 	// of clustering as connections come and go
 
 	tlsConfig := &tls.Config{
-		ClientAuth:           tls.RequireAndVerifyClientCert,
+		ClientAuth:           c.clusterClientAuth,
 		GetCertificate:       clusterTLSServerLookup(ctx, c, repClusters, perfStandbyCluster),
 		GetClientCertificate: clusterTLSClientLookup(ctx, c, repClusters, perfStandbyCluster),
 		GetConfigForClient:   clusterTLSServerConfigLookup(ctx, c, repClusters, perfStandbyCluster),
@@ -361,22 +751,124 @@ func (c *Core) ClusterTLSConfig(ctx context.Context, repClusters *ReplicatedClus
 	if parsedCert != nil {
 		tlsConfig.ServerName = parsedCert.Subject.CommonName
 
-		pool := x509.NewCertPool()
-		pool.AddCert(parsedCert)
+		pool := c.clusterCertPool()
 		tlsConfig.RootCAs = pool
 		tlsConfig.ClientCAs = pool
 	}
 
+	c.setClusterInsecureSkipVerify(tlsConfig)
+
 	return tlsConfig, nil
 }
 
+// setClusterInsecureSkipVerify turns off certificate verification on the
+// given cluster TLS config if CoreConfig.ClusterInsecureSkipVerify is set,
+// warning on every handshake made with it.
+func (c *Core) setClusterInsecureSkipVerify(tlsConfig *tls.Config) {
+	if !c.clusterInsecureSkipVerify {
+		return
+	}
+
+	tlsConfig.InsecureSkipVerify = true
+	tlsConfig.VerifyPeerCertificate = func([][]byte, [][]*x509.Certificate) error {
+		c.logger.Warn("cluster connection established WITHOUT certificate verification; disable cluster_insecure_skip_verify outside of debugging")
+		return nil
+	}
+}
+
+// allowTrustedPeerServers lets a dialer using the given cluster TLS config
+// connect to nodes of other clusters trusted through
+// CoreConfig.ClusterTrustedPeerCerts. Their certs are issued for their own
+// cluster's server name, so the name is only checked for other servers.
+func (c *Core) allowTrustedPeerServers(tlsConfig *tls.Config) {
+	if len(c.clusterTrustedPeerCerts) == 0 || tlsConfig.InsecureSkipVerify {
+		return
+	}
+
+	roots, serverName := tlsConfig.RootCAs, tlsConfig.ServerName
+	tlsConfig.InsecureSkipVerify = true
+	tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("no cluster certificate presented")
+		}
+		certs := make([]*x509.Certificate, len(rawCerts))
+		for i, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return err
+			}
+			certs[i] = cert
+		}
+
+		opts := x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: x509.NewCertPool(),
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		}
+		for _, cert := range certs[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+		if !c.isClusterTrustedPeer(certs[0]) {
+			opts.DNSName = serverName
+		}
+		_, err := certs[0].Verify(opts)
+		return err
+	}
+}
+
+// SetClusterListenerAddrs sets the addresses cluster listeners bind to,
+// unless CoreConfig.ClusterListenAddrs was given.
 func (c *Core) SetClusterListenerAddrs(addrs []*net.TCPAddr) {
 	c.clusterListenerAddrs = addrs
+	if len(c.clusterListenAddrs) > 0 {
+		return
+	}
 	if c.clusterAddr == "" && len(addrs) == 1 {
 		c.clusterAddr = fmt.Sprintf("https://%s", addrs[0].String())
 	}
 }
 
+// clusterBindAddrs returns the addresses cluster listeners bind to.
+func (c *Core) clusterBindAddrs() []*net.TCPAddr {
+	if len(c.clusterListenAddrs) > 0 {
+		return c.clusterListenAddrs
+	}
+	return c.clusterListenerAddrs
+}
+
+// ClusterListenerAddrs returns the addresses the cluster listeners are
+// serving on, which may differ from the configured ones, e.g. when binding to
+// port 0. It is empty if no cluster listeners are running, such as on a
+// standby or sealed node.
+func (c *Core) ClusterListenerAddrs() []net.Addr {
+	c.clusterListenerBoundAddrsLock.RLock()
+	defer c.clusterListenerBoundAddrsLock.RUnlock()
+
+	addrs := make([]net.Addr, len(c.clusterListenerBoundAddrs))
+	copy(addrs, c.clusterListenerBoundAddrs)
+	sort.Slice(addrs, func(i, j int) bool {
+		return addrs[i].String() < addrs[j].String()
+	})
+	return addrs
+}
+
+func (c *Core) addClusterListenerBoundAddr(addr net.Addr) {
+	c.clusterListenerBoundAddrsLock.Lock()
+	defer c.clusterListenerBoundAddrsLock.Unlock()
+	c.clusterListenerBoundAddrs = append(c.clusterListenerBoundAddrs, addr)
+}
+
+func (c *Core) removeClusterListenerBoundAddr(addr net.Addr) {
+	c.clusterListenerBoundAddrsLock.Lock()
+	defer c.clusterListenerBoundAddrsLock.Unlock()
+	for i, boundAddr := range c.clusterListenerBoundAddrs {
+		if boundAddr == addr {
+			c.clusterListenerBoundAddrs = append(c.clusterListenerBoundAddrs[:i], c.clusterListenerBoundAddrs[i+1:]...)
+			return
+		}
+	}
+}
+
 func (c *Core) SetClusterHandler(handler http.Handler) {
 	c.clusterHandler = handler
 }
@@ -0,0 +1,270 @@
+package vault
+
+import (
+	"context"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/helper/consts"
+	"github.com/hashicorp/vault/helper/jsonutil"
+)
+
+const (
+	// coreClusterMembersPrefix is the storage prefix under which every node
+	// records its membership heartbeat, keyed by node ID. Entries are written
+	// by other nodes, so the prefix is excluded from the physical cache.
+	coreClusterMembersPrefix = "core/cluster/members/"
+
+	// These are the roles a node can report in its membership heartbeat
+	ClusterMemberRoleActive             = "active"
+	ClusterMemberRoleStandby            = "standby"
+	ClusterMemberRolePerformanceStandby = "performance-standby"
+)
+
+const (
+	// defaultMemberHeartbeatTTL is how long a membership entry may go
+	// without being refreshed before the node is no longer considered a
+	// member
+	defaultMemberHeartbeatTTL = 30 * time.Second
+
+	// defaultMemberScanInterval is how often the active node looks for
+	// members to evict
+	defaultMemberScanInterval = 10 * time.Second
+)
+
+var (
+	// memberHeartbeatInterval is how often a node refreshes its membership
+	// entry
+	memberHeartbeatInterval = 5 * time.Second
+)
+
+// ClusterMember is the membership entry a node periodically writes to
+// storage to announce itself to the rest of the cluster.
+type ClusterMember struct {
+	NodeID      string    `json:"node_id" structs:"node_id" mapstructure:"node_id"`
+	APIAddr     string    `json:"api_addr" structs:"api_addr" mapstructure:"api_addr"`
+	ClusterAddr string    `json:"cluster_addr" structs:"cluster_addr" mapstructure:"cluster_addr"`
+	ClusterID   string    `json:"cluster_id" structs:"cluster_id" mapstructure:"cluster_id"`
+	Role        string    `json:"role" structs:"role" mapstructure:"role"`
+	LastSeen    time.Time `json:"last_seen" structs:"last_seen" mapstructure:"last_seen"`
+}
+
+// ClusterMembers returns the nodes that have sent a membership heartbeat
+// within the member heartbeat TTL, sorted by node
+// ID. This method errors out when Vault is sealed.
+func (c *Core) ClusterMembers() ([]*ClusterMember, error) {
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+	if c.Sealed() {
+		return nil, consts.ErrSealed
+	}
+
+	members, err := c.readClusterMembers(c.activeContext)
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := time.Now().Add(-c.memberHeartbeatTTL)
+	var live []*ClusterMember
+	for _, member := range members {
+		if member.LastSeen.Before(cutoff) {
+			continue
+		}
+		live = append(live, member)
+	}
+	return live, nil
+}
+
+// readClusterMembers returns every stored membership entry, stale or not,
+// sorted by node ID.
+func (c *Core) readClusterMembers(ctx context.Context) ([]*ClusterMember, error) {
+	keys, err := c.barrier.List(ctx, coreClusterMembersPrefix)
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to list cluster members: {{err}}", err)
+	}
+
+	members := make([]*ClusterMember, 0, len(keys))
+	for _, key := range keys {
+		entry, err := c.barrier.Get(ctx, coreClusterMembersPrefix+key)
+		if err != nil {
+			return nil, errwrap.Wrapf("failed to read cluster member: {{err}}", err)
+		}
+		if entry == nil {
+			continue
+		}
+
+		var member ClusterMember
+		if err := jsonutil.DecodeJSON(entry.Value, &member); err != nil {
+			return nil, errwrap.Wrapf("failed to decode cluster member: {{err}}", err)
+		}
+		members = append(members, &member)
+	}
+
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].NodeID < members[j].NodeID
+	})
+	return members, nil
+}
+
+// writeMembershipHeartbeat records this node's current membership entry.
+func (c *Core) writeMembershipHeartbeat(ctx context.Context) error {
+	if c.nodeID == "" {
+		return nil
+	}
+
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+	if c.Sealed() {
+		return nil
+	}
+
+	role := ClusterMemberRoleActive
+	switch {
+	case c.perfStandby:
+		role = ClusterMemberRolePerformanceStandby
+	case c.standby:
+		role = ClusterMemberRoleStandby
+	}
+
+	cluster, err := c.Cluster(ctx)
+	if err != nil {
+		return err
+	}
+
+	member := &ClusterMember{
+		NodeID:      c.nodeID,
+		APIAddr:     c.redirectAddr,
+		ClusterAddr: c.clusterAddr,
+		ClusterID:   cluster.ID,
+		Role:        role,
+		LastSeen:    time.Now().UTC(),
+	}
+	value, err := jsonutil.EncodeJSON(member)
+	if err != nil {
+		return err
+	}
+
+	c.clusterMembersLock.Lock()
+	defer c.clusterMembersLock.Unlock()
+	return c.barrier.Put(ctx, &Entry{
+		Key:   coreClusterMembersPrefix + c.nodeID,
+		Value: value,
+	})
+}
+
+// periodicMembershipHeartbeat refreshes this node's membership entry until
+// the stop channel is closed. The write happens in its own goroutine so that
+// a seal waiting on this actor while holding the state lock can't deadlock
+// with it.
+func (c *Core) periodicMembershipHeartbeat(ctx context.Context, stopCh chan struct{}) {
+	opCount := new(int32)
+	heartbeat := func() {
+		if count := atomic.AddInt32(opCount, 1); count > 1 {
+			atomic.AddInt32(opCount, -1)
+			return
+		}
+
+		go func() {
+			// Bind locally, as the race detector is tripping here
+			lopCount := opCount
+			defer atomic.AddInt32(lopCount, -1)
+
+			if err := c.writeMembershipHeartbeat(ctx); err != nil {
+				c.logger.Error("failed to write cluster membership heartbeat", "error", err)
+			}
+		}()
+	}
+
+	heartbeat()
+	for {
+		select {
+		case <-time.After(memberHeartbeatInterval):
+			heartbeat()
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// periodicEvictStaleMembers runs on the active node, removing the entries of
+// members that haven't sent a heartbeat within the member heartbeat TTL
+// until the context is canceled or leadership is lost.
+func (c *Core) periodicEvictStaleMembers(ctx context.Context, leaderLostCh <-chan struct{}) {
+	for {
+		select {
+		case <-time.After(c.memberScanInterval):
+			if err := c.evictStaleMembers(ctx); err != nil {
+				c.logger.Error("failed to evict stale cluster members", "error", err)
+			}
+		case <-ctx.Done():
+			return
+		case <-leaderLostCh:
+			return
+		}
+	}
+}
+
+// evictStaleMembers deletes the entries of members whose heartbeats are
+// older than the member heartbeat TTL.
+func (c *Core) evictStaleMembers(ctx context.Context) error {
+	members, err := c.readClusterMembers(ctx)
+	if err != nil {
+		return err
+	}
+
+	cutoff := time.Now().Add(-c.memberHeartbeatTTL)
+	for _, member := range members {
+		if !member.LastSeen.Before(cutoff) {
+			continue
+		}
+		evicted, err := c.evictStaleMember(ctx, member.NodeID, cutoff)
+		if err != nil {
+			return err
+		}
+		if evicted == nil {
+			continue
+		}
+
+		c.logger.Warn("evicted cluster member that stopped sending heartbeats", "node_id", evicted.NodeID, "last_seen", evicted.LastSeen)
+		metrics.IncrCounter([]string{"core", "cluster", "member_evicted"}, 1)
+		if c.onMemberEvicted != nil {
+			go c.onMemberEvicted(evicted)
+		}
+	}
+	return nil
+}
+
+// evictStaleMember deletes the entry of the given member if its heartbeat is
+// still older than cutoff, and returns the deleted entry. The entry is read
+// again right before deleting it, so that a member that sent a heartbeat
+// since the scan is kept. Storage has no conditional delete, so a heartbeat
+// landing in between is still lost; the member writes it again within the
+// heartbeat interval, well inside the TTL.
+func (c *Core) evictStaleMember(ctx context.Context, nodeID string, cutoff time.Time) (*ClusterMember, error) {
+	c.clusterMembersLock.Lock()
+	defer c.clusterMembersLock.Unlock()
+
+	key := coreClusterMembersPrefix + nodeID
+	entry, err := c.barrier.Get(ctx, key)
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to read cluster member: {{err}}", err)
+	}
+	if entry == nil {
+		return nil, nil
+	}
+	var member ClusterMember
+	if err := jsonutil.DecodeJSON(entry.Value, &member); err != nil {
+		return nil, errwrap.Wrapf("failed to decode cluster member: {{err}}", err)
+	}
+	if !member.LastSeen.Before(cutoff) {
+		return nil, nil
+	}
+
+	if err := c.barrier.Delete(ctx, key); err != nil {
+		return nil, errwrap.Wrapf("failed to delete cluster member: {{err}}", err)
+	}
+	return &member, nil
+}
@@ -0,0 +1,144 @@
+package vault
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/vault/helper/forwarding"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// clusterPeerStatsMaxPeers caps the number of peers accounted at once. Node
+// IDs are announced by the peers themselves, so a peer could claim any
+// number of them; the least recently seen peer makes room for a new one.
+const clusterPeerStatsMaxPeers = 256
+
+// ClusterPeerID identifies a node that forwards requests to this one. The
+// nodes of a cluster share the cluster cert, so the node ID a peer announces
+// tells standbys apart, while the fingerprint ties it to the cert it was
+// verified with. The node ID is not verified, and is empty for requests
+// forwarded over connections that don't carry it, such as upgrade and
+// logical request forwarding. Peers that presented no verified cert have an
+// empty fingerprint.
+type ClusterPeerID struct {
+	CertFingerprint string
+	NodeID          string
+}
+
+// ClusterPeerStats holds the requests a peer has forwarded to this node
+type ClusterPeerStats struct {
+	Requests uint64
+	Bytes    uint64
+	LastSeen time.Time
+}
+
+// clusterPeerStats accounts the requests forwarded to this node per peer
+type clusterPeerStats struct {
+	l     sync.Mutex
+	peers map[ClusterPeerID]*ClusterPeerStats
+}
+
+func newClusterPeerStats() *clusterPeerStats {
+	return &clusterPeerStats{
+		peers: make(map[ClusterPeerID]*ClusterPeerStats),
+	}
+}
+
+func (s *clusterPeerStats) record(id ClusterPeerID, requests, bytes int, now time.Time) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	stats, ok := s.peers[id]
+	if !ok {
+		if len(s.peers) >= clusterPeerStatsMaxPeers {
+			s.evictOldest()
+		}
+		stats = new(ClusterPeerStats)
+		s.peers[id] = stats
+	}
+	stats.Requests += uint64(requests)
+	stats.Bytes += uint64(bytes)
+	stats.LastSeen = now
+}
+
+// evictOldest drops the least recently seen peer. The lock must be held.
+func (s *clusterPeerStats) evictOldest() {
+	var oldest ClusterPeerID
+	var oldestSeen time.Time
+	first := true
+	for id, stats := range s.peers {
+		if first || stats.LastSeen.Before(oldestSeen) {
+			oldest, oldestSeen, first = id, stats.LastSeen, false
+		}
+	}
+	delete(s.peers, oldest)
+}
+
+// recordForwardedRequest accounts a request forwarded to this node to the
+// peer that sent it
+func (c *Core) recordForwardedRequest(ctx context.Context, freq *forwarding.Request) {
+	c.clusterPeerStats.record(clusterPeerIDFromContext(ctx), 1, proto.Size(freq), c.clock.Now())
+}
+
+// clusterPeerIDFromContext returns the identity of the peer of an incoming
+// forwarding RPC
+func clusterPeerIDFromContext(ctx context.Context) ClusterPeerID {
+	var id ClusterPeerID
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			id = clusterPeerIDFromTLSState(info.State)
+		}
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(forwardingNodeIDMetadataKey); len(ids) > 0 {
+			id.NodeID = ids[0]
+		}
+	}
+	return id
+}
+
+// clusterPeerIDFromTLSState returns the identity of the peer of an incoming
+// cluster connection, which carries no node ID
+func clusterPeerIDFromTLSState(state tls.ConnectionState) ClusterPeerID {
+	var id ClusterPeerID
+	if chains := state.VerifiedChains; len(chains) > 0 && len(chains[0]) > 0 {
+		sum := sha256.Sum256(chains[0][0].Raw)
+		id.CertFingerprint = hex.EncodeToString(sum[:])
+	}
+	return id
+}
+
+// countingConn counts the bytes read from a connection
+type countingConn struct {
+	net.Conn
+	read uint64
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddUint64(&c.read, uint64(n))
+	return n, err
+}
+
+// PeerStats returns the number and total size of the requests each peer has
+// forwarded to this node since it started, for spotting a misbehaving
+// standby. Sizes are those of the forwarded requests as received.
+func (c *Core) PeerStats() map[ClusterPeerID]ClusterPeerStats {
+	c.clusterPeerStats.l.Lock()
+	defer c.clusterPeerStats.l.Unlock()
+
+	out := make(map[ClusterPeerID]ClusterPeerStats, len(c.clusterPeerStats.peers))
+	for id, stats := range c.clusterPeerStats.peers {
+		out[id] = *stats
+	}
+	return out
+}
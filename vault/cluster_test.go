@@ -1,25 +1,57 @@
 package vault
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	mathrand "math/rand"
 	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/hashicorp/errwrap"
 	log "github.com/hashicorp/go-hclog"
+	sockaddr "github.com/hashicorp/go-sockaddr"
+	uuid "github.com/hashicorp/go-uuid"
 	"github.com/hashicorp/vault/helper/consts"
+	"github.com/hashicorp/vault/helper/forwarding"
+	"github.com/hashicorp/vault/helper/jsonutil"
 	"github.com/hashicorp/vault/helper/logging"
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/helper/strutil"
 	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/physical"
 	"github.com/hashicorp/vault/physical/inmem"
+	"google.golang.org/grpc"
 )
 
 var (
 	clusterTestPausePeriod = 2 * time.Second
+	clusterTestWaitTimeout = 30 * time.Second
+
+	// Make step-downs nicer for tests
+	clusterTestStepDownSleepPeriod = 5 * time.Second
 )
 
 func TestClusterFetching(t *testing.T) {
@@ -40,6 +72,129 @@ func TestClusterFetching(t *testing.T) {
 	}
 }
 
+func TestCluster_ClusterIdentitySealed(t *testing.T) {
+	c, _, token := TestCoreUnsealed(t)
+
+	expected, err := c.ClusterIdentity(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected == nil || expected.Name == "" || expected.ID == "" {
+		t.Fatalf("cluster identity missing: %#v", expected)
+	}
+
+	if err := c.Seal(token); err != nil {
+		t.Fatal(err)
+	}
+	if !c.Sealed() {
+		t.Fatal("should be sealed")
+	}
+
+	// The barrier copy can't be read any more, but the identity can
+	if _, err := c.Cluster(context.Background()); err == nil {
+		t.Fatal("expected an error reading cluster details while sealed")
+	}
+	cluster, err := c.ClusterIdentity(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cluster, expected) {
+		t.Fatalf("bad cluster identity while sealed: got %#v, expected %#v", cluster, expected)
+	}
+
+	// Only the name and identifier are kept outside the barrier
+	entry, err := c.physical.Get(context.Background(), coreLocalClusterPublicInfoPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored map[string]interface{}
+	if err := jsonutil.DecodeJSON(entry.Value, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 2 || stored["name"] != expected.Name || stored["id"] != expected.ID {
+		t.Fatalf("unexpected public cluster identity entry: %s", entry.Value)
+	}
+
+	// Nothing is known about an uninitialized core
+	uninit := TestCore(t)
+	cluster, err = uninit.ClusterIdentity(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cluster != nil {
+		t.Fatalf("expected no cluster identity, got %#v", cluster)
+	}
+}
+
+func TestCluster_ClusterInfo(t *testing.T) {
+	// Without HA there is no cluster certificate to describe
+	c, _, _ := TestCoreUnsealed(t)
+	if err := c.setupCluster(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	info, err := c.ClusterInfo(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name == "" || info.ID == "" || info.CertSubjectCN != "" || len(info.CertSANs) != 0 {
+		t.Fatalf("bad cluster info: %#v", info)
+	}
+
+	logger := logging.NewVaultLogger(log.Trace)
+	inm, err := inmem.NewInmemHA(nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inmha, err := inmem.NewInmemHA(nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err = NewCore(&CoreConfig{
+		Physical:     inm,
+		HAPhysical:   inmha.(physical.HABackend),
+		RedirectAddr: "http://127.0.0.1:8200",
+		DisableMlock: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, root := TestCoreInit(t, c)
+	for _, key := range keys {
+		if _, err := TestCoreUnseal(c, TestKeyCopy(key)); err != nil {
+			t.Fatalf("unseal err: %s", err)
+		}
+	}
+	TestWaitActive(t, c)
+
+	cluster, err := c.Cluster(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err = c.ClusterInfo(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name != cluster.Name || info.ID != cluster.ID {
+		t.Fatalf("bad cluster details: %#v", info)
+	}
+	if info.CertSubjectCN == "" {
+		t.Fatal("missing certificate subject")
+	}
+	if !strutil.StrListContains(info.CertSANs, info.CertSubjectCN) {
+		t.Fatalf("expected SANs %v to contain %q", info.CertSANs, info.CertSubjectCN)
+	}
+	if !info.CertNotAfter.After(time.Now()) {
+		t.Fatalf("bad certificate expiry: %v", info.CertNotAfter)
+	}
+
+	if err := c.Seal(root); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ClusterInfo(context.Background()); err != consts.ErrSealed {
+		t.Fatalf("expected sealed error, got %v", err)
+	}
+}
+
 func TestClusterHAFetching(t *testing.T) {
 	logger := logging.NewVaultLogger(log.Trace)
 
@@ -88,10 +243,9 @@ func TestClusterHAFetching(t *testing.T) {
 }
 
 func TestCluster_ListenForRequests(t *testing.T) {
-	// Make this nicer for tests
-	manualStepDownSleepPeriod = 5 * time.Second
-
-	cluster := NewTestCluster(t, nil, &TestClusterOptions{
+	cluster := NewTestCluster(t, &CoreConfig{
+		ManualStepDownSleepPeriod: clusterTestStepDownSleepPeriod,
+	}, &TestClusterOptions{
 		KeepStandbysSealed: true,
 	})
 	cluster.Start()
@@ -102,7 +256,8 @@ func TestCluster_ListenForRequests(t *testing.T) {
 	TestWaitActive(t, cores[0].Core)
 
 	// Use this to have a valid config after sealing since ClusterTLSConfig returns nil
-	var lastTLSConfig *tls.Config
+	lastTLSConfig := cores[0].ClusterTLS.Clone()
+	lastTLSConfig.NextProtos = []string{"h2"}
 	checkListenersFunc := func(expectFail bool) {
 		tlsConfig, err := cores[0].ClusterTLSConfig(context.Background(), nil, nil)
 		if err != nil {
@@ -115,22 +270,17 @@ func TestCluster_ListenForRequests(t *testing.T) {
 			lastTLSConfig = tlsConfig
 		}
 
-		for _, ln := range cores[0].Listeners {
-			tcpAddr, ok := ln.Addr().(*net.TCPAddr)
-			if !ok {
-				t.Fatalf("%s not a TCP port", tcpAddr.String())
-			}
-
-			conn, err := tls.Dial("tcp", fmt.Sprintf("%s:%d", tcpAddr.IP.String(), tcpAddr.Port+105), tlsConfig)
+		for _, addr := range cores[0].ClusterAddrs {
+			conn, err := tls.Dial("tcp", addr.String(), tlsConfig)
 			if err != nil {
 				if expectFail {
-					t.Logf("testing %s:%d unsuccessful as expected", tcpAddr.IP.String(), tcpAddr.Port+105)
+					t.Logf("testing %s unsuccessful as expected", addr)
 					continue
 				}
-				t.Fatalf("error: %v\nlisteners are\n%#v\n%#v\n", err, cores[0].Listeners[0], cores[0].Listeners[1])
+				t.Fatalf("error: %v\ncluster addresses are %v", err, cores[0].ClusterAddrs)
 			}
 			if expectFail {
-				t.Fatalf("testing %s:%d not unsuccessful as expected", tcpAddr.IP.String(), tcpAddr.Port+105)
+				t.Fatalf("testing %s not unsuccessful as expected", addr)
 			}
 			err = conn.Handshake()
 			if err != nil {
@@ -143,274 +293,2763 @@ func TestCluster_ListenForRequests(t *testing.T) {
 			case connState.NegotiatedProtocol != "h2" || !connState.NegotiatedProtocolIsMutual:
 				t.Fatal("bad protocol negotiation")
 			}
-			t.Logf("testing %s:%d successful", tcpAddr.IP.String(), tcpAddr.Port+105)
+			t.Logf("testing %s successful", addr)
 		}
 	}
 
 	time.Sleep(clusterTestPausePeriod)
 	checkListenersFunc(false)
 
-	err := cores[0].StepDown(context.Background(), &logical.Request{
-		Operation:   logical.UpdateOperation,
-		Path:        "sys/step-down",
-		ClientToken: cluster.RootToken,
-	})
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	// StepDown doesn't wait during actual preSeal so give time for listeners
-	// to close
-	time.Sleep(clusterTestPausePeriod)
+	testCluster_StepDown(t, cores[0], cluster.RootToken)
 	checkListenersFunc(true)
 
 	// After this period it should be active again
-	time.Sleep(manualStepDownSleepPeriod)
+	time.Sleep(clusterTestStepDownSleepPeriod)
 	checkListenersFunc(false)
 
-	err = cores[0].Seal(cluster.RootToken)
+	ctx, cancel := context.WithTimeout(context.Background(), clusterTestWaitTimeout)
+	defer cancel()
+	err := cores[0].SealWithContext(ctx, cluster.RootToken)
 	if err != nil {
 		t.Fatal(err)
 	}
-	time.Sleep(clusterTestPausePeriod)
 	// After sealing it should be inactive again
 	checkListenersFunc(true)
 }
 
-func TestCluster_ForwardRequests(t *testing.T) {
-	// Make this nicer for tests
-	manualStepDownSleepPeriod = 5 * time.Second
+func TestCluster_ListenTCPWithRetry(t *testing.T) {
+	blocker, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := blocker.Addr().(*net.TCPAddr)
+
+	// Gives up once the timeout has passed
+	if _, err := listenTCPWithRetry(addr, 100*time.Millisecond); err == nil || !isAddrInUse(err) {
+		t.Fatalf("expected address in use error, got: %v", err)
+	}
 
-	testCluster_ForwardRequestsCommon(t)
+	// Succeeds once the address is released
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		blocker.Close()
+	}()
+	ln, err := listenTCPWithRetry(addr, clusterTestWaitTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
 }
 
-func testCluster_ForwardRequestsCommon(t *testing.T) {
-	cluster := NewTestCluster(t, nil, nil)
-	cores := cluster.Cores
-	cores[0].Handler.(*http.ServeMux).HandleFunc("/core1", func(w http.ResponseWriter, req *http.Request) {
-		w.Header().Add("Content-Type", "application/json")
-		w.WriteHeader(201)
-		w.Write([]byte("core1"))
-	})
-	cores[1].Handler.(*http.ServeMux).HandleFunc("/core2", func(w http.ResponseWriter, req *http.Request) {
-		w.Header().Add("Content-Type", "application/json")
-		w.WriteHeader(202)
-		w.Write([]byte("core2"))
-	})
-	cores[2].Handler.(*http.ServeMux).HandleFunc("/core3", func(w http.ResponseWriter, req *http.Request) {
-		w.Header().Add("Content-Type", "application/json")
-		w.WriteHeader(203)
-		w.Write([]byte("core3"))
+func TestCluster_ImmediateReunseal(t *testing.T) {
+	cluster := NewTestCluster(t, nil, &TestClusterOptions{
+		NumCores: 1,
 	})
 	cluster.Start()
 	defer cluster.Cleanup()
+	core := cluster.Cores[0]
 
-	root := cluster.RootToken
+	TestWaitActive(t, core.Core)
 
-	// Wait for core to become active
-	TestWaitActive(t, cores[0].Core)
+	for i := 0; i < 3; i++ {
+		if err := core.Seal(cluster.RootToken); err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range cluster.BarrierKeys {
+			if _, err := core.Unseal(TestKeyCopy(key)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		TestWaitActive(t, core.Core)
 
-	// Test forwarding a request. Since we're going directly from core to core
-	// with no fallback we know that if it worked, request handling is working
-	testCluster_ForwardRequests(t, cores[1], root, "core1")
-	testCluster_ForwardRequests(t, cores[2], root, "core1")
+		// The cluster listener must be serving again
+		tlsConfig, err := core.ClusterTLSConfig(context.Background(), nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tlsConfig.NextProtos = []string{"h2"}
+		var conn *tls.Conn
+		deadline := time.Now().Add(clusterTestWaitTimeout)
+		for {
+			conn, err = tls.Dial("tcp", core.ClusterAddrs[0].String(), tlsConfig)
+			if err == nil || time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		if err != nil {
+			t.Fatalf("cluster listener not serving after re-unseal %d: %v", i, err)
+		}
+		conn.Close()
+	}
+}
 
-	//
-	// Now we do a bunch of round-robining. The point is to make sure that as
-	// nodes come and go, we can always successfully forward to the active
-	// node.
-	//
+func TestCluster_StepDownContext(t *testing.T) {
+	cluster := NewTestCluster(t, &CoreConfig{
+		ManualStepDownSleepPeriod: clusterTestStepDownSleepPeriod,
+	}, nil)
+	cluster.Start()
+	defer cluster.Cleanup()
+	cores := cluster.Cores
 
-	// Ensure active core is cores[1] and test
-	err := cores[0].StepDown(context.Background(), &logical.Request{
+	TestWaitActive(t, cores[0].Core)
+
+	req := &logical.Request{
 		Operation:   logical.UpdateOperation,
 		Path:        "sys/step-down",
-		ClientToken: root,
-	})
-	if err != nil {
-		t.Fatal(err)
+		ClientToken: cluster.RootToken,
 	}
-	time.Sleep(clusterTestPausePeriod)
-	_ = cores[2].StepDown(context.Background(), &logical.Request{
-		Operation:   logical.UpdateOperation,
-		Path:        "sys/step-down",
-		ClientToken: root,
-	})
-	time.Sleep(clusterTestPausePeriod)
-	TestWaitActive(t, cores[1].Core)
-	testCluster_ForwardRequests(t, cores[0], root, "core2")
-	testCluster_ForwardRequests(t, cores[2], root, "core2")
 
-	// Ensure active core is cores[2] and test
-	err = cores[1].StepDown(context.Background(), &logical.Request{
-		Operation:   logical.UpdateOperation,
-		Path:        "sys/step-down",
-		ClientToken: root,
-	})
-	if err != nil {
+	// A standby has nothing to give up, so this shouldn't block
+	ctx, cancel := context.WithTimeout(context.Background(), clusterTestWaitTimeout)
+	defer cancel()
+	if err := cores[1].StepDownContext(ctx, req); err != nil {
 		t.Fatal(err)
 	}
-	time.Sleep(clusterTestPausePeriod)
-	_ = cores[0].StepDown(context.Background(), &logical.Request{
-		Operation:   logical.UpdateOperation,
-		Path:        "sys/step-down",
-		ClientToken: root,
-	})
-	time.Sleep(clusterTestPausePeriod)
-	TestWaitActive(t, cores[2].Core)
-	testCluster_ForwardRequests(t, cores[0], root, "core3")
-	testCluster_ForwardRequests(t, cores[1], root, "core3")
 
-	// Ensure active core is cores[0] and test
-	err = cores[2].StepDown(context.Background(), &logical.Request{
-		Operation:   logical.UpdateOperation,
-		Path:        "sys/step-down",
-		ClientToken: root,
-	})
-	if err != nil {
+	if err := cores[0].StepDownContext(ctx, req); err != nil {
 		t.Fatal(err)
 	}
-	time.Sleep(clusterTestPausePeriod)
-	_ = cores[1].StepDown(context.Background(), &logical.Request{
-		Operation:   logical.UpdateOperation,
-		Path:        "sys/step-down",
-		ClientToken: root,
-	})
-	time.Sleep(clusterTestPausePeriod)
-	TestWaitActive(t, cores[0].Core)
-	testCluster_ForwardRequests(t, cores[1], root, "core1")
-	testCluster_ForwardRequests(t, cores[2], root, "core1")
-
-	// Ensure active core is cores[1] and test
-	err = cores[0].StepDown(context.Background(), &logical.Request{
-		Operation:   logical.UpdateOperation,
-		Path:        "sys/step-down",
-		ClientToken: root,
-	})
+	standby, err := cores[0].Standby()
 	if err != nil {
 		t.Fatal(err)
 	}
-	time.Sleep(clusterTestPausePeriod)
-	_ = cores[2].StepDown(context.Background(), &logical.Request{
-		Operation:   logical.UpdateOperation,
-		Path:        "sys/step-down",
-		ClientToken: root,
-	})
-	time.Sleep(clusterTestPausePeriod)
-	TestWaitActive(t, cores[1].Core)
-	testCluster_ForwardRequests(t, cores[0], root, "core2")
-	testCluster_ForwardRequests(t, cores[2], root, "core2")
+	if !standby {
+		t.Fatal("expected core to be in standby once StepDownContext returned")
+	}
 
-	// Ensure active core is cores[2] and test
-	err = cores[1].StepDown(context.Background(), &logical.Request{
-		Operation:   logical.UpdateOperation,
-		Path:        "sys/step-down",
-		ClientToken: root,
-	})
-	if err != nil {
-		t.Fatal(err)
+	// An expired context is reported rather than waited out
+	testWaitAnyActive(t, cores[1], cores[2])
+	active := cores[1]
+	if standby, _ := active.Standby(); standby {
+		active = cores[2]
+	}
+	expiredCtx, expiredCancel := context.WithCancel(context.Background())
+	expiredCancel()
+	if err := active.StepDownContext(expiredCtx, req); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got: %v", err)
 	}
-	time.Sleep(clusterTestPausePeriod)
-	_ = cores[0].StepDown(context.Background(), &logical.Request{
-		Operation:   logical.UpdateOperation,
-		Path:        "sys/step-down",
-		ClientToken: root,
-	})
-	time.Sleep(clusterTestPausePeriod)
-	TestWaitActive(t, cores[2].Core)
-	testCluster_ForwardRequests(t, cores[0], root, "core3")
-	testCluster_ForwardRequests(t, cores[1], root, "core3")
 }
 
-func testCluster_ForwardRequests(t *testing.T, c *TestClusterCore, rootToken, remoteCoreID string) {
-	standby, err := c.Standby()
+func TestCluster_SeparateListenAddrs(t *testing.T) {
+	// Find a free port on a second loopback address to keep cluster traffic
+	// off the address the API listens on
+	ln, err := net.Listen("tcp", "127.0.0.2:0")
 	if err != nil {
-		t.Fatal(err)
-	}
-	if !standby {
-		t.Fatal("expected core to be standby")
+		t.Skipf("second loopback address not available: %v", err)
 	}
+	clusterAddr := ln.Addr().(*net.TCPAddr)
+	ln.Close()
 
-	// We need to call Leader as that refreshes the connection info
-	isLeader, _, _, err := c.Leader()
-	if err != nil {
-		t.Fatal(err)
+	cluster := NewTestCluster(t, &CoreConfig{
+		ClusterListenAddrs: []*net.TCPAddr{clusterAddr},
+	}, &TestClusterOptions{
+		NumCores: 1,
+	})
+	cluster.Start()
+	defer cluster.Cleanup()
+	core := cluster.Cores[0]
+
+	TestWaitActive(t, core.Core)
+
+	if len(core.ClusterAddrs) != 1 || core.ClusterAddrs[0].String() != clusterAddr.String() {
+		t.Fatalf("bad cluster addresses: %v", core.ClusterAddrs)
 	}
-	if isLeader {
-		t.Fatal("core should not be leader")
+	apiAddr := core.Listeners[0].Address
+	if apiAddr.IP.Equal(clusterAddr.IP) {
+		t.Fatalf("API and cluster listeners share an address: %s", apiAddr.IP)
 	}
 
-	bodBuf := bytes.NewReader([]byte(`{ "foo": "bar", "zip": "zap" }`))
-	req, err := http.NewRequest("PUT", "https://pushit.real.good:9281/"+remoteCoreID, bodBuf)
+	tlsConfig, err := core.ClusterTLSConfig(context.Background(), nil, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
-	req.Header.Add(consts.AuthHeaderName, rootToken)
-	req = req.WithContext(context.WithValue(req.Context(), "original_request_path", req.URL.Path))
+	tlsConfig.NextProtos = []string{"h2"}
 
-	statusCode, header, respBytes, err := c.ForwardRequest(req)
+	conn, err := tls.Dial("tcp", clusterAddr.String(), tlsConfig)
 	if err != nil {
-		t.Fatalf("err: %v", err)
-	}
-
-	if header == nil {
-		t.Fatal("err: expected at least a content-type header")
+		t.Fatal(err)
 	}
-	if header.Get("Content-Type") != "application/json" {
-		t.Fatalf("bad content-type: %s", header.Get("Content-Type"))
+	if err := conn.Handshake(); err != nil {
+		t.Fatal(err)
 	}
+	conn.Close()
 
-	body := string(respBytes)
-
-	if body != remoteCoreID {
-		t.Fatalf("expected %s, got %s", remoteCoreID, body)
+	// Nothing should be listening where the cluster port would have been
+	// derived from the API listener
+	derived := &net.TCPAddr{
+		IP:   apiAddr.IP,
+		Port: apiAddr.Port + 105,
 	}
-	switch body {
-	case "core1":
-		if statusCode != 201 {
-			t.Fatal("bad response")
-		}
-	case "core2":
-		if statusCode != 202 {
-			t.Fatal("bad response")
-		}
-	case "core3":
-		if statusCode != 203 {
-			t.Fatal("bad response")
-		}
+	if conn, err := tls.Dial("tcp", derived.String(), tlsConfig); err == nil {
+		conn.Close()
+		t.Fatalf("cluster listener bound to derived address %s", derived)
 	}
 }
 
-func TestCluster_CustomCipherSuites(t *testing.T) {
-	cluster := NewTestCluster(t, &CoreConfig{
-		ClusterCipherSuites: "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA",
-	}, nil)
+func TestCluster_SealWithContext(t *testing.T) {
+	cluster := NewTestCluster(t, nil, &TestClusterOptions{
+		KeepStandbysSealed: true,
+	})
 	cluster.Start()
 	defer cluster.Cleanup()
 	core := cluster.Cores[0]
 
-	// Wait for core to become active
 	TestWaitActive(t, core.Core)
 
-	tlsConf, err := core.Core.ClusterTLSConfig(context.Background(), nil, nil)
-	if err != nil {
+	ctx, cancel := context.WithTimeout(context.Background(), clusterTestWaitTimeout)
+	defer cancel()
+	if err := core.SealWithContext(ctx, cluster.RootToken); err != nil {
 		t.Fatal(err)
 	}
 
-	conn, err := tls.Dial("tcp", fmt.Sprintf("%s:%d", core.Listeners[0].Address.IP.String(), core.Listeners[0].Address.Port+105), tlsConf)
-	if err != nil {
-		t.Fatal(err)
+	// Everything should be torn down as soon as the call returns
+	if !core.Sealed() {
+		t.Fatal("should be sealed")
+	}
+	if core.expiration != nil {
+		t.Fatal("expiration manager should be stopped")
+	}
+	for _, addr := range core.ClusterAddrs {
+		if conn, err := net.Dial("tcp", addr.String()); err == nil {
+			conn.Close()
+			t.Fatalf("cluster listener on %s still accepting connections", addr)
+		}
+	}
+
+	// An expired context is reported without waiting on anything
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if err := core.SealWithContext(ctx, cluster.RootToken); err != nil && err != context.Canceled {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestCluster_ForwardRequests(t *testing.T) {
+	testCluster_ForwardRequestsCommon(t, nil)
+}
+
+func TestCluster_ForwardRequests_IPv6(t *testing.T) {
+	ln, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback not available: %v", err)
+	}
+	ln.Close()
+
+	testCluster_ForwardRequestsCommon(t, &TestClusterOptions{
+		BaseListenAddress: "[::1]:0",
+	})
+}
+
+func TestCluster_ForwardRequests_ClusterMismatch(t *testing.T) {
+	clusterA := NewTestCluster(t, nil, nil)
+	recorder := NewRecordingHandler()
+	recorder.StatusCode = 201
+	recorder.Header.Set("Content-Type", "application/json")
+	recorder.Body = []byte("core1")
+	clusterA.Cores[0].Handler.(*http.ServeMux).Handle("/core1", recorder)
+	clusterA.Start()
+	defer clusterA.Cleanup()
+
+	clusterB := NewTestCluster(t, nil, nil)
+	clusterB.Start()
+	defer clusterB.Cleanup()
+
+	TestWaitActive(t, clusterA.Cores[0].Core)
+	TestWaitActive(t, clusterB.Cores[0].Core)
+
+	// Forwarding within the cluster works
+	standby := clusterA.Cores[1]
+	testCluster_ForwardRequests(t, standby, clusterA.RootToken, "core1", recorder)
+
+	// Point the standby at cluster B's active node, as if it had been handed
+	// the wrong advertisement
+	activeB := clusterB.Cores[0].Core
+	key := activeB.localClusterPrivateKey.Load().(*ecdsa.PrivateKey)
+	adv := activeAdvertisement{
+		RedirectAddr: activeB.redirectAddr,
+		ClusterAddr:  activeB.clusterAddr,
+		ClusterCert:  activeB.localClusterCert.Load().([]byte),
+		ClusterKeyParams: &clusterKeyParams{
+			Type: corePrivateKeyTypeP521,
+			X:    key.X,
+			Y:    key.Y,
+			D:    key.D,
+		},
+	}
+	if err := standby.loadLocalClusterTLS(adv); err != nil {
+		t.Fatal(err)
+	}
+	if err := standby.refreshRequestForwardingConnection(context.Background(), adv.ClusterAddr); err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("PUT", "https://pushit.real.good:9281/core1", bytes.NewReader([]byte(`{}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add(consts.AuthHeaderName, clusterA.RootToken)
+	req = req.WithContext(context.WithValue(req.Context(), "original_request_path", req.URL.Path))
+
+	if _, _, _, err := standby.ForwardRequest(req); err != ErrForwardingClusterMismatch {
+		t.Fatalf("expected cluster mismatch error, got: %v", err)
+	}
+
+	// Logical requests are refused the same way
+	if _, err := standby.ForwardLogicalRequest(context.Background(), &logical.Request{
+		Operation:   logical.ReadOperation,
+		Path:        "sys/policy/default",
+		ClientToken: clusterA.RootToken,
+	}); err != ErrForwardingClusterMismatch {
+		t.Fatalf("expected cluster mismatch error for logical request, got: %v", err)
+	}
+
+	// So are upgrade requests, which find the active node on their own
+	standby.clusterLeaderParamsLock.Lock()
+	standby.clusterLeaderClusterAddr = activeB.clusterAddr
+	standby.clusterLeaderParamsLock.Unlock()
+	upgradeReq := httptest.NewRequest("GET", "/core1", nil)
+	upgradeReq.Header.Set("Connection", "Upgrade")
+	upgradeReq.Header.Set("Upgrade", "echo")
+	if err := standby.ForwardUpgradeRequest(hijackableRecorder{httptest.NewRecorder()}, upgradeReq); err != ErrForwardingClusterMismatch {
+		t.Fatalf("expected cluster mismatch error for upgrade request, got: %v", err)
+	}
+
+	// The active node refuses them itself too
+	called := false
+	handler := activeB.upgradeForwardingHandler(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		called = true
+	}), tls.ConnectionState{})
+	upgradeReq.Header.Set(upgradeForwardingClusterIDHeaderName, standby.localClusterID.Load().(string))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, upgradeReq)
+	if called || rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected the upgrade request to be refused, got status %d", rec.Code)
+	}
+	if id := rec.Header().Get(upgradeForwardingClusterIDHeaderName); id != activeB.localClusterID.Load().(string) {
+		t.Fatalf("bad cluster ID on the refusal: %q", id)
+	}
+}
+
+// hijackableRecorder is a ResponseRecorder that claims to support hijacking,
+// for upgrade requests that are expected to fail before being hijacked
+type hijackableRecorder struct {
+	*httptest.ResponseRecorder
+}
+
+func (r hijackableRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, errors.New("hijacking not supported")
+}
+
+func TestCluster_ForwardRequests_MaxRequestSize(t *testing.T) {
+	cluster := NewTestCluster(t, &CoreConfig{
+		MaxRequestSize: 64,
+	}, nil)
+	recorder := NewRecordingHandler()
+	recorder.StatusCode = 201
+	recorder.Header.Set("Content-Type", "application/json")
+	recorder.Body = []byte("core1")
+	cluster.Cores[0].Handler.(*http.ServeMux).Handle("/core1", recorder)
+	cluster.Start()
+	defer cluster.Cleanup()
+
+	TestWaitActive(t, cluster.Cores[0].Core)
+
+	// A small body is forwarded as usual
+	standby := cluster.Cores[1]
+	testCluster_ForwardRequests(t, standby, cluster.RootToken, "core1", recorder)
+
+	req, err := http.NewRequest("PUT", "https://pushit.real.good:9281/core1", bytes.NewReader([]byte(`{"foo":"a body that is well over the sixty-four bytes allowed here"}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add(consts.AuthHeaderName, cluster.RootToken)
+	req = req.WithContext(context.WithValue(req.Context(), "original_request_path", req.URL.Path))
+
+	if _, _, _, err := standby.ForwardRequest(req); err != ErrForwardedRequestTooLarge {
+		t.Fatalf("expected request too large error, got: %v", err)
+	}
+
+	// The active node enforces the limit on its own as well
+	server := newForwardedRequestRPCServer(context.Background(), cluster.Cores[0].Core, nil)
+	resp, err := server.ForwardRequest(context.Background(), &forwarding.Request{
+		Method: "PUT",
+		Url: &forwarding.URL{
+			Path: "/core1",
+		},
+		Body: []byte(`{"foo":"a body that is well over the sixty-four bytes allowed here"}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected status %d, got %d", http.StatusRequestEntityTooLarge, resp.StatusCode)
+	}
+}
+
+func TestCluster_ForwardRequests_Compression(t *testing.T) {
+	cluster := NewTestCluster(t, &CoreConfig{
+		ClusterCompression: true,
+	}, nil)
+	cluster.Cores[0].Handler.(*http.ServeMux).HandleFunc("/echo", func(w http.ResponseWriter, req *http.Request) {
+		if enc := req.Header.Get("Content-Encoding"); enc != "" {
+			w.WriteHeader(400)
+			w.Write([]byte("unexpected content encoding " + enc))
+			return
+		}
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			w.WriteHeader(500)
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(201)
+		w.Write(body)
+	})
+	cluster.Start()
+	defer cluster.Cleanup()
+
+	TestWaitActive(t, cluster.Cores[0].Core)
+
+	body := []byte(`{"data":"` + strings.Repeat("compressible", 4096) + `"}`)
+	req, err := http.NewRequest("PUT", "https://pushit.real.good:9281/echo", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add(consts.AuthHeaderName, cluster.RootToken)
+	req = req.WithContext(context.WithValue(req.Context(), "original_request_path", req.URL.Path))
+
+	standby := cluster.Cores[1]
+	if err := standby.RefreshForwarding(); err != nil {
+		t.Fatal(err)
+	}
+
+	statusCode, header, respBody, err := standby.ForwardRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != 201 {
+		t.Fatalf("bad status code %d: %s", statusCode, respBody)
+	}
+	if enc := header.Get("Content-Encoding"); enc != "" {
+		t.Fatalf("response still carries content encoding %q", enc)
+	}
+	if !bytes.Equal(respBody, body) {
+		t.Fatalf("bad response body of length %d", len(respBody))
+	}
+}
+
+func TestCluster_ForwardRequests_Rewrite(t *testing.T) {
+	cluster := NewTestCluster(t, &CoreConfig{
+		ForwardedRequestRewrite: func(scheme, host string) (string, string) {
+			if scheme != "https" || host != "pushit.real.good:9281" {
+				return scheme, host
+			}
+			return "http", "vault-active.proxy.internal:8200"
+		},
+	}, nil)
+	recorder := NewRecordingHandler()
+	recorder.Header.Set("Content-Type", "application/json")
+	cluster.Cores[0].Handler.(*http.ServeMux).Handle("/core1", recorder)
+	cluster.Start()
+	defer cluster.Cleanup()
+
+	TestWaitActive(t, cluster.Cores[0].Core)
+	standby := cluster.Cores[1]
+	if err := standby.RefreshForwarding(); err != nil {
+		t.Fatal(err)
+	}
+
+	body := []byte(`{"foo":"bar"}`)
+	req, err := http.NewRequest("PUT", "https://pushit.real.good:9281/core1", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add(consts.AuthHeaderName, cluster.RootToken)
+	req = req.WithContext(context.WithValue(req.Context(), "original_request_path", req.URL.Path))
+
+	if _, _, _, err := standby.ForwardRequest(req); err != nil {
+		t.Fatal(err)
+	}
+
+	received := recorder.Requests()
+	if len(received) != 1 {
+		t.Fatalf("expected one forwarded request, got %d", len(received))
+	}
+	got := received[0]
+	if got.Scheme != "http" || got.Host != "vault-active.proxy.internal:8200" {
+		t.Fatalf("bad forwarded target: %s://%s", got.Scheme, got.Host)
+	}
+	if got.Path != "/core1" || !bytes.Equal(got.Body, body) {
+		t.Fatalf("forwarded path or body changed: %s %q", got.Path, got.Body)
+	}
+}
+
+func TestCluster_ForwardRequests_Idempotency(t *testing.T) {
+	cluster := NewTestCluster(t, nil, nil)
+	recorder := NewRecordingHandler()
+	recorder.StatusCode = 204
+	cluster.Cores[0].Handler.(*http.ServeMux).Handle("/core1", recorder)
+	secretRecorder := NewRecordingHandler()
+	secretRecorder.Header.Set("Content-Type", "application/json")
+	secretRecorder.Body = []byte(`{"auth":{"client_token":"secret"}}`)
+	cluster.Cores[0].Handler.(*http.ServeMux).Handle("/core2", secretRecorder)
+	cluster.Start()
+	defer cluster.Cleanup()
+
+	TestWaitActive(t, cluster.Cores[0].Core)
+	standby := cluster.Cores[1]
+	if err := standby.RefreshForwarding(); err != nil {
+		t.Fatal(err)
+	}
+
+	newRequest := func(method, path, body string) *http.Request {
+		t.Helper()
+		req, err := http.NewRequest(method, "https://pushit.real.good:9281"+path, bytes.NewReader([]byte(body)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Add(consts.AuthHeaderName, cluster.RootToken)
+		return req.WithContext(context.WithValue(req.Context(), "original_request_path", req.URL.Path))
+	}
+	// newForwardedRequest builds a request the way ForwardRequest does, so
+	// that it can be sent more than once as a retry would be
+	newForwardedRequest := func(method, path, body string) *forwarding.Request {
+		t.Helper()
+		freq, err := forwarding.GenerateForwardedRequest(newRequest(method, path, body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := forwarding.SetIdempotencyKey(freq); err != nil {
+			t.Fatal(err)
+		}
+		return freq
+	}
+	send := func(freq *forwarding.Request, expectedStatus int) {
+		t.Helper()
+		statusCode, _, respBody, err := standby.forwardRequest(context.Background(), freq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if statusCode != expectedStatus {
+			t.Fatalf("bad response %d: %s", statusCode, respBody)
+		}
+	}
+
+	// A retry is answered without being handled again
+	freq := newForwardedRequest("PUT", "/core1", `{"foo":"bar"}`)
+	send(freq, 204)
+	send(freq, 204)
+	if n := len(recorder.Requests()); n != 1 {
+		t.Fatalf("expected the request to be handled once, got %d", n)
+	}
+
+	// The same key with a different body is a different request
+	other := newForwardedRequest("PUT", "/core1", `{"foo":"baz"}`)
+	other.HeaderEntries[forwarding.IdempotencyKeyHeaderName] = freq.HeaderEntries[forwarding.IdempotencyKeyHeaderName]
+	send(other, 204)
+	if n := len(recorder.Requests()); n != 2 {
+		t.Fatalf("expected 2 handled requests, got %d", n)
+	}
+
+	// Reads are always handled
+	read := newForwardedRequest("GET", "/core1", "")
+	send(read, 204)
+	send(read, 204)
+	if n := len(recorder.Requests()); n != 4 {
+		t.Fatalf("expected 4 handled requests, got %d", n)
+	}
+
+	// Clients can't pick the key themselves
+	for i := 0; i < 2; i++ {
+		req := newRequest("PUT", "/core1", `{"foo":"bar"}`)
+		req.Header.Set(forwarding.IdempotencyKeyHeaderName, "client-key")
+		statusCode, _, respBody, err := standby.ForwardRequest(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if statusCode != 204 {
+			t.Fatalf("bad response %d: %s", statusCode, respBody)
+		}
+	}
+	if n := len(recorder.Requests()); n != 6 {
+		t.Fatalf("expected 6 handled requests, got %d", n)
+	}
+	for _, got := range recorder.Requests() {
+		if v := got.Header.Get(forwarding.IdempotencyKeyHeaderName); v != "" {
+			t.Fatalf("idempotency key passed on to the handler: %q", v)
+		}
+	}
+
+	// A response carrying secrets isn't kept, but the request still isn't
+	// handled twice
+	freq = newForwardedRequest("PUT", "/core2", `{"foo":"bar"}`)
+	send(freq, 200)
+	send(freq, http.StatusConflict)
+	if n := len(secretRecorder.Requests()); n != 1 {
+		t.Fatalf("expected the request to be handled once, got %d", n)
+	}
+}
+
+func TestCluster_ForwardRequests_CaptureReplay(t *testing.T) {
+	cluster := NewTestCluster(t, &CoreConfig{
+		ForwardedRequestCaptureSize:   2,
+		ForwardedRequestCaptureBodies: true,
+	}, nil)
+	recorder := NewRecordingHandler()
+	recorder.StatusCode = 201
+	recorder.Header.Set("Content-Type", "application/json")
+	recorder.Body = []byte("core1")
+	cluster.Cores[0].Handler.(*http.ServeMux).Handle("/core1", recorder)
+	cluster.Start()
+	defer cluster.Cleanup()
+
+	TestWaitActive(t, cluster.Cores[0].Core)
+	standby := cluster.Cores[1]
+	if err := standby.RefreshForwarding(); err != nil {
+		t.Fatal(err)
+	}
+
+	forward := func(body string) {
+		t.Helper()
+		req, err := http.NewRequest("PUT", "https://pushit.real.good:9281/core1", bytes.NewReader([]byte(body)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Add(consts.AuthHeaderName, cluster.RootToken)
+		req = req.WithContext(context.WithValue(req.Context(), "original_request_path", req.URL.Path))
+		if _, _, _, err := standby.ForwardRequest(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Only the latest requests are kept
+	forward(`{"n":1}`)
+	forward(`{"n":2}`)
+	forward(`{"n":3}`)
+	captured := standby.CapturedForwardedRequests()
+	if len(captured) != 2 {
+		t.Fatalf("expected 2 captured requests, got %d", len(captured))
+	}
+	if string(captured[0].Request.Body) != `{"n":2}` || string(captured[1].Request.Body) != `{"n":3}` {
+		t.Fatalf("bad captured bodies: %s, %s", captured[0].Request.Body, captured[1].Request.Body)
+	}
+	if captured[1].Request.HeaderEntries[consts.AuthHeaderName] != nil {
+		t.Fatal("captured request kept its token")
+	}
+
+	statusCode, _, respBody, err := standby.ReplayForwarded(captured[1].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != 201 || string(respBody) != "core1" {
+		t.Fatalf("bad response %d: %s", statusCode, respBody)
+	}
+
+	// The replay is handled like the original, minus the token
+	received := recorder.Requests()
+	if len(received) != 4 {
+		t.Fatalf("expected 4 handled requests, got %d", len(received))
+	}
+	orig, replayed := received[2], received[3]
+	if replayed.Method != orig.Method || replayed.Path != orig.Path || !bytes.Equal(replayed.Body, orig.Body) {
+		t.Fatalf("replay differs: %s %s %s, original %s %s %s", replayed.Method, replayed.Path, replayed.Body, orig.Method, orig.Path, orig.Body)
+	}
+	if orig.Header.Get(consts.AuthHeaderName) == "" || replayed.Header.Get(consts.AuthHeaderName) != "" {
+		t.Fatal("replay should carry no token")
+	}
+
+	if _, _, _, err := standby.ReplayForwarded("bogus"); err != ErrForwardedRequestNotCaptured {
+		t.Fatalf("expected ErrForwardedRequestNotCaptured, got %v", err)
+	}
+
+	// Without ForwardedRequestCaptureBodies, bodies are dropped and such
+	// requests can't be replayed
+	standby.forwardedRequestCapture = newForwardedRequestCapture(2, false)
+	forward(`{"password":"hunter2"}`)
+	captured = standby.CapturedForwardedRequests()
+	if len(captured) != 1 || len(captured[0].Request.Body) != 0 || !captured[0].BodyDropped {
+		t.Fatalf("body should have been dropped: %#v", captured)
+	}
+	if _, _, _, err := standby.ReplayForwarded(captured[0].ID); err != ErrForwardedRequestBodyNotCaptured {
+		t.Fatalf("expected ErrForwardedRequestBodyNotCaptured, got %v", err)
+	}
+}
+
+func TestCluster_ForwardRequests_UntrustedPeer(t *testing.T) {
+	cluster := NewTestCluster(t, nil, nil)
+	cluster.Cores[0].Handler.(*http.ServeMux).Handle("/core1", NewRecordingHandler())
+	cluster.Start()
+	defer cluster.Cleanup()
+
+	TestWaitActive(t, cluster.Cores[0].Core)
+	standby := cluster.Cores[1]
+	if err := standby.RefreshForwarding(); err != nil {
+		t.Fatal(err)
+	}
+
+	forward := func() error {
+		t.Helper()
+		req, err := http.NewRequest("PUT", "https://pushit.real.good:9281/core1", bytes.NewReader([]byte("{}")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Add(consts.AuthHeaderName, cluster.RootToken)
+		req = req.WithContext(context.WithValue(req.Context(), "original_request_path", req.URL.Path))
+		_, _, _, err = standby.ForwardRequest(req)
+		return err
+	}
+	if err := forward(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Have the standby trust only some other cert, as if the active node's
+	// cert had been rotated without it noticing, and reconnect
+	untrustedCert, _ := testClusterCert(t)
+	standby.localClusterParsedCert.Store(untrustedCert)
+	if err := standby.refreshRequestForwardingConnection(context.Background(), standby.clusterLeaderClusterAddr); err != nil {
+		t.Fatal(err)
+	}
+	if err := forward(); err != ErrClusterPeerUntrusted {
+		t.Fatalf("expected ErrClusterPeerUntrusted, got %v", err)
+	}
+
+	// Reloading the cluster TLS information from storage restores trust
+	standby.clusterRetryUntrustedPeer = true
+	if err := standby.refreshRequestForwardingConnection(context.Background(), standby.clusterLeaderClusterAddr); err != nil {
+		t.Fatal(err)
+	}
+	if err := forward(); err != nil {
+		t.Fatalf("expected forwarding to succeed after reloading, got %v", err)
+	}
+
+	// Reloading is rate limited, so breaking trust again right away fails
+	// until the interval has passed
+	standby.localClusterParsedCert.Store(untrustedCert)
+	if err := standby.refreshRequestForwardingConnection(context.Background(), standby.clusterLeaderClusterAddr); err != nil {
+		t.Fatal(err)
+	}
+	if err := forward(); err != ErrClusterPeerUntrusted {
+		t.Fatalf("expected ErrClusterPeerUntrusted while rate limited, got %v", err)
+	}
+	standby.untrustedPeerRetryLock.Lock()
+	standby.untrustedPeerRetryLast = standby.clock.Now().Add(-untrustedPeerRetryInterval)
+	standby.untrustedPeerRetryLock.Unlock()
+	if err := standby.refreshRequestForwardingConnection(context.Background(), standby.clusterLeaderClusterAddr); err != nil {
+		t.Fatal(err)
+	}
+	if err := forward(); err != nil {
+		t.Fatalf("expected forwarding to succeed after the interval, got %v", err)
+	}
+}
+
+func TestCluster_ForwardSealedHealth(t *testing.T) {
+	cluster := NewTestCluster(t, &CoreConfig{
+		ForwardSealedHealthRequests: true,
+	}, nil)
+	recorder := NewRecordingHandler()
+	recorder.Header.Set("Content-Type", "application/json")
+	recorder.Body = []byte(`{"sealed":false,"standby":false}`)
+	cluster.Cores[0].Handler.(*http.ServeMux).Handle("/v1/sys/health", recorder)
+	cluster.Cores[0].Handler.(*http.ServeMux).Handle("/v1/sys/mounts", NewRecordingHandler())
+	cluster.Start()
+	defer cluster.Cleanup()
+
+	active := cluster.Cores[0]
+	TestWaitActive(t, active.Core)
+
+	// Only the cluster address and cert are kept outside the barrier
+	entry, err := active.physical.Get(context.Background(), coreActiveClusterTLSPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry == nil {
+		t.Fatal("expected the active node to store its cluster information")
+	}
+	var stored map[string]interface{}
+	if err := json.Unmarshal(entry.Value, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 2 || stored["cluster_addr"] == nil || stored["cluster_cert"] == nil {
+		t.Fatalf("unexpected stored cluster information: %v", stored)
+	}
+
+	standby := cluster.Cores[1]
+	if err := standby.sealInternal(); err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("GET", "https://pushit.real.good:9281/v1/sys/health?standbyok=true", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add(consts.AuthHeaderName, cluster.RootToken)
+	statusCode, header, body, err := standby.ForwardSealedHealthRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != 200 || header.Get("Content-Type") != "application/json" || string(body) != string(recorder.Body) {
+		t.Fatalf("bad response %d %v: %s", statusCode, header, body)
+	}
+
+	// The token stays behind
+	received := recorder.Requests()
+	if len(received) != 1 {
+		t.Fatalf("expected 1 handled request, got %d", len(received))
+	}
+	if received[0].Method != "GET" || received[0].Path != "/v1/sys/health" || received[0].Header.Get(consts.AuthHeaderName) != "" {
+		t.Fatalf("bad forwarded request: %#v", received[0])
+	}
+
+	// Nothing but health checks is served without a client cert
+	clusterURL, err := url.Parse(active.clusterAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := tls.Dial("tcp", clusterURL.Host, &tls.Config{
+		InsecureSkipVerify: true,
+		NextProtos:         []string{healthForwardingALPN},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	mountsReq, err := http.NewRequest("GET", "http://"+clusterURL.Host+"/v1/sys/mounts", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mountsReq.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), mountsReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 for a path other than sys/health, got %d", resp.StatusCode)
+	}
+
+	// Nor is anything forwarded unless enabled
+	standby.forwardSealedHealthRequests = false
+	if _, _, _, err := standby.ForwardSealedHealthRequest(req); err != ErrCannotForward {
+		t.Fatalf("expected ErrCannotForward, got %v", err)
+	}
+}
+
+func TestCluster_PeerStats(t *testing.T) {
+	cluster := NewTestCluster(t, nil, nil)
+	recorder := NewRecordingHandler()
+	cluster.Cores[0].Handler.(*http.ServeMux).Handle("/core1", recorder)
+	cluster.Start()
+	defer cluster.Cleanup()
+
+	active := cluster.Cores[0]
+	TestWaitActive(t, active.Core)
+
+	forward := func(standby *TestClusterCore, n int) {
+		t.Helper()
+		if err := standby.RefreshForwarding(); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < n; i++ {
+			req, err := http.NewRequest("PUT", "https://pushit.real.good:9281/core1", bytes.NewReader([]byte(`{"foo":"bar"}`)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Add(consts.AuthHeaderName, cluster.RootToken)
+			req = req.WithContext(context.WithValue(req.Context(), "original_request_path", req.URL.Path))
+			if _, _, _, err := standby.ForwardRequest(req); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	forward(cluster.Cores[1], 2)
+	forward(cluster.Cores[2], 5)
+
+	// Logical requests are accounted too
+	if _, err := cluster.Cores[1].ForwardLogicalRequest(context.Background(), &logical.Request{
+		Operation:   logical.ReadOperation,
+		Path:        "sys/policy/default",
+		ClientToken: cluster.RootToken,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	counts := make(map[string]uint64)
+	var fingerprint string
+	for id, stats := range active.PeerStats() {
+		if id.CertFingerprint == "" {
+			t.Fatalf("requests from %q were not attributed to a verified cert", id.NodeID)
+		}
+		if fingerprint != "" && id.CertFingerprint != fingerprint {
+			t.Fatalf("standbys of one cluster presented different certs")
+		}
+		fingerprint = id.CertFingerprint
+		if stats.Bytes == 0 {
+			t.Fatalf("no bytes accounted to %q", id.NodeID)
+		}
+		counts[id.NodeID] += stats.Requests
+	}
+	if len(counts) != 2 || counts["core-1"] != 3 || counts["core-2"] != 5 {
+		t.Fatalf("bad per-peer request counts: %v", counts)
+	}
+
+	// Peers claiming ever new node IDs only push out the least recently
+	// seen ones
+	stats := newClusterPeerStats()
+	now := time.Now()
+	for i := 0; i < 2*clusterPeerStatsMaxPeers; i++ {
+		stats.record(ClusterPeerID{NodeID: fmt.Sprintf("node-%d", i)}, 1, 1, now.Add(time.Duration(i)*time.Second))
+	}
+	if len(stats.peers) != clusterPeerStatsMaxPeers {
+		t.Fatalf("expected %d peers, got %d", clusterPeerStatsMaxPeers, len(stats.peers))
+	}
+	if _, ok := stats.peers[ClusterPeerID{NodeID: fmt.Sprintf("node-%d", 2*clusterPeerStatsMaxPeers-1)}]; !ok {
+		t.Fatal("most recent peer was evicted")
+	}
+	if _, ok := stats.peers[ClusterPeerID{NodeID: "node-0"}]; ok {
+		t.Fatal("oldest peer was kept")
+	}
+}
+
+func TestCluster_ForwardingReconnect(t *testing.T) {
+	cluster := NewTestCluster(t, &CoreConfig{
+		ForwardingReconnectBaseDelay: 50 * time.Millisecond,
+		ForwardingReconnectMaxDelay:  200 * time.Millisecond,
+	}, nil)
+	recorder := NewRecordingHandler()
+	recorder.Header.Set("Content-Type", "application/json")
+	cluster.Cores[0].Handler.(*http.ServeMux).Handle("/core1", recorder)
+	cluster.Start()
+	defer cluster.Cleanup()
+
+	active := cluster.Cores[0]
+	TestWaitActive(t, active.Core)
+	standby := cluster.Cores[1]
+	if err := standby.RefreshForwarding(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Put a proxy in front of the active node's cluster port that can drop
+	// every connection, as if the active node were down, and counts the
+	// connection attempts made through it
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var down, attempts int32 = 1, 0
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&attempts, 1)
+			if atomic.LoadInt32(&down) == 1 {
+				conn.Close()
+				continue
+			}
+			backend, err := net.Dial("tcp", active.ClusterAddrs[0].String())
+			if err != nil {
+				conn.Close()
+				continue
+			}
+			go func() {
+				io.Copy(backend, conn)
+				backend.Close()
+			}()
+			go func() {
+				io.Copy(conn, backend)
+				conn.Close()
+			}()
+		}
+	}()
+	if err := standby.refreshRequestForwardingConnection(context.Background(), "https://"+ln.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+
+	forward := func() error {
+		req, err := http.NewRequest("PUT", "https://pushit.real.good:9281/core1", bytes.NewReader([]byte(`{}`)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Add(consts.AuthHeaderName, cluster.RootToken)
+		req = req.WithContext(context.WithValue(req.Context(), "original_request_path", req.URL.Path))
+		_, _, _, err = standby.ForwardRequest(req)
+		return err
+	}
+
+	// Hammer the standby while the active node is down. Every request
+	// fails, but the connection attempts are paced by the backoff rather
+	// than by the requests.
+	var requests int
+	for start := time.Now(); time.Since(start) < time.Second; requests++ {
+		if err := forward(); err == nil {
+			t.Fatal("expected forwarding to fail while the active node is down")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	// One attempt from the initial connection, then backoffs of roughly
+	// 50, 100 and 200ms, staying at 200ms
+	if n := atomic.LoadInt32(&attempts); n < 2 || n > 10 {
+		t.Fatalf("expected a bounded number of reconnection attempts for %d requests, got %d", requests, n)
+	}
+
+	// Once the active node is back, forwarding recovers by itself
+	atomic.StoreInt32(&down, 0)
+	deadline := time.Now().Add(clusterTestWaitTimeout)
+	for {
+		err := forward()
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("forwarding did not recover: %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if len(recorder.Requests()) != 1 {
+		t.Fatalf("expected the active node to receive one request, got %d", len(recorder.Requests()))
+	}
+}
+
+func TestCluster_LeaderDoesNotRefreshForwarding(t *testing.T) {
+	cluster := NewTestCluster(t, nil, nil)
+	cluster.Start()
+	defer cluster.Cleanup()
+
+	active := cluster.Cores[0].Core
+	TestWaitActive(t, active)
+
+	standby := cluster.Cores[1].Core
+	if err := standby.RefreshForwarding(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Forget the leader we refreshed against
+	standby.clusterLeaderParamsLock.Lock()
+	standby.clusterLeaderUUID = ""
+	standby.clusterLeaderRedirectAddr = ""
+	standby.clusterLeaderClusterAddr = ""
+	standby.clusterLeaderParamsLock.Unlock()
+
+	isLeader, redirectAddr, clusterAddr, err := standby.Leader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isLeader {
+		t.Fatal("standby should not be leader")
+	}
+	if redirectAddr != active.redirectAddr || clusterAddr != active.clusterAddr {
+		t.Fatalf("bad leader addresses %q and %q", redirectAddr, clusterAddr)
+	}
+
+	standby.clusterLeaderParamsLock.RLock()
+	leaderUUID := standby.clusterLeaderUUID
+	standby.clusterLeaderParamsLock.RUnlock()
+	if leaderUUID != "" {
+		t.Fatalf("Leader should not have refreshed forwarding, leader UUID is %q", leaderUUID)
+	}
+
+	if err := standby.RefreshForwarding(); err != nil {
+		t.Fatal(err)
+	}
+	standby.clusterLeaderParamsLock.RLock()
+	leaderUUID = standby.clusterLeaderUUID
+	leaderClusterAddr := standby.clusterLeaderClusterAddr
+	standby.clusterLeaderParamsLock.RUnlock()
+	if leaderUUID == "" || leaderClusterAddr != active.clusterAddr {
+		t.Fatalf("expected RefreshForwarding to record the leader, got %q at %q", leaderUUID, leaderClusterAddr)
+	}
+}
+
+func TestCluster_LeaderForForwarding(t *testing.T) {
+	cluster := NewTestCluster(t, nil, nil)
+	cluster.Start()
+	defer cluster.Cleanup()
+
+	active := cluster.Cores[0].Core
+	TestWaitActive(t, active)
+
+	// Count the standby's reads of the HA lock
+	standby := cluster.Cores[1].Core
+	var reads uint32
+	standby.ha = &countingHABackend{
+		HABackend: standby.ha,
+		reads:     &reads,
+	}
+
+	isLeader, leaderAddr, err := standby.LeaderForForwarding()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isLeader {
+		t.Fatal("standby should not be leader")
+	}
+	if leaderAddr != active.redirectAddr {
+		t.Fatalf("bad leader address %q", leaderAddr)
+	}
+	if n := atomic.LoadUint32(&reads); n != 1 {
+		t.Fatalf("expected the HA lock to be read once, got %d", n)
+	}
+
+	standby.clusterLeaderParamsLock.RLock()
+	leaderClusterAddr := standby.clusterLeaderClusterAddr
+	standby.clusterLeaderParamsLock.RUnlock()
+	if leaderClusterAddr != active.clusterAddr {
+		t.Fatalf("expected forwarding to be set up for %q, got %q", active.clusterAddr, leaderClusterAddr)
+	}
+
+	isLeader, leaderAddr, err = active.LeaderForForwarding()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isLeader || leaderAddr != active.redirectAddr {
+		t.Fatalf("bad active node result %t %q", isLeader, leaderAddr)
+	}
+}
+
+func testCluster_ForwardRequestsCommon(t *testing.T, opts *TestClusterOptions) {
+	cluster := NewTestCluster(t, &CoreConfig{
+		ManualStepDownSleepPeriod: clusterTestStepDownSleepPeriod,
+	}, opts)
+	cores := cluster.Cores
+	if opts != nil && opts.BaseListenAddress != "" {
+		base, err := net.ResolveTCPAddr("tcp", opts.BaseListenAddress)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, core := range cores {
+			if !core.ClusterAddrs[0].IP.Equal(base.IP) {
+				t.Fatalf("expected cluster address on %s, got %s", base.IP, core.ClusterAddrs[0])
+			}
+		}
+	}
+	recorders := make(map[string]*RecordingHandler, len(cores))
+	for i, core := range cores {
+		id := fmt.Sprintf("core%d", i+1)
+		recorder := NewRecordingHandler()
+		recorder.StatusCode = 201 + i
+		recorder.Header.Set("Content-Type", "application/json")
+		recorder.Body = []byte(id)
+		core.Handler.(*http.ServeMux).Handle("/"+id, recorder)
+		recorders[id] = recorder
+	}
+	cluster.Start()
+	defer cluster.Cleanup()
+
+	root := cluster.RootToken
+
+	// Wait for core to become active
+	TestWaitActive(t, cores[0].Core)
+
+	// Test forwarding a request. Since we're going directly from core to core
+	// with no fallback we know that if it worked, request handling is working
+	testCluster_ForwardRequests(t, cores[1], root, "core1", recorders["core1"])
+	testCluster_ForwardRequests(t, cores[2], root, "core1", recorders["core1"])
+
+	//
+	// Now we do a bunch of round-robining. The point is to make sure that as
+	// nodes come and go, we can always successfully forward to the active
+	// node.
+	//
+
+	// Ensure active core is cores[1] and test
+	testCluster_StepDown(t, cores[0], root)
+	testWaitAnyActive(t, cores[2], cores[1])
+	testCluster_StepDown(t, cores[2], root)
+	testWaitAnyActive(t, cores[1])
+	testCluster_ForwardRequests(t, cores[0], root, "core2", recorders["core2"])
+	testCluster_ForwardRequests(t, cores[2], root, "core2", recorders["core2"])
+
+	// Ensure active core is cores[2] and test
+	testCluster_StepDown(t, cores[1], root)
+	testWaitAnyActive(t, cores[0], cores[2])
+	testCluster_StepDown(t, cores[0], root)
+	testWaitAnyActive(t, cores[2])
+	testCluster_ForwardRequests(t, cores[0], root, "core3", recorders["core3"])
+	testCluster_ForwardRequests(t, cores[1], root, "core3", recorders["core3"])
+
+	// Ensure active core is cores[0] and test
+	testCluster_StepDown(t, cores[2], root)
+	testWaitAnyActive(t, cores[1], cores[0])
+	testCluster_StepDown(t, cores[1], root)
+	testWaitAnyActive(t, cores[0])
+	testCluster_ForwardRequests(t, cores[1], root, "core1", recorders["core1"])
+	testCluster_ForwardRequests(t, cores[2], root, "core1", recorders["core1"])
+
+	// Ensure active core is cores[1] and test
+	testCluster_StepDown(t, cores[0], root)
+	testWaitAnyActive(t, cores[2], cores[1])
+	testCluster_StepDown(t, cores[2], root)
+	testWaitAnyActive(t, cores[1])
+	testCluster_ForwardRequests(t, cores[0], root, "core2", recorders["core2"])
+	testCluster_ForwardRequests(t, cores[2], root, "core2", recorders["core2"])
+
+	// Ensure active core is cores[2] and test
+	testCluster_StepDown(t, cores[1], root)
+	testWaitAnyActive(t, cores[0], cores[2])
+	testCluster_StepDown(t, cores[0], root)
+	testWaitAnyActive(t, cores[2])
+	testCluster_ForwardRequests(t, cores[0], root, "core3", recorders["core3"])
+	testCluster_ForwardRequests(t, cores[1], root, "core3", recorders["core3"])
+}
+
+// testCluster_StepDown steps the core down if it is active and waits until it
+// has entered standby
+func testCluster_StepDown(t *testing.T, core *TestClusterCore, rootToken string) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), clusterTestWaitTimeout)
+	defer cancel()
+	err := core.StepDownContext(ctx, &logical.Request{
+		Operation:   logical.UpdateOperation,
+		Path:        "sys/step-down",
+		ClientToken: rootToken,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testWaitAnyActive waits until one of the given cores has become active
+func testWaitAnyActive(t *testing.T, cores ...*TestClusterCore) {
+	t.Helper()
+	deadline := time.Now().Add(clusterTestWaitTimeout)
+	for time.Now().Before(deadline) {
+		for _, core := range cores {
+			standby, err := core.Standby()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !standby {
+				return
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatal("timed out waiting for an active core")
+}
+
+func testCluster_ForwardRequests(t *testing.T, c *TestClusterCore, rootToken, remoteCoreID string, remote *RecordingHandler) {
+	standby, err := c.Standby()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !standby {
+		t.Fatal("expected core to be standby")
+	}
+
+	isLeader, _, _, err := c.Leader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isLeader {
+		t.Fatal("core should not be leader")
+	}
+
+	// Make sure the forwarding connection points at the current leader
+	if err := c.RefreshForwarding(); err != nil {
+		t.Fatal(err)
+	}
+
+	reqBody := []byte(`{ "foo": "bar", "zip": "zap" }`)
+	bodBuf := bytes.NewReader(reqBody)
+	req, err := http.NewRequest("PUT", "https://pushit.real.good:9281/"+remoteCoreID, bodBuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add(consts.AuthHeaderName, rootToken)
+	req = req.WithContext(context.WithValue(req.Context(), "original_request_path", req.URL.Path))
+
+	statusCode, header, respBytes, err := c.ForwardRequest(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if header == nil {
+		t.Fatal("err: expected at least a content-type header")
+	}
+	if header.Get("Content-Type") != "application/json" {
+		t.Fatalf("bad content-type: %s", header.Get("Content-Type"))
+	}
+
+	body := string(respBytes)
+
+	if body != remoteCoreID {
+		t.Fatalf("expected %s, got %s", remoteCoreID, body)
+	}
+	switch body {
+	case "core1":
+		if statusCode != 201 {
+			t.Fatal("bad response")
+		}
+	case "core2":
+		if statusCode != 202 {
+			t.Fatal("bad response")
+		}
+	case "core3":
+		if statusCode != 203 {
+			t.Fatal("bad response")
+		}
+	}
+
+	// Check what the active node's handler actually received
+	received := remote.Requests()
+	if len(received) == 0 {
+		t.Fatal("active node did not receive the forwarded request")
+	}
+	got := received[len(received)-1]
+	if got.Method != "PUT" || got.Path != "/"+remoteCoreID {
+		t.Fatalf("bad forwarded request: %s %s", got.Method, got.Path)
+	}
+	// Without a rewrite the request keeps the host it arrived with
+	if got.Scheme != "https" || got.Host != "pushit.real.good:9281" {
+		t.Fatalf("bad forwarded target: %s://%s", got.Scheme, got.Host)
+	}
+	if got.Header.Get(consts.AuthHeaderName) != rootToken {
+		t.Fatal("forwarded request lost its token")
+	}
+	if !bytes.Equal(got.Body, reqBody) {
+		t.Fatalf("forwarded body mismatch: %q", got.Body)
+	}
+}
+
+func TestCluster_ForwardLogicalRequest(t *testing.T) {
+	cluster := NewTestCluster(t, nil, nil)
+	cluster.Start()
+	defer cluster.Cleanup()
+	cores := cluster.Cores
+
+	TestWaitActive(t, cores[0].Core)
+	standby := cores[1]
+	if err := standby.RefreshForwarding(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), clusterTestWaitTimeout)
+	defer cancel()
+
+	// Write through the standby, then read it back the same way
+	resp, err := standby.ForwardLogicalRequest(ctx, &logical.Request{
+		Operation:   logical.UpdateOperation,
+		Path:        "sys/policy/forwarded",
+		ClientToken: cluster.RootToken,
+		Data: map[string]interface{}{
+			"policy": `path "secret/*" { capabilities = ["read"] }`,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp != nil && resp.IsError() {
+		t.Fatalf("bad response: %#v", resp)
+	}
+
+	resp, err = standby.ForwardLogicalRequest(ctx, &logical.Request{
+		Operation:   logical.ReadOperation,
+		Path:        "sys/policy/forwarded",
+		ClientToken: cluster.RootToken,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.Data["name"] != "forwarded" || !strings.Contains(resp.Data["rules"].(string), "secret/*") {
+		t.Fatalf("bad response: %#v", resp)
+	}
+
+	// The policy should really be on the active node
+	policy, err := cores[0].policyStore.GetPolicy(namespace.RootContext(nil), "forwarded", PolicyTypeToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy == nil {
+		t.Fatal("forwarded write didn't reach the active node")
+	}
+
+	// The active node still checks the token
+	_, err = standby.ForwardLogicalRequest(ctx, &logical.Request{
+		Operation:   logical.ReadOperation,
+		Path:        "sys/policy/forwarded",
+		ClientToken: "not-a-token",
+	})
+	if err != logical.ErrPermissionDenied {
+		t.Fatalf("expected permission denied, got: %v", err)
+	}
+
+	// There's nowhere to forward to from the active node
+	if _, err := cores[0].ForwardLogicalRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "sys/policy/forwarded",
+	}); err != ErrCannotForward {
+		t.Fatalf("expected ErrCannotForward, got: %v", err)
+	}
+}
+
+func TestCluster_ForwardUpgradeRequests(t *testing.T) {
+	cluster := NewTestCluster(t, nil, nil)
+
+	// The active node switches to a protocol that echoes back every line
+	cluster.Cores[0].Handler.(*http.ServeMux).HandleFunc("/echo", func(w http.ResponseWriter, req *http.Request) {
+		if !IsUpgradeRequest(req) || req.Header.Get("Upgrade") != "echo" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		rw.Flush()
+		io.Copy(conn, rw)
+	})
+	cluster.Start()
+	defer cluster.Cleanup()
+
+	TestWaitActive(t, cluster.Cores[0].Core)
+	standby := cluster.Cores[1]
+	if err := standby.RefreshForwarding(); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if err := standby.ForwardUpgradeRequest(w, req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+		}
+	}))
+	defer srv.Close()
+
+	upgrade := func(path, protocol string) (net.Conn, *bufio.Reader, *http.Response) {
+		conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: vault\r\nConnection: Upgrade\r\nUpgrade: %s\r\n\r\n", path, protocol)
+		br := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			conn.Close()
+			t.Fatal(err)
+		}
+		return conn, br, resp
+	}
+
+	conn, br, resp := upgrade("/echo", "echo")
+	defer conn.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected the standby to relay the upgrade, got status %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Upgrade") != "echo" {
+		t.Fatalf("bad upgrade header: %q", resp.Header.Get("Upgrade"))
+	}
+	for _, msg := range []string{"hello", "through the standby"} {
+		if _, err := fmt.Fprintf(conn, "%s\n", msg); err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line != msg+"\n" {
+			t.Fatalf("expected %q echoed, got %q", msg, line)
+		}
+	}
+
+	// A refused upgrade is passed back as a normal response
+	conn2, _, resp := upgrade("/echo", "something-else")
+	defer conn2.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected the active node's refusal, got status %d", resp.StatusCode)
+	}
+}
+
+func TestCluster_ListenerAddrs(t *testing.T) {
+	cluster := NewTestCluster(t, nil, nil)
+	cluster.Start()
+	defer cluster.Cleanup()
+
+	active := cluster.Cores[0]
+	TestWaitActive(t, active.Core)
+
+	var expected []string
+	for _, addr := range active.ClusterAddrs {
+		expected = append(expected, addr.String())
+	}
+	sort.Strings(expected)
+
+	// The listeners bind in the background once the node becomes active
+	var got []string
+	deadline := time.Now().Add(clusterTestWaitTimeout)
+	for {
+		got = got[:0]
+		for _, addr := range active.ClusterListenerAddrs() {
+			got = append(got, addr.String())
+		}
+		if len(got) == len(expected) || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("bad listener addresses: got %v, expected %v", got, expected)
+	}
+
+	// Standbys don't run cluster listeners
+	for _, core := range cluster.Cores[1:] {
+		if addrs := core.ClusterListenerAddrs(); len(addrs) != 0 {
+			t.Fatalf("expected no listener addresses on a standby, got %v", addrs)
+		}
+	}
+
+	// Nor do sealed nodes
+	if err := active.Seal(cluster.RootToken); err != nil {
+		t.Fatal(err)
+	}
+	if addrs := active.ClusterListenerAddrs(); addrs == nil || len(addrs) != 0 {
+		t.Fatalf("expected an empty list of listener addresses once sealed, got %#v", addrs)
+	}
+}
+
+func TestCluster_CustomCipherSuites(t *testing.T) {
+	cluster := NewTestCluster(t, &CoreConfig{
+		ClusterCipherSuites: "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA",
+	}, nil)
+	cluster.Start()
+	defer cluster.Cleanup()
+	core := cluster.Cores[0]
+
+	// Wait for core to become active
+	TestWaitActive(t, core.Core)
+
+	conn, err := tls.Dial("tcp", core.ClusterAddrs[0].String(), core.ClusterTLS)
+	if err != nil {
+		t.Fatal(err)
 	}
 	defer conn.Close()
 	err = conn.Handshake()
 	if err != nil {
 		t.Fatal(err)
 	}
-	if conn.ConnectionState().CipherSuite != tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
-		var availCiphers string
-		for _, cipher := range core.clusterCipherSuites {
-			availCiphers += fmt.Sprintf("%x ", cipher)
+	if conn.ConnectionState().CipherSuite != tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+		var availCiphers string
+		for _, cipher := range core.clusterCipherSuites {
+			availCiphers += fmt.Sprintf("%x ", cipher)
+		}
+		t.Fatalf("got bad negotiated cipher %x, core-set suites are %s", conn.ConnectionState().CipherSuite, availCiphers)
+	}
+}
+
+func TestCluster_RequireClientCert(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		require *bool
+		ok      bool
+	}{
+		{"default", nil, false},
+		{"required", &[]bool{true}[0], false},
+		{"not required", &[]bool{false}[0], true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cluster := NewTestCluster(t, &CoreConfig{
+				ClusterRequireClientCert: tc.require,
+			}, nil)
+			cluster.Start()
+			defer cluster.Cleanup()
+			core := cluster.Cores[0]
+			TestWaitActive(t, core.Core)
+
+			// Dial the cluster port without offering a client certificate.
+			// TLS 1.2 makes the client wait for the server to accept it
+			// before the handshake completes.
+			tlsConfig := core.ClusterTLS.Clone()
+			tlsConfig.GetClientCertificate = nil
+			tlsConfig.Certificates = nil
+			tlsConfig.MaxVersion = tls.VersionTLS12
+			tlsConfig.NextProtos = []string{requestForwardingALPN}
+
+			conn, err := tls.Dial("tcp", core.ClusterAddrs[0].String(), tlsConfig)
+			if conn != nil {
+				defer conn.Close()
+			}
+			switch {
+			case tc.ok && err != nil:
+				t.Fatalf("expected handshake without a client certificate to succeed: %v", err)
+			case !tc.ok && err == nil:
+				t.Fatal("expected handshake without a client certificate to fail")
+			}
+		})
+	}
+}
+
+func TestCluster_InsecureSkipVerify(t *testing.T) {
+	_, untrustedCert := testClusterCert(t)
+
+	for _, tc := range []struct {
+		name     string
+		insecure bool
+	}{
+		{"verify", false},
+		{"skip verify", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cluster := NewTestCluster(t, &CoreConfig{
+				ClusterInsecureSkipVerify: tc.insecure,
+			}, nil)
+			cluster.Start()
+			defer cluster.Cleanup()
+			core := cluster.Cores[0]
+			TestWaitActive(t, core.Core)
+
+			// Connect to the cluster port the way a debugging tool would,
+			// with a certificate the cluster doesn't trust. TLS 1.2 makes
+			// the client wait for the server to accept it.
+			conn, err := tls.Dial("tcp", core.ClusterAddrs[0].String(), &tls.Config{
+				Certificates:       []tls.Certificate{untrustedCert},
+				InsecureSkipVerify: true,
+				MaxVersion:         tls.VersionTLS12,
+				NextProtos:         []string{requestForwardingALPN},
+			})
+			if conn != nil {
+				defer conn.Close()
+			}
+			switch {
+			case tc.insecure && err != nil:
+				t.Fatalf("expected handshake with an untrusted certificate to succeed: %v", err)
+			case !tc.insecure && err == nil:
+				t.Fatal("expected handshake with an untrusted certificate to fail")
+			}
+
+			// The node's own client config accepts an untrusted server
+			// only when verification is skipped
+			tlsConfig, err := core.ClusterTLSConfig(context.Background(), nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tlsConfig.MaxVersion = tls.VersionTLS12
+			err = testClusterClientHandshake(untrustedCert, tlsConfig)
+			switch {
+			case tc.insecure && err != nil:
+				t.Fatalf("expected handshake with an untrusted server to succeed: %v", err)
+			case !tc.insecure && err == nil:
+				t.Fatal("expected handshake with an untrusted server to fail")
+			}
+		})
+	}
+}
+
+func TestCluster_TrustedPeerCerts(t *testing.T) {
+	clusterCertPEM := func(core *TestClusterCore) string {
+		return string(pem.EncodeToMemory(&pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: core.localClusterCert.Load().([]byte),
+		}))
+	}
+
+	clusterA := NewTestCluster(t, nil, &TestClusterOptions{
+		NumCores: 1,
+	})
+	recorder := NewRecordingHandler()
+	recorder.StatusCode = 201
+	clusterA.Cores[0].Handler.(*http.ServeMux).Handle("/core1", recorder)
+	clusterA.Start()
+	defer clusterA.Cleanup()
+	coreA := clusterA.Cores[0]
+	TestWaitActive(t, coreA.Core)
+
+	clusterB := NewTestCluster(t, &CoreConfig{
+		ClusterTrustedPeerCerts: []string{clusterCertPEM(coreA)},
+	}, &TestClusterOptions{
+		NumCores: 1,
+	})
+	clusterB.Start()
+	defer clusterB.Cleanup()
+	coreB := clusterB.Cores[0]
+	TestWaitActive(t, coreB.Core)
+
+	// Forward an echo from cluster B to cluster A over the cluster port
+	echo := func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		serverName := coreA.localClusterParsedCert.Load().(*x509.Certificate).Subject.CommonName
+		conn, err := grpc.DialContext(ctx, coreA.ClusterAddrs[0].String(),
+			grpc.WithDialer(coreB.getGRPCDialer(ctx, requestForwardingALPN, serverName, nil, nil, nil)),
+			grpc.WithInsecure(),
+			grpc.WithBlock())
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		_, err = NewRequestForwardingClient(conn).Echo(ctx, &EchoRequest{})
+		return err
+	}
+
+	// Cluster A doesn't trust cluster B's cert yet
+	if err := echo(); err == nil {
+		t.Fatal("expected forwarding to an untrusting cluster to fail")
+	}
+
+	// Cluster B's cert only exists once it has been unsealed, so have
+	// cluster A trust it as if it had been configured up front
+	certs, err := parseClusterPeerCerts([]string{clusterCertPEM(coreB)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	coreA.clusterTrustedPeerCerts = certs
+
+	if err := echo(); err != nil {
+		t.Fatalf("expected forwarding between federated clusters to succeed: %v", err)
+	}
+
+	// A request forwarded from cluster B is handled by cluster A, even
+	// though the two clusters have different IDs
+	if err := coreB.refreshRequestForwardingConnection(context.Background(), fmt.Sprintf("https://%s", coreA.ClusterAddrs[0])); err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("PUT", "https://pushit.real.good:9281/core1", bytes.NewReader([]byte(`{"foo":"bar"}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = req.WithContext(context.WithValue(req.Context(), "original_request_path", req.URL.Path))
+	statusCode, _, _, err := coreB.ForwardRequest(req)
+	if err != nil {
+		t.Fatalf("expected forwarding a request between federated clusters to succeed: %v", err)
+	}
+	if statusCode != 201 || len(recorder.Requests()) != 1 {
+		t.Fatalf("expected request to be handled by cluster A, got status %d", statusCode)
+	}
+
+	if _, err := parseClusterPeerCerts([]string{"not a certificate"}); err == nil {
+		t.Fatal("expected an error parsing an invalid certificate")
+	}
+}
+
+func TestCluster_CertRotationOverlap(t *testing.T) {
+	c := TestCore(t)
+	c.clusterCertOverlapPeriod = time.Hour
+
+	oldCert, oldTLSCert := testClusterCert(t)
+	newCert, newTLSCert := testClusterCert(t)
+
+	// Simulate the active node rotating its cluster cert
+	c.storeLocalClusterParsedCert(oldCert)
+	c.storeLocalClusterParsedCert(nil)
+	c.storeLocalClusterParsedCert(newCert)
+
+	pool := c.clusterCertPool()
+
+	// A node that has picked up the new cert must still accept a peer that
+	// is presenting the old one, and vice versa
+	if err := testClusterHandshake(newTLSCert, oldTLSCert, newCert.Subject.CommonName, pool); err != nil {
+		t.Fatalf("new server, old client: %v", err)
+	}
+	if err := testClusterHandshake(oldTLSCert, newTLSCert, oldCert.Subject.CommonName, pool); err != nil {
+		t.Fatalf("old server, new client: %v", err)
+	}
+
+	// Once the overlap window closes only the new cert is trusted
+	c.localClusterPrevParsedCert.Store(&retiredClusterCert{
+		cert:      oldCert,
+		expiresAt: time.Now().Add(-time.Second),
+	})
+	pool = c.clusterCertPool()
+
+	if err := testClusterHandshake(newTLSCert, newTLSCert, newCert.Subject.CommonName, pool); err != nil {
+		t.Fatalf("new server, new client: %v", err)
+	}
+	if err := testClusterHandshake(newTLSCert, oldTLSCert, newCert.Subject.CommonName, pool); err == nil {
+		t.Fatal("expected handshake with expired cert to fail")
+	}
+}
+
+func TestCluster_CertRotationNoOverlap(t *testing.T) {
+	c := TestCore(t)
+
+	oldCert, _ := testClusterCert(t)
+	newCert, _ := testClusterCert(t)
+
+	c.storeLocalClusterParsedCert(oldCert)
+	c.storeLocalClusterParsedCert(newCert)
+
+	if prev := c.localClusterPrevParsedCert.Load().(*retiredClusterCert); prev != nil {
+		t.Fatalf("expected no retained cert without an overlap period, got %#v", prev)
+	}
+}
+
+func TestCluster_CertRotationForwarding(t *testing.T) {
+	cluster := NewTestCluster(t, nil, nil)
+	cluster.Start()
+	defer cluster.Cleanup()
+
+	active := cluster.Cores[0]
+	TestWaitActive(t, active.Core)
+	standby := cluster.Cores[1]
+	if err := standby.RefreshForwarding(); err != nil {
+		t.Fatal(err)
+	}
+	if err := standby.TestForward(); err != nil {
+		t.Fatal(err)
+	}
+
+	standby.requestForwardingConnectionLock.RLock()
+	oldConn := standby.rpcClientConn
+	standby.requestForwardingConnectionLock.RUnlock()
+
+	// Rotate the cluster cert on both nodes. Without an overlap period the
+	// active node stops trusting the old cert at once, so new connections
+	// only succeed if the standby presents the new one.
+	newCert, newTLSCert := testClusterCert(t)
+	for _, core := range []*TestClusterCore{active, standby} {
+		core.localClusterPrivateKey.Store(newTLSCert.PrivateKey.(*ecdsa.PrivateKey))
+		core.localClusterCert.Store(newTLSCert.Certificate[0])
+		core.storeLocalClusterParsedCert(newCert)
+	}
+
+	// The forwarding connection is replaced on the next heartbeat
+	deadline := time.Now().Add(clusterTestWaitTimeout)
+	for {
+		standby.requestForwardingConnectionLock.RLock()
+		conn := standby.rpcClientConn
+		standby.requestForwardingConnectionLock.RUnlock()
+		if conn != nil && conn != oldConn {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("forwarding connection was not replaced after the cert rotation")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if err := standby.TestForward(); err != nil {
+		t.Fatalf("forwarding failed after the cert rotation: %v", err)
+	}
+}
+
+func TestCluster_CertRotationListener(t *testing.T) {
+	cluster := NewTestCluster(t, nil, nil)
+	cluster.Start()
+	defer cluster.Cleanup()
+
+	active := cluster.Cores[0]
+	TestWaitActive(t, active.Core)
+	standby := cluster.Cores[1]
+	if err := standby.RefreshForwarding(); err != nil {
+		t.Fatal(err)
+	}
+	if err := standby.TestForward(); err != nil {
+		t.Fatal(err)
+	}
+
+	standby.requestForwardingConnectionLock.RLock()
+	oldConn := standby.rpcClientConn
+	standby.requestForwardingConnectionLock.RUnlock()
+
+	// Rotate the cluster cert on the active node only, leaving its
+	// listeners running
+	newCert, newTLSCert := testClusterCert(t)
+	active.localClusterPrivateKey.Store(newTLSCert.PrivateKey.(*ecdsa.PrivateKey))
+	active.localClusterCert.Store(newTLSCert.Certificate[0])
+	active.storeLocalClusterParsedCert(newCert)
+
+	// New handshakes are served the new cert
+	pool := x509.NewCertPool()
+	pool.AddCert(newCert)
+	conn, err := tls.Dial("tcp", active.ClusterAddrs[0].String(), &tls.Config{
+		Certificates: []tls.Certificate{newTLSCert},
+		RootCAs:      pool,
+		ServerName:   newCert.Subject.CommonName,
+		NextProtos:   []string{requestForwardingALPN},
+	})
+	if err != nil {
+		t.Fatalf("handshake after the cert rotation failed: %v", err)
+	}
+	peerCerts := conn.ConnectionState().PeerCertificates
+	conn.Close()
+	if len(peerCerts) == 0 || !peerCerts[0].Equal(newCert) {
+		t.Fatal("expected the active node to present the new cert")
+	}
+
+	// The standby's established connection keeps working even though the
+	// active node no longer trusts the cert it was set up with
+	if err := standby.TestForward(); err != nil {
+		t.Fatalf("forwarding over the existing connection failed: %v", err)
+	}
+	standby.requestForwardingConnectionLock.RLock()
+	currentConn := standby.rpcClientConn
+	standby.requestForwardingConnectionLock.RUnlock()
+	if currentConn != oldConn {
+		t.Fatal("expected the existing forwarding connection to be kept")
+	}
+}
+
+// testClusterCert generates a self-signed cert shaped like the ones produced
+// by setupCluster.
+func testClusterCert(t *testing.T) (*x509.Certificate, tls.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	host, err := uuid.GenerateUUID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	host = fmt.Sprintf("fw-%s", host)
+	template := &x509.Certificate{
+		Subject: pkix.Name{
+			CommonName: host,
+		},
+		DNSNames: []string{host},
+		ExtKeyUsage: []x509.ExtKeyUsage{
+			x509.ExtKeyUsageServerAuth,
+			x509.ExtKeyUsageClientAuth,
+		},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageKeyAgreement | x509.KeyUsageCertSign,
+		SerialNumber:          big.NewInt(mathrand.Int63()),
+		NotBefore:             time.Now().Add(-30 * time.Second),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(certBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, tls.Certificate{
+		Certificate: [][]byte{certBytes},
+		PrivateKey:  key,
+		Leaf:        cert,
+	}
+}
+
+// testClusterHandshake performs a mutually-authenticated TLS handshake over an
+// in-memory pipe, with both sides trusting the given pool.
+func testClusterHandshake(serverCert, clientCert tls.Certificate, serverName string, pool *x509.CertPool) error {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	// Pin TLS 1.2 so that the client waits for the server to accept its
+	// certificate; with 1.3 the client finishes first and the server's alert
+	// would block forever on the unbuffered pipe
+	server := tls.Server(serverConn, &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+		MaxVersion:   tls.VersionTLS12,
+	})
+	client := tls.Client(clientConn, &tls.Config{
+		Certificates: []tls.Certificate{clientCert},
+		RootCAs:      pool,
+		ServerName:   serverName,
+		MinVersion:   tls.VersionTLS12,
+		MaxVersion:   tls.VersionTLS12,
+	})
+
+	errCh := make(chan error, 1)
+	go func() {
+		err := server.Handshake()
+		if err != nil {
+			// Unblock the client side
+			serverConn.Close()
+		}
+		errCh <- err
+	}()
+
+	clientErr := client.Handshake()
+	if clientErr != nil {
+		clientConn.Close()
+	}
+	serverErr := <-errCh
+
+	if clientErr != nil {
+		return clientErr
+	}
+	return serverErr
+}
+
+type testBarrierRecorder struct {
+	l   sync.Mutex
+	ops []string
+}
+
+func (r *testBarrierRecorder) record(op, key string) {
+	r.l.Lock()
+	defer r.l.Unlock()
+	r.ops = append(r.ops, op+" "+key)
+}
+
+func (r *testBarrierRecorder) reset() {
+	r.l.Lock()
+	defer r.l.Unlock()
+	r.ops = nil
+}
+
+func (r *testBarrierRecorder) OnGet(key string)    { r.record("get", key) }
+func (r *testBarrierRecorder) OnPut(key string)    { r.record("put", key) }
+func (r *testBarrierRecorder) OnDelete(key string) { r.record("delete", key) }
+
+func TestCluster_SetupBarrierOperations(t *testing.T) {
+	logger := logging.NewVaultLogger(log.Trace)
+	inm, err := inmem.NewInmem(nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := &testBarrierRecorder{}
+	conf := testCoreConfig(t, inm, logger)
+	conf.BarrierObserver = recorder
+	c, err := NewCore(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCoreUnsealed(t, c)
+
+	// Cluster info already exists after unseal, so it should only be read
+	recorder.reset()
+	if err := c.setupCluster(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"get " + coreLocalClusterInfoPath}
+	if !reflect.DeepEqual(recorder.ops, expected) {
+		t.Fatalf("bad: expected %v, got %v", expected, recorder.ops)
+	}
+
+	// With the entry gone it should be read and then rewritten
+	if err := c.barrier.Delete(context.Background(), coreLocalClusterInfoPath); err != nil {
+		t.Fatal(err)
+	}
+	recorder.reset()
+	if err := c.setupCluster(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{
+		"get " + coreLocalClusterInfoPath,
+		"put " + coreLocalClusterInfoPath,
+	}
+	if !reflect.DeepEqual(recorder.ops, expected) {
+		t.Fatalf("bad: expected %v, got %v", expected, recorder.ops)
+	}
+}
+
+func TestCluster_ValidateClusterSetup(t *testing.T) {
+	logger := logging.NewVaultLogger(log.Trace)
+	inm, err := inmem.NewInmem(nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inmha, err := inmem.NewInmemHA(nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := &testBarrierRecorder{}
+	conf := testCoreConfig(t, inm, logger)
+	conf.BarrierObserver = recorder
+	c, err := NewCore(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCoreUnsealed(t, c)
+
+	// Everything is in place after unseal on a non-HA core
+	report, err := c.ValidateClusterSetup(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(report, &ClusterSetupReport{}) {
+		t.Fatalf("bad: %#v", report)
+	}
+
+	// Pretend to be an HA node that hasn't got a cert yet and has lost its
+	// stored cluster info
+	c.clusterParamsLock.Lock()
+	c.ha = inmha.(physical.HABackend)
+	c.localClusterPrivateKey.Store((*ecdsa.PrivateKey)(nil))
+	c.localClusterCert.Store(([]byte)(nil))
+	c.storeLocalClusterParsedCert(nil)
+	c.clusterParamsLock.Unlock()
+	if err := c.barrier.Delete(context.Background(), coreLocalClusterInfoPath); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder.reset()
+	report, err = c.ValidateClusterSetup(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &ClusterSetupReport{
+		GenerateName:       true,
+		GenerateID:         true,
+		GenerateKey:        true,
+		GenerateCert:       true,
+		PersistClusterInfo: true,
+	}
+	if !reflect.DeepEqual(report, expected) {
+		t.Fatalf("bad: expected %#v, got %#v", expected, report)
+	}
+
+	// Nothing should have been written or generated
+	if ops := []string{"get " + coreLocalClusterInfoPath}; !reflect.DeepEqual(recorder.ops, ops) {
+		t.Fatalf("bad: expected %v, got %v", ops, recorder.ops)
+	}
+	if c.localClusterCert.Load().([]byte) != nil {
+		t.Fatal("cluster cert was generated")
+	}
+}
+
+func TestCluster_TestPassthroughOptions(t *testing.T) {
+	leased := func(t *testing.T, cluster *TestCluster) bool {
+		core := cluster.Cores[0]
+		TestWaitActive(t, core.Core)
+
+		ctx := namespace.RootContext(nil)
+		req := &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "secret/test",
+			Data: map[string]interface{}{
+				"foo":   "bar",
+				"lease": "1h",
+			},
+			ClientToken: cluster.RootToken,
+		}
+		if _, err := core.HandleRequest(ctx, req); err != nil {
+			t.Fatal(err)
+		}
+
+		req.Operation = logical.ReadOperation
+		req.Data = nil
+		resp, err := core.HandleRequest(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp == nil || resp.Data["foo"] != "bar" {
+			t.Fatalf("bad: %#v", resp)
+		}
+		return resp.Secret != nil && resp.Secret.LeaseID != ""
+	}
+
+	t.Run("default", func(t *testing.T) {
+		cluster := NewTestCluster(t, nil, &TestClusterOptions{
+			NumCores: 1,
+		})
+		cluster.Start()
+		defer cluster.Cleanup()
+
+		// Unlike TestCore, clusters use the non-leased passthrough by default
+		if leased(t, cluster) {
+			t.Fatal("expected no lease from the default cluster kv backend")
+		}
+	})
+
+	t.Run("leased", func(t *testing.T) {
+		cluster := NewTestCluster(t, nil, &TestClusterOptions{
+			NumCores: 1,
+			CoreOptions: &TestCoreOptions{
+				Passthrough: TestPassthroughLeased,
+			},
+		})
+		cluster.Start()
+		defer cluster.Cleanup()
+
+		if !leased(t, cluster) {
+			t.Fatal("expected a lease from the leased kv backend")
+		}
+	})
+}
+
+func TestCluster_SharedPhysicalFactory(t *testing.T) {
+	var calls int
+	var phys physical.Backend
+	cluster := NewTestClusterWithPhysical(t, nil, nil, func(logger log.Logger) (physical.Backend, physical.HABackend, error) {
+		calls++
+		var err error
+		phys, err = inmem.NewInmemHA(nil, logger)
+		return phys, nil, err
+	})
+	cluster.Start()
+	defer cluster.Cleanup()
+
+	if calls != 1 {
+		t.Fatalf("expected factory to be called once, got %d", calls)
+	}
+
+	for i, core := range cluster.Cores {
+		if core.CoreConfig.Physical != phys {
+			t.Fatalf("core[%d] not using shared physical backend", i)
+		}
+		if core.CoreConfig.HAPhysical != phys.(physical.HABackend) {
+			t.Fatalf("core[%d] not using shared HA backend", i)
+		}
+	}
+
+	TestWaitActive(t, cluster.Cores[0].Core)
+	for i := 1; i < len(cluster.Cores); i++ {
+		standby, err := cluster.Cores[i].Standby()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !standby {
+			t.Fatalf("core[%d] should be standby", i)
+		}
+	}
+}
+
+func TestCluster_ClusterMembers(t *testing.T) {
+	oldInterval := memberHeartbeatInterval
+	memberHeartbeatInterval = 100 * time.Millisecond
+	defer func() { memberHeartbeatInterval = oldInterval }()
+
+	cluster := NewTestCluster(t, &CoreConfig{
+		MemberHeartbeatTTL: time.Second,
+	}, nil)
+	cluster.Start()
+	defer cluster.Cleanup()
+
+	core := cluster.Cores[0].Core
+	TestWaitActive(t, core)
+
+	clusterInfo, err := core.Cluster(namespace.RootContext(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var members []*ClusterMember
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		members, err = core.ClusterMembers()
+		if err != nil {
+			t.Fatal(err)
 		}
-		t.Fatalf("got bad negotiated cipher %x, core-set suites are %s", conn.ConnectionState().CipherSuite, availCiphers)
+		if len(members) == len(cluster.Cores) && members[0].Role == ClusterMemberRoleActive {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for cluster members, got %d", len(members))
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	for i, member := range members {
+		c := cluster.Cores[i]
+		if member.NodeID != fmt.Sprintf("core-%d", i) {
+			t.Fatalf("bad node ID for member %d: %q", i, member.NodeID)
+		}
+		if member.APIAddr != c.CoreConfig.RedirectAddr {
+			t.Fatalf("bad API address for %s: %q", member.NodeID, member.APIAddr)
+		}
+		if member.ClusterAddr != c.CoreConfig.ClusterAddr {
+			t.Fatalf("bad cluster address for %s: %q", member.NodeID, member.ClusterAddr)
+		}
+		if member.ClusterID != clusterInfo.ID {
+			t.Fatalf("bad cluster ID for %s: %q", member.NodeID, member.ClusterID)
+		}
+		if i > 0 && member.Role != ClusterMemberRoleStandby {
+			t.Fatalf("bad role for %s: %q", member.NodeID, member.Role)
+		}
+		if member.LastSeen.IsZero() {
+			t.Fatalf("missing last seen time for %s", member.NodeID)
+		}
+	}
+
+	// Members that stop sending heartbeats age out
+	if err := cluster.Cores[2].Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(1500 * time.Millisecond)
+
+	members, err = core.ClusterMembers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 2 || members[0].NodeID != "core-0" || members[1].NodeID != "core-1" {
+		t.Fatalf("expected only the unsealed nodes to be members, got %d members", len(members))
+	}
+}
+
+func TestCluster_EvictStaleMembers(t *testing.T) {
+	oldInterval := memberHeartbeatInterval
+	memberHeartbeatInterval = 100 * time.Millisecond
+	defer func() { memberHeartbeatInterval = oldInterval }()
+
+	evictedCh := make(chan *ClusterMember, 3)
+	cluster := NewTestCluster(t, &CoreConfig{
+		MemberHeartbeatTTL: time.Second,
+		MemberScanInterval: 100 * time.Millisecond,
+		OnMemberEvicted: func(member *ClusterMember) {
+			evictedCh <- member
+		},
+	}, nil)
+	cluster.Start()
+	defer cluster.Cleanup()
+
+	core := cluster.Cores[0].Core
+	TestWaitActive(t, core)
+
+	// Wait for every node to have sent a heartbeat
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		members, err := core.ClusterMembers()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(members) == len(cluster.Cores) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for cluster members, got %d", len(members))
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	// A node that stops heartbeating without the others noticing
+	if err := cluster.Cores[2].Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	stoppedAt := time.Now()
+
+	select {
+	case member := <-evictedCh:
+		if member.NodeID != "core-2" {
+			t.Fatalf("evicted the wrong member: %q", member.NodeID)
+		}
+		if elapsed := time.Since(stoppedAt); elapsed < 500*time.Millisecond {
+			t.Fatalf("member evicted before its TTL ran out, after %s", elapsed)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for member eviction")
+	}
+
+	members, err := core.readClusterMembers(namespace.RootContext(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 2 {
+		t.Fatalf("expected the evicted member's entry to be removed, got %d entries", len(members))
+	}
+	for _, member := range members {
+		if member.NodeID == "core-2" {
+			t.Fatal("evicted member's entry still stored")
+		}
+	}
+
+	select {
+	case member := <-evictedCh:
+		t.Fatalf("unexpected eviction of %q", member.NodeID)
+	default:
+	}
+
+	// A member that sent a heartbeat since the scan read it is kept
+	ctx := namespace.RootContext(nil)
+	cutoff := time.Now()
+	value, err := jsonutil.EncodeJSON(&ClusterMember{
+		NodeID:   "core-late",
+		LastSeen: cutoff.Add(time.Second),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := core.barrier.Put(ctx, &Entry{Key: coreClusterMembersPrefix + "core-late", Value: value}); err != nil {
+		t.Fatal(err)
+	}
+	if evicted, err := core.evictStaleMember(ctx, "core-late", cutoff); err != nil || evicted != nil {
+		t.Fatalf("evicted a member with a fresh heartbeat: %#v, %v", evicted, err)
+	}
+	if entry, err := core.barrier.Get(ctx, coreClusterMembersPrefix+"core-late"); err != nil || entry == nil {
+		t.Fatalf("fresh member entry was deleted: %v", err)
+	}
+
+	// A TTL that healthy members can't keep up with is refused
+	if _, err := NewCore(&CoreConfig{
+		Physical:           core.physical,
+		DisableMlock:       true,
+		MemberHeartbeatTTL: memberHeartbeatInterval,
+	}); err == nil {
+		t.Fatal("expected a member heartbeat TTL below twice the interval to be refused")
+	}
+}
+
+// testClusterClientHandshake performs a TLS handshake using clientConfig
+// against a server presenting serverCert, which asks for but does not verify
+// a client certificate.
+func testClusterClientHandshake(serverCert tls.Certificate, clientConfig *tls.Config) error {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	server := tls.Server(serverConn, &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequestClientCert,
+		MaxVersion:   tls.VersionTLS12,
+	})
+	client := tls.Client(clientConn, clientConfig)
+
+	errCh := make(chan error, 1)
+	go func() {
+		err := server.Handshake()
+		if err != nil {
+			// Unblock the client side
+			serverConn.Close()
+		}
+		errCh <- err
+	}()
+
+	clientErr := client.Handshake()
+	if clientErr != nil {
+		clientConn.Close()
+	}
+	serverErr := <-errCh
+
+	if clientErr != nil {
+		return clientErr
+	}
+	return serverErr
+}
+
+func TestCluster_CertMaxAge(t *testing.T) {
+	clock := newTestClock(time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC))
+	cluster := NewTestCluster(t, &CoreConfig{
+		Clock:             clock,
+		ClusterCertMaxAge: 90 * 24 * time.Hour,
+	}, nil)
+	cluster.Start()
+	defer cluster.Cleanup()
+	TestWaitActive(t, cluster.Cores[0].Core)
+
+	orig := cluster.Cores[0].localClusterParsedCert.Load().(*x509.Certificate)
+	if orig == nil {
+		t.Fatal("expected a cluster cert")
+	}
+
+	// activeCert waits for a node to be active with a cert other than prev
+	activeCert := func(prev *x509.Certificate) (*TestClusterCore, *x509.Certificate) {
+		t.Helper()
+		deadline := time.Now().Add(clusterTestWaitTimeout)
+		for time.Now().Before(deadline) {
+			for _, core := range cluster.Cores {
+				if standby, err := core.Standby(); err != nil || standby {
+					continue
+				}
+				cert := core.localClusterParsedCert.Load().(*x509.Certificate)
+				if cert != nil && !cert.Equal(prev) {
+					return core, cert
+				}
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		return nil, nil
+	}
+
+	// Wait for the active node to set its timer for the cert's max age, so
+	// that advancing the clock below is seen by it
+	expiry := orig.NotBefore.Add(90 * 24 * time.Hour)
+	deadline := time.Now().Add(clusterTestWaitTimeout)
+	for !clock.hasTimer(expiry) {
+		if time.Now().After(deadline) {
+			t.Fatal("active node did not set a timer for the cluster cert max age")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// A cert younger than the max age is kept. The timer runs when the clock
+	// is advanced, so it not having run yet means it won't.
+	clock.Advance(30 * 24 * time.Hour)
+	if !clock.hasTimer(expiry) {
+		t.Fatal("cluster cert max age timer went off early")
+	}
+	if standby, err := cluster.Cores[0].Standby(); err != nil || standby {
+		t.Fatalf("active node stepped down before the cert reached the max age: %v", err)
+	}
+	if cert := cluster.Cores[0].localClusterParsedCert.Load().(*x509.Certificate); !cert.Equal(orig) {
+		t.Fatal("cluster cert was regenerated before reaching the max age")
+	}
+
+	// Once it is older than that the active node steps down, and the next
+	// active node brings a new one
+	clock.Advance(61 * 24 * time.Hour)
+	active, cert := activeCert(orig)
+	if active == nil {
+		t.Fatal("cluster cert was not rotated after reaching the max age")
+	}
+	if !cert.NotBefore.Equal(clock.Now().Add(-30 * time.Second)) {
+		t.Fatalf("bad NotBefore on regenerated cert: %v", cert.NotBefore)
+	}
+
+	// Standbys pick up the new cert once they see the new active node's
+	// advertisement, which it writes after generating the cert
+	for _, core := range cluster.Cores {
+		if core == active {
+			continue
+		}
+		deadline := time.Now().Add(clusterTestWaitTimeout)
+		for {
+			err := core.RefreshForwarding()
+			if err == nil {
+				err = core.TestForward()
+			}
+			if err == nil {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal(err)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+}
+
+func TestCluster_LoadLocalClusterTLS_Errors(t *testing.T) {
+	c, _, _ := TestCoreUnsealed(t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]struct {
+		params *clusterKeyParams
+		kind   error
+	}{
+		"key parse": {
+			params: &clusterKeyParams{
+				Type: corePrivateKeyTypeP521,
+				X:    key.X,
+			},
+			kind: ErrClusterKeyParse,
+		},
+		"missing cert": {
+			params: &clusterKeyParams{
+				Type: corePrivateKeyTypeP521,
+				X:    key.X,
+				Y:    key.Y,
+				D:    key.D,
+			},
+			kind: ErrClusterCertMissing,
+		},
+	}
+	for name, tc := range cases {
+		err := c.loadLocalClusterTLS(activeAdvertisement{
+			ClusterAddr:      "https://127.0.0.1:8201",
+			ClusterKeyParams: tc.params,
+		})
+		if !errors.Is(err, tc.kind) {
+			t.Fatalf("%s: expected %q, got %v", name, tc.kind, err)
+		}
+		for _, other := range []error{ErrClusterKeyParse, ErrClusterCertMissing} {
+			if other != tc.kind && errors.Is(err, other) {
+				t.Fatalf("%s: error also matches %q", name, other)
+			}
+		}
+		// The message is unchanged for anyone matching on it
+		if err.Error() != tc.kind.Error() || !errwrap.Contains(err, tc.kind.Error()) {
+			t.Fatalf("%s: bad error message %q", name, err)
+		}
+	}
+}
+
+func TestCluster_MaxClusterListeners(t *testing.T) {
+	c, _, _ := TestCoreUnsealedWithConfig(t, &CoreConfig{
+		MaxClusterListeners: 2,
+	})
+
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+	c.clusterAddr = "https://127.0.0.1:8201"
+	for i := 0; i < 3; i++ {
+		c.clusterListenAddrs = append(c.clusterListenAddrs, &net.TCPAddr{
+			IP:   net.ParseIP("127.0.0.1"),
+			Port: 0,
+		})
+	}
+
+	err := c.startClusterListener(context.Background())
+	if !errors.Is(err, ErrClusterTooManyListeners) {
+		t.Fatalf("expected %q, got %v", ErrClusterTooManyListeners, err)
+	}
+	if c.clusterListenersRunning || len(c.ClusterListenerAddrs()) != 0 {
+		t.Fatal("cluster listeners should not have been started")
+	}
+}
+
+func TestCluster_TestForward(t *testing.T) {
+	cluster := NewTestCluster(t, nil, nil)
+	recorder := NewRecordingHandler()
+	cluster.Cores[0].Handler.(*http.ServeMux).Handle("/", recorder)
+	cluster.Start()
+	defer cluster.Cleanup()
+
+	active := cluster.Cores[0]
+	TestWaitActive(t, active.Core)
+	standby := cluster.Cores[1]
+	if err := standby.RefreshForwarding(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := standby.TestForward(); err != nil {
+		t.Fatal(err)
+	}
+	// The test request is answered without reaching the active node's
+	// handler
+	if n := len(recorder.Requests()); n != 0 {
+		t.Fatalf("expected the test request not to be handled, got %d requests", n)
+	}
+
+	// The active node has nothing to forward to
+	if err := active.TestForward(); err == nil {
+		t.Fatal("expected an error testing forwarding from the active node")
+	}
+
+	// Nor does a standby whose forwarding connection is gone
+	if err := standby.refreshRequestForwardingConnection(context.Background(), ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := standby.TestForward(); !errwrap.Contains(err, ErrCannotForward.Error()) {
+		t.Fatalf("expected %q, got %v", ErrCannotForward, err)
+	}
+}
+
+func TestCluster_ForwardDuringStepDown(t *testing.T) {
+	cluster := NewTestCluster(t, nil, nil)
+	recorder := NewRecordingHandler()
+	recorder.StatusCode = 201
+	recorder.Header.Set("Content-Type", "application/json")
+	recorder.Body = []byte("core1")
+	cluster.Cores[0].Handler.(*http.ServeMux).Handle("/core1", recorder)
+	cluster.Start()
+	defer cluster.Cleanup()
+
+	active := cluster.Cores[0]
+	TestWaitActive(t, active.Core)
+	standby := cluster.Cores[1]
+	testCluster_ForwardRequests(t, standby, cluster.RootToken, "core1", recorder)
+
+	forward := func() error {
+		req, err := http.NewRequest("PUT", "https://pushit.real.good:9281/core1", bytes.NewReader([]byte(`{}`)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Add(consts.AuthHeaderName, cluster.RootToken)
+		req = req.WithContext(context.WithValue(req.Context(), "original_request_path", req.URL.Path))
+		_, _, _, err = standby.ForwardRequest(req)
+		return err
+	}
+
+	// Hold the state lock as an in-flight request would, which keeps the
+	// step-down from getting as far as closing the cluster listener
+	active.stateLock.RLock()
+	released := false
+	defer func() {
+		if !released {
+			active.stateLock.RUnlock()
+		}
+	}()
+	err := active.StepDown(context.Background(), &logical.Request{
+		Operation:   logical.UpdateOperation,
+		Path:        "sys/step-down",
+		ClientToken: cluster.RootToken,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Requests forwarded in the meantime are turned away with a retriable
+	// error instead of being handled by a node on its way out
+	deadline := time.Now().Add(clusterTestWaitTimeout)
+	for {
+		err := forward()
+		if err == ErrForwardingNodeNotActive {
+			break
+		}
+		if err != nil || time.Now().After(deadline) {
+			t.Fatalf("expected %q, got %v", ErrForwardingNodeNotActive, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	handled := len(recorder.Requests())
+	if err := forward(); err != ErrForwardingNodeNotActive {
+		t.Fatalf("expected %q, got %v", ErrForwardingNodeNotActive, err)
+	}
+	if n := len(recorder.Requests()); n != handled {
+		t.Fatalf("rejected request reached the handler: %d requests, expected %d", n, handled)
+	}
+
+	released = true
+	active.stateLock.RUnlock()
+	testWaitAnyActive(t, cluster.Cores[1], cluster.Cores[2])
+}
+
+func TestCluster_ActiveAdvertiseAddr(t *testing.T) {
+	cluster := NewTestCluster(t, nil, nil)
+	cluster.Start()
+	defer cluster.Cleanup()
+
+	active := cluster.Cores[0]
+	TestWaitActive(t, active.Core)
+
+	for _, core := range cluster.Cores {
+		addr, err := core.ActiveAdvertiseAddr()
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if addr != active.redirectAddr {
+			t.Fatalf("bad: expected %q, got %q", active.redirectAddr, addr)
+		}
+	}
+}
+
+func TestCluster_ProxyProtocol(t *testing.T) {
+	// Only a load balancer that isn't there may send headers
+	lb, err := sockaddr.NewSockAddr("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cluster := NewTestCluster(t, &CoreConfig{
+		ClusterProxyProtocol: true,
+		ClusterProxyAuthorizedAddrs: []*sockaddr.SockAddrMarshaler{
+			&sockaddr.SockAddrMarshaler{SockAddr: lb},
+		},
+	}, nil)
+	cluster.Start()
+	defer cluster.Cleanup()
+
+	active := cluster.Cores[0]
+	TestWaitActive(t, active.Core)
+
+	// Standbys connecting directly, without a PROXY protocol header, are
+	// still served
+	for _, standby := range cluster.Cores[1:] {
+		if err := standby.RefreshForwarding(); err != nil {
+			t.Fatal(err)
+		}
+		if err := standby.TestForward(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Anyone else sending a header is refused
+	conn, err := net.Dial("tcp", active.ClusterListenerAddrs()[0].String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("PROXY TCP4 10.1.2.3 10.0.0.1 51234 8201\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	tlsConn := tls.Client(conn, &tls.Config{
+		InsecureSkipVerify: true,
+		NextProtos:         []string{requestForwardingALPN},
+	})
+	if err := tlsConn.Handshake(); err == nil {
+		t.Fatal("expected connection with a spoofed PROXY protocol header to be refused")
 	}
 }
@@ -34,8 +34,11 @@ var (
 
 	clusterTLSClientLookup = func(ctx context.Context, c *Core, repClusters *ReplicatedClusters, _ *ReplicatedCluster) func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
 		return func(requestInfo *tls.CertificateRequestInfo) (*tls.Certificate, error) {
-			if len(requestInfo.AcceptableCAs) != 1 {
-				return nil, fmt.Errorf("expected only a single acceptable CA")
+			// During a cert rotation the server may advertise both the
+			// current and the previous cluster cert. A server intercepting
+			// the traffic for debugging may not advertise any.
+			if len(requestInfo.AcceptableCAs) == 0 && !c.clusterInsecureSkipVerify {
+				return nil, fmt.Errorf("expected at least one acceptable CA")
 			}
 
 			currCert := c.localClusterCert.Load().([]byte)
@@ -58,10 +61,16 @@ var (
 		return func(clientHello *tls.ClientHelloInfo) (*tls.Config, error) {
 			//c.logger.Trace("performing server config lookup")
 
-			caPool := x509.NewCertPool()
+			parsedCert := c.localClusterParsedCert.Load().(*x509.Certificate)
+
+			if parsedCert == nil {
+				return nil, fmt.Errorf("forwarding connection client but no local cert")
+			}
+
+			caPool := c.clusterCertPool()
 
 			ret := &tls.Config{
-				ClientAuth:           tls.RequireAndVerifyClientCert,
+				ClientAuth:           c.clusterClientAuth,
 				GetCertificate:       clusterTLSServerLookup(ctx, c, repClusters, repCluster),
 				GetClientCertificate: clusterTLSClientLookup(ctx, c, repClusters, repCluster),
 				MinVersion:           tls.VersionTLS12,
@@ -70,15 +79,15 @@ var (
 				NextProtos:           clientHello.SupportedProtos,
 				CipherSuites:         c.clusterCipherSuites,
 			}
+			c.setClusterInsecureSkipVerify(ret)
 
-			parsedCert := c.localClusterParsedCert.Load().(*x509.Certificate)
-
-			if parsedCert == nil {
-				return nil, fmt.Errorf("forwarding connection client but no local cert")
+			// Sealed standbys forwarding health checks have no cluster key
+			// to authenticate with; the connection can't be used for
+			// anything else
+			if len(clientHello.SupportedProtos) == 1 && clientHello.SupportedProtos[0] == healthForwardingALPN {
+				ret.ClientAuth = tls.NoClientCert
 			}
 
-			caPool.AddCert(parsedCert)
-
 			return ret, nil
 		}
 	}
@@ -0,0 +1,106 @@
+package vault
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	log "github.com/hashicorp/go-hclog"
+)
+
+// reloadableConfigFields are the CoreConfig fields that Reload can apply to a
+// running core
+var reloadableConfigFields = map[string]bool{
+	"LogLevel":                     true,
+	"DefaultLeaseTTL":              true,
+	"MaxLeaseTTL":                  true,
+	"RequestTimeout":               true,
+	"ForwardingReconnectBaseDelay": true,
+	"ForwardingReconnectMaxDelay":  true,
+}
+
+// Reload applies the settings set in partial to the running core without
+// re-initializing it. Only the log level, the system default and max lease
+// TTLs, the request timeout and the forwarding reconnect delays can be
+// reloaded; unset fields are left as they are, and setting any other field is
+// an error. Nothing is applied unless the whole of partial is valid. New TTLs
+// apply to leases created after the reload; existing leases keep theirs.
+func (c *Core) Reload(partial *CoreConfig) error {
+	if partial == nil {
+		return nil
+	}
+
+	v := reflect.ValueOf(partial).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		name := v.Type().Field(i).Name
+		if reloadableConfigFields[name] {
+			continue
+		}
+		field := v.Field(i)
+		if !reflect.DeepEqual(field.Interface(), reflect.Zero(field.Type()).Interface()) {
+			return fmt.Errorf("%s cannot be changed without a restart", name)
+		}
+	}
+
+	if partial.DefaultLeaseTTL < 0 || partial.MaxLeaseTTL < 0 {
+		return fmt.Errorf("lease TTLs cannot be negative")
+	}
+	if partial.RequestTimeout < 0 {
+		return fmt.Errorf("request timeout cannot be negative")
+	}
+	if partial.ForwardingReconnectBaseDelay < 0 || partial.ForwardingReconnectMaxDelay < 0 {
+		return fmt.Errorf("forwarding reconnect delays cannot be negative")
+	}
+
+	c.reloadableConfigLock.Lock()
+	defer c.reloadableConfigLock.Unlock()
+
+	defaultLeaseTTL, maxLeaseTTL := c.defaultLeaseTTL, c.maxLeaseTTL
+	if partial.DefaultLeaseTTL != 0 {
+		defaultLeaseTTL = partial.DefaultLeaseTTL
+	}
+	if partial.MaxLeaseTTL != 0 {
+		maxLeaseTTL = partial.MaxLeaseTTL
+	}
+	if defaultLeaseTTL > maxLeaseTTL {
+		return fmt.Errorf("cannot have DefaultLeaseTTL larger than MaxLeaseTTL")
+	}
+
+	baseDelay, maxDelay := c.forwardingReconnectBaseDelay, c.forwardingReconnectMaxDelay
+	if partial.ForwardingReconnectBaseDelay != 0 {
+		baseDelay = partial.ForwardingReconnectBaseDelay
+	}
+	if partial.ForwardingReconnectMaxDelay != 0 {
+		maxDelay = partial.ForwardingReconnectMaxDelay
+	}
+	if maxDelay < baseDelay {
+		return fmt.Errorf("forwarding reconnect max delay cannot be less than the base delay")
+	}
+
+	c.defaultLeaseTTL, c.maxLeaseTTL = defaultLeaseTTL, maxLeaseTTL
+	c.forwardingReconnectBaseDelay, c.forwardingReconnectMaxDelay = baseDelay, maxDelay
+	if partial.RequestTimeout != 0 {
+		c.requestTimeout = partial.RequestTimeout
+	}
+	if partial.LogLevel != log.NoLevel {
+		c.SetLogLevel(partial.LogLevel)
+	}
+
+	c.logger.Info("reloaded configuration", "default_lease_ttl", c.defaultLeaseTTL, "max_lease_ttl", c.maxLeaseTTL, "request_timeout", c.requestTimeout)
+	return nil
+}
+
+// leaseTTLs returns the system default and max lease TTLs
+func (c *Core) leaseTTLs() (def, max time.Duration) {
+	c.reloadableConfigLock.RLock()
+	defer c.reloadableConfigLock.RUnlock()
+	return c.defaultLeaseTTL, c.maxLeaseTTL
+}
+
+// currentRequestTimeout returns how long a request may run inside Core, or
+// zero for no limit
+func (c *Core) currentRequestTimeout() time.Duration {
+	c.reloadableConfigLock.RLock()
+	defer c.reloadableConfigLock.RUnlock()
+	return c.requestTimeout
+}
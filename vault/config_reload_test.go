@@ -0,0 +1,69 @@
+package vault
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestCore_Reload_MaxLeaseTTL(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "secret/test",
+		Data: map[string]interface{}{
+			"foo":   "bar",
+			"lease": "1000h",
+		},
+		ClientToken: root,
+	}
+	ctx := namespace.RootContext(nil)
+	if _, err := c.HandleRequest(ctx, req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := c.Reload(&CoreConfig{DefaultLeaseTTL: 30 * time.Minute, MaxLeaseTTL: time.Hour}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	req.Operation = logical.ReadOperation
+	req.Data = nil
+	resp, err := c.HandleRequest(ctx, req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp == nil || resp.Secret == nil {
+		t.Fatalf("bad: %#v", resp)
+	}
+	if resp.Secret.TTL != time.Hour {
+		t.Fatalf("expected the reloaded max lease TTL to apply, got %v", resp.Secret.TTL)
+	}
+}
+
+func TestCore_Reload_Rejected(t *testing.T) {
+	c, _, _ := TestCoreUnsealed(t)
+	def, max := c.leaseTTLs()
+
+	// Not reloadable
+	if err := c.Reload(&CoreConfig{ClusterName: "foo"}); err == nil {
+		t.Fatal("expected error reloading the cluster name")
+	}
+	if err := c.Reload(&CoreConfig{ClusterName: "foo", MaxLeaseTTL: time.Hour}); err == nil {
+		t.Fatal("expected error reloading the cluster name")
+	}
+
+	// Invalid once merged with the current settings
+	if err := c.Reload(&CoreConfig{DefaultLeaseTTL: max + time.Hour}); err == nil {
+		t.Fatal("expected error reloading a default lease TTL larger than the max")
+	}
+	if err := c.Reload(&CoreConfig{RequestTimeout: -time.Second}); err == nil {
+		t.Fatal("expected error reloading a negative request timeout")
+	}
+
+	if newDef, newMax := c.leaseTTLs(); newDef != def || newMax != max {
+		t.Fatalf("rejected reload changed lease TTLs: %v/%v, expected %v/%v", newDef, newMax, def, max)
+	}
+}
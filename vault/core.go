@@ -4,13 +4,16 @@ import (
 	"context"
 	"crypto/ecdsa"
 	"crypto/subtle"
+	"crypto/tls"
 	"crypto/x509"
 	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"net/url"
+	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -23,13 +26,14 @@ import (
 
 	"github.com/hashicorp/errwrap"
 	"github.com/hashicorp/go-multierror"
+	sockaddr "github.com/hashicorp/go-sockaddr"
 	"github.com/hashicorp/go-uuid"
 	"github.com/hashicorp/vault/audit"
 	"github.com/hashicorp/vault/helper/consts"
-	"github.com/hashicorp/vault/helper/jsonutil"
 	"github.com/hashicorp/vault/helper/logging"
 	"github.com/hashicorp/vault/helper/mlock"
 	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/helper/pathmanager"
 	"github.com/hashicorp/vault/helper/reload"
 	"github.com/hashicorp/vault/helper/tlsutil"
 	"github.com/hashicorp/vault/logical"
@@ -54,6 +58,11 @@ const (
 	// information for primaries
 	knownPrimaryAddrsPrefix = "core/primary-addrs/"
 
+	// defaultManualStepDownSleepPeriod is how long to sleep after a
+	// user-initiated step down of the active node, to prevent instantly
+	// regrabbing the lock, if not set in CoreConfig
+	defaultManualStepDownSleepPeriod = 10 * time.Second
+
 	// coreKeyringCanaryPath is used as a canary to indicate to replicated
 	// clusters that they need to perform a rekey operation synchronously; this
 	// isn't keyring-canary to avoid ignoring it when ignoring core/keyring
@@ -77,10 +86,9 @@ var (
 	// in an HA setting
 	ErrHANotEnabled = errors.New("Vault is not configured for highly-available mode")
 
-	// manualStepDownSleepPeriod is how long to sleep after a user-initiated
-	// step down of the active node, to prevent instantly regrabbing the lock.
-	// It's var not const so that tests can manipulate it.
-	manualStepDownSleepPeriod = 10 * time.Second
+	// ErrHALockLost is passed to the OnLeadershipLost callback when the
+	// active node loses its HA lock without having been asked to step down
+	ErrHALockLost = errors.New("HA lock lost unexpectedly")
 
 	// Functions only in the Enterprise version
 	enterprisePostUnseal = enterprisePostUnsealImpl
@@ -148,10 +156,16 @@ type Core struct {
 
 	// HABackend may be available depending on the physical backend
 	ha physical.HABackend
+	// The key of the HA lock, CoreLockPath under CoreConfig.StoragePrefix
+	coreLockPath string
 
 	// redirectAddr is the address we advertise as leader if held
 	redirectAddr string
 
+	// nodeID identifies this node among those sharing storage, and keeps the
+	// data of node-local mounts apart
+	nodeID string
+
 	// clusterAddr is the address we use for clustering
 	clusterAddr string
 
@@ -184,6 +198,11 @@ type Core struct {
 	stateLock sync.RWMutex
 	sealed    *uint32
 
+	// unsealedCh is closed once the core is unsealed and replaced when it is
+	// sealed again, for WaitForUnseal to block on
+	unsealedCh     chan struct{}
+	unsealedChLock sync.Mutex
+
 	standby              bool
 	perfStandby          bool
 	standbyDoneCh        chan struct{}
@@ -192,9 +211,46 @@ type Core struct {
 	keepHALockOnStepDown *uint32
 	heldHALock           physical.Lock
 
+	// leavingActiveDuty is set from the moment the active node decides to
+	// give up active duty until it next becomes active, so that forwarded
+	// requests arriving in between are turned away cleanly
+	leavingActiveDuty *uint32
+
+	// inMaintenance is set while only reads are being served; see
+	// EnterMaintenance
+	inMaintenance *uint32
+
+	// How long a newly active node defers writes at least, and whether it
+	// currently is, along with the channel that ends the grace period early
+	activeWriteGracePeriod time.Duration
+	inActiveWriteGrace     *uint32
+	activeWriteGraceStopCh chan struct{}
+	activeWriteGraceLock   sync.Mutex
+
+	// manualStepDownSleepPeriod is how long to wait after a user-initiated
+	// step down before campaigning for the lock again
+	manualStepDownSleepPeriod time.Duration
+
+	// stepDownDoneCh is closed once the node next finishes giving up active
+	// duty; see StepDownDoneCh
+	stepDownDoneCh   chan struct{}
+	stepDownDoneLock sync.Mutex
+
+	// leadershipSuspendedUntil is when a suspension set by SuspendLeadership
+	// lapses; leadershipSuspendCh is closed whenever it changes
+	leadershipSuspendedUntil time.Time
+	leadershipSuspendCh      chan struct{}
+	leadershipSuspendLock    sync.Mutex
+
 	// unlockInfo has the keys provided to Unseal until the threshold number of parts is available, as well as the operation nonce
 	unlockInfo *unlockInformation
 
+	// unsealLockout tracks failed unseal attempts and any resulting lockout;
+	// protected by stateLock
+	unsealLockout *unsealLockout
+	// unsealAuthorizer may refuse submitted unseal key shares
+	unsealAuthorizer UnsealAuthorizerFunc
+
 	// generateRootProgress holds the shares until we reach enough
 	// to verify the master key
 	generateRootConfig   *GenerateRootConfig
@@ -272,6 +328,10 @@ type Core struct {
 	// metrics emission and sealing leading to a nil pointer
 	metricsMutex sync.Mutex
 
+	// reloadableConfigLock guards the settings that Reload can change while
+	// running
+	reloadableConfigLock sync.RWMutex
+
 	defaultLeaseTTL time.Duration
 	maxLeaseTTL     time.Duration
 
@@ -303,8 +363,34 @@ type Core struct {
 	clusterName string
 	// Specific cipher suites to use for clustering, if any
 	clusterCipherSuites []uint16
+	// The client certificate policy for the cluster listener
+	clusterClientAuth tls.ClientAuthType
+	// clusterInsecureSkipVerify disables verification of cluster certificates
+	clusterInsecureSkipVerify bool
+	// clusterRetryUntrustedPeer reloads the cluster TLS information and
+	// retries when forwarding fails with ErrClusterPeerUntrusted, and
+	// untrustedPeerRetryLast is when that was last done
+	clusterRetryUntrustedPeer bool
+	untrustedPeerRetryLast    time.Time
+	untrustedPeerRetryLock    sync.Mutex
+	// forwardSealedHealthRequests lets this node forward health checks to
+	// the active node while sealed
+	forwardSealedHealthRequests bool
+	// clusterTrustedPeerCerts are the certs of nodes outside this cluster
+	// that are added to the cluster cert pool
+	clusterTrustedPeerCerts []*x509.Certificate
 	// Used to modify cluster parameters
 	clusterParamsLock sync.RWMutex
+
+	// serviceRegistration is notified when this node becomes active or stops
+	// being active. The calls are queued in serviceRegistrationQueue and made
+	// in order by a goroutine of their own, and serviceRegistered tracks
+	// whether this node is registered.
+	serviceRegistration        ServiceRegistration
+	serviceRegistrationQueue   []serviceRegistrationUpdate
+	serviceRegistrationRunning bool
+	serviceRegistrationLock    sync.Mutex
+	serviceRegistered          bool
 	// The private key stored in the barrier used for establishing
 	// mutually-authenticated connections between Vault cluster members
 	localClusterPrivateKey *atomic.Value
@@ -312,13 +398,80 @@ type Core struct {
 	localClusterCert *atomic.Value
 	// The parsed form of the local cluster cert
 	localClusterParsedCert *atomic.Value
+	// The previous parsed local cluster cert, kept trusted for the overlap
+	// window after a rotation
+	localClusterPrevParsedCert *atomic.Value
+	// Serializes changes to the parsed local cluster cert, so that the cert
+	// being replaced is always the one retired
+	localClusterParsedCertLock sync.Mutex
+	// How long a rotated-out local cluster cert remains trusted
+	clusterCertOverlapPeriod time.Duration
+	// How old the local cluster cert may get before the active node steps down
+	// to have it replaced
+	clusterCertMaxAge time.Duration
+
+	// Whether leases are stored grouped into buckets rather than one storage
+	// entry per lease
+	compactLeaseStorage bool
+
+	// How long a root token stays valid after being rotated out
+	rootTokenRotationGracePeriod time.Duration
+	// Serializes root token rotations
+	rootTokenRotationLock sync.Mutex
+	// How often the active node rotates the root token last returned by
+	// RotateRootToken, where to hand the new one to, and the channel that
+	// stops the rotation on giving up active duty
+	rootTokenRotationPeriod time.Duration
+	rootTokenDelivery       RootTokenDeliveryFunc
+	rootTokenRotationStopCh chan struct{}
+
+	// How many bytes of a write's raw request body are hashed into the
+	// audit log; zero disables request body auditing
+	auditRequestBodyLimit int64
+
+	// clock is the source of time for cluster certs and leases
+	clock Clock
+	// The largest request body that will be forwarded to or accepted from
+	// another node, or zero for no limit
+	maxRequestSize int64
+	// How long a single request may run inside Core before it is cancelled,
+	// or zero for no limit beyond the caller's own context
+	requestTimeout time.Duration
+	// Whether to gzip the bodies of forwarded requests and responses
+	clusterCompression bool
+	// Whether cluster connections may start with a PROXY protocol header
+	clusterProxyProtocol bool
+	// The peers allowed to send a PROXY protocol header
+	clusterProxyAuthorizedAddrs []*sockaddr.SockAddrMarshaler
+	// Paths a standby never forwards to the active node
+	neverForwardPaths *pathmanager.PathManager
+	// Rewrites the scheme and host of requests forwarded to the active node
+	forwardedRequestRewrite ForwardedRequestRewriteFunc
+	// Keeps the latest forwarded requests for debugging, if enabled
+	forwardedRequestCapture *forwardedRequestCapture
+	// Accounts the requests forwarded to this node per peer
+	clusterPeerStats *clusterPeerStats
+	// The ID of the cluster this node belongs to, used to make sure requests
+	// are only forwarded within the cluster
+	localClusterID *atomic.Value
 	// The TCP addresses we should use for clustering
 	clusterListenerAddrs []*net.TCPAddr
+	// Bind addresses for cluster listeners that take precedence over
+	// clusterListenerAddrs, if set
+	clusterListenAddrs []*net.TCPAddr
+	// How long to keep retrying to bind a cluster listener whose address is
+	// still in use
+	clusterListenerBindTimeout time.Duration
+	// The most cluster listeners that may be started
+	maxClusterListeners int
 	// The handler to use for request forwarding
 	clusterHandler http.Handler
 	// Tracks whether cluster listeners are running, e.g. it's safe to send a
 	// shutdown down the channel
 	clusterListenersRunning bool
+	// The addresses the running cluster listeners are bound to
+	clusterListenerBoundAddrs     []net.Addr
+	clusterListenerBoundAddrsLock sync.RWMutex
 	// Shutdown channel for the cluster listeners
 	clusterListenerShutdownCh chan struct{}
 	// Shutdown success channel. We need this to be done serially to ensure
@@ -336,6 +489,24 @@ type Core struct {
 	clusterLeaderClusterAddr string
 	// Lock for the cluster leader values
 	clusterLeaderParamsLock sync.RWMutex
+	// How long a read of the HA lock is trusted before going back to storage
+	leaderLookupCacheTTL time.Duration
+	// How long to wait before retrying a failed HA lock acquisition
+	haLockRetryInterval time.Duration
+	// Called when the HA lock is lost involuntarily
+	onLeadershipLost func(reason error)
+	// Consulted before each request is handled, if set
+	requestLimiter RequestLimiter
+	// How long a cluster member's heartbeat stays fresh, and how often the
+	// active node looks for members to evict
+	memberHeartbeatTTL time.Duration
+	memberScanInterval time.Duration
+	// Called when the active node evicts a dead cluster member
+	onMemberEvicted func(member *ClusterMember)
+	// Serializes this node's membership heartbeats with evicting members
+	clusterMembersLock sync.Mutex
+	// The most recent read of the HA lock
+	leaderLookupCache leaderLookupCache
 	// Info on cluster members
 	clusterPeerClusterAddrsCache *cache.Cache
 	// Stores whether we currently have a server running
@@ -348,6 +519,15 @@ type Core struct {
 	rpcClientConn *grpc.ClientConn
 	// The grpc forwarding client
 	rpcForwardingClient *forwardingClient
+	// The cluster address the forwarding client is connected to
+	rpcClientClusterAddr string
+	// Cancels the background reconnection of the forwarding client, if one
+	// is running
+	forwardingReconnectCancelFunc context.CancelFunc
+	// The first and longest waits between attempts to reconnect the
+	// forwarding client once its connection is lost
+	forwardingReconnectBaseDelay time.Duration
+	forwardingReconnectMaxDelay  time.Duration
 
 	// CORS Information
 	corsConfig *CORSConfig
@@ -388,6 +568,14 @@ type Core struct {
 	// Stores any funcs that should be run on successful postUnseal
 	postUnsealFuncs []func()
 
+	// Hooks registered by embedders through RegisterPostUnsealFunc,
+	// RegisterPreSealFunc and RegisterRenewalCallback. Unlike postUnsealFuncs
+	// these persist across seal/unseal cycles.
+	registeredPostUnsealFuncs  []func() error
+	registeredPreSealFuncs     []func() error
+	registeredRenewalCallbacks []RenewalCallback
+	registeredFuncsLock        sync.RWMutex
+
 	// replicationFailure is used to mark when replication has entered an
 	// unrecoverable failure.
 	replicationFailure *uint32
@@ -417,6 +605,13 @@ type CoreConfig struct {
 
 	Physical physical.Backend `json:"physical" structs:"physical" mapstructure:"physical"`
 
+	// A prefix under which this core keeps everything it stores in Physical,
+	// including its seal config, keyring, cluster info and mount tables, and
+	// which is also applied to the HA lock. This lets several cores share one
+	// backend namespace without seeing each other's data. Transactions on
+	// Physical are not used while it is set.
+	StoragePrefix string `json:"storage_prefix" structs:"storage_prefix" mapstructure:"storage_prefix"`
+
 	// May be nil, which disables HA operations
 	HAPhysical physical.HABackend `json:"ha_physical" structs:"ha_physical" mapstructure:"ha_physical"`
 
@@ -424,6 +619,10 @@ type CoreConfig struct {
 
 	Logger log.Logger `json:"logger" structs:"logger" mapstructure:"logger"`
 
+	// The level to set on Logger and AllLoggers, or NoLevel to leave their
+	// levels as they are
+	LogLevel log.Level `json:"log_level" structs:"log_level" mapstructure:"log_level"`
+
 	// Disables the LRU cache on the physical backend
 	DisableCache bool `json:"disable_cache" structs:"disable_cache" mapstructure:"disable_cache"`
 
@@ -439,14 +638,218 @@ type CoreConfig struct {
 	// Set as the cluster address for HA
 	ClusterAddr string `json:"cluster_addr" structs:"cluster_addr" mapstructure:"cluster_addr"`
 
+	// Identifies this node among the nodes sharing its storage. Node-local
+	// mounts keep their data under this ID. Defaults to the hostname.
+	NodeID string `json:"node_id" structs:"node_id" mapstructure:"node_id"`
+
 	DefaultLeaseTTL time.Duration `json:"default_lease_ttl" structs:"default_lease_ttl" mapstructure:"default_lease_ttl"`
 
 	MaxLeaseTTL time.Duration `json:"max_lease_ttl" structs:"max_lease_ttl" mapstructure:"max_lease_ttl"`
 
+	// How long the active node waits after a manual step down before trying
+	// to regain leadership, giving other nodes a chance to take over
+	ManualStepDownSleepPeriod time.Duration `json:"manual_step_down_sleep_period" structs:"manual_step_down_sleep_period" mapstructure:"manual_step_down_sleep_period"`
+
 	ClusterName string `json:"cluster_name" structs:"cluster_name" mapstructure:"cluster_name"`
 
 	ClusterCipherSuites string `json:"cluster_cipher_suites" structs:"cluster_cipher_suites" mapstructure:"cluster_cipher_suites"`
 
+	// The addresses cluster listeners bind to. When set, these are used
+	// instead of the addresses passed to SetClusterListenerAddrs, which are
+	// usually derived from the API listeners, so that cluster traffic can be
+	// kept on a separate interface.
+	ClusterListenAddrs []*net.TCPAddr `json:"cluster_listen_addrs" structs:"cluster_listen_addrs" mapstructure:"cluster_listen_addrs"`
+
+	// How long to keep retrying, with backoff, to bind a cluster listener
+	// whose address is still in use, e.g. by the listener of a seal that
+	// hasn't finished closing yet. Zero uses the default.
+	ClusterListenerBindTimeout time.Duration `json:"cluster_listener_bind_timeout" structs:"cluster_listener_bind_timeout" mapstructure:"cluster_listener_bind_timeout"`
+
+	// The most cluster listeners that may be started; unsealing as the
+	// active node fails if more cluster addresses than this are given, as a
+	// guard against a misconfiguration spawning a server per address. Zero
+	// uses the default.
+	MaxClusterListeners int `json:"max_cluster_listeners" structs:"max_cluster_listeners" mapstructure:"max_cluster_listeners"`
+
+	// The first and longest waits between attempts to reconnect to the active
+	// node once a standby's forwarding connection is lost. The wait doubles
+	// after every failed attempt, with jitter, up to the maximum. Zero uses
+	// the defaults.
+	ForwardingReconnectBaseDelay time.Duration `json:"forwarding_reconnect_base_delay" structs:"forwarding_reconnect_base_delay" mapstructure:"forwarding_reconnect_base_delay"`
+	ForwardingReconnectMaxDelay  time.Duration `json:"forwarding_reconnect_max_delay" structs:"forwarding_reconnect_max_delay" mapstructure:"forwarding_reconnect_max_delay"`
+
+	// Whether connections to the cluster listener must present a client
+	// certificate. Unset means true. Setting it to false only verifies
+	// certificates that are offered; this is a migration aid for nodes that
+	// do not yet have a trusted client certificate and should not be left
+	// in place.
+	ClusterRequireClientCert *bool `json:"cluster_require_client_cert" structs:"cluster_require_client_cert" mapstructure:"cluster_require_client_cert"`
+
+	// Disables verification of cluster certificates in both directions, so
+	// that cluster traffic can be intercepted and inspected with standard
+	// tools in a lab. This removes the authentication of cluster peers and
+	// must never be used outside of debugging; a warning is logged on every
+	// handshake while it is set.
+	ClusterInsecureSkipVerify bool `json:"cluster_insecure_skip_verify" structs:"cluster_insecure_skip_verify" mapstructure:"cluster_insecure_skip_verify"`
+
+	// Reloads the cluster TLS information from storage and retries once when
+	// a request cannot be forwarded because the active node's certificate is
+	// not trusted, rather than returning ErrClusterPeerUntrusted. This covers
+	// the window in which the active node has rotated its certificate but
+	// this node has not yet seen the new one. The information is reloaded at
+	// most once every ten seconds.
+	ClusterRetryUntrustedPeer bool `json:"cluster_retry_untrusted_peer" structs:"cluster_retry_untrusted_peer" mapstructure:"cluster_retry_untrusted_peer"`
+
+	// Lets this node forward health checks to the active node while it is
+	// sealed, so that sys/health reports on the cluster through any node.
+	// Only the active node's cluster address and cert, which it stores
+	// outside the barrier, are used to reach it, and the active node answers
+	// nothing but health checks on such connections. Forwarded responses
+	// carry the X-Vault-Health-Forwarded header.
+	ForwardSealedHealthRequests bool `json:"forward_sealed_health_requests" structs:"forward_sealed_health_requests" mapstructure:"forward_sealed_health_requests"`
+
+	// PEM-encoded cluster certificates of nodes outside this cluster that are
+	// trusted in addition to the local cluster cert, both when connecting to
+	// them and when they connect in, e.g. to forward between federated
+	// clusters.
+	ClusterTrustedPeerCerts []string `json:"cluster_trusted_peer_certs" structs:"cluster_trusted_peer_certs" mapstructure:"cluster_trusted_peer_certs"`
+
+	// The source of time for cluster cert validity and lease expiration.
+	// Defaults to the system clock; tests can replace it to control time.
+	Clock Clock `json:"-" structs:"-" mapstructure:"-"`
+
+	// Notified with the redirect address when this node becomes active and
+	// again when it steps down or seals, so that the active node can be
+	// announced in a service catalog
+	ServiceRegistration ServiceRegistration `json:"-" structs:"-" mapstructure:"-"`
+
+	// How long the previous cluster cert remains trusted after the active
+	// node rotates it, or zero to stop trusting it immediately
+	ClusterCertOverlapPeriod time.Duration `json:"cluster_cert_overlap_period" structs:"cluster_cert_overlap_period" mapstructure:"cluster_cert_overlap_period"`
+
+	// How old the local cluster cert may be, measured from its NotBefore,
+	// before it is replaced, even though it is still valid. The cert is only
+	// generated when a node becomes active, so to replace it the active node
+	// steps down once the cert reaches this age: every max age causes a
+	// leadership change, with the usual failover of requests to the next
+	// active node, which generates a new cert. A node without other nodes to
+	// take over simply becomes active again. Zero means certs are only
+	// regenerated on leadership changes.
+	ClusterCertMaxAge time.Duration `json:"cluster_cert_max_age" structs:"cluster_cert_max_age" mapstructure:"cluster_cert_max_age"`
+
+	// Store leases grouped into a fixed number of bucket entries instead of
+	// one storage entry per lease, so that restoring leases on unseal reads
+	// far fewer entries. Existing leases are moved to the configured format
+	// on unseal, in either direction.
+	CompactLeaseStorage bool `json:"compact_lease_storage" structs:"compact_lease_storage" mapstructure:"compact_lease_storage"`
+
+	// How long a root token passed to RotateRootToken remains valid after
+	// its replacement is issued, so that requests already using it can
+	// finish. Zero uses the default.
+	RootTokenRotationGracePeriod time.Duration `json:"root_token_rotation_grace_period" structs:"root_token_rotation_grace_period" mapstructure:"root_token_rotation_grace_period"`
+
+	// How often the active node rotates the root token last returned by
+	// RotateRootToken on its own, handing each new token to
+	// RootTokenDelivery. Zero disables periodic rotation; otherwise
+	// RootTokenDelivery must be set.
+	RootTokenRotationPeriod time.Duration         `json:"root_token_rotation_period" structs:"root_token_rotation_period" mapstructure:"root_token_rotation_period"`
+	RootTokenDelivery       RootTokenDeliveryFunc `json:"-" structs:"-" mapstructure:"-"`
+
+	// How many bytes of a write's raw request body are captured so that
+	// audit backends can record a salted hash of them. Longer bodies are
+	// hashed up to this limit and marked as truncated in the audit entry.
+	// Zero disables request body auditing.
+	AuditRequestBodyLimit int64 `json:"audit_request_body_limit" structs:"audit_request_body_limit" mapstructure:"audit_request_body_limit"`
+
+	// How long a node that has just become active keeps turning away
+	// requests that would change data with a retriable 503, while reads and
+	// health checks are served. Writes stay turned away past this for as
+	// long as leases are still being restored in the background. Zero
+	// disables the grace period.
+	ActiveWriteGracePeriod time.Duration `json:"active_write_grace_period" structs:"active_write_grace_period" mapstructure:"active_write_grace_period"`
+
+	// The largest request body, in bytes, a standby will forward to the
+	// active node and the active node will accept from a standby. Zero means
+	// no limit beyond the listener's own.
+	MaxRequestSize int64 `json:"max_request_size" structs:"max_request_size" mapstructure:"max_request_size"`
+
+	// How long HandleRequest lets a request run before cancelling its context
+	// and returning ErrRequestTimeout. Zero means no limit beyond the
+	// caller's own context.
+	RequestTimeout time.Duration `json:"request_timeout" structs:"request_timeout" mapstructure:"request_timeout"`
+
+	// Whether to gzip request and response bodies sent over the cluster port
+	// when forwarding. Nodes always accept compressed bodies; this controls
+	// whether they send them.
+	ClusterCompression bool `json:"cluster_compression" structs:"cluster_compression" mapstructure:"cluster_compression"`
+
+	// Whether connections to the cluster listeners may start with a PROXY
+	// protocol header, version 1 or 2, as sent by an L4 load balancer in
+	// front of them. The source address in the header is then used as the
+	// peer's address. Connections without a header are still accepted.
+	ClusterProxyProtocol bool `json:"cluster_proxy_protocol" structs:"cluster_proxy_protocol" mapstructure:"cluster_proxy_protocol"`
+
+	// The addresses of the load balancers allowed to send a PROXY protocol
+	// header when ClusterProxyProtocol is set. A connection from anywhere
+	// else that starts with a header is refused, so that a peer can't claim
+	// someone else's address. Required with ClusterProxyProtocol.
+	ClusterProxyAuthorizedAddrs []*sockaddr.SockAddrMarshaler `json:"cluster_proxy_authorized_addrs" structs:"cluster_proxy_authorized_addrs" mapstructure:"cluster_proxy_authorized_addrs"`
+
+	// Paths, relative to /v1/ and to the request's namespace, that a standby
+	// handles itself instead of forwarding to the active node, e.g. endpoints
+	// that administer the node they are sent to. A trailing * matches any
+	// path with that prefix. Requests the standby can't serve itself are
+	// rejected with a 503.
+	NeverForwardPaths []string `json:"never_forward_paths" structs:"never_forward_paths" mapstructure:"never_forward_paths"`
+
+	// Rewrites the scheme and host of requests forwarded to the active node,
+	// for when forwarding passes through a proxy that routes on them. The
+	// path, query and body are always forwarded unchanged. If nil, the
+	// request keeps the scheme and host it arrived with.
+	ForwardedRequestRewrite ForwardedRequestRewriteFunc `json:"-" structs:"-" mapstructure:"-"`
+
+	// The number of requests this node forwarded to the active node that are
+	// kept for debugging, without their tokens, so they can be inspected with
+	// CapturedForwardedRequests and sent again with ReplayForwarded. Zero,
+	// the default, disables capturing.
+	ForwardedRequestCaptureSize int `json:"forwarded_request_capture_size" structs:"forwarded_request_capture_size" mapstructure:"forwarded_request_capture_size"`
+
+	// Whether captured forwarded requests keep their bodies. Bodies may hold
+	// passwords and secrets being written, so they are dropped unless this
+	// is set, and requests with a body can then not be replayed.
+	ForwardedRequestCaptureBodies bool `json:"forwarded_request_capture_bodies" structs:"forwarded_request_capture_bodies" mapstructure:"forwarded_request_capture_bodies"`
+
+	// How long a standby caches the result of looking up the active node in
+	// the HA backend. Zero disables the cache.
+	LeaderLookupCacheTTL time.Duration `json:"leader_lookup_cache_ttl" structs:"leader_lookup_cache_ttl" mapstructure:"leader_lookup_cache_ttl"`
+
+	// How long to wait between HA lock acquisition attempts, and how long a
+	// held lock survives without renewal. These are passed on to HA backends
+	// that implement physical.LockTimingSetter; zero leaves the backend's
+	// defaults in place.
+	HALockRetryInterval time.Duration `json:"ha_lock_retry_interval" structs:"ha_lock_retry_interval" mapstructure:"ha_lock_retry_interval"`
+	HALockTTL           time.Duration `json:"ha_lock_ttl" structs:"ha_lock_ttl" mapstructure:"ha_lock_ttl"`
+
+	// The number of failed unseal attempts within UnsealFailureWindow after
+	// which unsealing is locked out for UnsealLockoutPeriod. Zero disables
+	// the lockout.
+	UnsealFailureThreshold int           `json:"unseal_failure_threshold" structs:"unseal_failure_threshold" mapstructure:"unseal_failure_threshold"`
+	UnsealFailureWindow    time.Duration `json:"unseal_failure_window" structs:"unseal_failure_window" mapstructure:"unseal_failure_window"`
+	UnsealLockoutPeriod    time.Duration `json:"unseal_lockout_period" structs:"unseal_lockout_period" mapstructure:"unseal_lockout_period"`
+
+	// Called before each submitted unseal key share is counted, with the
+	// metadata passed to UnsealWithMetadata, so that shares can be refused
+	// e.g. from unexpected addresses or outside change windows. If nil,
+	// every share is counted.
+	UnsealAuthorizer UnsealAuthorizerFunc `json:"-" structs:"-" mapstructure:"-"`
+
+	// How long a cluster member's heartbeat stays fresh before the active
+	// node evicts it, and how often the active node scans for such members.
+	// Zero uses the defaults. The TTL must be at least twice the 5 second
+	// heartbeat interval.
+	MemberHeartbeatTTL time.Duration `json:"member_heartbeat_ttl" structs:"member_heartbeat_ttl" mapstructure:"member_heartbeat_ttl"`
+	MemberScanInterval time.Duration `json:"member_scan_interval" structs:"member_scan_interval" mapstructure:"member_scan_interval"`
+
 	EnableUI bool `json:"ui" structs:"ui" mapstructure:"ui"`
 
 	// Enable the raw endpoint
@@ -456,6 +859,25 @@ type CoreConfig struct {
 
 	DisableSealWrap bool `json:"disable_sealwrap" structs:"disable_sealwrap" mapstructure:"disable_sealwrap"`
 
+	// If set, is notified of every get, put and delete performed through the
+	// barrier. Only keys are reported. Meant for debugging and tests.
+	BarrierObserver BarrierObserver `json:"barrier_observer" structs:"barrier_observer" mapstructure:"barrier_observer"`
+
+	// If set, is asked whether each request may proceed before it is
+	// handled, including requests forwarded from standbys. Denied requests
+	// get a 429 response.
+	RequestLimiter RequestLimiter `json:"request_limiter" structs:"request_limiter" mapstructure:"request_limiter"`
+
+	// If set, is called in its own goroutine whenever this node stops being
+	// active because it lost the HA lock, as opposed to stepping down or
+	// shutting down. This usually points at a storage problem.
+	OnLeadershipLost func(reason error) `json:"-" structs:"-" mapstructure:"-"`
+
+	// If set, is called in its own goroutine with the last heartbeat of each
+	// cluster member the active node evicts for not refreshing it within
+	// MemberHeartbeatTTL.
+	OnMemberEvicted func(member *ClusterMember) `json:"-" structs:"-" mapstructure:"-"`
+
 	ReloadFuncs     *map[string][]reload.ReloadFunc
 	ReloadFuncsLock *sync.RWMutex
 
@@ -473,34 +895,80 @@ type CoreConfig struct {
 
 func (c *CoreConfig) Clone() *CoreConfig {
 	return &CoreConfig{
-		DevToken:                  c.DevToken,
-		LogicalBackends:           c.LogicalBackends,
-		CredentialBackends:        c.CredentialBackends,
-		AuditBackends:             c.AuditBackends,
-		Physical:                  c.Physical,
-		HAPhysical:                c.HAPhysical,
-		Seal:                      c.Seal,
-		Logger:                    c.Logger,
-		DisableCache:              c.DisableCache,
-		DisableMlock:              c.DisableMlock,
-		CacheSize:                 c.CacheSize,
-		RedirectAddr:              c.RedirectAddr,
-		ClusterAddr:               c.ClusterAddr,
-		DefaultLeaseTTL:           c.DefaultLeaseTTL,
-		MaxLeaseTTL:               c.MaxLeaseTTL,
-		ClusterName:               c.ClusterName,
-		ClusterCipherSuites:       c.ClusterCipherSuites,
-		EnableUI:                  c.EnableUI,
-		EnableRaw:                 c.EnableRaw,
-		PluginDirectory:           c.PluginDirectory,
-		DisableSealWrap:           c.DisableSealWrap,
-		ReloadFuncs:               c.ReloadFuncs,
-		ReloadFuncsLock:           c.ReloadFuncsLock,
-		LicensingConfig:           c.LicensingConfig,
-		DevLicenseDuration:        c.DevLicenseDuration,
-		DisablePerformanceStandby: c.DisablePerformanceStandby,
-		DisableIndexing:           c.DisableIndexing,
-		AllLoggers:                c.AllLoggers,
+		DevToken:                      c.DevToken,
+		LogicalBackends:               c.LogicalBackends,
+		CredentialBackends:            c.CredentialBackends,
+		AuditBackends:                 c.AuditBackends,
+		Physical:                      c.Physical,
+		StoragePrefix:                 c.StoragePrefix,
+		HAPhysical:                    c.HAPhysical,
+		Seal:                          c.Seal,
+		Logger:                        c.Logger,
+		LogLevel:                      c.LogLevel,
+		DisableCache:                  c.DisableCache,
+		DisableMlock:                  c.DisableMlock,
+		CacheSize:                     c.CacheSize,
+		RedirectAddr:                  c.RedirectAddr,
+		NodeID:                        c.NodeID,
+		ClusterAddr:                   c.ClusterAddr,
+		DefaultLeaseTTL:               c.DefaultLeaseTTL,
+		MaxLeaseTTL:                   c.MaxLeaseTTL,
+		ManualStepDownSleepPeriod:     c.ManualStepDownSleepPeriod,
+		ClusterName:                   c.ClusterName,
+		ClusterCipherSuites:           c.ClusterCipherSuites,
+		ClusterListenAddrs:            c.ClusterListenAddrs,
+		ClusterListenerBindTimeout:    c.ClusterListenerBindTimeout,
+		MaxClusterListeners:           c.MaxClusterListeners,
+		ForwardingReconnectBaseDelay:  c.ForwardingReconnectBaseDelay,
+		ForwardingReconnectMaxDelay:   c.ForwardingReconnectMaxDelay,
+		ClusterRequireClientCert:      c.ClusterRequireClientCert,
+		ClusterInsecureSkipVerify:     c.ClusterInsecureSkipVerify,
+		ClusterRetryUntrustedPeer:     c.ClusterRetryUntrustedPeer,
+		ForwardSealedHealthRequests:   c.ForwardSealedHealthRequests,
+		ClusterTrustedPeerCerts:       c.ClusterTrustedPeerCerts,
+		Clock:                         c.Clock,
+		ServiceRegistration:           c.ServiceRegistration,
+		ClusterCertOverlapPeriod:      c.ClusterCertOverlapPeriod,
+		ClusterCertMaxAge:             c.ClusterCertMaxAge,
+		CompactLeaseStorage:           c.CompactLeaseStorage,
+		RootTokenRotationGracePeriod:  c.RootTokenRotationGracePeriod,
+		RootTokenRotationPeriod:       c.RootTokenRotationPeriod,
+		RootTokenDelivery:             c.RootTokenDelivery,
+		AuditRequestBodyLimit:         c.AuditRequestBodyLimit,
+		ActiveWriteGracePeriod:        c.ActiveWriteGracePeriod,
+		MaxRequestSize:                c.MaxRequestSize,
+		RequestTimeout:                c.RequestTimeout,
+		ClusterCompression:            c.ClusterCompression,
+		ClusterProxyProtocol:          c.ClusterProxyProtocol,
+		ClusterProxyAuthorizedAddrs:   c.ClusterProxyAuthorizedAddrs,
+		NeverForwardPaths:             c.NeverForwardPaths,
+		ForwardedRequestRewrite:       c.ForwardedRequestRewrite,
+		ForwardedRequestCaptureSize:   c.ForwardedRequestCaptureSize,
+		ForwardedRequestCaptureBodies: c.ForwardedRequestCaptureBodies,
+		LeaderLookupCacheTTL:          c.LeaderLookupCacheTTL,
+		HALockRetryInterval:           c.HALockRetryInterval,
+		HALockTTL:                     c.HALockTTL,
+		UnsealFailureThreshold:        c.UnsealFailureThreshold,
+		UnsealFailureWindow:           c.UnsealFailureWindow,
+		UnsealLockoutPeriod:           c.UnsealLockoutPeriod,
+		UnsealAuthorizer:              c.UnsealAuthorizer,
+		MemberHeartbeatTTL:            c.MemberHeartbeatTTL,
+		MemberScanInterval:            c.MemberScanInterval,
+		EnableUI:                      c.EnableUI,
+		EnableRaw:                     c.EnableRaw,
+		PluginDirectory:               c.PluginDirectory,
+		DisableSealWrap:               c.DisableSealWrap,
+		BarrierObserver:               c.BarrierObserver,
+		RequestLimiter:                c.RequestLimiter,
+		OnLeadershipLost:              c.OnLeadershipLost,
+		OnMemberEvicted:               c.OnMemberEvicted,
+		ReloadFuncs:                   c.ReloadFuncs,
+		ReloadFuncsLock:               c.ReloadFuncsLock,
+		LicensingConfig:               c.LicensingConfig,
+		DevLicenseDuration:            c.DevLicenseDuration,
+		DisablePerformanceStandby:     c.DisablePerformanceStandby,
+		DisableIndexing:               c.DisableIndexing,
+		AllLoggers:                    c.AllLoggers,
 	}
 }
 
@@ -521,6 +989,97 @@ func NewCore(conf *CoreConfig) (*Core, error) {
 	if conf.DefaultLeaseTTL > conf.MaxLeaseTTL {
 		return nil, fmt.Errorf("cannot have DefaultLeaseTTL larger than MaxLeaseTTL")
 	}
+	if conf.ManualStepDownSleepPeriod < 0 {
+		return nil, fmt.Errorf("manual step-down sleep period cannot be negative")
+	}
+	if conf.ManualStepDownSleepPeriod == 0 {
+		conf.ManualStepDownSleepPeriod = defaultManualStepDownSleepPeriod
+	}
+	if conf.StoragePrefix != "" && (!strings.HasSuffix(conf.StoragePrefix, "/") || strings.HasPrefix(conf.StoragePrefix, "/") || strings.Contains(conf.StoragePrefix, "..")) {
+		return nil, fmt.Errorf("storage prefix must be a relative path ending in a slash")
+	}
+	if conf.HALockRetryInterval < 0 || conf.HALockTTL < 0 {
+		return nil, fmt.Errorf("HA lock retry interval and TTL cannot be negative")
+	}
+	if conf.MaxRequestSize < 0 {
+		return nil, fmt.Errorf("max request size cannot be negative")
+	}
+	if conf.ClusterProxyProtocol && len(conf.ClusterProxyAuthorizedAddrs) == 0 {
+		return nil, fmt.Errorf("cluster proxy authorized addresses must be set to use the PROXY protocol on cluster listeners")
+	}
+	if conf.RequestTimeout < 0 {
+		return nil, fmt.Errorf("request timeout cannot be negative")
+	}
+	if conf.UnsealFailureThreshold < 0 || conf.UnsealFailureWindow < 0 || conf.UnsealLockoutPeriod < 0 {
+		return nil, fmt.Errorf("unseal failure threshold, window and lockout period cannot be negative")
+	}
+	if conf.MemberHeartbeatTTL < 0 || conf.MemberScanInterval < 0 {
+		return nil, fmt.Errorf("member heartbeat TTL and scan interval cannot be negative")
+	}
+	if conf.MemberHeartbeatTTL != 0 && conf.MemberHeartbeatTTL < 2*memberHeartbeatInterval {
+		return nil, fmt.Errorf("member heartbeat TTL must be at least twice the heartbeat interval of %s", memberHeartbeatInterval)
+	}
+	if conf.ClusterListenerBindTimeout < 0 {
+		return nil, fmt.Errorf("cluster listener bind timeout cannot be negative")
+	}
+	if conf.ClusterListenerBindTimeout == 0 {
+		conf.ClusterListenerBindTimeout = defaultClusterListenerBindTimeout
+	}
+	if conf.MaxClusterListeners < 0 {
+		return nil, fmt.Errorf("max cluster listeners cannot be negative")
+	}
+	if conf.MaxClusterListeners == 0 {
+		conf.MaxClusterListeners = defaultMaxClusterListeners
+	}
+	if conf.ForwardingReconnectBaseDelay < 0 || conf.ForwardingReconnectMaxDelay < 0 {
+		return nil, fmt.Errorf("forwarding reconnect delays cannot be negative")
+	}
+	if conf.ForwardingReconnectBaseDelay == 0 {
+		conf.ForwardingReconnectBaseDelay = defaultForwardingReconnectBaseDelay
+	}
+	if conf.ForwardingReconnectMaxDelay == 0 {
+		conf.ForwardingReconnectMaxDelay = defaultForwardingReconnectMaxDelay
+	}
+	if conf.ForwardingReconnectMaxDelay < conf.ForwardingReconnectBaseDelay {
+		return nil, fmt.Errorf("forwarding reconnect max delay cannot be less than the base delay")
+	}
+	if conf.ForwardedRequestCaptureSize < 0 {
+		return nil, fmt.Errorf("forwarded request capture size cannot be negative")
+	}
+	if conf.Clock == nil {
+		conf.Clock = realClock{}
+	}
+	if conf.ClusterCertMaxAge < 0 {
+		return nil, fmt.Errorf("cluster cert max age cannot be negative")
+	}
+	if conf.RootTokenRotationGracePeriod < 0 {
+		return nil, fmt.Errorf("root token rotation grace period cannot be negative")
+	}
+	if conf.RootTokenRotationGracePeriod == 0 {
+		conf.RootTokenRotationGracePeriod = defaultRootTokenRotationGracePeriod
+	}
+	if conf.RootTokenRotationPeriod < 0 {
+		return nil, fmt.Errorf("root token rotation period cannot be negative")
+	}
+	if conf.RootTokenRotationPeriod > 0 && conf.RootTokenDelivery == nil {
+		return nil, fmt.Errorf("root token rotation period requires a root token delivery function")
+	}
+	if conf.AuditRequestBodyLimit < 0 {
+		return nil, fmt.Errorf("audit request body limit cannot be negative")
+	}
+	if conf.ActiveWriteGracePeriod < 0 {
+		return nil, fmt.Errorf("active write grace period cannot be negative")
+	}
+	if conf.MemberHeartbeatTTL == 0 {
+		conf.MemberHeartbeatTTL = defaultMemberHeartbeatTTL
+	}
+	if conf.MemberScanInterval == 0 {
+		conf.MemberScanInterval = defaultMemberScanInterval
+	}
+	if conf.NodeID == "" {
+		// Best effort; node-local mounts are refused if there's no ID
+		conf.NodeID, _ = os.Hostname()
+	}
 
 	// Validate the advertise addr if its given to us
 	if conf.RedirectAddr != "" {
@@ -544,7 +1103,9 @@ func NewCore(conf *CoreConfig) (*Core, error) {
 		entCore:                          entCore{},
 		devToken:                         conf.DevToken,
 		physical:                         conf.Physical,
+		coreLockPath:                     conf.StoragePrefix + CoreLockPath,
 		redirectAddr:                     conf.RedirectAddr,
+		nodeID:                           conf.NodeID,
 		clusterAddr:                      conf.ClusterAddr,
 		seal:                             conf.Seal,
 		router:                           NewRouter(),
@@ -554,8 +1115,10 @@ func NewCore(conf *CoreConfig) (*Core, error) {
 		logger:                           conf.Logger.Named("core"),
 		defaultLeaseTTL:                  conf.DefaultLeaseTTL,
 		maxLeaseTTL:                      conf.MaxLeaseTTL,
+		manualStepDownSleepPeriod:        conf.ManualStepDownSleepPeriod,
 		cachingDisabled:                  conf.DisableCache,
 		clusterName:                      conf.ClusterName,
+		unsealedCh:                       make(chan struct{}),
 		clusterListenerShutdownCh:        make(chan struct{}),
 		clusterListenerShutdownSuccessCh: make(chan struct{}),
 		clusterPeerClusterAddrsCache:     cache.New(3*HeartbeatInterval, time.Second),
@@ -568,8 +1131,46 @@ func NewCore(conf *CoreConfig) (*Core, error) {
 		localClusterPrivateKey:           new(atomic.Value),
 		localClusterCert:                 new(atomic.Value),
 		localClusterParsedCert:           new(atomic.Value),
+		localClusterPrevParsedCert:       new(atomic.Value),
+		localClusterID:                   new(atomic.Value),
+		clusterCertOverlapPeriod:         conf.ClusterCertOverlapPeriod,
+		clusterCertMaxAge:                conf.ClusterCertMaxAge,
+		compactLeaseStorage:              conf.CompactLeaseStorage,
+		rootTokenRotationGracePeriod:     conf.RootTokenRotationGracePeriod,
+		rootTokenRotationPeriod:          conf.RootTokenRotationPeriod,
+		rootTokenDelivery:                conf.RootTokenDelivery,
+		auditRequestBodyLimit:            conf.AuditRequestBodyLimit,
+		clock:                            conf.Clock,
+		serviceRegistration:              conf.ServiceRegistration,
+		maxRequestSize:                   conf.MaxRequestSize,
+		requestTimeout:                   conf.RequestTimeout,
+		clusterCompression:               conf.ClusterCompression,
+		clusterProxyProtocol:             conf.ClusterProxyProtocol,
+		clusterProxyAuthorizedAddrs:      conf.ClusterProxyAuthorizedAddrs,
+		clusterRetryUntrustedPeer:        conf.ClusterRetryUntrustedPeer,
+		forwardSealedHealthRequests:      conf.ForwardSealedHealthRequests,
+		neverForwardPaths:                pathmanager.New(),
+		clusterPeerStats:                 newClusterPeerStats(),
+		forwardedRequestRewrite:          conf.ForwardedRequestRewrite,
+		leaderLookupCacheTTL:             conf.LeaderLookupCacheTTL,
+		haLockRetryInterval:              conf.HALockRetryInterval,
+		onLeadershipLost:                 conf.OnLeadershipLost,
+		requestLimiter:                   conf.RequestLimiter,
+		memberHeartbeatTTL:               conf.MemberHeartbeatTTL,
+		memberScanInterval:               conf.MemberScanInterval,
+		clusterListenerBindTimeout:       conf.ClusterListenerBindTimeout,
+		maxClusterListeners:              conf.MaxClusterListeners,
+		forwardingReconnectBaseDelay:     conf.ForwardingReconnectBaseDelay,
+		forwardingReconnectMaxDelay:      conf.ForwardingReconnectMaxDelay,
+		onMemberEvicted:                  conf.OnMemberEvicted,
+		unsealLockout:                    newUnsealLockout(conf.UnsealFailureThreshold, conf.UnsealFailureWindow, conf.UnsealLockoutPeriod),
+		unsealAuthorizer:                 conf.UnsealAuthorizer,
 		activeNodeReplicationState:       new(uint32),
 		keepHALockOnStepDown:             new(uint32),
+		leavingActiveDuty:                new(uint32),
+		inMaintenance:                    new(uint32),
+		inActiveWriteGrace:               new(uint32),
+		activeWriteGracePeriod:           conf.ActiveWriteGracePeriod,
 		replicationFailure:               new(uint32),
 		disablePerfStandby:               true,
 		activeContextCancelFunc:          new(atomic.Value),
@@ -579,10 +1180,15 @@ func NewCore(conf *CoreConfig) (*Core, error) {
 
 	atomic.StoreUint32(c.sealed, 1)
 	c.allLoggers = append(c.allLoggers, c.logger)
+	if conf.LogLevel != log.NoLevel {
+		c.SetLogLevel(conf.LogLevel)
+	}
 
 	atomic.StoreUint32(c.replicationState, uint32(consts.ReplicationDRDisabled|consts.ReplicationPerformanceDisabled))
 	c.localClusterCert.Store(([]byte)(nil))
 	c.localClusterParsedCert.Store((*x509.Certificate)(nil))
+	c.localClusterPrevParsedCert.Store((*retiredClusterCert)(nil))
+	c.localClusterID.Store("")
 	c.localClusterPrivateKey.Store((*ecdsa.PrivateKey)(nil))
 
 	c.activeContextCancelFunc.Store((context.CancelFunc)(nil))
@@ -595,6 +1201,43 @@ func NewCore(conf *CoreConfig) (*Core, error) {
 		c.clusterCipherSuites = suites
 	}
 
+	if len(conf.ClusterTrustedPeerCerts) > 0 {
+		certs, err := parseClusterPeerCerts(conf.ClusterTrustedPeerCerts)
+		if err != nil {
+			return nil, err
+		}
+		c.clusterTrustedPeerCerts = certs
+	}
+
+	for _, addr := range conf.ClusterListenAddrs {
+		if addr == nil {
+			return nil, fmt.Errorf("cluster listen addresses cannot be empty")
+		}
+	}
+	if len(conf.ClusterListenAddrs) > 0 {
+		c.clusterListenAddrs = conf.ClusterListenAddrs
+		if c.clusterAddr == "" && len(conf.ClusterListenAddrs) == 1 {
+			c.clusterAddr = fmt.Sprintf("https://%s", conf.ClusterListenAddrs[0].String())
+		}
+	}
+
+	c.neverForwardPaths.AddPaths(conf.NeverForwardPaths)
+
+	if conf.ForwardedRequestCaptureSize > 0 {
+		c.forwardedRequestCapture = newForwardedRequestCapture(conf.ForwardedRequestCaptureSize, conf.ForwardedRequestCaptureBodies)
+	}
+
+	c.clusterClientAuth = tls.RequireAndVerifyClientCert
+	if conf.ClusterRequireClientCert != nil && !*conf.ClusterRequireClientCert {
+		c.logger.Warn("cluster listener client certificates are not required; this is only meant for migrations and should be re-enabled as soon as possible")
+		c.clusterClientAuth = tls.VerifyClientCertIfGiven
+	}
+	if conf.ClusterInsecureSkipVerify {
+		c.logger.Warn("cluster certificate verification is DISABLED; cluster peers are not authenticated and this must only be used for debugging in a lab")
+		c.clusterInsecureSkipVerify = true
+		c.clusterClientAuth = tls.RequestClientCert
+	}
+
 	// Load CORS config and provide a value for the core field.
 	c.corsConfig = &CORSConfig{
 		core:    c,
@@ -606,6 +1249,10 @@ func NewCore(conf *CoreConfig) (*Core, error) {
 	}
 	c.seal.SetCore(c)
 
+	if conf.StoragePrefix != "" {
+		c.physical = physical.NewView(c.physical, conf.StoragePrefix)
+	}
+
 	if err := coreInit(c, conf); err != nil {
 		return nil, err
 	}
@@ -640,11 +1287,25 @@ func NewCore(conf *CoreConfig) (*Core, error) {
 	if err != nil {
 		return nil, errwrap.Wrapf("barrier setup failed: {{err}}", err)
 	}
+	if conf.BarrierObserver != nil {
+		c.barrier = newObservedBarrier(c.barrier, conf.BarrierObserver)
+	}
 
 	createSecondaries(c, conf)
 
 	if conf.HAPhysical != nil && conf.HAPhysical.HAEnabled() {
 		c.ha = conf.HAPhysical
+
+		if conf.HALockRetryInterval != 0 || conf.HALockTTL != 0 {
+			if lts, ok := c.ha.(physical.LockTimingSetter); ok {
+				lts.SetLockTiming(conf.HALockRetryInterval, conf.HALockTTL)
+			} else {
+				c.logger.Warn("HA backend does not support tuning lock timing, ignoring configured values")
+			}
+		}
+	}
+	if c.haLockRetryInterval == 0 {
+		c.haLockRetryInterval = lockRetryInterval
 	}
 
 	// We create the funcs here, then populate the given config with it so that
@@ -732,6 +1393,43 @@ func (c *Core) Sealed() bool {
 	return atomic.LoadUint32(c.sealed) == 1
 }
 
+// WaitForUnseal blocks until the core is unsealed, or until ctx is done, in
+// which case the context's error is returned. Without HA this is once
+// post-unseal setup has completed; an HA node returns once it is unsealed in
+// standby, and only sets up the rest when it becomes active. This gives
+// embedders that submit unseal keys asynchronously a point to synchronize
+// on.
+func (c *Core) WaitForUnseal(ctx context.Context) error {
+	c.unsealedChLock.Lock()
+	unsealedCh := c.unsealedCh
+	c.unsealedChLock.Unlock()
+
+	select {
+	case <-unsealedCh:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// setUnsealedCh closes or replaces unsealedCh to match whether the core is
+// now unsealed
+func (c *Core) setUnsealedCh(unsealed bool) {
+	c.unsealedChLock.Lock()
+	defer c.unsealedChLock.Unlock()
+
+	select {
+	case <-c.unsealedCh:
+		if !unsealed {
+			c.unsealedCh = make(chan struct{})
+		}
+	default:
+		if unsealed {
+			close(c.unsealedCh)
+		}
+	}
+}
+
 // SecretProgress returns the number of keys provided so far
 func (c *Core) SecretProgress() (int, string) {
 	c.stateLock.RLock()
@@ -744,6 +1442,18 @@ func (c *Core) SecretProgress() (int, string) {
 	}
 }
 
+// KeyStatus returns the term of the barrier's active encryption key and the
+// time it was installed. This method errors out when Vault is sealed.
+func (c *Core) KeyStatus() (*KeyInfo, error) {
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+	if c.Sealed() {
+		return nil, consts.ErrSealed
+	}
+
+	return c.barrier.ActiveKeyInfo()
+}
+
 // ResetUnsealProcess removes the current unlock parts from memory, to reset
 // the unsealing process
 func (c *Core) ResetUnsealProcess() {
@@ -758,14 +1468,14 @@ func (c *Core) ResetUnsealProcess() {
 // this method is done with it. If you want to keep the key around, a copy
 // should be made.
 func (c *Core) Unseal(key []byte) (bool, error) {
-	return c.unseal(key, false)
+	return c.unseal(key, false, nil)
 }
 
 func (c *Core) UnsealWithRecoveryKeys(key []byte) (bool, error) {
-	return c.unseal(key, true)
+	return c.unseal(key, true, nil)
 }
 
-func (c *Core) unseal(key []byte, useRecoveryKeys bool) (bool, error) {
+func (c *Core) unseal(key []byte, useRecoveryKeys bool, metadata map[string]string) (bool, error) {
 	defer metrics.MeasureSince([]string{"core", "unseal"}, time.Now())
 
 	c.stateLock.Lock()
@@ -783,13 +1493,19 @@ func (c *Core) unseal(key []byte, useRecoveryKeys bool) (bool, error) {
 		return false, ErrNotInit
 	}
 
+	if err := c.unsealLockout.check(c.clock.Now()); err != nil {
+		return false, err
+	}
+
 	// Verify the key length
 	min, max := c.barrier.KeyLength()
 	max += shamir.ShareOverhead
 	if len(key) < min {
+		c.recordUnsealFailure()
 		return false, &ErrInvalidKey{fmt.Sprintf("key is shorter than minimum %d bytes", min)}
 	}
 	if len(key) > max {
+		c.recordUnsealFailure()
 		return false, &ErrInvalidKey{fmt.Sprintf("key is longer than maximum %d bytes", max)}
 	}
 
@@ -798,6 +1514,10 @@ func (c *Core) unseal(key []byte, useRecoveryKeys bool) (bool, error) {
 		return true, nil
 	}
 
+	if err := c.authorizeUnseal(ctx, useRecoveryKeys, metadata); err != nil {
+		return false, err
+	}
+
 	sealToUse := c.seal
 	if c.migrationSeal != nil {
 		sealToUse = c.migrationSeal
@@ -805,10 +1525,18 @@ func (c *Core) unseal(key []byte, useRecoveryKeys bool) (bool, error) {
 
 	masterKey, err := c.unsealPart(ctx, sealToUse, key, useRecoveryKeys)
 	if err != nil {
+		c.recordUnsealFailure()
 		return false, err
 	}
 	if masterKey != nil {
-		return c.unsealInternal(ctx, masterKey)
+		unsealed, err := c.unsealInternal(ctx, masterKey)
+		switch {
+		case err == ErrBarrierInvalidKey:
+			c.recordUnsealFailure()
+		case unsealed:
+			c.unsealLockout.reset()
+		}
+		return unsealed, err
 	}
 
 	return false, nil
@@ -1047,6 +1775,7 @@ func (c *Core) unsealInternal(ctx context.Context, masterKey []byte) (bool, erro
 
 	// Success!
 	atomic.StoreUint32(c.sealed, 0)
+	c.setUnsealedCh(true)
 
 	if c.ha != nil {
 		sd, ok := c.ha.(physical.ServiceDiscovery)
@@ -1111,6 +1840,24 @@ func (c *Core) Seal(token string) error {
 	return c.sealInitCommon(namespace.RootContext(nil), req)
 }
 
+// SealWithContext is like Seal, but only returns once sealing has finished:
+// pre-seal teardown has completed, the cluster listeners have shut down and
+// the expiration manager has stopped. If ctx is done first its error is
+// returned and sealing carries on in the background.
+func (c *Core) SealWithContext(ctx context.Context, token string) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- c.Seal(token)
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // sealInitCommon is common logic for Seal and SealWithRequest and is used to
 // re-seal the Vault. This requires the Vault to be unsealed again to perform
 // any further operations. Note: this function will read-unlock the state lock.
@@ -1264,6 +2011,7 @@ func (c *Core) sealInternalWithOptions(grabStateLock, keepHALock bool) error {
 	if swapped := atomic.CompareAndSwapUint32(c.sealed, 0, 1); !swapped {
 		return nil
 	}
+	c.setUnsealedCh(false)
 
 	c.logger.Info("marked as sealed")
 
@@ -1439,6 +2187,7 @@ func (s standardUnsealStrategy) unseal(ctx context.Context, logger log.Logger, c
 			return err
 		}
 	}
+	c.registerActiveService()
 
 	c.clusterParamsLock.Lock()
 	defer c.clusterParamsLock.Unlock()
@@ -1490,6 +2239,13 @@ func (c *Core) postUnseal(ctx context.Context, ctxCancelFunc context.CancelFunc,
 	c.metricsCh = make(chan struct{})
 	go c.emitMetrics(c.metricsCh)
 
+	var restoreDoneCh chan struct{}
+	if c.expiration != nil {
+		restoreDoneCh = c.expiration.restoreDoneCh
+	}
+	c.startActiveWriteGrace(restoreDoneCh)
+	c.startRootTokenRotation()
+
 	// This is intentionally the last block in this function. We want to allow
 	// writes just before allowing client requests, to ensure everything has
 	// been set up properly before any writes can have happened.
@@ -1497,6 +2253,16 @@ func (c *Core) postUnseal(ctx context.Context, ctxCancelFunc context.CancelFunc,
 		v()
 	}
 
+	c.registeredFuncsLock.RLock()
+	postUnsealFuncs := c.registeredPostUnsealFuncs
+	c.registeredFuncsLock.RUnlock()
+	for _, f := range postUnsealFuncs {
+		if err := f(); err != nil {
+			c.logger.Error("registered post-unseal function failed", "error", err)
+			return errwrap.Wrapf("registered post-unseal function failed: {{err}}", err)
+		}
+	}
+
 	c.logger.Info("post-unseal setup complete")
 	return nil
 }
@@ -1507,9 +2273,23 @@ func (c *Core) preSeal() error {
 	defer metrics.MeasureSince([]string{"core", "pre_seal"}, time.Now())
 	c.logger.Info("pre-seal teardown starting")
 
+	var result error
+
+	c.registeredFuncsLock.RLock()
+	preSealFuncs := c.registeredPreSealFuncs
+	c.registeredFuncsLock.RUnlock()
+	for _, f := range preSealFuncs {
+		if err := f(); err != nil {
+			result = multierror.Append(result, errwrap.Wrapf("error running registered pre-seal function: {{err}}", err))
+		}
+	}
+
 	// Clear any pending funcs
 	c.postUnsealFuncs = nil
 
+	c.stopActiveWriteGrace()
+	c.stopRootTokenRotation()
+
 	// Clear any rekey progress
 	c.barrierRekeyConfig = nil
 	c.recoveryRekeyConfig = nil
@@ -1518,7 +2298,6 @@ func (c *Core) preSeal() error {
 		close(c.metricsCh)
 		c.metricsCh = nil
 	}
-	var result error
 
 	c.clusterParamsLock.Lock()
 	if err := stopReplication(c); err != nil {
@@ -1526,6 +2305,7 @@ func (c *Core) preSeal() error {
 	}
 	c.clusterParamsLock.Unlock()
 
+	c.deregisterActiveService()
 	c.stopClusterListener()
 
 	if err := c.teardownAudits(); err != nil {
@@ -1556,6 +2336,37 @@ func (c *Core) preSeal() error {
 	return result
 }
 
+// RegisterPostUnsealFunc registers a function to run at the end of post-unseal
+// setup, each time this node unseals and becomes active. Functions run in
+// registration order; if one returns an error the unseal is aborted and the
+// core is sealed again.
+func (c *Core) RegisterPostUnsealFunc(f func() error) {
+	c.registeredFuncsLock.Lock()
+	defer c.registeredFuncsLock.Unlock()
+	c.registeredPostUnsealFuncs = append(c.registeredPostUnsealFuncs, f)
+}
+
+// RegisterPreSealFunc registers a function to run at the start of pre-seal
+// teardown, each time this node seals or steps down from active duty.
+// Functions run in registration order; errors are reported but do not stop
+// the teardown.
+func (c *Core) RegisterPreSealFunc(f func() error) {
+	c.registeredFuncsLock.Lock()
+	defer c.registeredFuncsLock.Unlock()
+	c.registeredPreSealFuncs = append(c.registeredPreSealFuncs, f)
+}
+
+// RegisterRenewalCallback registers a callback that is invoked on every
+// successful lease renewal, as with ExpirationManager.RegisterRenewalCallback.
+// Unlike callbacks registered on an expiration manager, which is replaced
+// each time this node unseals or becomes active, it stays registered for the
+// lifetime of the core.
+func (c *Core) RegisterRenewalCallback(cb RenewalCallback) {
+	c.registeredFuncsLock.Lock()
+	defer c.registeredFuncsLock.Unlock()
+	c.registeredRenewalCallbacks = append(c.registeredRenewalCallbacks, cb)
+}
+
 func enterprisePostUnsealImpl(c *Core) error {
 	return nil
 }
@@ -1614,6 +2425,12 @@ func (c *Core) AuditedHeadersConfig() *AuditedHeadersConfig {
 	return c.auditedHeaders
 }
 
+// AuditRequestBodyLimit returns how many bytes of a write's raw request body
+// should be captured for auditing, zero if request bodies aren't audited
+func (c *Core) AuditRequestBodyLimit() int64 {
+	return c.auditRequestBodyLimit
+}
+
 func waitUntilWALShippedImpl(ctx context.Context, c *Core, index uint64) bool {
 	return true
 }
@@ -1635,9 +2452,8 @@ func (c *Core) PhysicalSealConfigs(ctx context.Context) (*SealConfig, *SealConfi
 		return nil, nil, nil
 	}
 
-	barrierConf := new(SealConfig)
-
-	if err := jsonutil.DecodeJSON(pe.Value, barrierConf); err != nil {
+	barrierConf, err := decodeSealConfig(pe.Value)
+	if err != nil {
 		return nil, nil, errwrap.Wrapf("failed to decode barrier seal configuration at migration check time: {{err}}", err)
 	}
 
@@ -1647,8 +2463,8 @@ func (c *Core) PhysicalSealConfigs(ctx context.Context) (*SealConfig, *SealConfi
 		return nil, nil, errwrap.Wrapf("failed to fetch seal configuration at migration check time: {{err}}", err)
 	}
 	if pe != nil {
-		recoveryConf = &SealConfig{}
-		if err := jsonutil.DecodeJSON(pe.Value, recoveryConf); err != nil {
+		recoveryConf, err = decodeSealConfig(pe.Value)
+		if err != nil {
 			return nil, nil, errwrap.Wrapf("failed to decode seal configuration at migration check time: {{err}}", err)
 		}
 	}
@@ -2,7 +2,11 @@ package vault
 
 import (
 	"context"
+	"errors"
 	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -130,6 +134,363 @@ func TestCore_Unseal_MultiShare(t *testing.T) {
 	}
 }
 
+func TestCore_Unseal_Lockout(t *testing.T) {
+	clock := newTestClock(time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC))
+	c := TestCoreWithSealAndUI(t, &CoreConfig{
+		UnsealFailureThreshold: 3,
+		UnsealFailureWindow:    time.Minute,
+		UnsealLockoutPeriod:    5 * time.Minute,
+		Clock:                  clock,
+	})
+
+	res, err := c.Initialize(namespace.RootContext(nil), &InitParams{
+		BarrierConfig: &SealConfig{
+			SecretShares:    1,
+			SecretThreshold: 1,
+		},
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	key := res.SecretShares[0]
+
+	// Unseal zeroes the keys it's given, so always hand it a copy
+	goodKey := func() []byte {
+		return append([]byte(nil), key...)
+	}
+	badKey := func() []byte {
+		bad := goodKey()
+		bad[0] ^= 0xff
+		return bad
+	}
+
+	for i := 0; i < 3; i++ {
+		unseal, err := TestCoreUnseal(c, badKey())
+		if err != ErrBarrierInvalidKey {
+			t.Fatalf("expected invalid key error on attempt %d, got: %v", i, err)
+		}
+		if unseal {
+			t.Fatalf("should not be unsealed")
+		}
+	}
+
+	// Locked out now, even with the correct key
+	unseal, err := TestCoreUnseal(c, goodKey())
+	if err != ErrUnsealLockedOut {
+		t.Fatalf("expected lockout error, got: %v", err)
+	}
+	if unseal || !c.Sealed() {
+		t.Fatalf("should be sealed")
+	}
+
+	clock.Advance(4 * time.Minute)
+	if _, err := TestCoreUnseal(c, goodKey()); err != ErrUnsealLockedOut {
+		t.Fatalf("expected lockout error, got: %v", err)
+	}
+
+	clock.Advance(time.Minute)
+	unseal, err = TestCoreUnseal(c, goodKey())
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !unseal || c.Sealed() {
+		t.Fatalf("should be unsealed")
+	}
+
+	inm, err := inmem.NewInmem(nil, logging.NewVaultLogger(log.Trace))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, conf := range map[string]*CoreConfig{
+		"threshold": {UnsealFailureThreshold: -1},
+		"window":    {UnsealFailureThreshold: 3, UnsealFailureWindow: -time.Minute},
+		"period":    {UnsealFailureThreshold: 3, UnsealLockoutPeriod: -time.Minute},
+	} {
+		conf.Physical = inm
+		conf.DisableMlock = true
+		if _, err := NewCore(conf); err == nil {
+			t.Fatalf("expected an error for a negative %s", name)
+		}
+	}
+}
+
+func TestCore_UnsealAuthorizer(t *testing.T) {
+	var attempts []*UnsealAttempt
+	c := TestCoreWithConfig(t, &CoreConfig{
+		UnsealAuthorizer: func(ctx context.Context, attempt *UnsealAttempt) error {
+			attempts = append(attempts, attempt)
+			if attempt.Metadata["remote_addr"] != "10.0.0.1" {
+				return errors.New("unexpected source")
+			}
+			return nil
+		},
+	})
+	keys, _ := TestCoreInit(t, c)
+
+	// A valid share from the wrong place is not counted
+	unseal, err := c.UnsealWithMetadata(TestKeyCopy(keys[0]), map[string]string{"remote_addr": "10.0.0.2"})
+	if _, ok := err.(*ErrUnsealRejected); !ok {
+		t.Fatalf("expected ErrUnsealRejected, got: %v", err)
+	}
+	if unseal || !c.Sealed() {
+		t.Fatal("should be sealed")
+	}
+	if progress, _ := c.SecretProgress(); progress != 0 {
+		t.Fatalf("rejected share was counted, progress is %d", progress)
+	}
+
+	// Neither is one without metadata
+	if _, err := TestCoreUnseal(c, TestKeyCopy(keys[0])); err == nil {
+		t.Fatal("expected share without metadata to be rejected")
+	}
+
+	for i, key := range keys {
+		unseal, err := c.UnsealWithMetadata(TestKeyCopy(key), map[string]string{"remote_addr": "10.0.0.1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if progress, _ := c.SecretProgress(); !unseal && progress != i+1 {
+			t.Fatalf("expected progress %d, got %d", i+1, progress)
+		}
+	}
+	if c.Sealed() {
+		t.Fatal("should be unsealed")
+	}
+
+	if len(attempts) != 5 {
+		t.Fatalf("expected the authorizer to see 5 attempts, got %d", len(attempts))
+	}
+	if attempts[4].Progress != 2 || attempts[4].RecoveryKey {
+		t.Fatalf("bad last attempt: %#v", attempts[4])
+	}
+}
+
+func TestCore_RegisteredUnsealFuncs(t *testing.T) {
+	c := TestCore(t)
+	keys, root := TestCoreInit(t, c)
+
+	var order []string
+	c.RegisterPostUnsealFunc(func() error {
+		order = append(order, "post-unseal-1")
+		return nil
+	})
+	c.RegisterPostUnsealFunc(func() error {
+		order = append(order, "post-unseal-2")
+		return nil
+	})
+	c.RegisterPreSealFunc(func() error {
+		order = append(order, "pre-seal")
+		return nil
+	})
+
+	unseal := func() {
+		for _, key := range keys {
+			if _, err := TestCoreUnseal(c, TestKeyCopy(key)); err != nil {
+				t.Fatalf("unseal err: %s", err)
+			}
+		}
+		if c.Sealed() {
+			t.Fatal("should not be sealed")
+		}
+	}
+
+	unseal()
+	if err := c.Seal(root); err != nil {
+		t.Fatal(err)
+	}
+	unseal()
+
+	expected := []string{"post-unseal-1", "post-unseal-2", "pre-seal", "post-unseal-1", "post-unseal-2"}
+	if !reflect.DeepEqual(order, expected) {
+		t.Fatalf("bad: expected %v, got %v", expected, order)
+	}
+}
+
+func TestCore_RegisteredPostUnsealFunc_Error(t *testing.T) {
+	c := TestCore(t)
+	keys, _ := TestCoreInit(t, c)
+
+	c.RegisterPostUnsealFunc(func() error {
+		return errors.New("not ready")
+	})
+
+	var err error
+	for _, key := range keys {
+		if _, err = TestCoreUnseal(c, TestKeyCopy(key)); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		t.Fatal("expected unseal to fail")
+	}
+	if !c.Sealed() {
+		t.Fatal("should be sealed")
+	}
+}
+
+func TestCore_StoragePrefix(t *testing.T) {
+	logger := logging.NewVaultLogger(log.Trace)
+	inm, err := inmem.NewInmem(nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newCore := func(prefix string) (*Core, error) {
+		conf := testCoreConfig(t, inm, logger)
+		conf.StoragePrefix = prefix
+		return NewCore(conf)
+	}
+
+	if _, err := newCore("tenant-a"); err == nil {
+		t.Fatal("expected an error for a prefix without a trailing slash")
+	}
+
+	coreA, err := newCore("tenant-a/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	coreB, err := newCore("tenant-b/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	_, keysA, rootA := testCoreUnsealed(t, coreA)
+	if init, err := coreB.Initialized(ctx); err != nil || init {
+		t.Fatalf("initializing one core should not initialize the other: %v, %v", init, err)
+	}
+	testCoreUnsealed(t, coreB)
+
+	// Everything is stored under the prefixes
+	keys, err := inm.List(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(keys)
+	if !reflect.DeepEqual(keys, []string{"tenant-a/", "tenant-b/"}) {
+		t.Fatalf("bad top-level keys: %v", keys)
+	}
+
+	clusterA, err := coreA.Cluster(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clusterB, err := coreB.Cluster(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clusterA.ID == clusterB.ID {
+		t.Fatal("expected the cores to have their own cluster info")
+	}
+
+	err = coreA.mount(namespace.RootContext(nil), &MountEntry{
+		Table: mountTableType,
+		Path:  "foo",
+		Type:  "kv",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hasFoo := func(c *Core) bool {
+		t.Helper()
+		mounts, err := c.ListMounts(MountFilter{Table: mountTableType})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range mounts {
+			if entry.Path == "foo/" {
+				return true
+			}
+		}
+		return false
+	}
+	if !hasFoo(coreA) || hasFoo(coreB) {
+		t.Fatal("mount should only exist on the core that created it")
+	}
+
+	// Sealing and unsealing one core reads back its own seal config and
+	// mount table and leaves the other alone
+	if err := coreA.Seal(rootA); err != nil {
+		t.Fatal(err)
+	}
+	if coreB.Sealed() {
+		t.Fatal("sealing one core should not seal the other")
+	}
+	for _, key := range keysA {
+		if _, err := TestCoreUnseal(coreA, TestKeyCopy(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if coreA.Sealed() {
+		t.Fatal("should be unsealed")
+	}
+	if !hasFoo(coreA) || hasFoo(coreB) {
+		t.Fatal("mount should only exist on the core that created it")
+	}
+}
+
+func TestCore_WaitForUnseal(t *testing.T) {
+	c := TestCore(t)
+	res, err := c.Initialize(namespace.RootContext(nil), &InitParams{
+		BarrierConfig: &SealConfig{
+			SecretShares:    5,
+			SecretThreshold: 3,
+		},
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Nothing to wait for while the core stays sealed
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := c.WaitForUnseal(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		for _, key := range res.SecretShares[:3] {
+			if _, err := TestCoreUnseal(c, key); err != nil {
+				errCh <- err
+				return
+			}
+		}
+		errCh <- nil
+	}()
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := c.WaitForUnseal(ctx); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if c.Sealed() {
+		t.Fatal("should not be sealed")
+	}
+
+	// Post-unseal setup is done, so requests can be handled
+	req := &logical.Request{
+		Operation:   logical.ReadOperation,
+		Path:        "sys/mounts",
+		ClientToken: res.RootToken,
+	}
+	if _, err := c.HandleRequest(namespace.RootContext(nil), req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Sealing again means waiting again
+	if err := c.Seal(res.RootToken); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := c.WaitForUnseal(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+}
+
 func TestCore_Unseal_Single(t *testing.T) {
 	c := TestCore(t)
 
@@ -1229,6 +1590,403 @@ func TestCore_Standby_Seal(t *testing.T) {
 	}
 }
 
+// countingHABackend counts how often the HA lock value is read
+type countingHABackend struct {
+	physical.HABackend
+	reads *uint32
+}
+
+func (b *countingHABackend) LockWith(key, value string) (physical.Lock, error) {
+	lock, err := b.HABackend.LockWith(key, value)
+	if err != nil {
+		return nil, err
+	}
+	return &countingLock{inner: lock, reads: b.reads}, nil
+}
+
+type countingLock struct {
+	inner physical.Lock
+	reads *uint32
+}
+
+func (l *countingLock) Lock(stopCh <-chan struct{}) (<-chan struct{}, error) {
+	return l.inner.Lock(stopCh)
+}
+
+func (l *countingLock) Unlock() error {
+	return l.inner.Unlock()
+}
+
+func (l *countingLock) Value() (bool, string, error) {
+	atomic.AddUint32(l.reads, 1)
+	return l.inner.Value()
+}
+
+func TestCore_Leader_LookupCache(t *testing.T) {
+	logger = logging.NewVaultLogger(log.Trace)
+
+	inm, err := inmem.NewInmemHA(nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inmha, err := inmem.NewInmemHA(nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	redirectOriginal := "http://127.0.0.1:8200"
+	core, err := NewCore(&CoreConfig{
+		Physical:     inm,
+		HAPhysical:   inmha.(physical.HABackend),
+		RedirectAddr: redirectOriginal,
+		DisableMlock: true,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	keys, _ := TestCoreInit(t, core)
+	for _, key := range keys {
+		if _, err := TestCoreUnseal(core, TestKeyCopy(key)); err != nil {
+			t.Fatalf("unseal err: %s", err)
+		}
+	}
+	TestWaitActive(t, core)
+
+	// The standby reads the lock through a counting wrapper and caches the
+	// result for far longer than the test runs
+	var reads uint32
+	core2, err := NewCore(&CoreConfig{
+		Physical: inm,
+		HAPhysical: &countingHABackend{
+			HABackend: inmha.(physical.HABackend),
+			reads:     &reads,
+		},
+		RedirectAddr:         "http://127.0.0.1:8500",
+		DisableMlock:         true,
+		LeaderLookupCacheTTL: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	for _, key := range keys {
+		if _, err := TestCoreUnseal(core2, TestKeyCopy(key)); err != nil {
+			t.Fatalf("unseal err: %s", err)
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		isLeader, advertise, _, err := core2.Leader()
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if isLeader {
+			t.Fatal("should not be leader")
+		}
+		if advertise != redirectOriginal {
+			t.Fatalf("bad advertise: %v, orig is %v", advertise, redirectOriginal)
+		}
+	}
+	if n := atomic.LoadUint32(&reads); n != 1 {
+		t.Fatalf("expected the HA lock to be read once, got %d", n)
+	}
+
+	// Dropping the cache sends the next lookup back to storage
+	core2.invalidateLeaderLookupCache()
+	if _, _, _, err := core2.Leader(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if n := atomic.LoadUint32(&reads); n != 2 {
+		t.Fatalf("expected the HA lock to be read twice, got %d", n)
+	}
+}
+
+func TestNewCore_HALockTiming(t *testing.T) {
+	logger = logging.NewVaultLogger(log.Trace)
+
+	inm, err := inmem.NewInmem(nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inmha, err := inmem.NewInmemHA(nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	core, err := NewCore(&CoreConfig{
+		Physical:            inm,
+		HAPhysical:          inmha.(physical.HABackend),
+		RedirectAddr:        "http://127.0.0.1:8200",
+		DisableMlock:        true,
+		HALockRetryInterval: 3 * time.Second,
+		HALockTTL:           200 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if core.haLockRetryInterval != 3*time.Second {
+		t.Fatalf("bad retry interval: %v", core.haLockRetryInterval)
+	}
+
+	// The in-memory backend picks up the TTL, so a lock whose renewals fail
+	// is soon lost
+	ha := inmha.(*inmem.InmemHABackend)
+	lock, err := ha.LockWith(CoreLockPath, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaderCh, err := lock.Lock(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Unlock()
+	ha.FailLockRenewals(true)
+	defer ha.FailLockRenewals(false)
+	select {
+	case <-leaderCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("lock was not lost after its TTL passed without renewal")
+	}
+
+	if _, err := NewCore(&CoreConfig{
+		Physical:     inm,
+		HAPhysical:   inmha.(physical.HABackend),
+		DisableMlock: true,
+		HALockTTL:    -time.Second,
+	}); err == nil {
+		t.Fatal("expected an error for a negative lock TTL")
+	}
+}
+
+func TestCore_ManualStepDownSleepPeriod_Negative(t *testing.T) {
+	inm, err := inmem.NewInmem(nil, logging.NewVaultLogger(log.Trace))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewCore(&CoreConfig{
+		Physical:                  inm,
+		DisableMlock:              true,
+		ManualStepDownSleepPeriod: -time.Second,
+	}); err == nil {
+		t.Fatal("expected an error for a negative manual step-down sleep period")
+	}
+}
+
+func TestCore_OnLeadershipLost(t *testing.T) {
+	logger = logging.NewVaultLogger(log.Trace)
+
+	inm, err := inmem.NewInmem(nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inmha, err := inmem.NewInmemHA(nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ha := inmha.(*inmem.InmemHABackend)
+
+	lostCh := make(chan error, 10)
+	core, err := NewCore(&CoreConfig{
+		Physical:                  inm,
+		HAPhysical:                ha,
+		RedirectAddr:              "http://127.0.0.1:8200",
+		DisableMlock:              true,
+		HALockTTL:                 200 * time.Millisecond,
+		ManualStepDownSleepPeriod: 100 * time.Millisecond,
+		OnLeadershipLost: func(reason error) {
+			lostCh <- reason
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, root := TestCoreInit(t, core)
+	for _, key := range keys {
+		if _, err := TestCoreUnseal(core, TestKeyCopy(key)); err != nil {
+			t.Fatalf("unseal err: %s", err)
+		}
+	}
+	TestWaitActive(t, core)
+
+	// A manual step-down is not reported
+	req := &logical.Request{
+		ClientToken: root,
+		Path:        "sys/step-down",
+	}
+	req.ID, err = uuid.GenerateUUID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := core.StepDown(namespace.RootContext(nil), req); err != nil {
+		t.Fatal(err)
+	}
+	TestWaitActive(t, core)
+	select {
+	case reason := <-lostCh:
+		t.Fatalf("callback invoked for a manual step-down: %v", reason)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	// Losing the lock because the backend stopped renewing it is
+	ha.FailLockRenewals(true)
+	select {
+	case reason := <-lostCh:
+		if reason != ErrHALockLost {
+			t.Fatalf("bad reason: %v", reason)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("callback not invoked after losing the HA lock")
+	}
+	ha.FailLockRenewals(false)
+
+	// The node can become active again once the backend recovers
+	TestWaitActive(t, core)
+}
+
+func TestCore_SuspendLeadership(t *testing.T) {
+	logger = logging.NewVaultLogger(log.Trace)
+
+	inm, err := inmem.NewInmem(nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inmha, err := inmem.NewInmemHA(nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	core, err := NewCore(&CoreConfig{
+		Physical:                  inm,
+		HAPhysical:                inmha.(physical.HABackend),
+		RedirectAddr:              "http://127.0.0.1:8200",
+		DisableMlock:              true,
+		ManualStepDownSleepPeriod: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, _ := TestCoreInit(t, core)
+	for _, key := range keys {
+		if _, err := TestCoreUnseal(core, TestKeyCopy(key)); err != nil {
+			t.Fatalf("unseal err: %s", err)
+		}
+	}
+	TestWaitActive(t, core)
+
+	if err := core.SuspendLeadership(-time.Second); err == nil {
+		t.Fatal("expected an error for a negative suspension")
+	}
+
+	// The node steps down and, although nothing else is campaigning, stays in
+	// standby until the suspension lapses
+	suspension := 3 * time.Second
+	start := time.Now()
+	if err := core.SuspendLeadership(suspension); err != nil {
+		t.Fatal(err)
+	}
+	for time.Since(start) < suspension-500*time.Millisecond {
+		standby, err := core.Standby()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !standby && time.Since(start) > 500*time.Millisecond {
+			t.Fatal("suspended node became active")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	TestWaitActive(t, core)
+	if elapsed := time.Since(start); elapsed < suspension {
+		t.Fatalf("node became active after %s, before the suspension lapsed", elapsed)
+	}
+}
+
+func TestCore_SuspendLeadership_Standby(t *testing.T) {
+	logger = logging.NewVaultLogger(log.Trace)
+
+	inm, err := inmem.NewInmem(nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inmha, err := inmem.NewInmemHA(nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	core, err := NewCore(&CoreConfig{
+		Physical:                  inm,
+		HAPhysical:                inmha.(physical.HABackend),
+		RedirectAddr:              "http://127.0.0.1:8200",
+		DisableMlock:              true,
+		ManualStepDownSleepPeriod: 2 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, root := TestCoreInit(t, core)
+	for _, key := range keys {
+		if _, err := TestCoreUnseal(core, TestKeyCopy(key)); err != nil {
+			t.Fatalf("unseal err: %s", err)
+		}
+	}
+	TestWaitActive(t, core)
+
+	core2, err := NewCore(&CoreConfig{
+		Physical:     inm,
+		HAPhysical:   inmha.(physical.HABackend),
+		RedirectAddr: "http://127.0.0.1:8500",
+		DisableMlock: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range keys {
+		if _, err := TestCoreUnseal(core2, TestKeyCopy(key)); err != nil {
+			t.Fatalf("unseal err: %s", err)
+		}
+	}
+
+	// Suspend the standby, which is already waiting on the lock, then step
+	// the active node down. While the active node sits out its step-down
+	// sleep the standby is the only candidate, but must not take over.
+	if err := core2.SuspendLeadership(time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	req := &logical.Request{
+		ClientToken: root,
+		Path:        "sys/step-down",
+	}
+	req.ID, err = uuid.GenerateUUID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := core.StepDown(namespace.RootContext(nil), req); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		standby, err := core2.Standby()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !standby {
+			t.Fatal("suspended standby became active")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	TestWaitActive(t, core)
+
+	// Lifting the suspension lets the standby campaign again
+	if err := core2.SuspendLeadership(0); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.StepDown(namespace.RootContext(nil), req); err != nil {
+		t.Fatal(err)
+	}
+	TestWaitActive(t, core2)
+}
+
 func TestCore_StepDown(t *testing.T) {
 	// Create the first core and initialize it
 	logger = logging.NewVaultLogger(log.Trace)
@@ -2386,3 +3144,173 @@ func TestCore_HandleRequest_Headers_denyList(t *testing.T) {
 		t.Fatalf("did not expect %q to be in the headers map", consts.AuthHeaderName)
 	}
 }
+
+func TestCore_TestPassthroughOptions(t *testing.T) {
+	writeAndRead := func(t *testing.T, c *Core, root string) (*logical.Response, error) {
+		ctx := namespace.RootContext(nil)
+		req := &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "secret/test",
+			Data: map[string]interface{}{
+				"foo":   "bar",
+				"lease": "1h",
+			},
+			ClientToken: root,
+		}
+		if _, err := c.HandleRequest(ctx, req); err != nil {
+			return nil, err
+		}
+
+		req.Operation = logical.ReadOperation
+		req.Data = nil
+		req.SetTokenEntry(&logical.TokenEntry{ID: root, NamespaceID: "root", Policies: []string{"root"}})
+		return c.HandleRequest(ctx, req)
+	}
+
+	t.Run("leased", func(t *testing.T) {
+		c, _, root := testCoreUnsealed(t, TestCoreWithOptions(t, &CoreConfig{}, &TestCoreOptions{
+			Passthrough: TestPassthroughLeased,
+		}))
+		resp, err := writeAndRead(t, c, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp == nil || resp.Secret == nil || resp.Secret.LeaseID == "" {
+			t.Fatalf("expected a lease: %#v", resp)
+		}
+	})
+
+	t.Run("non-leased", func(t *testing.T) {
+		c, _, root := testCoreUnsealed(t, TestCoreWithOptions(t, &CoreConfig{}, &TestCoreOptions{
+			Passthrough: TestPassthroughNonLeased,
+		}))
+		resp, err := writeAndRead(t, c, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp == nil || resp.Data["foo"] != "bar" {
+			t.Fatalf("bad: %#v", resp)
+		}
+		if resp.Secret != nil && resp.Secret.LeaseID != "" {
+			t.Fatalf("expected no lease: %#v", resp.Secret)
+		}
+	})
+
+	t.Run("none", func(t *testing.T) {
+		c, _, root := testCoreUnsealed(t, TestCoreWithOptions(t, &CoreConfig{}, &TestCoreOptions{
+			Passthrough: TestPassthroughNone,
+		}))
+		if _, err := writeAndRead(t, c, root); err == nil {
+			t.Fatal("expected an error with no kv backend")
+		}
+	})
+}
+
+func TestCore_KeyStatus(t *testing.T) {
+	c := TestCore(t)
+	if _, err := c.KeyStatus(); err != consts.ErrSealed {
+		t.Fatalf("expected sealed error, got %v", err)
+	}
+
+	start := time.Now()
+	keys, root := TestCoreInit(t, c)
+	for _, key := range keys {
+		if _, err := TestCoreUnseal(c, TestKeyCopy(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	info, err := c.KeyStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Term != 1 {
+		t.Fatalf("expected term 1, got %d", info.Term)
+	}
+	if info.InstallTime.Before(start.Add(-time.Second)) || info.InstallTime.After(time.Now()) {
+		t.Fatalf("bad install time %s", info.InstallTime)
+	}
+
+	req := logical.TestRequest(t, logical.UpdateOperation, "sys/rotate")
+	req.ClientToken = root
+	if _, err := c.HandleRequest(namespace.RootContext(nil), req); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err = c.KeyStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Term != 2 {
+		t.Fatalf("expected term 2 after rotation, got %d", info.Term)
+	}
+}
+
+func TestCoreWithConfig_ScopedLogicalBackends(t *testing.T) {
+	scoped := func(context.Context, *logical.BackendConfig) (logical.Backend, error) {
+		return &NoopBackend{}, nil
+	}
+	c, _, _ := TestCoreUnsealedWithConfig(t, &CoreConfig{
+		LogicalBackends: map[string]logical.Factory{
+			"scoped": scoped,
+		},
+	})
+	other, _, _ := TestCoreUnsealed(t)
+
+	if _, ok := testLogicalBackends["scoped"]; ok {
+		t.Fatal("scoped backend leaked into the global test backends")
+	}
+
+	mount := func(core *Core) error {
+		return core.mount(namespace.RootContext(nil), &MountEntry{
+			Table: mountTableType,
+			Path:  "scoped/",
+			Type:  "scoped",
+		})
+	}
+	if err := mount(c); err != nil {
+		t.Fatalf("expected the scoped backend to be mountable: %v", err)
+	}
+	if err := mount(other); err == nil {
+		t.Fatal("expected the scoped backend to be unknown to other cores")
+	}
+}
+
+func TestCore_HashRootToken(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+
+	hash := c.HashRootToken(root)
+	if hash == "" || strings.Contains(hash, root) {
+		t.Fatalf("bad hash: %q", hash)
+	}
+	if !c.VerifyRootToken(root, hash) {
+		t.Fatal("expected the root token to verify")
+	}
+
+	// Each hash is salted separately
+	other := c.HashRootToken(root)
+	if other == hash {
+		t.Fatal("expected a different salt for each hash")
+	}
+	if !c.VerifyRootToken(root, other) {
+		t.Fatal("expected the root token to verify against either hash")
+	}
+
+	if c.VerifyRootToken(root+"x", hash) {
+		t.Fatal("mismatched token should not verify")
+	}
+	tampered := []byte(hash)
+	if tampered[len(tampered)-1] == '0' {
+		tampered[len(tampered)-1] = '1'
+	} else {
+		tampered[len(tampered)-1] = '0'
+	}
+	if c.VerifyRootToken(root, string(tampered)) {
+		t.Fatal("tampered hash should not verify")
+	}
+	for _, bad := range []string{"", "sha256:", "md5:" + strings.TrimPrefix(hash, "sha256:"), root} {
+		if c.VerifyRootToken(root, bad) {
+			t.Fatalf("malformed hash %q should not verify", bad)
+		}
+	}
+}
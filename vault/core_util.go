@@ -16,7 +16,7 @@ type entCore struct{}
 type LicensingConfig struct{}
 
 func coreInit(c *Core, conf *CoreConfig) error {
-	phys := conf.Physical
+	phys := c.physical
 	_, txnOK := phys.(physical.Transactional)
 	sealUnwrapperLogger := conf.Logger.Named("storage.sealunwrapper")
 	c.allLoggers = append(c.allLoggers, sealUnwrapperLogger)
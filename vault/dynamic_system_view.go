@@ -92,8 +92,7 @@ func (d dynamicSystemView) SudoPrivilege(ctx context.Context, path string, token
 // TTLsByPath returns the default and max TTLs corresponding to a particular
 // mount point, or the system default
 func (d dynamicSystemView) fetchTTLs() (def, max time.Duration) {
-	def = d.core.defaultLeaseTTL
-	max = d.core.maxLeaseTTL
+	def, max = d.core.leaseTTLs()
 
 	if d.mountEntry != nil {
 		if d.mountEntry.Config.DefaultLeaseTTL != 0 {
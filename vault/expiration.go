@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -54,7 +55,7 @@ const (
 
 type pendingInfo struct {
 	exportLeaseTimes *leaseEntry
-	timer            *time.Timer
+	timer            ClockTimer
 }
 
 // ExpirationManager is used by the Core to manage leases. Secrets
@@ -66,6 +67,12 @@ type ExpirationManager struct {
 	router     *Router
 	idView     *BarrierView
 	tokenView  *BarrierView
+	bucketView *BarrierView
+
+	// compactView holds the lease entries in place of idView when lease
+	// storage is compacted
+	compactView *compactLeaseStorage
+
 	tokenStore *TokenStore
 	logger     log.Logger
 
@@ -79,7 +86,10 @@ type ExpirationManager struct {
 	restoreRequestLock sync.RWMutex
 	restoreLocks       []*locksutil.LockEntry
 	restoreLoaded      sync.Map
-	quitCh             chan struct{}
+	// restoreDoneCh is closed once Restore first returns, however it went
+	restoreDoneCh   chan struct{}
+	restoreDoneOnce sync.Once
+	quitCh          chan struct{}
 
 	coreStateLock     *sync.RWMutex
 	quitContext       context.Context
@@ -87,10 +97,27 @@ type ExpirationManager struct {
 
 	logLeaseExpirations bool
 	expireFunc          ExpireLeaseStrategy
+
+	// clock is used for lease times and expiration timers
+	clock Clock
+
+	renewalCallbacks     []RenewalCallback
+	renewalCallbacksLock sync.RWMutex
+
+	// revoking tracks the leases whose timers have fired but whose
+	// revocation has not finished yet, keyed by lease ID, along with the
+	// time the revocation started
+	revoking     map[string]time.Time
+	revokingLock sync.Mutex
 }
 
 type ExpireLeaseStrategy func(context.Context, *ExpirationManager, *leaseEntry)
 
+// RenewalCallback is invoked after a lease has been successfully renewed,
+// with the lease ID and the lease's expiration time before and after the
+// renewal. It is called for both secret and token leases.
+type RenewalCallback func(leaseID string, oldExpireTime, newExpireTime time.Time)
+
 // revokeIDFunc is invoked when a given ID is expired
 func expireLeaseStrategyRevoke(ctx context.Context, m *ExpirationManager, le *leaseEntry) {
 	for attempt := uint(0); attempt < maxRevokeAttempts; attempt++ {
@@ -140,16 +167,19 @@ func NewExpirationManager(c *Core, view *BarrierView, e ExpireLeaseStrategy, log
 		router:     c.router,
 		idView:     view.SubView(leaseViewPrefix),
 		tokenView:  view.SubView(tokenViewPrefix),
+		bucketView: view.SubView(compactLeaseViewPrefix),
 		tokenStore: c.tokenStore,
 		logger:     logger,
 		pending:    make(map[string]pendingInfo),
 		tidyLock:   new(int32),
+		revoking:   make(map[string]time.Time),
 
 		// new instances of the expiration manager will go immediately into
 		// restore mode
-		restoreMode:  new(int32),
-		restoreLocks: locksutil.CreateLocks(),
-		quitCh:       make(chan struct{}),
+		restoreMode:   new(int32),
+		restoreDoneCh: make(chan struct{}),
+		restoreLocks:  locksutil.CreateLocks(),
+		quitCh:        make(chan struct{}),
 
 		coreStateLock:     &c.stateLock,
 		quitContext:       c.activeContext,
@@ -157,9 +187,18 @@ func NewExpirationManager(c *Core, view *BarrierView, e ExpireLeaseStrategy, log
 
 		logLeaseExpirations: os.Getenv("VAULT_SKIP_LOGGING_LEASE_EXPIRATIONS") == "",
 		expireFunc:          e,
+		clock:               c.clock,
 	}
 	*exp.restoreMode = 1
 
+	if c.compactLeaseStorage {
+		exp.compactView = newCompactLeaseStorage(exp.bucketView)
+	}
+
+	if exp.clock == nil {
+		exp.clock = realClock{}
+	}
+
 	if exp.logger == nil {
 		opts := log.LoggerOptions{Name: "expiration_manager"}
 		exp.logger = log.New(&opts)
@@ -185,6 +224,12 @@ func (c *Core) setupExpiration(e ExpireLeaseStrategy) error {
 	// Link the token store to this
 	c.tokenStore.SetExpirationManager(mgr)
 
+	// Move leases over if the storage format was switched, before anything
+	// looks them up
+	if err := mgr.migrateLeaseStorage(c.activeContext); err != nil {
+		return err
+	}
+
 	// Restore the existing state
 	c.logger.Info("restoring leases")
 	errorFunc := func() {
@@ -212,6 +257,34 @@ func (c *Core) stopExpiration() error {
 	return nil
 }
 
+// RegisterRenewalCallback adds a callback that is invoked on every successful
+// lease renewal. Callbacks are called synchronously, in the order they were
+// registered, after the renewed lease has been persisted, so they should
+// return quickly. The callback only lasts as long as this manager; use
+// Core.RegisterRenewalCallback to keep it across seals and step-downs.
+func (m *ExpirationManager) RegisterRenewalCallback(cb RenewalCallback) {
+	m.renewalCallbacksLock.Lock()
+	defer m.renewalCallbacksLock.Unlock()
+	m.renewalCallbacks = append(m.renewalCallbacks, cb)
+}
+
+// runRenewalCallbacks invokes the renewal callbacks registered on the core,
+// followed by those registered on this manager
+func (m *ExpirationManager) runRenewalCallbacks(leaseID string, oldExpireTime, newExpireTime time.Time) {
+	m.core.registeredFuncsLock.RLock()
+	coreCallbacks := m.core.registeredRenewalCallbacks
+	m.core.registeredFuncsLock.RUnlock()
+	for _, cb := range coreCallbacks {
+		cb(leaseID, oldExpireTime, newExpireTime)
+	}
+
+	m.renewalCallbacksLock.RLock()
+	defer m.renewalCallbacksLock.RUnlock()
+	for _, cb := range m.renewalCallbacks {
+		cb(leaseID, oldExpireTime, newExpireTime)
+	}
+}
+
 // lockLease takes out a lock for a given lease ID
 func (m *ExpirationManager) lockLease(leaseID string) {
 	locksutil.LockForKey(m.restoreLocks, leaseID).Lock()
@@ -363,6 +436,7 @@ func (m *ExpirationManager) Tidy(ctx context.Context) error {
 // Restore is used to recover the lease states when starting.
 // This is used after starting the vault.
 func (m *ExpirationManager) Restore(errorFunc func()) (retErr error) {
+	defer m.restoreDoneOnce.Do(func() { close(m.restoreDoneCh) })
 	defer func() {
 		// Turn off restore mode. We can do this safely without the lock because
 		// if restore mode finished successfully, restore mode was already
@@ -590,7 +664,7 @@ func (m *ExpirationManager) LazyRevoke(ctx context.Context, leaseID string) erro
 		return nil
 	}
 
-	le.ExpireTime = time.Now()
+	le.ExpireTime = m.clock.Now()
 	{
 		m.pendingLock.Lock()
 		if err := m.persistEntry(ctx, le); err != nil {
@@ -711,7 +785,7 @@ func (m *ExpirationManager) RevokeByToken(ctx context.Context, te *logical.Token
 		// updatePending to hand off revocation to the expiration manager's pending
 		// timer map
 		if le != nil {
-			le.ExpireTime = time.Now()
+			le.ExpireTime = m.clock.Now()
 
 			{
 				m.pendingLock.Lock()
@@ -779,9 +853,7 @@ func (m *ExpirationManager) revokePrefixCommon(ctx context.Context, prefix strin
 	if err != nil {
 		return err
 	}
-	view := m.leaseView(ns)
-	sub := view.SubView(prefix)
-	existing, err := logical.CollectKeys(ctx, sub)
+	existing, err := collectLeaseIDs(ctx, m.leaseView(ns), prefix)
 	if err != nil {
 		return errwrap.Wrapf("failed to scan for leases: {{err}}", err)
 	}
@@ -804,6 +876,58 @@ func (m *ExpirationManager) revokePrefixCommon(ctx context.Context, prefix strin
 	return nil
 }
 
+// ExpireBefore revokes every lease that expires before t and returns the IDs
+// of the leases it revoked, e.g. to get leases out of the way ahead of
+// planned maintenance of the backends that issued them. A lease that fails
+// to revoke is kept and the error is returned along with the other revoked
+// IDs.
+func (m *ExpirationManager) ExpireBefore(t time.Time) ([]string, error) {
+	defer metrics.MeasureSince([]string{"expire", "expire-before"}, time.Now())
+
+	// Until restoring is done not every lease has a timer yet
+	if m.inRestoreMode() {
+		return nil, errors.New("cannot expire leases while restoring leases")
+	}
+
+	var candidates []string
+	m.pendingLock.RLock()
+	for leaseID, pending := range m.pending {
+		if pending.exportLeaseTimes != nil && pending.exportLeaseTimes.ExpireTime.Before(t) {
+			candidates = append(candidates, leaseID)
+		}
+	}
+	m.pendingLock.RUnlock()
+	sort.Strings(candidates)
+
+	var revoked []string
+	var expireErrors *multierror.Error
+	for _, leaseID := range candidates {
+		le, err := m.loadEntry(m.quitContext, leaseID)
+		if err != nil {
+			expireErrors = multierror.Append(expireErrors, errwrap.Wrapf(fmt.Sprintf("failed to load lease %q: {{err}}", leaseID), err))
+			continue
+		}
+
+		// It may have been revoked or renewed in the meantime
+		if le == nil || le.ExpireTime.IsZero() || !le.ExpireTime.Before(t) {
+			continue
+		}
+
+		revokeCtx := namespace.ContextWithNamespace(m.quitContext, le.namespace)
+		if err := m.revokeCommon(revokeCtx, leaseID, false, false); err != nil {
+			expireErrors = multierror.Append(expireErrors, errwrap.Wrapf(fmt.Sprintf("failed to revoke lease %q: {{err}}", leaseID), err))
+			continue
+		}
+		revoked = append(revoked, leaseID)
+	}
+
+	if m.logger.IsInfo() {
+		m.logger.Info("expired leases early", "before", t, "count", len(revoked))
+	}
+
+	return revoked, expireErrors.ErrorOrNil()
+}
+
 // Renew is used to renew a secret using the given leaseID
 // and a renew interval. The increment may be ignored.
 func (m *ExpirationManager) Renew(ctx context.Context, leaseID string, increment time.Duration) (*logical.Response, error) {
@@ -816,7 +940,7 @@ func (m *ExpirationManager) Renew(ctx context.Context, leaseID string, increment
 	}
 
 	// Check if the lease is renewable
-	if _, err := le.renewable(); err != nil {
+	if _, err := le.renewable(m.clock.Now()); err != nil {
 		return nil, err
 	}
 
@@ -871,10 +995,11 @@ func (m *ExpirationManager) Renew(ctx context.Context, leaseID string, increment
 	resp.Secret.LeaseID = leaseID
 
 	// Update the lease entry
+	oldExpireTime := le.ExpireTime
 	le.Data = resp.Data
 	le.Secret = resp.Secret
-	le.ExpireTime = resp.Secret.ExpirationTime()
-	le.LastRenewalTime = time.Now()
+	le.LastRenewalTime = m.clock.Now()
+	le.ExpireTime = leaseExpireTime(le.LastRenewalTime, &resp.Secret.LeaseOptions)
 
 	// If the token it's associated with is a batch token, constrain lease
 	// times
@@ -908,6 +1033,8 @@ func (m *ExpirationManager) Renew(ctx context.Context, leaseID string, increment
 		m.pendingLock.Unlock()
 	}
 
+	m.runRenewalCallbacks(leaseID, oldExpireTime, le.ExpireTime)
+
 	// Return the response
 	return resp, nil
 }
@@ -957,7 +1084,7 @@ func (m *ExpirationManager) RenewToken(ctx context.Context, req *logical.Request
 
 	// Check if the lease is renewable. Note that this also checks for a nil
 	// lease and errors in that case as well.
-	if _, err := le.renewable(); err != nil {
+	if _, err := le.renewable(m.clock.Now()); err != nil {
 		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
 	}
 
@@ -1009,9 +1136,10 @@ func (m *ExpirationManager) RenewToken(ctx context.Context, req *logical.Request
 	}
 
 	// Update the lease entry
+	oldExpireTime := le.ExpireTime
 	le.Auth = resp.Auth
-	le.ExpireTime = resp.Auth.ExpirationTime()
-	le.LastRenewalTime = time.Now()
+	le.LastRenewalTime = m.clock.Now()
+	le.ExpireTime = leaseExpireTime(le.LastRenewalTime, &resp.Auth.LeaseOptions)
 
 	{
 		m.pendingLock.Lock()
@@ -1025,6 +1153,8 @@ func (m *ExpirationManager) RenewToken(ctx context.Context, req *logical.Request
 		m.pendingLock.Unlock()
 	}
 
+	m.runRenewalCallbacks(leaseID, oldExpireTime, le.ExpireTime)
+
 	retResp.Auth = resp.Auth
 	return retResp, nil
 }
@@ -1067,6 +1197,7 @@ func (m *ExpirationManager) Register(ctx context.Context, req *logical.Request,
 		leaseID = fmt.Sprintf("%s.%s", leaseID, ns.ID)
 	}
 
+	now := m.clock.Now()
 	le := &leaseEntry{
 		LeaseID:         leaseID,
 		ClientToken:     req.ClientToken,
@@ -1074,8 +1205,8 @@ func (m *ExpirationManager) Register(ctx context.Context, req *logical.Request,
 		Path:            req.Path,
 		Data:            resp.Data,
 		Secret:          resp.Secret,
-		IssueTime:       time.Now(),
-		ExpireTime:      resp.Secret.ExpirationTime(),
+		IssueTime:       now,
+		ExpireTime:      leaseExpireTime(now, &resp.Secret.LeaseOptions),
 		namespace:       ns,
 	}
 
@@ -1179,13 +1310,14 @@ func (m *ExpirationManager) RegisterAuth(ctx context.Context, te *logical.TokenE
 	}
 
 	// Create a lease entry
+	now := m.clock.Now()
 	le := leaseEntry{
 		LeaseID:     leaseID,
 		ClientToken: auth.ClientToken,
 		Auth:        auth,
 		Path:        te.Path,
-		IssueTime:   time.Now(),
-		ExpireTime:  auth.ExpirationTime(),
+		IssueTime:   now,
+		ExpireTime:  leaseExpireTime(now, &auth.LeaseOptions),
 		namespace:   tokenNS,
 	}
 
@@ -1319,8 +1451,10 @@ func (m *ExpirationManager) updatePendingInternal(le *leaseEntry, leaseTotal tim
 	if ok {
 		pending.timer.Reset(leaseTotal)
 	} else {
-		timer := time.AfterFunc(leaseTotal, func() {
+		timer := m.clock.AfterFunc(leaseTotal, func() {
+			start := m.startRevocation(le.LeaseID)
 			m.expireFunc(m.quitContext, m, le)
+			m.finishRevocation(le.LeaseID, start)
 		})
 		pending = pendingInfo{
 			timer: timer,
@@ -1333,6 +1467,32 @@ func (m *ExpirationManager) updatePendingInternal(le *leaseEntry, leaseTotal tim
 	m.pending[le.LeaseID] = pending
 }
 
+// startRevocation records that the lease's timer has fired and its
+// revocation is underway, returning the time it started
+func (m *ExpirationManager) startRevocation(leaseID string) time.Time {
+	start := time.Now()
+	m.revokingLock.Lock()
+	m.revoking[leaseID] = start
+	m.revokingLock.Unlock()
+	return start
+}
+
+// finishRevocation records that the expiry of the lease has been handled.
+// If the lease is gone, i.e. it was actually revoked rather than given up on,
+// the time taken is emitted as the revocation latency.
+func (m *ExpirationManager) finishRevocation(leaseID string, start time.Time) {
+	m.pendingLock.RLock()
+	_, ok := m.pending[leaseID]
+	m.pendingLock.RUnlock()
+	if !ok {
+		metrics.MeasureSince([]string{"expire", "revoke-latency"}, start)
+	}
+
+	m.revokingLock.Lock()
+	delete(m.revoking, leaseID)
+	m.revokingLock.Unlock()
+}
+
 // revokeEntry is used to attempt revocation of an internal entry
 func (m *ExpirationManager) revokeEntry(ctx context.Context, le *leaseEntry) error {
 	// Revocation of login tokens is special since we can by-pass the
@@ -1480,7 +1640,7 @@ func (m *ExpirationManager) loadEntryInternal(ctx context.Context, leaseID strin
 		m.restoreLoaded.Store(le.LeaseID, struct{}{})
 
 		// Setup revocation timer
-		m.updatePending(le, le.ExpireTime.Sub(time.Now()))
+		m.updatePending(le, le.ExpireTime.Sub(m.clock.Now()))
 	}
 	return le, nil
 }
@@ -1665,7 +1825,7 @@ func (m *ExpirationManager) CreateOrFetchRevocationLeaseByToken(ctx context.Cont
 		}
 
 		// Create a lease entry
-		now := time.Now()
+		now := m.clock.Now()
 		le = &leaseEntry{
 			LeaseID:     leaseID,
 			ClientToken: auth.ClientToken,
@@ -1731,6 +1891,20 @@ func (m *ExpirationManager) emitMetrics() {
 	num := len(m.pending)
 	m.pendingLock.RUnlock()
 	metrics.SetGauge([]string{"expire", "num_leases"}, float32(num))
+
+	// Report on the leases that have expired but are still being revoked, so
+	// that revocation falling behind can be spotted
+	var oldest time.Duration
+	m.revokingLock.Lock()
+	numRevoking := len(m.revoking)
+	for _, start := range m.revoking {
+		if age := time.Since(start); age > oldest {
+			oldest = age
+		}
+	}
+	m.revokingLock.Unlock()
+	metrics.SetGauge([]string{"expire", "num_pending_revocations"}, float32(numRevoking))
+	metrics.SetGauge([]string{"expire", "oldest_pending_revocation"}, float32(oldest.Seconds()*1e3))
 	// Check if lease count is greater than the threshold
 	if num > maxLeaseThreshold {
 		if atomic.LoadUint32(m.leaseCheckCounter) > 59 {
@@ -1764,7 +1938,8 @@ func (le *leaseEntry) encode() ([]byte, error) {
 	return json.Marshal(le)
 }
 
-func (le *leaseEntry) renewable() (bool, error) {
+// renewable returns whether the lease can be renewed at the given time
+func (le *leaseEntry) renewable(now time.Time) (bool, error) {
 	switch {
 	// If there is no entry, cannot review to renew
 	case le == nil:
@@ -1777,7 +1952,7 @@ func (le *leaseEntry) renewable() (bool, error) {
 		return false, nil
 
 	// Determine if the lease is expired
-	case le.ExpireTime.Before(time.Now()):
+	case le.ExpireTime.Before(now):
 		return false, fmt.Errorf("lease expired")
 
 	// Determine if the lease is renewable
@@ -1791,8 +1966,9 @@ func (le *leaseEntry) renewable() (bool, error) {
 	return true, nil
 }
 
-func (le *leaseEntry) ttl() int64 {
-	return int64(le.ExpireTime.Sub(time.Now().Round(time.Second)).Seconds())
+// ttl returns the seconds left on the lease at the given time
+func (le *leaseEntry) ttl(now time.Time) int64 {
+	return int64(le.ExpireTime.Sub(now.Round(time.Second)).Seconds())
 }
 
 // decodeLeaseEntry is used to reverse encode and return a new entry
@@ -0,0 +1,684 @@
+package vault
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/golang-lru"
+	"github.com/hashicorp/vault/helper/jsonutil"
+	"github.com/hashicorp/vault/logical"
+)
+
+const (
+	// compactLeaseViewPrefix is the prefix used for the lease buckets when
+	// lease storage is compacted. It sits next to leaseViewPrefix.
+	compactLeaseViewPrefix = "bucket/"
+
+	// compactLeaseFormatKey holds the compactLeaseFormat of the buckets,
+	// under compactLeaseViewPrefix
+	compactLeaseFormatKey = "format"
+
+	// compactLeaseFormatVersion is the only layout of the buckets supported
+	compactLeaseFormatVersion = 1
+
+	// compactLeaseBucketCount is the number of buckets leases are spread
+	// across when compacted lease storage is first written. Storage written
+	// with a different count keeps the count it was written with.
+	compactLeaseBucketCount = 1024
+
+	// compactLeasePageMaxSize is the size of the lease entries a page of a
+	// bucket is filled up to before another page is added, well below the
+	// value size limits of the storage backends
+	compactLeasePageMaxSize = 64 * 1024
+
+	// compactLeasePageCacheSize is the number of decoded pages kept in
+	// memory
+	compactLeasePageCacheSize = 256
+
+	// compactLeaseMigrateBatchSize is the number of leases compacted at a
+	// time when switching to compacted lease storage
+	compactLeaseMigrateBatchSize = 1024
+)
+
+// compactLeaseFormat records how compacted lease storage is laid out, so that
+// it can change later
+type compactLeaseFormat struct {
+	Version     int `json:"version"`
+	BucketCount int `json:"bucket_count"`
+}
+
+// compactLeaseRecord is a single lease entry stored in a bucket
+type compactLeaseRecord struct {
+	Value    []byte `json:"value"`
+	SealWrap bool   `json:"seal_wrap,omitempty"`
+}
+
+// compactLeasePage is the stored form of a page of a bucket, keyed by lease
+// ID
+type compactLeasePage struct {
+	Entries map[string]*compactLeaseRecord `json:"entries"`
+}
+
+// compactLeaseStorage stores lease entries grouped into a fixed number of
+// buckets, chosen by hashing the lease ID, rather than one storage entry per
+// lease. Each bucket is made up of pages that are filled up to
+// compactLeasePageMaxSize, so that only the page holding a lease is
+// rewritten when it changes. It presents the same keys as the per-lease
+// view, so the expiration manager can use either; the expiration manager
+// must be the only writer.
+type compactLeaseStorage struct {
+	view *BarrierView
+
+	// pageMaxSize is compactLeasePageMaxSize, except in tests
+	pageMaxSize int
+
+	formatLock  sync.Mutex
+	bucketCount int
+
+	// locks guard the buckets, and pages holds the keys of the pages of
+	// each bucket once listed
+	locks []sync.Mutex
+	pages [][]string
+
+	// cache holds recently used pages by key. Cached pages are never
+	// modified; changes replace them.
+	cache *lru.Cache
+}
+
+var _ logical.Storage = (*compactLeaseStorage)(nil)
+
+func newCompactLeaseStorage(view *BarrierView) *compactLeaseStorage {
+	cache, _ := lru.New(compactLeasePageCacheSize)
+	return &compactLeaseStorage{
+		view:        view,
+		pageMaxSize: compactLeasePageMaxSize,
+		cache:       cache,
+	}
+}
+
+// loadFormat reads the layout of the buckets, or records the default one if
+// nothing has been written yet. It must be called before the buckets are
+// used.
+func (s *compactLeaseStorage) loadFormat(ctx context.Context) error {
+	s.formatLock.Lock()
+	defer s.formatLock.Unlock()
+
+	if s.bucketCount != 0 {
+		return nil
+	}
+
+	format := &compactLeaseFormat{
+		Version:     compactLeaseFormatVersion,
+		BucketCount: compactLeaseBucketCount,
+	}
+	entry, err := s.view.Get(ctx, compactLeaseFormatKey)
+	if err != nil {
+		return errwrap.Wrapf("failed to read lease bucket format: {{err}}", err)
+	}
+	if entry != nil {
+		if err := jsonutil.DecodeJSON(entry.Value, format); err != nil {
+			return errwrap.Wrapf("failed to decode lease bucket format: {{err}}", err)
+		}
+		if format.Version != compactLeaseFormatVersion || format.BucketCount <= 0 {
+			return fmt.Errorf("unsupported lease bucket format version %d with %d buckets", format.Version, format.BucketCount)
+		}
+	} else {
+		buf, err := jsonutil.EncodeJSON(format)
+		if err != nil {
+			return err
+		}
+		if err := s.view.Put(ctx, &logical.StorageEntry{
+			Key:   compactLeaseFormatKey,
+			Value: buf,
+		}); err != nil {
+			return errwrap.Wrapf("failed to persist lease bucket format: {{err}}", err)
+		}
+	}
+
+	s.locks = make([]sync.Mutex, format.BucketCount)
+	s.pages = make([][]string, format.BucketCount)
+	s.bucketCount = format.BucketCount
+	return nil
+}
+
+// bucketFor returns the bucket that holds the given lease ID
+func (s *compactLeaseStorage) bucketFor(leaseID string) int {
+	h := fnv.New32a()
+	h.Write([]byte(leaseID))
+	return int(h.Sum32() % uint32(s.bucketCount))
+}
+
+func compactLeaseBucketPrefix(i int) string {
+	return fmt.Sprintf("%04x/", i)
+}
+
+// bucketPages returns the keys of the pages of bucket i. The lock for the
+// bucket must be held.
+func (s *compactLeaseStorage) bucketPages(ctx context.Context, i int) ([]string, error) {
+	if s.pages[i] != nil {
+		return s.pages[i], nil
+	}
+
+	prefix := compactLeaseBucketPrefix(i)
+	keys, err := s.view.List(ctx, prefix)
+	if err != nil {
+		return nil, errwrap.Wrapf(fmt.Sprintf("failed to list lease bucket %d: {{err}}", i), err)
+	}
+	pages := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pages = append(pages, prefix+key)
+	}
+	sort.Strings(pages)
+
+	s.pages[i] = pages
+	return pages, nil
+}
+
+// loadPage returns the entries of the page with the given key. The returned
+// map must not be modified. The lock for the page's bucket must be held.
+func (s *compactLeaseStorage) loadPage(ctx context.Context, key string) (map[string]*compactLeaseRecord, error) {
+	if cached, ok := s.cache.Get(key); ok {
+		return cached.(map[string]*compactLeaseRecord), nil
+	}
+
+	entry, err := s.view.Get(ctx, key)
+	if err != nil {
+		return nil, errwrap.Wrapf(fmt.Sprintf("failed to read lease page %s: {{err}}", key), err)
+	}
+	page := &compactLeasePage{}
+	if entry != nil {
+		if err := jsonutil.DecodeJSON(entry.Value, page); err != nil {
+			return nil, errwrap.Wrapf(fmt.Sprintf("failed to decode lease page %s: {{err}}", key), err)
+		}
+	}
+	if page.Entries == nil {
+		page.Entries = make(map[string]*compactLeaseRecord)
+	}
+
+	s.cache.Add(key, page.Entries)
+	return page.Entries, nil
+}
+
+// storePage writes the page with the given key, or removes it once it is
+// empty. The lock for the page's bucket must be held.
+func (s *compactLeaseStorage) storePage(ctx context.Context, key string, entries map[string]*compactLeaseRecord) error {
+	if len(entries) == 0 {
+		if err := s.view.Delete(ctx, key); err != nil {
+			return errwrap.Wrapf(fmt.Sprintf("failed to delete lease page %s: {{err}}", key), err)
+		}
+		s.cache.Remove(key)
+		return nil
+	}
+
+	buf, err := jsonutil.EncodeJSON(&compactLeasePage{
+		Entries: entries,
+	})
+	if err != nil {
+		return errwrap.Wrapf(fmt.Sprintf("failed to encode lease page %s: {{err}}", key), err)
+	}
+	sealWrap := false
+	for _, record := range entries {
+		if record.SealWrap {
+			sealWrap = true
+			break
+		}
+	}
+	if err := s.view.Put(ctx, &logical.StorageEntry{
+		Key:      key,
+		Value:    buf,
+		SealWrap: sealWrap,
+	}); err != nil {
+		return errwrap.Wrapf(fmt.Sprintf("failed to persist lease page %s: {{err}}", key), err)
+	}
+	s.cache.Add(key, entries)
+	return nil
+}
+
+// newPageKey returns the key for a page to add to bucket i, given the keys of
+// its pages
+func (s *compactLeaseStorage) newPageKey(i int, pages []string) string {
+	next := 0
+	for _, page := range pages {
+		n, err := strconv.Atoi(page[strings.LastIndex(page, "/")+1:])
+		if err == nil && n >= next {
+			next = n + 1
+		}
+	}
+	return fmt.Sprintf("%s%d", compactLeaseBucketPrefix(i), next)
+}
+
+// compactLeaseRecordSize is what a lease entry counts for towards the size of
+// a page
+func compactLeaseRecordSize(key string, record *compactLeaseRecord) int {
+	return len(key) + len(record.Value)
+}
+
+func compactLeasePageSize(entries map[string]*compactLeaseRecord) int {
+	size := 0
+	for key, record := range entries {
+		size += compactLeaseRecordSize(key, record)
+	}
+	return size
+}
+
+func copyCompactLeasePage(entries map[string]*compactLeaseRecord) map[string]*compactLeaseRecord {
+	out := make(map[string]*compactLeaseRecord, len(entries)+1)
+	for k, v := range entries {
+		out[k] = v
+	}
+	return out
+}
+
+// findPage returns the key and entries of the page of bucket i holding the
+// given lease ID, or an empty key if there is none. The lock for the bucket
+// must be held.
+func (s *compactLeaseStorage) findPage(ctx context.Context, i int, leaseID string) (string, map[string]*compactLeaseRecord, error) {
+	pages, err := s.bucketPages(ctx, i)
+	if err != nil {
+		return "", nil, err
+	}
+	for _, page := range pages {
+		entries, err := s.loadPage(ctx, page)
+		if err != nil {
+			return "", nil, err
+		}
+		if _, ok := entries[leaseID]; ok {
+			return page, entries, nil
+		}
+	}
+	return "", nil, nil
+}
+
+func (s *compactLeaseStorage) Get(ctx context.Context, key string) (*logical.StorageEntry, error) {
+	if err := s.loadFormat(ctx); err != nil {
+		return nil, err
+	}
+
+	i := s.bucketFor(key)
+	s.locks[i].Lock()
+	defer s.locks[i].Unlock()
+
+	page, entries, err := s.findPage(ctx, i, key)
+	if err != nil {
+		return nil, err
+	}
+	if page == "" {
+		return nil, nil
+	}
+
+	record := entries[key]
+	value := make([]byte, len(record.Value))
+	copy(value, record.Value)
+	return &logical.StorageEntry{
+		Key:      key,
+		Value:    value,
+		SealWrap: record.SealWrap,
+	}, nil
+}
+
+func (s *compactLeaseStorage) Put(ctx context.Context, entry *logical.StorageEntry) error {
+	return s.putBatch(ctx, []*logical.StorageEntry{entry})
+}
+
+// putBatch stores several entries, writing each affected page once
+func (s *compactLeaseStorage) putBatch(ctx context.Context, batch []*logical.StorageEntry) error {
+	if err := s.loadFormat(ctx); err != nil {
+		return err
+	}
+
+	byBucket := make(map[int][]*logical.StorageEntry)
+	for _, entry := range batch {
+		i := s.bucketFor(entry.Key)
+		byBucket[i] = append(byBucket[i], entry)
+	}
+
+	for i, entries := range byBucket {
+		if err := s.putBucket(ctx, i, entries); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *compactLeaseStorage) putBucket(ctx context.Context, i int, batch []*logical.StorageEntry) error {
+	s.locks[i].Lock()
+	defer s.locks[i].Unlock()
+
+	pages, err := s.bucketPages(ctx, i)
+	if err != nil {
+		return err
+	}
+
+	// Work on copies of the pages of the bucket
+	working := make(map[string]map[string]*compactLeaseRecord, len(pages))
+	sizes := make(map[string]int, len(pages))
+	order := append([]string(nil), pages...)
+	for _, page := range pages {
+		entries, err := s.loadPage(ctx, page)
+		if err != nil {
+			return err
+		}
+		working[page] = entries
+		sizes[page] = compactLeasePageSize(entries)
+	}
+
+	added := make(map[string]struct{})
+	removed := make(map[string]struct{})
+	copied := make(map[string]struct{})
+	modify := func(page string) map[string]*compactLeaseRecord {
+		if _, ok := copied[page]; !ok {
+			working[page] = copyCompactLeasePage(working[page])
+			copied[page] = struct{}{}
+		}
+		return working[page]
+	}
+
+	for _, entry := range batch {
+		value := make([]byte, len(entry.Value))
+		copy(value, entry.Value)
+		record := &compactLeaseRecord{
+			Value:    value,
+			SealWrap: entry.SealWrap,
+		}
+		size := compactLeaseRecordSize(entry.Key, record)
+
+		current := ""
+		for _, page := range order {
+			if _, ok := working[page][entry.Key]; ok {
+				current = page
+				break
+			}
+		}
+
+		// Update the lease in place if it still fits
+		if current != "" {
+			others := sizes[current] - compactLeaseRecordSize(entry.Key, working[current][entry.Key])
+			if others == 0 || others+size <= s.pageMaxSize {
+				modify(current)[entry.Key] = record
+				sizes[current] = others + size
+				added[current] = struct{}{}
+				continue
+			}
+		}
+
+		// Otherwise move it to the first page with room, or a new one
+		target := ""
+		for _, page := range order {
+			if page != current && sizes[page]+size <= s.pageMaxSize {
+				target = page
+				break
+			}
+		}
+		if target == "" {
+			target = s.newPageKey(i, order)
+			order = append(order, target)
+			working[target] = make(map[string]*compactLeaseRecord)
+			copied[target] = struct{}{}
+		}
+		modify(target)[entry.Key] = record
+		sizes[target] += size
+		added[target] = struct{}{}
+
+		if current != "" {
+			delete(modify(current), entry.Key)
+			sizes[current] = compactLeasePageSize(working[current])
+			removed[current] = struct{}{}
+		}
+	}
+
+	// Write the pages that gained entries before the ones that only lost
+	// some, so that an interrupted write never loses a lease
+	var writeErr error
+	for _, page := range order {
+		if _, ok := added[page]; ok {
+			if writeErr = s.storePage(ctx, page, working[page]); writeErr != nil {
+				break
+			}
+		}
+	}
+	if writeErr == nil {
+		for _, page := range order {
+			_, isAdded := added[page]
+			if _, ok := removed[page]; ok && !isAdded {
+				if writeErr = s.storePage(ctx, page, working[page]); writeErr != nil {
+					break
+				}
+			}
+		}
+	}
+	if writeErr != nil {
+		// Read the bucket from storage again next time
+		for _, page := range order {
+			s.cache.Remove(page)
+		}
+		s.pages[i] = nil
+		return writeErr
+	}
+
+	var kept []string
+	for _, page := range order {
+		if len(working[page]) != 0 {
+			kept = append(kept, page)
+		}
+	}
+	s.pages[i] = kept
+	return nil
+}
+
+func (s *compactLeaseStorage) Delete(ctx context.Context, key string) error {
+	if err := s.loadFormat(ctx); err != nil {
+		return err
+	}
+
+	i := s.bucketFor(key)
+	s.locks[i].Lock()
+	defer s.locks[i].Unlock()
+
+	page, entries, err := s.findPage(ctx, i, key)
+	if err != nil {
+		return err
+	}
+	if page == "" {
+		return nil
+	}
+
+	updated := copyCompactLeasePage(entries)
+	delete(updated, key)
+	if err := s.storePage(ctx, page, updated); err != nil {
+		return err
+	}
+	if len(updated) == 0 {
+		kept := make([]string, 0, len(s.pages[i]))
+		for _, p := range s.pages[i] {
+			if p != page {
+				kept = append(kept, p)
+			}
+		}
+		s.pages[i] = kept
+	}
+	return nil
+}
+
+// List returns the keys directly under prefix, with deeper keys folded into
+// their first path segment, like a storage List
+func (s *compactLeaseStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	keys, err := s.keys(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]struct{}, len(keys))
+	var out []string
+	for _, key := range keys {
+		if idx := strings.Index(key, "/"); idx != -1 {
+			key = key[:idx+1]
+		}
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		out = append(out, key)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// keys returns every key under prefix, with the prefix removed. Keys are
+// returned grouped by the page holding them, so that looking them up in order
+// reads each page once.
+func (s *compactLeaseStorage) keys(ctx context.Context, prefix string) ([]string, error) {
+	if err := s.loadFormat(ctx); err != nil {
+		return nil, err
+	}
+
+	var out []string
+	for i := 0; i < s.bucketCount; i++ {
+		if err := s.bucketKeys(ctx, i, prefix, &out); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func (s *compactLeaseStorage) bucketKeys(ctx context.Context, i int, prefix string, out *[]string) error {
+	s.locks[i].Lock()
+	defer s.locks[i].Unlock()
+
+	pages, err := s.bucketPages(ctx, i)
+	if err != nil {
+		return err
+	}
+	for _, page := range pages {
+		entries, err := s.loadPage(ctx, page)
+		if err != nil {
+			return err
+		}
+		var keys []string
+		for key := range entries {
+			if strings.HasPrefix(key, prefix) {
+				keys = append(keys, strings.TrimPrefix(key, prefix))
+			}
+		}
+		sort.Strings(keys)
+		*out = append(*out, keys...)
+	}
+	return nil
+}
+
+// collectLeaseIDs returns the IDs of the leases under prefix in the given
+// lease view, with the prefix removed
+func collectLeaseIDs(ctx context.Context, view logical.Storage, prefix string) ([]string, error) {
+	switch v := view.(type) {
+	case *compactLeaseStorage:
+		return v.keys(ctx, prefix)
+	case *BarrierView:
+		return logical.CollectKeys(ctx, v.SubView(prefix))
+	default:
+		return nil, fmt.Errorf("unknown lease view type %T", view)
+	}
+}
+
+// migrateLeaseStorage moves leases stored in the other format into the one
+// this manager is configured for, so that switching
+// CoreConfig.CompactLeaseStorage on or off keeps every lease. Entries are
+// written in their new form before the old ones are removed, so an
+// interrupted migration is picked up again the next time.
+func (m *ExpirationManager) migrateLeaseStorage(ctx context.Context) error {
+	if m.compactView != nil {
+		leaseIDs, err := logical.CollectKeys(ctx, m.idView)
+		if err != nil {
+			return errwrap.Wrapf("failed to scan for leases to compact: {{err}}", err)
+		}
+		if len(leaseIDs) == 0 {
+			return nil
+		}
+
+		m.logger.Info("compacting lease storage", "num_leases", len(leaseIDs))
+		for len(leaseIDs) > 0 {
+			n := len(leaseIDs)
+			if n > compactLeaseMigrateBatchSize {
+				n = compactLeaseMigrateBatchSize
+			}
+			if err := m.compactLeases(ctx, leaseIDs[:n]); err != nil {
+				return err
+			}
+			leaseIDs = leaseIDs[n:]
+		}
+		m.logger.Info("lease storage compacted")
+		return nil
+	}
+
+	keys, err := logical.CollectKeys(ctx, m.bucketView)
+	if err != nil {
+		return errwrap.Wrapf("failed to scan for compacted leases: {{err}}", err)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	m.logger.Info("expanding compacted lease storage", "num_entries", len(keys))
+	for _, key := range keys {
+		if key == compactLeaseFormatKey {
+			continue
+		}
+		entry, err := m.bucketView.Get(ctx, key)
+		if err != nil {
+			return errwrap.Wrapf(fmt.Sprintf("failed to read lease page %s: {{err}}", key), err)
+		}
+		if entry == nil {
+			continue
+		}
+		var page compactLeasePage
+		if err := jsonutil.DecodeJSON(entry.Value, &page); err != nil {
+			return errwrap.Wrapf(fmt.Sprintf("failed to decode lease page %s: {{err}}", key), err)
+		}
+		for leaseID, record := range page.Entries {
+			if err := m.idView.Put(ctx, &logical.StorageEntry{
+				Key:      leaseID,
+				Value:    record.Value,
+				SealWrap: record.SealWrap,
+			}); err != nil {
+				return errwrap.Wrapf(fmt.Sprintf("failed to persist lease entry %s: {{err}}", leaseID), err)
+			}
+		}
+		if err := m.bucketView.Delete(ctx, key); err != nil {
+			return errwrap.Wrapf(fmt.Sprintf("failed to delete lease page %s: {{err}}", key), err)
+		}
+	}
+
+	// Only drop the format once every page is gone
+	if err := m.bucketView.Delete(ctx, compactLeaseFormatKey); err != nil {
+		return errwrap.Wrapf("failed to delete lease bucket format: {{err}}", err)
+	}
+	m.logger.Info("lease storage expanded")
+	return nil
+}
+
+// compactLeases moves the given per-lease entries into the compacted view
+func (m *ExpirationManager) compactLeases(ctx context.Context, leaseIDs []string) error {
+	batch := make([]*logical.StorageEntry, 0, len(leaseIDs))
+	for _, leaseID := range leaseIDs {
+		entry, err := m.idView.Get(ctx, leaseID)
+		if err != nil {
+			return errwrap.Wrapf(fmt.Sprintf("failed to read lease entry %s: {{err}}", leaseID), err)
+		}
+		if entry != nil {
+			batch = append(batch, entry)
+		}
+	}
+	if err := m.compactView.putBatch(ctx, batch); err != nil {
+		return errwrap.Wrapf("failed to write compacted leases: {{err}}", err)
+	}
+	for _, entry := range batch {
+		if err := m.idView.Delete(ctx, entry.Key); err != nil {
+			return errwrap.Wrapf(fmt.Sprintf("failed to delete lease entry %s: {{err}}", entry.Key), err)
+		}
+	}
+	return nil
+}
@@ -13,8 +13,10 @@ import (
 	"testing"
 	"time"
 
+	metrics "github.com/armon/go-metrics"
 	log "github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/helper/jsonutil"
 	"github.com/hashicorp/vault/helper/logging"
 	"github.com/hashicorp/vault/helper/namespace"
 	"github.com/hashicorp/vault/logical"
@@ -447,6 +449,327 @@ func TestExpiration_Restore(t *testing.T) {
 	}
 }
 
+// slowLeaseBackend delays reads of lease entries once enabled, so that lease
+// restoration takes a while
+type slowLeaseBackend struct {
+	physical.Backend
+	delay *int64
+}
+
+func (b *slowLeaseBackend) Get(ctx context.Context, key string) (*physical.Entry, error) {
+	if strings.HasPrefix(key, "sys/"+expirationSubPath+leaseViewPrefix) {
+		time.Sleep(time.Duration(atomic.LoadInt64(b.delay)))
+	}
+	return b.Backend.Get(ctx, key)
+}
+
+func TestExpiration_RestoreInBackground(t *testing.T) {
+	inm, err := inmem.NewInmem(nil, logging.NewVaultLogger(log.Trace))
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := &slowLeaseBackend{
+		Backend: inm,
+		delay:   new(int64),
+	}
+	core, keys, root := TestCoreUnsealedBackend(t, backend)
+
+	noop := &NoopBackend{
+		Response: &logical.Response{
+			Secret: &logical.Secret{
+				LeaseOptions: logical.LeaseOptions{
+					TTL: time.Hour,
+				},
+			},
+		},
+	}
+	core.logicalBackends["noop"] = func(context.Context, *logical.BackendConfig) (logical.Backend, error) {
+		return noop, nil
+	}
+	me := &MountEntry{
+		Table:    mountTableType,
+		Path:     "prod/",
+		Type:     "noop",
+		Accessor: "noop-accessor",
+	}
+	if err := core.mount(namespace.RootContext(nil), me); err != nil {
+		t.Fatal(err)
+	}
+
+	const numLeases = 256
+	var leaseIDs []string
+	for i := 0; i < numLeases; i++ {
+		leaseIDs = append(leaseIDs, testLeaseRevocationRead(t, core, root, fmt.Sprintf("prod/%d", i)))
+	}
+
+	if err := core.Seal(root); err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt64(backend.delay, int64(50*time.Millisecond))
+	for _, key := range keys {
+		if _, err := TestCoreUnseal(core, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The node is active and serving while the leases are still being
+	// restored
+	if core.Sealed() {
+		t.Fatal("should be unsealed")
+	}
+	if standby, err := core.Standby(); err != nil || standby {
+		t.Fatalf("should be active, standby: %v, err: %v", standby, err)
+	}
+	if !core.expiration.inRestoreMode() {
+		t.Fatal("expected restore to still be in progress")
+	}
+
+	// New leases can be created alongside the restore, and existing ones are
+	// loaded on demand
+	newLeaseID := testLeaseRevocationRead(t, core, root, "prod/new")
+	le, err := core.expiration.FetchLeaseTimes(namespace.RootContext(nil), leaseIDs[numLeases-1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if le == nil {
+		t.Fatal("expected lease to be loaded")
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for core.expiration.inRestoreMode() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for restore to complete")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Every lease is tracked exactly once and none was revoked
+	core.expiration.pendingLock.RLock()
+	numPending := len(core.expiration.pending)
+	_, newPending := core.expiration.pending[newLeaseID]
+	core.expiration.pendingLock.RUnlock()
+	if numPending != numLeases+1 || !newPending {
+		t.Fatalf("bad pending leases: %d, new lease pending: %v", numPending, newPending)
+	}
+	noop.Lock()
+	defer noop.Unlock()
+	for _, req := range noop.Requests {
+		if req.Operation == logical.RevokeOperation {
+			t.Fatalf("unexpected revocation: %#v", req)
+		}
+	}
+}
+
+func TestExpiration_RestoreCompacted(t *testing.T) {
+	core, keys, root := TestCoreUnsealedWithConfig(t, &CoreConfig{
+		CompactLeaseStorage: true,
+	})
+
+	noop := &NoopBackend{
+		Response: &logical.Response{
+			Secret: &logical.Secret{
+				LeaseOptions: logical.LeaseOptions{
+					TTL: time.Hour,
+				},
+			},
+		},
+	}
+	core.logicalBackends["noop"] = func(context.Context, *logical.BackendConfig) (logical.Backend, error) {
+		return noop, nil
+	}
+	me := &MountEntry{
+		Table:    mountTableType,
+		Path:     "prod/",
+		Type:     "noop",
+		Accessor: "noop-accessor",
+	}
+	if err := core.mount(namespace.RootContext(nil), me); err != nil {
+		t.Fatal(err)
+	}
+
+	const numLeases = 2048
+	leaseIDs := make(map[string]struct{}, numLeases)
+	for i := 0; i < numLeases; i++ {
+		leaseIDs[testLeaseRevocationRead(t, core, root, fmt.Sprintf("prod/%d", i))] = struct{}{}
+	}
+
+	countEntries := func(prefix string) int {
+		keys, err := logical.CollectKeys(namespace.RootContext(nil), core.systemBarrierView.SubView(expirationSubPath+prefix))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(keys)
+	}
+
+	resealAndCheck := func() {
+		t.Helper()
+		if err := core.Seal(root); err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range keys {
+			if _, err := TestCoreUnseal(core, TestKeyCopy(key)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		deadline := time.Now().Add(10 * time.Second)
+		for core.expiration.inRestoreMode() {
+			if time.Now().After(deadline) {
+				t.Fatal("timed out waiting for restore to complete")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		core.expiration.pendingLock.RLock()
+		defer core.expiration.pendingLock.RUnlock()
+		if len(core.expiration.pending) != numLeases {
+			t.Fatalf("bad pending leases: %d", len(core.expiration.pending))
+		}
+		for leaseID := range leaseIDs {
+			if _, ok := core.expiration.pending[leaseID]; !ok {
+				t.Fatalf("lease %q was not restored", leaseID)
+			}
+		}
+	}
+
+	// Leases are held in buckets rather than per-lease entries
+	if n := countEntries(leaseViewPrefix); n != 0 {
+		t.Fatalf("expected no per-lease entries, got %d", n)
+	}
+	if n := countEntries(compactLeaseViewPrefix); n <= 1 || n > compactLeaseBucketCount+1 {
+		t.Fatalf("bad number of lease pages: %d", n)
+	}
+	format, err := core.systemBarrierView.Get(namespace.RootContext(nil), expirationSubPath+compactLeaseViewPrefix+compactLeaseFormatKey)
+	if err != nil || format == nil {
+		t.Fatalf("missing lease bucket format: %v", err)
+	}
+	resealAndCheck()
+
+	// Switching the format off moves the leases back to per-lease entries
+	core.compactLeaseStorage = false
+	resealAndCheck()
+	if n := countEntries(leaseViewPrefix); n != numLeases {
+		t.Fatalf("expected %d per-lease entries, got %d", numLeases, n)
+	}
+	if n := countEntries(compactLeaseViewPrefix); n != 0 {
+		t.Fatalf("expected no lease pages, got %d", n)
+	}
+
+	// And switching it back on compacts them again
+	core.compactLeaseStorage = true
+	resealAndCheck()
+	if n := countEntries(leaseViewPrefix); n != 0 {
+		t.Fatalf("expected no per-lease entries, got %d", n)
+	}
+
+	noop.Lock()
+	defer noop.Unlock()
+	for _, req := range noop.Requests {
+		if req.Operation == logical.RevokeOperation {
+			t.Fatalf("unexpected revocation: %#v", req)
+		}
+	}
+}
+
+func TestExpiration_CompactLeaseStoragePages(t *testing.T) {
+	c, _, _ := TestCoreUnsealed(t)
+	ctx := namespace.RootContext(nil)
+	view := c.systemBarrierView.SubView("compact-test/")
+
+	s := newCompactLeaseStorage(view)
+	s.pageMaxSize = 256
+
+	const numLeases = 4096
+	value := make([]byte, 100)
+	for i := 0; i < numLeases; i++ {
+		if err := s.Put(ctx, &logical.StorageEntry{
+			Key:   fmt.Sprintf("prod/%d", i),
+			Value: value,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Buckets are split into pages that stay within the size limit
+	pages, err := logical.CollectKeys(ctx, view)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) <= compactLeaseBucketCount {
+		t.Fatalf("expected buckets to be split into pages, got %d entries", len(pages))
+	}
+	for _, key := range pages {
+		if key == compactLeaseFormatKey {
+			continue
+		}
+		entry, err := view.Get(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var page compactLeasePage
+		if err := jsonutil.DecodeJSON(entry.Value, &page); err != nil {
+			t.Fatal(err)
+		}
+		if size := compactLeasePageSize(page.Entries); size > s.pageMaxSize {
+			t.Fatalf("page %q is %d bytes", key, size)
+		}
+	}
+
+	// A lease that outgrows its page moves to another one
+	large := make([]byte, 200)
+	if err := s.Put(ctx, &logical.StorageEntry{
+		Key:   "prod/0",
+		Value: large,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Delete every other lease through a fresh view so pages are read back
+	// from storage
+	s = newCompactLeaseStorage(view)
+	for i := 0; i < numLeases; i += 2 {
+		if err := s.Delete(ctx, fmt.Sprintf("prod/%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keys, err := s.keys(ctx, "prod/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != numLeases/2 {
+		t.Fatalf("expected %d leases, got %d", numLeases/2, len(keys))
+	}
+	for i := 0; i < numLeases; i++ {
+		entry, err := s.Get(ctx, fmt.Sprintf("prod/%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (entry != nil) != (i%2 == 1) {
+			t.Fatalf("bad entry for lease %d: %#v", i, entry)
+		}
+	}
+
+	// A different bucket count recorded in storage is kept
+	s = newCompactLeaseStorage(c.systemBarrierView.SubView("compact-test-format/"))
+	buf, err := jsonutil.EncodeJSON(&compactLeaseFormat{
+		Version:     compactLeaseFormatVersion,
+		BucketCount: 16,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.view.Put(ctx, &logical.StorageEntry{Key: compactLeaseFormatKey, Value: buf}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(ctx, &logical.StorageEntry{Key: "prod/foo", Value: value}); err != nil {
+		t.Fatal(err)
+	}
+	if s.bucketCount != 16 {
+		t.Fatalf("bad bucket count: %d", s.bucketCount)
+	}
+}
+
 func TestExpiration_Register(t *testing.T) {
 	exp := mockExpiration(t)
 	req := &logical.Request{
@@ -736,6 +1059,88 @@ func TestExpiration_RevokePrefix(t *testing.T) {
 	}
 }
 
+func TestExpiration_ExpireBefore(t *testing.T) {
+	exp := mockExpiration(t)
+	if err := exp.Restore(nil); err != nil {
+		t.Fatal(err)
+	}
+	noop := &NoopBackend{}
+	_, barrier, _ := mockBarrier(t)
+	view := NewBarrierView(barrier, "logical/")
+	meUUID, err := uuid.GenerateUUID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = exp.router.Mount(noop, "prod/aws/", &MountEntry{Path: "prod/aws/", Type: "noop", UUID: meUUID, Accessor: "noop-accessor", namespace: namespace.RootNamespace}, view)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Register leases expiring 10, 20, 30 and 40 minutes from now
+	var leaseIDs []string
+	for i, path := range []string{"prod/aws/a", "prod/aws/b", "prod/aws/c", "prod/aws/d"} {
+		req := &logical.Request{
+			Operation:   logical.ReadOperation,
+			Path:        path,
+			ClientToken: "foobar",
+		}
+		req.SetTokenEntry(&logical.TokenEntry{ID: "foobar", NamespaceID: "root"})
+		resp := &logical.Response{
+			Secret: &logical.Secret{
+				LeaseOptions: logical.LeaseOptions{
+					TTL: time.Duration(i+1) * 10 * time.Minute,
+				},
+			},
+			Data: map[string]interface{}{
+				"access_key": "xyz",
+				"secret_key": "abcd",
+			},
+		}
+		id, err := exp.Register(namespace.RootContext(nil), req, resp)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		leaseIDs = append(leaseIDs, id)
+	}
+
+	revoked, err := exp.ExpireBefore(time.Now().Add(25 * time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{leaseIDs[0], leaseIDs[1]}
+	sort.Strings(expected)
+	if !reflect.DeepEqual(revoked, expected) {
+		t.Fatalf("bad revoked leases: expected %v, got %v", expected, revoked)
+	}
+
+	sort.Strings(noop.Paths)
+	if !reflect.DeepEqual(noop.Paths, []string{"a", "b"}) {
+		t.Fatalf("bad revoked paths: %v", noop.Paths)
+	}
+
+	for i, leaseID := range leaseIDs {
+		le, err := exp.loadEntry(namespace.RootContext(nil), leaseID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i < 2 && le != nil {
+			t.Fatalf("lease %q should have been revoked", leaseID)
+		}
+		if i >= 2 && le == nil {
+			t.Fatalf("lease %q should not have been revoked", leaseID)
+		}
+	}
+
+	// Nothing else expires before the cutoff
+	revoked, err = exp.ExpireBefore(time.Now().Add(25 * time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revoked) != 0 {
+		t.Fatalf("expected no more revoked leases, got %v", revoked)
+	}
+}
+
 func TestExpiration_RevokeByToken(t *testing.T) {
 	exp := mockExpiration(t)
 	noop := &NoopBackend{}
@@ -1205,6 +1610,166 @@ func TestExpiration_Renew(t *testing.T) {
 	}
 }
 
+func TestExpiration_RenewalCallback(t *testing.T) {
+	exp := mockExpiration(t)
+	noop := &NoopBackend{}
+	_, barrier, _ := mockBarrier(t)
+	view := NewBarrierView(barrier, "logical/")
+	meUUID, err := uuid.GenerateUUID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = exp.router.Mount(noop, "prod/aws/", &MountEntry{Path: "prod/aws/", Type: "noop", UUID: meUUID, Accessor: "noop-accessor", namespace: namespace.RootNamespace}, view)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type renewal struct {
+		leaseID                      string
+		oldExpireTime, newExpireTime time.Time
+	}
+	var renewals []renewal
+	exp.RegisterRenewalCallback(func(leaseID string, oldExpireTime, newExpireTime time.Time) {
+		renewals = append(renewals, renewal{leaseID, oldExpireTime, newExpireTime})
+	})
+
+	// Renew a secret lease
+	req := &logical.Request{
+		Operation:   logical.ReadOperation,
+		Path:        "prod/aws/foo",
+		ClientToken: "foobar",
+	}
+	req.SetTokenEntry(&logical.TokenEntry{ID: "foobar", NamespaceID: "root"})
+	resp := &logical.Response{
+		Secret: &logical.Secret{
+			LeaseOptions: logical.LeaseOptions{
+				TTL:       time.Minute,
+				Renewable: true,
+			},
+		},
+	}
+	id, err := exp.Register(namespace.RootContext(nil), req, resp)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	before, err := exp.FetchLeaseTimes(namespace.RootContext(nil), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	noop.Response = &logical.Response{
+		Secret: &logical.Secret{
+			LeaseOptions: logical.LeaseOptions{
+				TTL: time.Hour,
+			},
+		},
+	}
+	if _, err := exp.Renew(namespace.RootContext(nil), id, time.Hour); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	after, err := exp.FetchLeaseTimes(namespace.RootContext(nil), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(renewals) != 1 {
+		t.Fatalf("expected one renewal, got %#v", renewals)
+	}
+	if renewals[0].leaseID != id {
+		t.Fatalf("bad lease ID: %q", renewals[0].leaseID)
+	}
+	if !renewals[0].oldExpireTime.Equal(before.ExpireTime) || !renewals[0].newExpireTime.Equal(after.ExpireTime) {
+		t.Fatalf("bad expiration times: got %v -> %v, expected %v -> %v",
+			renewals[0].oldExpireTime, renewals[0].newExpireTime, before.ExpireTime, after.ExpireTime)
+	}
+	if !renewals[0].newExpireTime.After(renewals[0].oldExpireTime) {
+		t.Fatalf("renewal did not extend the lease: %v -> %v", renewals[0].oldExpireTime, renewals[0].newExpireTime)
+	}
+
+	// Renew a token lease
+	root, err := exp.tokenStore.rootToken(context.Background())
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	te := &logical.TokenEntry{
+		ID:          root.ID,
+		Path:        "auth/token/login",
+		NamespaceID: namespace.RootNamespaceID,
+	}
+	auth := &logical.Auth{
+		ClientToken: root.ID,
+		LeaseOptions: logical.LeaseOptions{
+			TTL:       time.Minute,
+			Renewable: true,
+		},
+	}
+	if err := exp.RegisterAuth(namespace.RootContext(nil), te, auth); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	before, err = exp.FetchLeaseTimesByToken(namespace.RootContext(nil), te)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := exp.RenewToken(namespace.RootContext(nil), &logical.Request{}, te, time.Hour); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	after, err = exp.FetchLeaseTimesByToken(namespace.RootContext(nil), te)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(renewals) != 2 {
+		t.Fatalf("expected two renewals, got %#v", renewals)
+	}
+	if !strings.HasPrefix(renewals[1].leaseID, te.Path+"/") {
+		t.Fatalf("bad lease ID: %q", renewals[1].leaseID)
+	}
+	if !renewals[1].oldExpireTime.Equal(before.ExpireTime) || !renewals[1].newExpireTime.Equal(after.ExpireTime) {
+		t.Fatalf("bad expiration times: got %v -> %v, expected %v -> %v",
+			renewals[1].oldExpireTime, renewals[1].newExpireTime, before.ExpireTime, after.ExpireTime)
+	}
+}
+
+func TestCore_RegisterRenewalCallback(t *testing.T) {
+	c, keys, root := TestCoreUnsealed(t)
+
+	var renewed []string
+	c.RegisterRenewalCallback(func(leaseID string, oldExpireTime, newExpireTime time.Time) {
+		renewed = append(renewed, leaseID)
+	})
+
+	// Sealing and unsealing replaces the expiration manager, but the
+	// callback stays registered
+	if err := c.Seal(root); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range keys {
+		if _, err := TestCoreUnseal(c, TestKeyCopy(key)); err != nil {
+			t.Fatalf("unseal err: %s", err)
+		}
+	}
+
+	req := logical.TestRequest(t, logical.UpdateOperation, "auth/token/create")
+	req.ClientToken = root
+	req.Data["ttl"] = "1h"
+	resp, err := c.HandleRequest(namespace.RootContext(nil), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err: %v, resp: %#v", err, resp)
+	}
+	token := resp.Auth.ClientToken
+
+	req = logical.TestRequest(t, logical.UpdateOperation, "auth/token/renew-self")
+	req.ClientToken = token
+	resp, err = c.HandleRequest(namespace.RootContext(nil), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err: %v, resp: %#v", err, resp)
+	}
+
+	if len(renewed) != 1 || !strings.HasPrefix(renewed[0], "auth/token/create/") {
+		t.Fatalf("expected one token renewal, got %#v", renewed)
+	}
+}
+
 func TestExpiration_Renew_NotRenewable(t *testing.T) {
 	exp := mockExpiration(t)
 	noop := &NoopBackend{}
@@ -1256,6 +1821,103 @@ func TestExpiration_Renew_NotRenewable(t *testing.T) {
 	}
 }
 
+func TestExpiration_RevocationMetrics(t *testing.T) {
+	inm := metrics.NewInmemSink(time.Minute, time.Minute)
+	conf := metrics.DefaultConfig("vault")
+	conf.EnableHostname = false
+	conf.EnableRuntimeMetrics = false
+	metrics.NewGlobal(conf, inm)
+	defer metrics.NewGlobal(metrics.DefaultConfig(""), &metrics.BlackholeSink{})
+
+	// Revocations block until released, so expired leases pile up
+	release := make(chan struct{})
+	noop := &NoopBackend{
+		RequestHandler: func(ctx context.Context, req *logical.Request) (*logical.Response, error) {
+			if req.Operation != logical.RevokeOperation {
+				return &logical.Response{
+					Secret: &logical.Secret{
+						LeaseOptions: logical.LeaseOptions{
+							TTL: 100 * time.Millisecond,
+						},
+					},
+				}, nil
+			}
+			select {
+			case <-release:
+			case <-ctx.Done():
+			}
+			return nil, nil
+		},
+	}
+	if err := AddTestLogicalBackend("slowrevoke", func(context.Context, *logical.BackendConfig) (logical.Backend, error) {
+		return noop, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer delete(testLogicalBackends, "slowrevoke")
+
+	core, _, root := TestCoreUnsealed(t)
+	me := &MountEntry{
+		Table:    mountTableType,
+		Path:     "slow/",
+		Type:     "slowrevoke",
+		Accessor: "slowrevoke-accessor",
+	}
+	if err := core.mount(namespace.RootContext(nil), me); err != nil {
+		t.Fatal(err)
+	}
+
+	// Other cores may set the same gauges at any time, so always emit ours
+	// right before reading them
+	gauge := func(name string) float32 {
+		core.expiration.emitMetrics()
+		data := inm.Data()
+		intv := data[len(data)-1]
+		intv.RLock()
+		defer intv.RUnlock()
+		return intv.Gauges["vault.expire."+name].Value
+	}
+	waitFor := func(name string, val float32) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for gauge(name) != val {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s to be %v, got %v", name, val, gauge(name))
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	for _, path := range []string{"slow/foo", "slow/bar"} {
+		testLeaseRevocationRead(t, core, root, path)
+	}
+
+	waitFor("num_pending_revocations", 2)
+	if num := gauge("num_leases"); num < 2 {
+		t.Fatalf("expected the expired leases to still be counted, got %v", num)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if age := gauge("oldest_pending_revocation"); age < 50 {
+		t.Fatalf("bad oldest pending revocation age: %v", age)
+	}
+
+	close(release)
+	waitFor("num_pending_revocations", 0)
+	waitFor("oldest_pending_revocation", 0)
+
+	data := inm.Data()
+	intv := data[len(data)-1]
+	intv.RLock()
+	latency, ok := intv.Samples["vault.expire.revoke-latency"]
+	intv.RUnlock()
+
+	// Cores left running by other tests report to the same sink, so there may
+	// be more samples than ours
+	if !ok || latency.Count < 2 {
+		t.Fatalf("expected two revocation latency samples, got %#v", latency)
+	}
+}
+
 func TestExpiration_Renew_RevokeOnExpire(t *testing.T) {
 	exp := mockExpiration(t)
 	noop := &NoopBackend{}
@@ -1762,19 +2424,19 @@ func TestLeaseEntry(t *testing.T) {
 
 	// Test renewability
 	le.ExpireTime = time.Time{}
-	if r, _ := le.renewable(); r {
+	if r, _ := le.renewable(time.Now()); r {
 		t.Fatal("lease with zero expire time is not renewable")
 	}
 	le.ExpireTime = time.Now().Add(-1 * time.Hour)
-	if r, _ := le.renewable(); r {
+	if r, _ := le.renewable(time.Now()); r {
 		t.Fatal("lease with expire time in the past is not renewable")
 	}
 	le.ExpireTime = time.Now().Add(1 * time.Hour)
-	if r, err := le.renewable(); !r {
+	if r, err := le.renewable(time.Now()); !r {
 		t.Fatalf("lease with future expire time is renewable, err: %v", err)
 	}
 	le.Secret.LeaseOptions.Renewable = false
-	if r, _ := le.renewable(); r {
+	if r, _ := le.renewable(time.Now()); r {
 		t.Fatal("secret is set to not be renewable but returns as renewable")
 	}
 	le.Secret = nil
@@ -1783,11 +2445,11 @@ func TestLeaseEntry(t *testing.T) {
 			Renewable: true,
 		},
 	}
-	if r, err := le.renewable(); !r {
+	if r, err := le.renewable(time.Now()); !r {
 		t.Fatalf("auth is renewable but is set to not be, err: %v", err)
 	}
 	le.Auth.LeaseOptions.Renewable = false
-	if r, _ := le.renewable(); r {
+	if r, _ := le.renewable(time.Now()); r {
 		t.Fatal("auth is set to not be renewable but returns as renewable")
 	}
 }
@@ -8,10 +8,19 @@ import (
 	"github.com/hashicorp/vault/logical"
 )
 
-func (m *ExpirationManager) leaseView(*namespace.Namespace) *BarrierView {
+// leaseView returns the view holding the lease entries of the given
+// namespace. Lease registration, lookup, listing and prefix revocation all go
+// through it, so it is where per-namespace isolation of leases happens. Only
+// the root namespace exists in this build, so every namespace shares a view.
+func (m *ExpirationManager) leaseView(*namespace.Namespace) logical.Storage {
+	if m.compactView != nil {
+		return m.compactView
+	}
 	return m.idView
 }
 
+// tokenIndexView returns the view holding the token-to-lease index of the
+// given namespace.
 func (m *ExpirationManager) tokenIndexView(*namespace.Namespace) *BarrierView {
 	return m.tokenView
 }
@@ -19,7 +28,7 @@ func (m *ExpirationManager) tokenIndexView(*namespace.Namespace) *BarrierView {
 func (m *ExpirationManager) collectLeases() (map[*namespace.Namespace][]string, int, error) {
 	leaseCount := 0
 	existing := make(map[*namespace.Namespace][]string)
-	keys, err := logical.CollectKeys(m.quitContext, m.leaseView(namespace.RootNamespace))
+	keys, err := collectLeaseIDs(m.quitContext, m.leaseView(namespace.RootNamespace), "")
 	if err != nil {
 		return nil, 0, errwrap.Wrapf("failed to scan for leases: {{err}}", err)
 	}
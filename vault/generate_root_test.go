@@ -8,6 +8,7 @@ import (
 	"github.com/hashicorp/vault/helper/namespace"
 	"github.com/hashicorp/vault/helper/pgpkeys"
 	"github.com/hashicorp/vault/helper/xor"
+	"github.com/hashicorp/vault/logical"
 )
 
 func TestCore_GenerateRoot_Lifecycle(t *testing.T) {
@@ -234,6 +235,73 @@ func testCore_GenerateRoot_Update_OTP_Common(t *testing.T, c *Core, keys [][]byt
 	}
 }
 
+func TestCore_GenerateRoot_SingleShare(t *testing.T) {
+	c := TestCore(t)
+	res, err := c.Initialize(namespace.RootContext(nil), &InitParams{
+		BarrierConfig: &SealConfig{
+			SecretShares:    1,
+			SecretThreshold: 1,
+		},
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	key := res.SecretShares[0]
+
+	// Unseal zeroes the key it's given
+	if unseal, err := TestCoreUnseal(c, append([]byte(nil), key...)); err != nil || !unseal {
+		t.Fatalf("unseal: %v, err: %v", unseal, err)
+	}
+
+	otp, err := base62.Random(26, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.GenerateRootInit(otp, "", GenerateStandardRootTokenStrategy); err != nil {
+		t.Fatal(err)
+	}
+	rkconf, err := c.GenerateRootConfiguration()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// A single share should complete the generation
+	result, err := c.GenerateRootUpdate(namespace.RootContext(nil), key, rkconf.Nonce, GenerateStandardRootTokenStrategy)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if result.EncodedToken == "" || result.Progress != 1 || result.Required != 1 {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	tokenBytes, err := base64.RawStdEncoding.DecodeString(result.EncodedToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenBytes, err = xor.XORBytes(tokenBytes, []byte(otp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := string(tokenBytes)
+
+	// The new token should be usable for a privileged request
+	req := logical.TestRequest(t, logical.UpdateOperation, "secret/foo")
+	req.Data["value"] = "bar"
+	req.ClientToken = token
+	if _, err := c.HandleRequest(namespace.RootContext(nil), req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The original root token must be unaffected
+	te, err := c.tokenStore.Lookup(namespace.RootContext(nil), res.RootToken)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if te == nil {
+		t.Fatalf("original root token was nil")
+	}
+}
+
 func TestCore_GenerateRoot_Update_PGP(t *testing.T) {
 	c, masterKeys, _ := TestCoreUnsealed(t)
 	testCore_GenerateRoot_Update_PGP_Common(t, c, masterKeys)
@@ -3,9 +3,9 @@ package vault
 import (
 	"context"
 	"crypto/ecdsa"
-	"crypto/x509"
 	"errors"
 	"fmt"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -69,7 +69,8 @@ func (c *Core) PerfStandby() bool {
 	return perfStandby
 }
 
-// Leader is used to get the current active leader
+// Leader is used to get the current active leader. It only reads state; use
+// RefreshForwarding to point request forwarding at the node it reports.
 func (c *Core) Leader() (isLeader bool, leaderAddr, clusterAddr string, err error) {
 	// Check if HA enabled. We don't need the lock for this check as it's set
 	// on startup and never modified
@@ -83,28 +84,19 @@ func (c *Core) Leader() (isLeader bool, leaderAddr, clusterAddr string, err erro
 	}
 
 	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
 
 	// Check if we are the leader
 	if !c.standby {
-		c.stateLock.RUnlock()
 		return true, c.redirectAddr, c.clusterAddr, nil
 	}
 
-	// Initialize a lock
-	lock, err := c.ha.LockWith(CoreLockPath, "read")
+	// Read the lock value, possibly from the cache
+	held, leaderUUID, err := c.lookupLeaderLock()
 	if err != nil {
-		c.stateLock.RUnlock()
-		return false, "", "", err
-	}
-
-	// Read the value
-	held, leaderUUID, err := lock.Value()
-	if err != nil {
-		c.stateLock.RUnlock()
 		return false, "", "", err
 	}
 	if !held {
-		c.stateLock.RUnlock()
 		return false, "", "", nil
 	}
 
@@ -117,58 +109,205 @@ func (c *Core) Leader() (isLeader bool, leaderAddr, clusterAddr string, err erro
 	// If the leader hasn't changed, return the cached value; nothing changes
 	// mid-leadership, and the barrier caches anyways
 	if leaderUUID == localLeaderUUID && localRedirAddr != "" {
-		c.stateLock.RUnlock()
 		return false, localRedirAddr, localClusterAddr, nil
 	}
 
-	c.logger.Trace("found new active node information, refreshing")
+	adv, _, err := c.readLeaderAdvertisement(leaderUUID)
+	if err != nil {
+		c.invalidateLeaderLookupCache()
+		return false, "", "", err
+	}
+	if adv == nil {
+		return false, "", "", nil
+	}
 
+	return false, adv.RedirectAddr, adv.ClusterAddr, nil
+}
+
+// ActiveAdvertiseAddr returns the API address advertised by the current active
+// node, read from the HA backend. Unlike RefreshForwarding it never loads
+// cluster TLS information or touches the request forwarding connection, so it
+// is safe to call from a standby at any time. It returns an empty address if
+// there is currently no active node.
+func (c *Core) ActiveAdvertiseAddr() (string, error) {
+	if c.ha == nil {
+		return "", ErrHANotEnabled
+	}
+
+	if c.Sealed() {
+		return "", consts.ErrSealed
+	}
+
+	c.stateLock.RLock()
 	defer c.stateLock.RUnlock()
-	c.clusterLeaderParamsLock.Lock()
-	defer c.clusterLeaderParamsLock.Unlock()
 
-	// Validate base conditions again
-	if leaderUUID == c.clusterLeaderUUID && c.clusterLeaderRedirectAddr != "" {
-		return false, localRedirAddr, localClusterAddr, nil
+	if !c.standby {
+		return c.redirectAddr, nil
 	}
 
-	key := coreLeaderPrefix + leaderUUID
-	// Use background because postUnseal isn't run on standby
-	entry, err := c.barrier.Get(context.Background(), key)
+	held, leaderUUID, err := c.lookupLeaderLock()
 	if err != nil {
-		return false, "", "", err
+		return "", err
 	}
-	if entry == nil {
-		return false, "", "", nil
+	if !held {
+		return "", nil
 	}
 
-	var oldAdv bool
+	adv, _, err := c.readLeaderAdvertisement(leaderUUID)
+	if err != nil {
+		c.invalidateLeaderLookupCache()
+		return "", err
+	}
+	if adv == nil {
+		return "", nil
+	}
 
-	var adv activeAdvertisement
-	err = jsonutil.DecodeJSON(entry.Value, &adv)
+	return adv.RedirectAddr, nil
+}
+
+// ReadOnlyBarrierView returns a view of the barrier under the given prefix
+// that can be read from but rejects writes and deletes with
+// logical.ErrReadOnly. Unlike the views set up for mounts it doesn't require
+// the node to be active: a standby reads what the active node has written to
+// the shared storage, so it can serve reads locally instead of forwarding
+// them. It errors out when Vault is sealed.
+func (c *Core) ReadOnlyBarrierView(prefix string) (*BarrierView, error) {
+	if c.Sealed() {
+		return nil, consts.ErrSealed
+	}
+
+	view := NewBarrierView(c.barrier, prefix)
+	view.setReadOnlyErr(logical.ErrReadOnly)
+	return view, nil
+}
+
+// RefreshForwarding looks up the active node and, if it has changed since the
+// last refresh, loads its cluster TLS information and points the request
+// forwarding connection at it. It does nothing on the active node.
+func (c *Core) RefreshForwarding() error {
+	return c.refreshForwarding(false)
+}
+
+// refreshForwarding does the work of RefreshForwarding. With force set, the
+// active node's cluster TLS information is reloaded from storage and the
+// forwarding connection is set up again even if the active node is unchanged.
+func (c *Core) refreshForwarding(force bool) error {
+	if c.ha == nil {
+		return ErrHANotEnabled
+	}
+
+	if c.Sealed() {
+		return consts.ErrSealed
+	}
+
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+
+	if !c.standby {
+		return nil
+	}
+
+	held, leaderUUID, err := c.lookupLeaderLock()
 	if err != nil {
-		// Fall back to pre-struct handling
-		adv.RedirectAddr = string(entry.Value)
-		c.logger.Debug("parsed redirect addr for new active node", "redirect_addr", adv.RedirectAddr)
-		oldAdv = true
+		return err
+	}
+	if !held {
+		return nil
+	}
+
+	_, err = c.refreshForwardingForLeader(leaderUUID, force)
+	return err
+}
+
+// LeaderForForwarding is like Leader, but on a standby it also points request
+// forwarding at the active node it finds, as RefreshForwarding does. The HA
+// lock is read only once for both, so this is what should be used to decide
+// whether and where to forward a request.
+func (c *Core) LeaderForForwarding() (isLeader bool, leaderAddr string, err error) {
+	if c.ha == nil {
+		return false, "", ErrHANotEnabled
+	}
+
+	if c.Sealed() {
+		return false, "", consts.ErrSealed
+	}
+
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+
+	if !c.standby {
+		return true, c.redirectAddr, nil
+	}
+
+	held, leaderUUID, err := c.lookupLeaderLock()
+	if err != nil {
+		return false, "", err
+	}
+	if !held {
+		return false, "", nil
+	}
+
+	leaderAddr, err = c.refreshForwardingForLeader(leaderUUID, false)
+	if err != nil {
+		return false, "", err
+	}
+	return false, leaderAddr, nil
+}
+
+// refreshForwardingForLeader sets up request forwarding for the active node
+// with the given UUID and returns its redirect address, which is empty if it
+// hasn't advertised itself. The caller must hold the state lock.
+func (c *Core) refreshForwardingForLeader(leaderUUID string, force bool) (leaderAddr string, retErr error) {
+	c.clusterLeaderParamsLock.Lock()
+	defer c.clusterLeaderParamsLock.Unlock()
+
+	// Nothing to do if we are already set up for this leader
+	if !force && leaderUUID == c.clusterLeaderUUID && c.clusterLeaderRedirectAddr != "" {
+		return c.clusterLeaderRedirectAddr, nil
+	}
+
+	c.logger.Trace("found new active node information, refreshing")
+
+	// Anything that goes wrong from here on should send the next caller back
+	// to storage rather than to a cached value that led us astray
+	defer func() {
+		if retErr != nil {
+			c.invalidateLeaderLookupCache()
+		}
+	}()
+
+	adv, oldAdv, err := c.readLeaderAdvertisement(leaderUUID)
+	if err != nil {
+		return "", err
+	}
+	if adv == nil {
+		return "", nil
 	}
 
 	if !oldAdv {
 		c.logger.Debug("parsing information for new active node", "active_cluster_addr", adv.ClusterAddr, "active_redirect_addr", adv.RedirectAddr)
 
 		// Ensure we are using current values
-		err = c.loadLocalClusterTLS(adv)
+		err = c.loadLocalClusterTLS(*adv)
 		if err != nil {
-			return false, "", "", err
+			return "", err
 		}
 
+		// Make sure we know which cluster we belong to so that forwarding can
+		// be refused if the active node turns out to be in another one
+		cluster, err := c.Cluster(context.Background())
+		if err != nil {
+			return "", err
+		}
+		c.localClusterID.Store(cluster.ID)
+
 		// This will ensure that we both have a connection at the ready and that
 		// the address is the current known value
 		// Since this is standby, we don't use the active context. Later we may
 		// use a process-scoped context
 		err = c.refreshRequestForwardingConnection(context.Background(), adv.ClusterAddr)
 		if err != nil {
-			return false, "", "", err
+			return "", err
 		}
 	}
 
@@ -178,7 +317,82 @@ func (c *Core) Leader() (isLeader bool, leaderAddr, clusterAddr string, err erro
 	c.clusterLeaderClusterAddr = adv.ClusterAddr
 	c.clusterLeaderUUID = leaderUUID
 
-	return false, adv.RedirectAddr, adv.ClusterAddr, nil
+	return adv.RedirectAddr, nil
+}
+
+// readLeaderAdvertisement reads the advertisement written by the active node
+// with the given UUID. It returns nil if there is none, and reports whether
+// the entry predates the structured advertisement format.
+func (c *Core) readLeaderAdvertisement(leaderUUID string) (*activeAdvertisement, bool, error) {
+	key := coreLeaderPrefix + leaderUUID
+	// Use background because postUnseal isn't run on standby
+	entry, err := c.barrier.Get(context.Background(), key)
+	if err != nil {
+		return nil, false, err
+	}
+	if entry == nil {
+		return nil, false, nil
+	}
+
+	var adv activeAdvertisement
+	err = jsonutil.DecodeJSON(entry.Value, &adv)
+	if err != nil {
+		// Fall back to pre-struct handling
+		adv.RedirectAddr = string(entry.Value)
+		c.logger.Debug("parsed redirect addr for new active node", "redirect_addr", adv.RedirectAddr)
+		return &adv, true, nil
+	}
+
+	return &adv, false, nil
+}
+
+// leaderLookupCache holds the most recent read of the HA lock so that a
+// standby calling Leader in quick succession doesn't hit storage every time
+type leaderLookupCache struct {
+	l          sync.Mutex
+	held       bool
+	leaderUUID string
+	expires    time.Time
+}
+
+// lookupLeaderLock returns whether the HA lock is held and by whom, using the
+// cached value if it hasn't yet expired
+func (c *Core) lookupLeaderLock() (bool, string, error) {
+	if c.leaderLookupCacheTTL > 0 {
+		c.leaderLookupCache.l.Lock()
+		defer c.leaderLookupCache.l.Unlock()
+		if time.Now().Before(c.leaderLookupCache.expires) {
+			return c.leaderLookupCache.held, c.leaderLookupCache.leaderUUID, nil
+		}
+	}
+
+	// Initialize a lock
+	lock, err := c.ha.LockWith(c.coreLockPath, "read")
+	if err != nil {
+		return false, "", err
+	}
+
+	// Read the value
+	held, leaderUUID, err := lock.Value()
+	if err != nil {
+		return false, "", err
+	}
+
+	if c.leaderLookupCacheTTL > 0 {
+		c.leaderLookupCache.held = held
+		c.leaderLookupCache.leaderUUID = leaderUUID
+		c.leaderLookupCache.expires = time.Now().Add(c.leaderLookupCacheTTL)
+	}
+
+	return held, leaderUUID, nil
+}
+
+// invalidateLeaderLookupCache forces the next call to Leader to read the HA
+// lock from storage
+func (c *Core) invalidateLeaderLookupCache() {
+	c.leaderLookupCache.l.Lock()
+	c.leaderLookupCache.expires = time.Time{}
+	c.leaderLookupCache.l.Unlock()
 }
 
 // StepDown is used to step down from leadership
@@ -310,6 +524,129 @@ func (c *Core) StepDown(httpCtx context.Context, req *logical.Request) (retErr e
 	return retErr
 }
 
+// StepDownContext is like StepDown, but only returns once this node has
+// actually relinquished active duty and entered standby, or the context is
+// done. It returns immediately if the node isn't active.
+func (c *Core) StepDownContext(ctx context.Context, req *logical.Request) error {
+	doneCh := c.StepDownDoneCh()
+
+	c.stateLock.RLock()
+	active := !c.Sealed() && c.ha != nil && !c.standby
+	c.stateLock.RUnlock()
+	if !active {
+		return nil
+	}
+
+	if err := c.StepDown(ctx, req); err != nil {
+		return err
+	}
+
+	select {
+	case <-doneCh:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// StepDownDoneCh returns a channel that is closed the next time this node
+// finishes relinquishing active duty, that is once pre-seal teardown has run,
+// the cluster listeners are closed, and the node has entered standby. To avoid
+// missing the notification, obtain the channel before calling StepDown.
+func (c *Core) StepDownDoneCh() <-chan struct{} {
+	c.stepDownDoneLock.Lock()
+	defer c.stepDownDoneLock.Unlock()
+
+	if c.stepDownDoneCh == nil {
+		c.stepDownDoneCh = make(chan struct{})
+	}
+	return c.stepDownDoneCh
+}
+
+// notifyStepDownDone wakes anyone waiting on StepDownDoneCh
+func (c *Core) notifyStepDownDone() {
+	c.stepDownDoneLock.Lock()
+	defer c.stepDownDoneLock.Unlock()
+
+	if c.stepDownDoneCh != nil {
+		close(c.stepDownDoneCh)
+		c.stepDownDoneCh = nil
+	}
+}
+
+// SuspendLeadership keeps this node from becoming the active node for the
+// given duration. An active node steps down, and a standby stops campaigning
+// for the HA lock. Normal election resumes once the duration has passed.
+// Calling it again replaces any suspension in effect; a zero duration lifts
+// it.
+func (c *Core) SuspendLeadership(d time.Duration) error {
+	if c.ha == nil {
+		return ErrHANotEnabled
+	}
+	if d < 0 {
+		return errors.New("leadership suspension cannot be negative")
+	}
+
+	c.leadershipSuspendLock.Lock()
+	c.leadershipSuspendedUntil = time.Now().Add(d)
+	if c.leadershipSuspendCh != nil {
+		close(c.leadershipSuspendCh)
+		c.leadershipSuspendCh = nil
+	}
+	c.leadershipSuspendLock.Unlock()
+
+	if d == 0 {
+		c.logger.Info("leadership suspension lifted")
+		return nil
+	}
+	c.logger.Info("suspending leadership", "duration", d)
+
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+	if c.Sealed() || c.standby {
+		return nil
+	}
+
+	select {
+	case c.manualStepDownCh <- struct{}{}:
+	default:
+		c.logger.Warn("manual step-down operation already queued")
+	}
+
+	return nil
+}
+
+// leadershipSuspension returns how much longer leadership is suspended for,
+// along with a channel that is closed if the suspension is changed
+func (c *Core) leadershipSuspension() (time.Duration, <-chan struct{}) {
+	c.leadershipSuspendLock.Lock()
+	defer c.leadershipSuspendLock.Unlock()
+
+	if c.leadershipSuspendCh == nil {
+		c.leadershipSuspendCh = make(chan struct{})
+	}
+	return time.Until(c.leadershipSuspendedUntil), c.leadershipSuspendCh
+}
+
+// waitForLeadershipSuspension blocks until any leadership suspension lapses.
+// It returns true if the stop channel was closed first.
+func (c *Core) waitForLeadershipSuspension(stopCh chan struct{}) (stopped bool) {
+	for {
+		remaining, changedCh := c.leadershipSuspension()
+		if remaining <= 0 {
+			return false
+		}
+
+		c.logger.Info("leadership suspended, not campaigning for the HA lock", "remaining", remaining)
+		select {
+		case <-time.After(remaining):
+		case <-changedCh:
+		case <-stopCh:
+			return true
+		}
+	}
+}
+
 // runStandby is a long running process that manages a number of the HA
 // subsystems.
 func (c *Core) runStandby(doneCh, manualStepDownCh, stopCh chan struct{}) {
@@ -339,6 +676,18 @@ func (c *Core) runStandby(doneCh, manualStepDownCh, stopCh chan struct{}) {
 			c.logger.Debug("shutting down periodic key rotation checker")
 		})
 	}
+	{
+		// Announce this node's cluster membership
+		heartbeatStop := make(chan struct{})
+
+		g.Add(func() error {
+			c.periodicMembershipHeartbeat(context.Background(), heartbeatStop)
+			return nil
+		}, func(error) {
+			close(heartbeatStop)
+			c.logger.Debug("shutting down periodic membership heartbeat")
+		})
+	}
 	{
 		// Monitor for new leadership
 		checkLeaderStop := make(chan struct{})
@@ -383,18 +732,23 @@ func (c *Core) waitForLeadership(newLeaderCh chan func(), manualStepDownCh, stop
 			// If we've just down, we could instantly grab the lock again. Give
 			// the other nodes a chance.
 			if manualStepDown {
-				time.Sleep(manualStepDownSleepPeriod)
+				time.Sleep(c.manualStepDownSleepPeriod)
 				manualStepDown = false
 			}
 		}
 
+		// Don't campaign while leadership is suspended
+		if stopped := c.waitForLeadershipSuspension(stopCh); stopped {
+			return
+		}
+
 		// Create a lock
 		uuid, err := uuid.GenerateUUID()
 		if err != nil {
 			c.logger.Error("failed to generate uuid", "error", err)
 			return
 		}
-		lock, err := c.ha.LockWith(CoreLockPath, uuid)
+		lock, err := c.ha.LockWith(c.coreLockPath, uuid)
 		if err != nil {
 			c.logger.Error("failed to create lock", "error", err)
 			return
@@ -407,6 +761,14 @@ func (c *Core) waitForLeadership(newLeaderCh chan func(), manualStepDownCh, stop
 		if leaderLostCh == nil {
 			return
 		}
+
+		// We may have been campaigning when leadership was suspended, in
+		// which case hand the lock straight back
+		if remaining, _ := c.leadershipSuspension(); remaining > 0 {
+			c.logger.Info("acquired lock while leadership is suspended, releasing it")
+			lock.Unlock()
+			continue
+		}
 		c.logger.Info("acquired lock, enabling active operation")
 
 		// This is used later to log a metrics event; this can be helpful to
@@ -438,6 +800,7 @@ func (c *Core) waitForLeadership(newLeaderCh chan func(), manualStepDownCh, stop
 		activeCtx, activeCtxCancel := context.WithCancel(namespace.RootContext(nil))
 		c.activeContext = activeCtx
 		c.activeContextCancelFunc.Store(activeCtxCancel)
+		atomic.StoreUint32(c.leavingActiveDuty, 0)
 
 		// This block is used to wipe barrier/seal state and verify that
 		// everything is sane. If we have no sanity in the barrier, we actually
@@ -466,8 +829,9 @@ func (c *Core) waitForLeadership(newLeaderCh chan func(), manualStepDownCh, stop
 
 		{
 			// Clear previous local cluster cert info so we generate new. Since the
-			// UUID will have changed, standbys will know to look for new info
-			c.localClusterParsedCert.Store((*x509.Certificate)(nil))
+			// UUID will have changed, standbys will know to look for new info.
+			// The outgoing cert stays trusted for the overlap period.
+			c.storeLocalClusterParsedCert(nil)
 			c.localClusterCert.Store(([]byte)(nil))
 			c.localClusterPrivateKey.Store((*ecdsa.PrivateKey)(nil))
 
@@ -510,15 +874,31 @@ func (c *Core) waitForLeadership(newLeaderCh chan func(), manualStepDownCh, stop
 			continue
 		}
 
+		go c.periodicEvictStaleMembers(activeCtx, leaderLostCh)
+
+		// The cluster cert is only generated when a node becomes active, so
+		// step down once it gets too old to have the next active node
+		// generate a new one
+		certExpiredCh, stopCertTimer := c.clusterCertMaxAgeTimer()
+
 		// Monitor a loss of leadership
+		var lostReason error
 		select {
 		case <-leaderLostCh:
 			c.logger.Warn("leadership lost, stopping active operation")
+			lostReason = ErrHALockLost
 		case <-stopCh:
 		case <-manualStepDownCh:
 			manualStepDown = true
 			c.logger.Warn("stepping down from active operation to standby")
+		case <-certExpiredCh:
+			c.logger.Info("local cluster certificate is older than the maximum age, stepping down to rotate it", "max_age", c.clusterCertMaxAge)
 		}
+		stopCertTimer()
+
+		// Turn away forwarded requests from here on, as we may not be able
+		// to serve them
+		atomic.StoreUint32(c.leavingActiveDuty, 1)
 
 		// Stop Active Duty
 		{
@@ -543,6 +923,7 @@ func (c *Core) waitForLeadership(newLeaderCh chan func(), manualStepDownCh, stop
 
 			// Mark as standby
 			c.standby = true
+			c.invalidateLeaderLookupCache()
 
 			// Seal
 			if err := c.preSeal(); err != nil {
@@ -559,15 +940,31 @@ func (c *Core) waitForLeadership(newLeaderCh chan func(), manualStepDownCh, stop
 				c.heldHALock = nil
 			}
 
+			c.notifyStepDownDone()
+
 			// If we are stopped return, otherwise unlock the statelock
 			if stopped {
+				c.notifyLeadershipLost(lostReason)
 				return
 			}
 			c.stateLock.Unlock()
+			c.notifyLeadershipLost(lostReason)
 		}
 	}
 }
 
+// notifyLeadershipLost records an involuntary loss of leadership and calls
+// the OnLeadershipLost callback, if one was configured
+func (c *Core) notifyLeadershipLost(reason error) {
+	if reason == nil {
+		return
+	}
+	metrics.IncrCounter([]string{"core", "leadership_lost_involuntarily"}, 1)
+	if c.onLeadershipLost != nil {
+		go c.onLeadershipLost(reason)
+	}
+}
+
 func grabLockOrStop(lockFunc, unlockFunc func(), stopCh chan struct{}) (stopped bool) {
 	// Grab the lock as we need it for cluster setup, which needs to happen
 	// before advertising;
@@ -598,9 +995,9 @@ func grabLockOrStop(lockFunc, unlockFunc func(), stopCh chan struct{}) (stopped
 }
 
 // This checks the leader periodically to ensure that we switch RPC to a new
-// leader pretty quickly. There is logic in Leader() already to not make this
-// onerous and avoid more traffic than needed, so we just call that and ignore
-// the result.
+// leader pretty quickly. There is logic in RefreshForwarding() already to not
+// make this onerous and avoid more traffic than needed, so we just call that
+// and then Leader(), which will return the refreshed values.
 func (c *Core) periodicLeaderRefresh(newLeaderCh chan func(), stopCh chan struct{}) {
 	opCount := new(int32)
 
@@ -620,6 +1017,9 @@ func (c *Core) periodicLeaderRefresh(newLeaderCh chan func(), stopCh chan struct
 			go func() {
 				// Bind locally, as the race detector is tripping here
 				lopCount := opCount
+				if err := c.RefreshForwarding(); err != nil {
+					c.logger.Debug("failed to refresh request forwarding", "error", err)
+				}
 				isLeader, _, newClusterAddr, _ := c.Leader()
 
 				if !isLeader && newClusterAddr != clusterAddr && newLeaderCh != nil {
@@ -777,7 +1177,7 @@ func (c *Core) acquireLock(lock physical.Lock, stopCh <-chan struct{}) <-chan st
 		// Retry the acquisition
 		c.logger.Error("failed to acquire lock", "error", err)
 		select {
-		case <-time.After(lockRetryInterval):
+		case <-time.After(c.haLockRetryInterval):
 		case <-stopCh:
 			return nil
 		}
@@ -826,6 +1226,12 @@ func (c *Core) advertiseLeader(ctx context.Context, uuid string, leaderLostCh <-
 		return err
 	}
 
+	// Sealed standbys can only forward health checks with what they can
+	// read without the barrier, so failing here isn't fatal
+	if err := c.persistActiveClusterTLS(ctx); err != nil {
+		c.logger.Warn("failed to store cluster information for sealed standbys", "error", err)
+	}
+
 	sd, ok := c.ha.(physical.ServiceDiscovery)
 	if ok {
 		if err := sd.NotifyActiveStateChange(); err != nil {
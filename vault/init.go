@@ -5,6 +5,7 @@ import (
 	"encoding/base64"
 	"encoding/hex"
 	"fmt"
+	"time"
 
 	"github.com/hashicorp/errwrap"
 	"github.com/hashicorp/vault/helper/namespace"
@@ -26,6 +27,13 @@ type InitResult struct {
 	SecretShares   [][]byte
 	RecoveryShares [][]byte
 	RootToken      string
+
+	// PGPFingerprints and RecoveryPGPFingerprints hold the fingerprints of
+	// the keys the corresponding shares were encrypted to, in the same order
+	// as the shares. They are empty if no PGP keys were given in the seal
+	// configurations.
+	PGPFingerprints         []string
+	RecoveryPGPFingerprints []string
 }
 
 var (
@@ -57,11 +65,11 @@ func (c *Core) Initialized(ctx context.Context) (bool, error) {
 	return true, nil
 }
 
-func (c *Core) generateShares(sc *SealConfig) ([]byte, [][]byte, error) {
+func (c *Core) generateShares(sc *SealConfig) ([]byte, [][]byte, []string, error) {
 	// Generate a master key
 	masterKey, err := c.barrier.GenerateKey()
 	if err != nil {
-		return nil, nil, errwrap.Wrapf("key generation failed: {{err}}", err)
+		return nil, nil, nil, errwrap.Wrapf("key generation failed: {{err}}", err)
 	}
 
 	// Return the master key if only a single key part is used
@@ -72,29 +80,35 @@ func (c *Core) generateShares(sc *SealConfig) ([]byte, [][]byte, error) {
 		// Split the master key using the Shamir algorithm
 		shares, err := shamir.Split(masterKey, sc.SecretShares, sc.SecretThreshold)
 		if err != nil {
-			return nil, nil, errwrap.Wrapf("failed to generate barrier shares: {{err}}", err)
+			return nil, nil, nil, errwrap.Wrapf("failed to generate barrier shares: {{err}}", err)
 		}
 		unsealKeys = shares
 	}
 
 	// If we have PGP keys, perform the encryption
+	var fingerprints []string
 	if len(sc.PGPKeys) > 0 {
 		hexEncodedShares := make([][]byte, len(unsealKeys))
 		for i, _ := range unsealKeys {
 			hexEncodedShares[i] = []byte(hex.EncodeToString(unsealKeys[i]))
 		}
-		_, encryptedShares, err := pgpkeys.EncryptShares(hexEncodedShares, sc.PGPKeys)
+		encryptedFingerprints, encryptedShares, err := pgpkeys.EncryptShares(hexEncodedShares, sc.PGPKeys)
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, nil, err
 		}
 		unsealKeys = encryptedShares
+		fingerprints = encryptedFingerprints
 	}
 
-	return masterKey, unsealKeys, nil
+	return masterKey, unsealKeys, fingerprints, nil
 }
 
 // Initialize is used to initialize the Vault with the given
-// configurations.
+// configurations. Cancellation of ctx is honored up until the barrier is
+// initialized; if it fires before then, the context error is returned and
+// nothing has been written. Once the keyring has been stored the rest of the
+// initialization runs to completion regardless, since abandoning it would
+// leave storage holding a keyring for which no one has the keys.
 func (c *Core) Initialize(ctx context.Context, initParams *InitParams) (*InitResult, error) {
 	barrierConfig := initParams.BarrierConfig
 	recoveryConfig := initParams.RecoveryConfig
@@ -125,6 +139,10 @@ func (c *Core) Initialize(ctx context.Context, initParams *InitParams) (*InitRes
 	c.stateLock.Lock()
 	defer c.stateLock.Unlock()
 
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	// Check if we are initialized
 	init, err := c.Initialized(ctx)
 	if err != nil {
@@ -134,13 +152,34 @@ func (c *Core) Initialize(ctx context.Context, initParams *InitParams) (*InitRes
 		return nil, ErrAlreadyInit
 	}
 
+	// A seal config or cluster info without an initialized barrier is left
+	// over from an earlier initialization; carrying on would overwrite it
+	for _, path := range []string{barrierSealConfigPath, coreLocalClusterInfoPath} {
+		entry, err := c.physical.Get(ctx, path)
+		if err != nil {
+			return nil, errwrap.Wrapf("failed to check for existing state: {{err}}", err)
+		}
+		if entry != nil {
+			c.logger.Error("refusing to initialize over existing state", "path", path)
+			return nil, ErrAlreadyInit
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	err = c.seal.Init(ctx)
 	if err != nil {
 		c.logger.Error("failed to initialize seal", "error", err)
 		return nil, errwrap.Wrapf("error initializing seal: {{err}}", err)
 	}
 
-	barrierKey, barrierUnsealKeys, err := c.generateShares(barrierConfig)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	barrierKey, barrierUnsealKeys, barrierFingerprints, err := c.generateShares(barrierConfig)
 	if err != nil {
 		c.logger.Error("error generating shares", "error", err)
 		return nil, err
@@ -151,6 +190,14 @@ func (c *Core) Initialize(ctx context.Context, initParams *InitParams) (*InitRes
 		defer initPTCleanup()
 	}
 
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Past this point the keys are only known to this call, so it must not
+	// be abandoned partway
+	ctx = uncancelledContext{ctx}
+
 	// Initialize the barrier
 	if err := c.barrier.Initialize(ctx, barrierKey); err != nil {
 		c.logger.Error("failed to initialize barrier", "error", err)
@@ -197,7 +244,8 @@ func (c *Core) Initialize(ctx context.Context, initParams *InitParams) (*InitRes
 	}
 
 	results := &InitResult{
-		SecretShares: barrierUnsealKeys,
+		SecretShares:    barrierUnsealKeys,
+		PGPFingerprints: barrierFingerprints,
 	}
 
 	// Perform initial setup
@@ -228,7 +276,7 @@ func (c *Core) Initialize(ctx context.Context, initParams *InitParams) (*InitRes
 		}
 
 		if recoveryConfig.SecretShares > 0 {
-			recoveryKey, recoveryUnsealKeys, err := c.generateShares(recoveryConfig)
+			recoveryKey, recoveryUnsealKeys, recoveryFingerprints, err := c.generateShares(recoveryConfig)
 			if err != nil {
 				c.logger.Error("failed to generate recovery shares", "error", err)
 				return nil, err
@@ -240,6 +288,7 @@ func (c *Core) Initialize(ctx context.Context, initParams *InitParams) (*InitRes
 			}
 
 			results.RecoveryShares = recoveryUnsealKeys
+			results.RecoveryPGPFingerprints = recoveryFingerprints
 		}
 	}
 
@@ -270,6 +319,16 @@ func (c *Core) Initialize(ctx context.Context, initParams *InitParams) (*InitRes
 	return results, nil
 }
 
+// uncancelledContext carries the values of the context it wraps but is never
+// cancelled and has no deadline.
+type uncancelledContext struct {
+	context.Context
+}
+
+func (uncancelledContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (uncancelledContext) Done() <-chan struct{}       { return nil }
+func (uncancelledContext) Err() error                  { return nil }
+
 // UnsealWithStoredKeys performs auto-unseal using stored keys.
 func (c *Core) UnsealWithStoredKeys(ctx context.Context) error {
 	if !c.seal.StoredKeysSupported() {
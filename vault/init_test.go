@@ -2,13 +2,17 @@ package vault
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/hex"
 	"reflect"
 	"testing"
 
 	log "github.com/hashicorp/go-hclog"
 
 	"github.com/hashicorp/vault/helper/logging"
+	"github.com/hashicorp/vault/helper/pgpkeys"
 	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/physical"
 	"github.com/hashicorp/vault/physical/inmem"
 )
 
@@ -17,6 +21,208 @@ func TestCore_Init(t *testing.T) {
 	testCore_Init_Common(t, c, conf, &SealConfig{SecretShares: 5, SecretThreshold: 3}, nil)
 }
 
+func TestCore_Init_Cancelled(t *testing.T) {
+	c, _ := testCore_NewTestCore(t, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := c.Initialize(ctx, &InitParams{
+		BarrierConfig: &SealConfig{SecretShares: 5, SecretThreshold: 3},
+	})
+	if err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got: %v", err)
+	}
+
+	init, err := c.Initialized(context.Background())
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if init {
+		t.Fatalf("should not be init")
+	}
+
+	// A later, uncancelled attempt should still succeed
+	res, err := c.Initialize(context.Background(), &InitParams{
+		BarrierConfig: &SealConfig{SecretShares: 5, SecretThreshold: 3},
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if res.RootToken == "" {
+		t.Fatalf("bad: %#v", res)
+	}
+}
+
+// cancelOnKeyringBackend cancels a context once the barrier keyring has been
+// written through it
+type cancelOnKeyringBackend struct {
+	physical.Backend
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnKeyringBackend) Put(ctx context.Context, entry *physical.Entry) error {
+	if err := b.Backend.Put(ctx, entry); err != nil {
+		return err
+	}
+	if entry.Key == keyringPath {
+		b.cancel()
+	}
+	return nil
+}
+
+func TestCore_Init_CancelledAfterBarrier(t *testing.T) {
+	logger := logging.NewVaultLogger(log.Trace)
+	inm, err := inmem.NewInmem(nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c, err := NewCore(&CoreConfig{
+		Physical:     &cancelOnKeyringBackend{Backend: inm, cancel: cancel},
+		DisableMlock: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Once the keyring is stored the initialization is not abandoned, so the
+	// keys are not lost
+	res, err := c.Initialize(ctx, &InitParams{
+		BarrierConfig: &SealConfig{SecretShares: 5, SecretThreshold: 3},
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if ctx.Err() == nil {
+		t.Fatal("expected the context to have been cancelled")
+	}
+	if len(res.SecretShares) != 5 || res.RootToken == "" {
+		t.Fatalf("bad: %#v", res)
+	}
+
+	for i, key := range res.SecretShares[:3] {
+		unsealed, err := TestCoreUnseal(c, key)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if unsealed != (i == 2) {
+			t.Fatalf("bad unseal state after key %d", i)
+		}
+	}
+}
+
+func TestCore_Init_ExistingState(t *testing.T) {
+	c, _ := testCore_NewTestCore(t, nil)
+	barrierConf := &SealConfig{SecretShares: 5, SecretThreshold: 3}
+	if _, err := c.Initialize(context.Background(), &InitParams{
+		BarrierConfig: barrierConf,
+	}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Simulate a partially clobbered backend where the barrier no longer
+	// reports being initialized but the rest of the state is still there
+	if err := c.physical.Delete(context.Background(), keyringPath); err != nil {
+		t.Fatal(err)
+	}
+	init, err := c.Initialized(context.Background())
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if init {
+		t.Fatalf("should not be init")
+	}
+	before, err := c.physical.Get(context.Background(), barrierSealConfigPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = c.Initialize(context.Background(), &InitParams{
+		BarrierConfig: barrierConf,
+	})
+	if err != ErrAlreadyInit {
+		t.Fatalf("expected ErrAlreadyInit, got: %v", err)
+	}
+
+	after, err := c.physical.Get(context.Background(), barrierSealConfigPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(before, after) {
+		t.Fatalf("seal config was modified: %q vs %q", before.Value, after.Value)
+	}
+
+	// Cluster info on its own is enough to refuse
+	c, _ = testCore_NewTestCore(t, nil)
+	if err := c.physical.Put(context.Background(), &physical.Entry{
+		Key:   coreLocalClusterInfoPath,
+		Value: []byte("existing"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Initialize(context.Background(), &InitParams{
+		BarrierConfig: barrierConf,
+	})
+	if err != ErrAlreadyInit {
+		t.Fatalf("expected ErrAlreadyInit, got: %v", err)
+	}
+}
+
+func TestCore_Init_PGPKeys(t *testing.T) {
+	c, _ := testCore_NewTestCore(t, nil)
+
+	pubKeys := []string{pgpkeys.TestPubKey1, pgpkeys.TestPubKey2, pgpkeys.TestPubKey3}
+	privKeys := []string{pgpkeys.TestPrivKey1, pgpkeys.TestPrivKey2, pgpkeys.TestPrivKey3}
+	res, err := c.Initialize(context.Background(), &InitParams{
+		BarrierConfig: &SealConfig{
+			SecretShares:    3,
+			SecretThreshold: 2,
+			PGPKeys:         pubKeys,
+		},
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	expectedFingerprints, err := pgpkeys.GetFingerprints(pubKeys, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(res.PGPFingerprints, expectedFingerprints) {
+		t.Fatalf("bad fingerprints: got %v, expected %v", res.PGPFingerprints, expectedFingerprints)
+	}
+	if len(res.SecretShares) != len(privKeys) {
+		t.Fatalf("bad: %#v", res)
+	}
+
+	// Each share should only decrypt with its own key, and together they
+	// should unseal the core
+	for i, share := range res.SecretShares {
+		encoded := base64.StdEncoding.EncodeToString(share)
+		ptBuf, err := pgpkeys.DecryptBytes(encoded, privKeys[i])
+		if err != nil {
+			t.Fatalf("failed to decrypt share %d: %v", i, err)
+		}
+		key, err := hex.DecodeString(ptBuf.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := pgpkeys.DecryptBytes(encoded, privKeys[(i+1)%len(privKeys)]); err == nil {
+			t.Fatalf("share %d decrypted with the wrong key", i)
+		}
+		if i < 2 {
+			if _, err := c.Unseal(key); err != nil {
+				t.Fatalf("unseal with share %d failed: %v", i, err)
+			}
+		}
+	}
+	if c.Sealed() {
+		t.Fatal("should be unsealed")
+	}
+}
+
 func testCore_NewTestCore(t *testing.T, seal Seal) (*Core, *CoreConfig) {
 	return testCore_NewTestCoreLicensing(t, seal, nil)
 }
@@ -0,0 +1,116 @@
+package vault
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/helper/consts"
+	"github.com/hashicorp/vault/helper/namespace"
+)
+
+// ErrTokenNotFound is returned when looking up leases for a token that
+// doesn't exist
+var ErrTokenNotFound = errors.New("token not found")
+
+// LeasesByToken returns the IDs of the secret leases created with the given
+// token, as recorded in the token-to-lease index. The token's own lease is
+// not included. This method errors out when Vault is sealed or in standby.
+func (c *Core) LeasesByToken(token string) ([]string, error) {
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+	if c.Sealed() {
+		return nil, consts.ErrSealed
+	}
+	if c.standby {
+		return nil, consts.ErrStandby
+	}
+
+	return c.leasesByTokenInternal(token)
+}
+
+// RevokeLeasesByToken revokes every secret lease created with the given
+// token, leaving the token itself alone. Revocation is synchronous and stops
+// at the first lease that fails to revoke. This method errors out when Vault
+// is sealed or in standby.
+func (c *Core) RevokeLeasesByToken(token string) error {
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+	if c.Sealed() {
+		return consts.ErrSealed
+	}
+	if c.standby {
+		return consts.ErrStandby
+	}
+
+	leaseIDs, err := c.leasesByTokenInternal(token)
+	if err != nil {
+		return err
+	}
+
+	ctx := namespace.RootContext(c.activeContext)
+	for idx, leaseID := range leaseIDs {
+		if err := c.expiration.Revoke(ctx, leaseID); err != nil {
+			return errwrap.Wrapf(fmt.Sprintf("failed to revoke %q (%d / %d): {{err}}", leaseID, idx+1, len(leaseIDs)), err)
+		}
+	}
+	return nil
+}
+
+func (c *Core) leasesByTokenInternal(token string) ([]string, error) {
+	ctx := namespace.RootContext(c.activeContext)
+	te, err := c.tokenStore.Lookup(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if te == nil {
+		return nil, ErrTokenNotFound
+	}
+
+	leaseIDs, err := c.expiration.lookupLeasesByToken(ctx, te)
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to scan for leases: {{err}}", err)
+	}
+	return leaseIDs, nil
+}
+
+// RevokeLeasePrefix synchronously revokes every lease under the given prefix,
+// such as all leases of a mount, while leaving the mount itself in place. If
+// force is set, leases the backend fails to revoke are removed anyway instead
+// of stopping the operation, so only use it when the backend is known to be
+// unable to cooperate. This method errors out when Vault is sealed or in
+// standby.
+func (c *Core) RevokeLeasePrefix(prefix string, force bool) error {
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+	if c.Sealed() {
+		return consts.ErrSealed
+	}
+	if c.standby {
+		return consts.ErrStandby
+	}
+
+	ctx := namespace.RootContext(c.activeContext)
+	if force {
+		return c.expiration.RevokeForce(ctx, prefix)
+	}
+	return c.expiration.RevokePrefix(ctx, prefix, true)
+}
+
+// ExpireLeasesBefore revokes every lease that expires before t, ahead of its
+// scheduled revocation, and returns the IDs of the leases it revoked. See
+// ExpirationManager.ExpireBefore. This method errors out when Vault is sealed
+// or in standby.
+func (c *Core) ExpireLeasesBefore(t time.Time) ([]string, error) {
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+	if c.Sealed() {
+		return nil, consts.ErrSealed
+	}
+	if c.standby {
+		return nil, consts.ErrStandby
+	}
+
+	return c.expiration.ExpireBefore(t)
+}
@@ -0,0 +1,204 @@
+package vault
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/logical"
+)
+
+// testLeaseRevocationCore returns an unsealed core with a backend mounted at
+// each of the given paths that hands out an hour-long lease on every read.
+func testLeaseRevocationCore(t *testing.T, paths ...string) (*Core, string) {
+	core, _, root := TestCoreUnsealed(t)
+
+	noop := &NoopBackend{
+		Response: &logical.Response{
+			Secret: &logical.Secret{
+				LeaseOptions: logical.LeaseOptions{
+					TTL: time.Hour,
+				},
+			},
+			Data: map[string]interface{}{
+				"secret": "value",
+			},
+		},
+	}
+	core.logicalBackends["noop"] = func(context.Context, *logical.BackendConfig) (logical.Backend, error) {
+		return noop, nil
+	}
+	for _, path := range paths {
+		me := &MountEntry{
+			Table:    mountTableType,
+			Path:     path,
+			Type:     "noop",
+			Accessor: "noop-" + path,
+		}
+		if err := core.mount(namespace.RootContext(nil), me); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return core, root
+}
+
+// testLeaseRevocationRead reads path with token and returns the lease ID of
+// the secret it gets back
+func testLeaseRevocationRead(t *testing.T, core *Core, token, path string) string {
+	t.Helper()
+	resp, err := core.HandleRequest(namespace.RootContext(nil), &logical.Request{
+		Operation:   logical.ReadOperation,
+		Path:        path,
+		ClientToken: token,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.Secret == nil || resp.Secret.LeaseID == "" {
+		t.Fatalf("expected a lease, got: %#v", resp)
+	}
+	return resp.Secret.LeaseID
+}
+
+func TestCore_RevokeLeasesByToken(t *testing.T) {
+	core, root := testLeaseRevocationCore(t, "prod/")
+
+	resp, err := core.HandleRequest(namespace.RootContext(nil), &logical.Request{
+		Operation:   logical.UpdateOperation,
+		Path:        "auth/token/create",
+		ClientToken: root,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := resp.Auth.ClientToken
+
+	expected := []string{
+		testLeaseRevocationRead(t, core, token, "prod/foo"),
+		testLeaseRevocationRead(t, core, token, "prod/bar"),
+	}
+	rootLease := testLeaseRevocationRead(t, core, root, "prod/baz")
+
+	leaseIDs, err := core.LeasesByToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(leaseIDs)
+	sort.Strings(expected)
+	if !reflect.DeepEqual(leaseIDs, expected) {
+		t.Fatalf("bad leases: got %v, expected %v", leaseIDs, expected)
+	}
+
+	if err := core.RevokeLeasesByToken(token); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, leaseID := range expected {
+		le, err := core.expiration.FetchLeaseTimes(namespace.RootContext(nil), leaseID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if le != nil {
+			t.Fatalf("lease %q should have been revoked", leaseID)
+		}
+	}
+	leaseIDs, err = core.LeasesByToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leaseIDs) != 0 {
+		t.Fatalf("expected no leases left, got %v", leaseIDs)
+	}
+
+	// Leases of other tokens and the token itself are left alone
+	le, err := core.expiration.FetchLeaseTimes(namespace.RootContext(nil), rootLease)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if le == nil {
+		t.Fatal("lease of another token was revoked")
+	}
+	te, err := core.tokenStore.Lookup(namespace.RootContext(nil), token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if te == nil {
+		t.Fatal("token should not have been revoked")
+	}
+
+	if _, err := core.LeasesByToken("not-a-token"); err != ErrTokenNotFound {
+		t.Fatalf("expected ErrTokenNotFound, got: %v", err)
+	}
+}
+
+func TestCore_RevokeLeasePrefix(t *testing.T) {
+	core, root := testLeaseRevocationCore(t, "prod/", "dev/")
+
+	prodLeases := []string{
+		testLeaseRevocationRead(t, core, root, "prod/foo"),
+		testLeaseRevocationRead(t, core, root, "prod/sub/bar"),
+	}
+	devLeases := []string{
+		testLeaseRevocationRead(t, core, root, "dev/foo"),
+	}
+
+	if err := core.RevokeLeasePrefix("prod/", false); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, leaseID := range prodLeases {
+		le, err := core.expiration.FetchLeaseTimes(namespace.RootContext(nil), leaseID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if le != nil {
+			t.Fatalf("lease %q should have been revoked", leaseID)
+		}
+	}
+	for _, leaseID := range devLeases {
+		le, err := core.expiration.FetchLeaseTimes(namespace.RootContext(nil), leaseID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if le == nil {
+			t.Fatalf("lease %q outside the prefix was revoked", leaseID)
+		}
+	}
+
+	// The mount stays and keeps handing out leases
+	testLeaseRevocationRead(t, core, root, "prod/foo")
+}
+
+func TestCore_RevokeLeasePrefix_Force(t *testing.T) {
+	core, _, root := TestCoreUnsealed(t)
+
+	core.logicalBackends["badrenew"] = badRenewFactory
+	me := &MountEntry{
+		Table:    mountTableType,
+		Path:     "badrenew/",
+		Type:     "badrenew",
+		Accessor: "badrenewaccessor",
+	}
+	if err := core.mount(namespace.RootContext(nil), me); err != nil {
+		t.Fatal(err)
+	}
+	leaseID := testLeaseRevocationRead(t, core, root, "badrenew/creds")
+
+	// The backend refuses, so only a forced revocation gets rid of the lease
+	if err := core.RevokeLeasePrefix("badrenew/", false); err == nil {
+		t.Fatal("expected an error")
+	}
+	if err := core.RevokeLeasePrefix("badrenew/", true); err != nil {
+		t.Fatal(err)
+	}
+	le, err := core.expiration.FetchLeaseTimes(namespace.RootContext(nil), leaseID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if le != nil {
+		t.Fatal("lease should have been removed")
+	}
+}
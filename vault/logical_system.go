@@ -660,6 +660,12 @@ func mountInfo(entry *MountEntry) map[string]interface{} {
 		"seal_wrap":   entry.SealWrap,
 		"options":     entry.Options,
 	}
+	if len(entry.Metadata) > 0 {
+		info["metadata"] = entry.Metadata
+	}
+	if entry.NodeLocal {
+		info["node_local"] = true
+	}
 	entryConfig := map[string]interface{}{
 		"default_lease_ttl": int64(entry.Config.DefaultLeaseTTL.Seconds()),
 		"max_lease_ttl":     int64(entry.Config.MaxLeaseTTL.Seconds()),
@@ -728,7 +734,9 @@ func (b *SystemBackend) handleMountTable(ctx context.Context, req *logical.Reque
 func (b *SystemBackend) handleMount(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	repState := b.Core.ReplicationState()
 
-	local := data.Get("local").(bool)
+	// Node-local mounts are never replicated
+	nodeLocal := data.Get("node_local").(bool)
+	local := data.Get("local").(bool) || nodeLocal
 	if !local && repState.HasState(consts.ReplicationPerformanceSecondary) {
 		return logical.ErrorResponse("cannot add a non-local mount to a replication secondary"), nil
 	}
@@ -742,6 +750,7 @@ func (b *SystemBackend) handleMount(ctx context.Context, req *logical.Request, d
 	pluginName := data.Get("plugin_name").(string)
 	sealWrap := data.Get("seal_wrap").(bool)
 	options := data.Get("options").(map[string]string)
+	metadata := data.Get("metadata").(map[string]string)
 
 	var config MountConfig
 	var apiConfig APIMountConfig
@@ -788,9 +797,9 @@ func (b *SystemBackend) handleMount(ctx context.Context, req *logical.Request, d
 			logical.ErrInvalidRequest
 	}
 
-	if config.DefaultLeaseTTL > b.Core.maxLeaseTTL && config.MaxLeaseTTL == 0 {
+	if _, sysMax := b.Core.leaseTTLs(); config.DefaultLeaseTTL > sysMax && config.MaxLeaseTTL == 0 {
 		return logical.ErrorResponse(fmt.Sprintf(
-				"given default lease TTL greater than system max lease TTL of %d", int(b.Core.maxLeaseTTL.Seconds()))),
+				"given default lease TTL greater than system max lease TTL of %d", int(sysMax.Seconds()))),
 			logical.ErrInvalidRequest
 	}
 
@@ -870,6 +879,8 @@ func (b *SystemBackend) handleMount(ctx context.Context, req *logical.Request, d
 		Local:       local,
 		SealWrap:    sealWrap,
 		Options:     options,
+		Metadata:    metadata,
+		NodeLocal:   nodeLocal,
 	}
 
 	// Attempt mount
@@ -1444,7 +1455,7 @@ func (b *SystemBackend) handleLeaseLookup(ctx context.Context, req *logical.Requ
 			"ttl":          int64(0),
 		},
 	}
-	renewable, _ := leaseTimes.renewable()
+	renewable, _ := leaseTimes.renewable(b.Core.clock.Now())
 	resp.Data["renewable"] = renewable
 
 	if !leaseTimes.LastRenewalTime.IsZero() {
@@ -1452,7 +1463,7 @@ func (b *SystemBackend) handleLeaseLookup(ctx context.Context, req *logical.Requ
 	}
 	if !leaseTimes.ExpireTime.IsZero() {
 		resp.Data["expire_time"] = leaseTimes.ExpireTime
-		resp.Data["ttl"] = leaseTimes.ttl()
+		resp.Data["ttl"] = leaseTimes.ttl(b.Core.clock.Now())
 	}
 	return resp, nil
 }
@@ -1627,6 +1638,7 @@ func (b *SystemBackend) handleEnableAuth(ctx context.Context, req *logical.Reque
 	pluginName := data.Get("plugin_name").(string)
 	sealWrap := data.Get("seal_wrap").(bool)
 	options := data.Get("options").(map[string]string)
+	metadata := data.Get("metadata").(map[string]string)
 
 	var config MountConfig
 	var apiConfig APIMountConfig
@@ -1673,9 +1685,9 @@ func (b *SystemBackend) handleEnableAuth(ctx context.Context, req *logical.Reque
 			logical.ErrInvalidRequest
 	}
 
-	if config.DefaultLeaseTTL > b.Core.maxLeaseTTL && config.MaxLeaseTTL == 0 {
+	if _, sysMax := b.Core.leaseTTLs(); config.DefaultLeaseTTL > sysMax && config.MaxLeaseTTL == 0 {
 		return logical.ErrorResponse(fmt.Sprintf(
-				"given default lease TTL greater than system max lease TTL of %d", int(b.Core.maxLeaseTTL.Seconds()))),
+				"given default lease TTL greater than system max lease TTL of %d", int(sysMax.Seconds()))),
 			logical.ErrInvalidRequest
 	}
 
@@ -1744,6 +1756,7 @@ func (b *SystemBackend) handleEnableAuth(ctx context.Context, req *logical.Reque
 		Local:       local,
 		SealWrap:    sealWrap,
 		Options:     options,
+		Metadata:    metadata,
 	}
 
 	// Attempt enabling
@@ -3354,6 +3367,14 @@ in the plugin catalog.`,
 		`The options to pass into the backend. Should be a json object with string keys and values.`,
 	},
 
+	"mount_node_local": {
+		`Mark the mount as node-local. Its data is kept under this node's ID, so other nodes sharing the storage backend see the mount but none of its data. Implies local.`,
+	},
+
+	"mount_metadata": {
+		`Arbitrary labels to attach to the mount, such as an owning team. Should be a json object with string keys and values. Vault stores these but does not act on them.`,
+	},
+
 	"seal_wrap": {
 		`Whether to turn on seal wrapping for the mount.`,
 	},
@@ -3530,6 +3551,10 @@ Example: you might have an OAuth backend for GitHub, and one for Google Apps.
 		`The options to pass into the backend. Should be a json object with string keys and values.`,
 	},
 
+	"auth_metadata": {
+		`Arbitrary labels to attach to the auth method, such as an owning team. Should be a json object with string keys and values. Vault stores these but does not act on them.`,
+	},
+
 	"policy-list": {
 		`List the configured access control policies.`,
 		`
@@ -1199,6 +1199,10 @@ func (b *SystemBackend) authPaths() []*framework.Path {
 					Type:        framework.TypeKVPairs,
 					Description: strings.TrimSpace(sysHelp["auth_options"][0]),
 				},
+				"metadata": &framework.FieldSchema{
+					Type:        framework.TypeKVPairs,
+					Description: strings.TrimSpace(sysHelp["auth_metadata"][0]),
+				},
 			},
 			Operations: map[logical.Operation]framework.OperationHandler{
 				logical.UpdateOperation: &framework.PathOperation{
@@ -1485,6 +1489,15 @@ func (b *SystemBackend) mountPaths() []*framework.Path {
 					Type:        framework.TypeKVPairs,
 					Description: strings.TrimSpace(sysHelp["mount_options"][0]),
 				},
+				"metadata": &framework.FieldSchema{
+					Type:        framework.TypeKVPairs,
+					Description: strings.TrimSpace(sysHelp["mount_metadata"][0]),
+				},
+				"node_local": &framework.FieldSchema{
+					Type:        framework.TypeBool,
+					Default:     false,
+					Description: strings.TrimSpace(sysHelp["mount_node_local"][0]),
+				},
 			},
 
 			Operations: map[logical.Operation]framework.OperationHandler{
@@ -0,0 +1,55 @@
+package vault
+
+import (
+	"errors"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+// ErrMaintenance is returned for requests that would change data while Vault
+// is in maintenance mode
+var ErrMaintenance = errors.New("Vault is in maintenance mode, only reads are being served")
+
+// EnterMaintenance puts Vault in maintenance mode. Until ExitMaintenance is
+// called, requests that would change data, including those forwarded from
+// standbys, are rejected with a 503 while reads and health checks are served
+// as usual. The barrier stays unsealed and leases keep being tracked. The
+// mode is local to this node and isn't persisted across restarts.
+func (c *Core) EnterMaintenance() {
+	if atomic.CompareAndSwapUint32(c.inMaintenance, 0, 1) {
+		c.logger.Info("entered maintenance mode")
+	}
+}
+
+// ExitMaintenance takes Vault out of maintenance mode
+func (c *Core) ExitMaintenance() {
+	if atomic.CompareAndSwapUint32(c.inMaintenance, 1, 0) {
+		c.logger.Info("exited maintenance mode")
+	}
+}
+
+// InMaintenance reports whether Vault is in maintenance mode
+func (c *Core) InMaintenance() bool {
+	return atomic.LoadUint32(c.inMaintenance) == 1
+}
+
+// rejectedForMaintenance returns the error to reply to req with if it must be
+// turned away because of maintenance mode, or nil
+func (c *Core) rejectedForMaintenance(req *logical.Request) error {
+	if !c.InMaintenance() || readOnlyOperation(req.Operation) {
+		return nil
+	}
+	return logical.CodedError(http.StatusServiceUnavailable, ErrMaintenance.Error())
+}
+
+// readOnlyOperation reports whether requests with the given operation never
+// change data
+func readOnlyOperation(op logical.Operation) bool {
+	switch op {
+	case logical.ReadOperation, logical.ListOperation, logical.HelpOperation:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package vault
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestCore_Maintenance(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+	ctx := namespace.RootContext(nil)
+
+	write := func(value string) error {
+		req := &logical.Request{
+			Operation:   logical.UpdateOperation,
+			Path:        "secret/foo",
+			Data:        map[string]interface{}{"value": value},
+			ClientToken: root,
+		}
+		_, err := c.HandleRequest(ctx, req)
+		return err
+	}
+	read := func() string {
+		req := &logical.Request{
+			Operation:   logical.ReadOperation,
+			Path:        "secret/foo",
+			ClientToken: root,
+		}
+		resp, err := c.HandleRequest(ctx, req)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if resp == nil || resp.Data == nil {
+			t.Fatalf("bad: %#v", resp)
+		}
+		return resp.Data["value"].(string)
+	}
+
+	if err := write("before"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	c.EnterMaintenance()
+	if !c.InMaintenance() {
+		t.Fatal("expected to be in maintenance mode")
+	}
+
+	err := write("during")
+	coded, ok := err.(logical.HTTPCodedError)
+	if !ok || coded.Code() != http.StatusServiceUnavailable || coded.Error() != ErrMaintenance.Error() {
+		t.Fatalf("expected maintenance error, got %v", err)
+	}
+	if v := read(); v != "before" {
+		t.Fatalf("bad: %q", v)
+	}
+
+	c.ExitMaintenance()
+	if c.InMaintenance() {
+		t.Fatal("expected to be out of maintenance mode")
+	}
+	if err := write("after"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if v := read(); v != "after" {
+		t.Fatalf("bad: %q", v)
+	}
+}
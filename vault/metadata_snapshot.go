@@ -0,0 +1,199 @@
+package vault
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/helper/consts"
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/logical"
+)
+
+// MetadataSnapshot is a point-in-time copy of the mount table, the auth
+// table and the outstanding secret leases of a Vault. Together with a backup
+// of the physical backend it can be used to rebuild a cluster. It carries no
+// secret values: leases are recorded by ID, path and timing only.
+type MetadataSnapshot struct {
+	Mounts []*MountEntry    `json:"mounts"`
+	Auth   []*MountEntry    `json:"auth"`
+	Leases []*LeaseSnapshot `json:"leases"`
+}
+
+// LeaseSnapshot records a single lease in a MetadataSnapshot
+type LeaseSnapshot struct {
+	LeaseID         string        `json:"lease_id"`
+	Path            string        `json:"path"`
+	TTL             time.Duration `json:"ttl"`
+	Renewable       bool          `json:"renewable"`
+	IssueTime       time.Time     `json:"issue_time"`
+	ExpireTime      time.Time     `json:"expire_time"`
+	LastRenewalTime time.Time     `json:"last_renewal_time"`
+}
+
+// SnapshotMetadata returns a snapshot of the mount table, the auth table and
+// the outstanding secret leases. Token leases are not included. This method
+// errors out when Vault is sealed or in standby.
+func (c *Core) SnapshotMetadata() (*MetadataSnapshot, error) {
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+	if c.Sealed() {
+		return nil, consts.ErrSealed
+	}
+	if c.standby {
+		return nil, consts.ErrStandby
+	}
+
+	snapshot := &MetadataSnapshot{}
+
+	c.mountsLock.RLock()
+	for _, entry := range c.mounts.Entries {
+		clone, err := entry.Clone()
+		if err != nil {
+			c.mountsLock.RUnlock()
+			return nil, errwrap.Wrapf(fmt.Sprintf("failed to copy mount entry %q: {{err}}", entry.Path), err)
+		}
+		snapshot.Mounts = append(snapshot.Mounts, clone)
+	}
+	c.mountsLock.RUnlock()
+
+	c.authLock.RLock()
+	for _, entry := range c.auth.Entries {
+		clone, err := entry.Clone()
+		if err != nil {
+			c.authLock.RUnlock()
+			return nil, errwrap.Wrapf(fmt.Sprintf("failed to copy auth entry %q: {{err}}", entry.Path), err)
+		}
+		snapshot.Auth = append(snapshot.Auth, clone)
+	}
+	c.authLock.RUnlock()
+
+	ctx := namespace.RootContext(c.activeContext)
+	leaseIDs, err := collectLeaseIDs(ctx, c.expiration.leaseView(namespace.RootNamespace), "")
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to scan for leases: {{err}}", err)
+	}
+	for _, leaseID := range leaseIDs {
+		le, err := c.expiration.loadEntry(ctx, leaseID)
+		if err != nil {
+			return nil, err
+		}
+		// Skip leases revoked since the scan and token leases
+		if le == nil || le.Secret == nil {
+			continue
+		}
+		snapshot.Leases = append(snapshot.Leases, &LeaseSnapshot{
+			LeaseID:         le.LeaseID,
+			Path:            le.Path,
+			TTL:             le.Secret.TTL,
+			Renewable:       le.Secret.Renewable,
+			IssueTime:       le.IssueTime,
+			ExpireTime:      le.ExpireTime,
+			LastRenewalTime: le.LastRenewalTime,
+		})
+	}
+
+	return snapshot, nil
+}
+
+// RestoreMetadata recreates the mounts, auth methods and leases of a snapshot
+// taken with SnapshotMetadata. Mounts and auth methods keep their UUIDs, so
+// the data of a restored physical backup is picked up again; those whose path
+// is already in use, such as the built-in ones, are left alone, as are leases
+// that already exist. Since a snapshot holds no secret values, a restored
+// lease can only be revoked by a backend that doesn't need them; others have
+// to be revoked with force. This method errors out when Vault is sealed or in
+// standby.
+func (c *Core) RestoreMetadata(snapshot *MetadataSnapshot) error {
+	if snapshot == nil {
+		return errors.New("nil snapshot")
+	}
+
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+	if c.Sealed() {
+		return consts.ErrSealed
+	}
+	if c.standby {
+		return consts.ErrStandby
+	}
+
+	ctx := namespace.RootContext(c.activeContext)
+
+	for _, entry := range snapshot.Mounts {
+		c.mountsLock.RLock()
+		exists := mountTableHasPath(c.mounts, entry.Path)
+		c.mountsLock.RUnlock()
+		if exists {
+			continue
+		}
+
+		clone, err := entry.Clone()
+		if err != nil {
+			return err
+		}
+		if err := c.mount(ctx, clone); err != nil {
+			return errwrap.Wrapf(fmt.Sprintf("failed to restore mount %q: {{err}}", entry.Path), err)
+		}
+	}
+
+	for _, entry := range snapshot.Auth {
+		c.authLock.RLock()
+		exists := mountTableHasPath(c.auth, entry.Path)
+		c.authLock.RUnlock()
+		if exists {
+			continue
+		}
+
+		clone, err := entry.Clone()
+		if err != nil {
+			return err
+		}
+		if err := c.enableCredential(ctx, clone); err != nil {
+			return errwrap.Wrapf(fmt.Sprintf("failed to restore auth method %q: {{err}}", entry.Path), err)
+		}
+	}
+
+	for _, lease := range snapshot.Leases {
+		existing, err := c.expiration.loadEntry(ctx, lease.LeaseID)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			continue
+		}
+
+		le := &leaseEntry{
+			LeaseID: lease.LeaseID,
+			Path:    lease.Path,
+			Secret: &logical.Secret{
+				LeaseOptions: logical.LeaseOptions{
+					TTL:       lease.TTL,
+					Renewable: lease.Renewable,
+				},
+			},
+			IssueTime:       lease.IssueTime,
+			ExpireTime:      lease.ExpireTime,
+			LastRenewalTime: lease.LastRenewalTime,
+			namespace:       namespace.RootNamespace,
+		}
+		if err := c.expiration.persistEntry(ctx, le); err != nil {
+			return errwrap.Wrapf(fmt.Sprintf("failed to restore lease %q: {{err}}", lease.LeaseID), err)
+		}
+		c.expiration.updatePending(le, le.ExpireTime.Sub(c.expiration.clock.Now()))
+	}
+
+	return nil
+}
+
+// mountTableHasPath returns whether the table has an entry at the given path
+// in the root namespace
+func mountTableHasPath(table *MountTable, path string) bool {
+	for _, entry := range table.Entries {
+		if entry.Path == path && entry.NamespaceID == namespace.RootNamespaceID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestCore_SnapshotRestoreMetadata(t *testing.T) {
+	credentialFactory := func(context.Context, *logical.BackendConfig) (logical.Backend, error) {
+		return &NoopBackend{BackendType: logical.TypeCredential}, nil
+	}
+
+	core, root := testLeaseRevocationCore(t, "prod/")
+	core.credentialBackends["noop"] = credentialFactory
+	if err := core.enableCredential(namespace.RootContext(nil), &MountEntry{
+		Table: credentialTableType,
+		Path:  "noop/",
+		Type:  "noop",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	leaseIDs := []string{
+		testLeaseRevocationRead(t, core, root, "prod/foo"),
+		testLeaseRevocationRead(t, core, root, "prod/bar"),
+	}
+
+	snapshot, err := core.SnapshotMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshot.Leases) != len(leaseIDs) {
+		t.Fatalf("expected %d leases, got %#v", len(leaseIDs), snapshot.Leases)
+	}
+
+	// Make sure the snapshot survives being written out
+	raw, err := json.Marshal(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var restored MetadataSnapshot
+	if err := json.Unmarshal(raw, &restored); err != nil {
+		t.Fatal(err)
+	}
+
+	core2, _ := testLeaseRevocationCore(t)
+	core2.credentialBackends["noop"] = credentialFactory
+	if err := core2.RestoreMetadata(&restored); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		table, path string
+	}{
+		{mountTableType, "prod/"},
+		{credentialTableType, "noop/"},
+	} {
+		var orig, got *MountEntry
+		for _, entry := range snapshot.Mounts {
+			if tc.table == mountTableType && entry.Path == tc.path {
+				orig = entry
+			}
+		}
+		for _, entry := range snapshot.Auth {
+			if tc.table == credentialTableType && entry.Path == tc.path {
+				orig = entry
+			}
+		}
+		table := core2.mounts
+		if tc.table == credentialTableType {
+			table = core2.auth
+		}
+		for _, entry := range table.Entries {
+			if entry.Path == tc.path {
+				got = entry
+			}
+		}
+		if orig == nil || got == nil {
+			t.Fatalf("%s entry %q not restored", tc.table, tc.path)
+		}
+		if got.Type != orig.Type || got.UUID != orig.UUID || got.Accessor != orig.Accessor {
+			t.Fatalf("bad restored %s entry: got %#v, expected %#v", tc.table, got, orig)
+		}
+	}
+
+	for _, leaseID := range leaseIDs {
+		orig, err := core.expiration.FetchLeaseTimes(namespace.RootContext(nil), leaseID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		le, err := core2.expiration.FetchLeaseTimes(namespace.RootContext(nil), leaseID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if le == nil {
+			t.Fatalf("lease %q not restored", leaseID)
+		}
+		if !le.ExpireTime.Equal(orig.ExpireTime) || !le.IssueTime.Equal(orig.IssueTime) {
+			t.Fatalf("bad restored lease times: got %#v, expected %#v", le, orig)
+		}
+	}
+
+	// Restoring again leaves everything as it is
+	if err := core2.RestoreMetadata(&restored); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -38,6 +38,10 @@ const (
 	// system logical backend.
 	systemBarrierPrefix = "sys/"
 
+	// nodeBarrierPrefix is the prefix under which node-local mounts keep
+	// their data, followed by the node ID
+	nodeBarrierPrefix = "node/"
+
 	// mountTableType is the value we expect to find for the mount table and
 	// corresponding entries
 	mountTableType = "mounts"
@@ -207,6 +211,11 @@ type MountEntry struct {
 	SealWrap         bool              `json:"seal_wrap"`          // Whether to wrap CSPs
 	Tainted          bool              `json:"tainted,omitempty"`  // Set as a Write-Ahead flag for unmount/remount
 	NamespaceID      string            `json:"namespace_id"`
+	Metadata         map[string]string `json:"metadata,omitempty"`   // Operator-supplied labels; not interpreted by Vault
+	NodeLocal        bool              `json:"node_local,omitempty"` // Data is kept per node and is not seen by other nodes sharing storage
+
+	// nodeID is the ID of the node a node-local mount's data belongs to
+	nodeID string
 
 	// namespace contains the populated namespace
 	namespace *namespace.Namespace
@@ -330,6 +339,141 @@ func (c *Core) decodeMountTable(ctx context.Context, raw []byte) (*MountTable, e
 	}, nil
 }
 
+// Mount mounts a new secret backend on a running core in the root namespace.
+// The entry is added to the persisted mount table, given a barrier view and
+// registered with the router.
+func (c *Core) Mount(ctx context.Context, entry *MountEntry) error {
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+	if c.Sealed() {
+		return consts.ErrSealed
+	}
+	if c.standby {
+		return consts.ErrStandby
+	}
+
+	if entry.Table == "" {
+		entry.Table = mountTableType
+	}
+	return c.mount(namespace.RootContext(ctx), entry)
+}
+
+// Unmount removes the secret backend mounted at path on a running core,
+// revoking its leases and clearing its storage.
+func (c *Core) Unmount(ctx context.Context, path string) error {
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+	if c.Sealed() {
+		return consts.ErrSealed
+	}
+	if c.standby {
+		return consts.ErrStandby
+	}
+
+	return c.unmount(namespace.RootContext(ctx), path)
+}
+
+// ResolvePath returns the mount path and backend type that a request for the
+// given API path in the root namespace would be routed to, without
+// dispatching it. Mounts are matched on the longest prefix, as the router
+// does.
+func (c *Core) ResolvePath(path string) (mount string, backendType string, err error) {
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+	if c.Sealed() {
+		return "", "", consts.ErrSealed
+	}
+	if c.standby {
+		return "", "", consts.ErrStandby
+	}
+
+	ctx := namespace.RootContext(c.activeContext)
+	entry := c.router.MatchingMountEntry(ctx, path)
+	if entry == nil {
+		return "", "", fmt.Errorf("no mount handles path %q", path)
+	}
+	return c.router.MatchingMount(ctx, path), entry.Type, nil
+}
+
+// MountFilter selects the entries returned by ListMounts. Empty fields match
+// every entry.
+type MountFilter struct {
+	// Table is "mounts" for secret mounts or "auth" for auth mounts
+	Table string
+
+	// Type is the backend type, e.g. "kv" or "token"
+	Type string
+}
+
+func (f MountFilter) matches(entry *MountEntry) bool {
+	if f.Table != "" && f.Table != entry.Table {
+		return false
+	}
+	if f.Type != "" && f.Type != entry.Type {
+		return false
+	}
+	return true
+}
+
+// ListMounts returns copies of the entries of both the secret and the auth
+// mount tables, in that order, that match the given filter. This method errors
+// out when Vault is sealed or in standby.
+func (c *Core) ListMounts(filter MountFilter) ([]*MountEntry, error) {
+	switch filter.Table {
+	case "", mountTableType, credentialTableType:
+	default:
+		return nil, fmt.Errorf("unknown mount table %q", filter.Table)
+	}
+
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+	if c.Sealed() {
+		return nil, consts.ErrSealed
+	}
+	if c.standby {
+		return nil, consts.ErrStandby
+	}
+
+	var out []*MountEntry
+	collect := func(table *MountTable) error {
+		if table == nil {
+			return nil
+		}
+		for _, entry := range table.Entries {
+			if !filter.matches(entry) {
+				continue
+			}
+			cp, err := entry.Clone()
+			if err != nil {
+				return err
+			}
+			// Clone only copies the exported fields, so carry over what
+			// isn't persisted
+			cp.namespace = entry.namespace
+			cp.nodeID = entry.nodeID
+			cp.SyncCache()
+			out = append(out, cp)
+		}
+		return nil
+	}
+
+	c.mountsLock.RLock()
+	err := collect(c.mounts)
+	c.mountsLock.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	c.authLock.RLock()
+	err = collect(c.auth)
+	c.authLock.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
 // Mount is used to mount a new backend to the mount table.
 func (c *Core) mount(ctx context.Context, entry *MountEntry) error {
 	// Ensure we end the path in a slash
@@ -369,6 +513,14 @@ func (c *Core) mountInternal(ctx context.Context, entry *MountEntry, updateStora
 	entry.NamespaceID = ns.ID
 	entry.namespace = ns
 
+	if entry.NodeLocal {
+		if c.nodeID == "" {
+			return fmt.Errorf("node-local mounts require a node ID")
+		}
+		entry.Local = true
+	}
+	entry.nodeID = c.nodeID
+
 	// Ensure the cache is populated, don't need the result
 	NamespaceByID(ctx, ns.ID, c)
 
@@ -900,6 +1052,12 @@ func (c *Core) loadMounts(ctx context.Context) error {
 		}
 		entry.namespace = ns
 
+		if entry.NodeLocal && c.nodeID == "" {
+			c.logger.Error("node-local mount found but this node has no node ID", "path", entry.Path)
+			return errLoadMountsFailed
+		}
+		entry.nodeID = c.nodeID
+
 		// Sync values to the cache
 		entry.SyncCache()
 	}
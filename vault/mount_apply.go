@@ -0,0 +1,304 @@
+package vault
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/helper/consts"
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/helper/strutil"
+	"github.com/hashicorp/vault/logical"
+)
+
+// ApplyMountTable replaces the secret mount table with the given snapshot,
+// e.g. one taken with ListMounts on another cluster. Entries are matched by
+// UUID: new ones are mounted, missing ones are unmounted and changed ones are
+// remounted on their existing storage. All of it is applied under the mount
+// table lock and swapped into the router in one step, and on error the
+// table is not changed. Leases are only revoked once the new table has been
+// stored and the router is known to accept it. Singleton mounts such as sys/ and cubbyhole/ belong
+// to this node, so they are kept as they are and snapshot entries for them
+// are ignored. Leases of removed mounts are revoked as unmount does, but
+// data under them is not cleared, since on a replication secondary it
+// belongs to the primary. Moving a mount to another path is refused, since
+// its leases would be left behind; use remount for that.
+func (c *Core) ApplyMountTable(snapshot []*MountEntry) error {
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+	if c.Sealed() {
+		return consts.ErrSealed
+	}
+	if c.standby {
+		return consts.ErrStandby
+	}
+
+	ctx := c.activeContext
+
+	entries := make([]*MountEntry, 0, len(snapshot))
+	for _, entry := range snapshot {
+		entry, err := entry.Clone()
+		if err != nil {
+			return err
+		}
+		if entry.Table == "" {
+			entry.Table = mountTableType
+		}
+		if entry.Table != mountTableType {
+			return fmt.Errorf("entry for %q is not in the %q table", entry.Path, mountTableType)
+		}
+		if entry.UUID == "" || entry.Accessor == "" {
+			return fmt.Errorf("entry for %q is missing its UUID or accessor", entry.Path)
+		}
+		if !strings.HasSuffix(entry.Path, "/") {
+			entry.Path += "/"
+		}
+		if strutil.StrListContains(singletonMounts, entry.Type) {
+			continue
+		}
+		for _, p := range protectedMounts {
+			if strings.HasPrefix(entry.Path, p) {
+				return logical.CodedError(403, fmt.Sprintf("cannot mount %q", entry.Path))
+			}
+		}
+
+		nsCtx, err := c.mountEntryNamespaceContext(ctx, entry)
+		if err != nil {
+			return err
+		}
+		ns, err := namespace.FromContext(nsCtx)
+		if err != nil {
+			return err
+		}
+		if err := verifyNamespace(c, ns, entry); err != nil {
+			return err
+		}
+		entry.NamespaceID = ns.ID
+		entry.namespace = ns
+
+		if entry.NodeLocal {
+			if c.nodeID == "" {
+				return fmt.Errorf("node-local mounts require a node ID")
+			}
+			entry.Local = true
+		}
+		entry.nodeID = c.nodeID
+		entry.SyncCache()
+
+		entries = append(entries, entry)
+	}
+
+	c.mountsLock.Lock()
+	defer c.mountsLock.Unlock()
+
+	added, removed := diffMountSnapshot(c.mounts.Entries, entries)
+	if len(added) == 0 && len(removed) == 0 {
+		return nil
+	}
+
+	snapshotByUUID := make(map[string]*MountEntry, len(entries))
+	for _, entry := range entries {
+		snapshotByUUID[entry.UUID] = entry
+	}
+	var dropped []*MountEntry
+	for _, entry := range removed {
+		s, ok := snapshotByUUID[entry.UUID]
+		switch {
+		case !ok:
+			dropped = append(dropped, entry)
+		case s.Path != entry.Path || s.NamespaceID != entry.NamespaceID:
+			return logical.CodedError(400, fmt.Sprintf("cannot move mount %q to %q; use remount", entry.Path, s.Path))
+		}
+	}
+
+	// Create every new backend before touching anything, so a failure only
+	// has to clean these up
+	var mounts []routerMount
+	cleanupBackends := func() {
+		for _, m := range mounts {
+			if m.backend != nil {
+				m.backend.Cleanup(ctx)
+			}
+		}
+	}
+	for _, entry := range added {
+		m, err := c.newApplyMount(entry)
+		if err != nil {
+			cleanupBackends()
+			return errwrap.Wrapf("failed to create backend for "+entry.Path+": {{err}}", err)
+		}
+		mounts = append(mounts, m)
+	}
+
+	isRemoved := make(map[*MountEntry]bool, len(removed))
+	for _, entry := range removed {
+		isRemoved[entry] = true
+	}
+	newTable := &MountTable{
+		Type: c.mounts.Type,
+	}
+	for _, entry := range c.mounts.Entries {
+		if !isRemoved[entry] {
+			newTable.Entries = append(newTable.Entries, entry)
+		}
+	}
+	newTable.Entries = append(newTable.Entries, added...)
+
+	// Make sure the router will take the change before anything is written,
+	// since revoked leases can't be brought back
+	if err := c.router.checkReplaceMounts(removed, mounts); err != nil {
+		cleanupBackends()
+		return err
+	}
+
+	if err := c.persistMounts(ctx, newTable, nil); err != nil {
+		cleanupBackends()
+		c.logger.Error("failed to update mount table", "error", err)
+		return logical.CodedError(500, "failed to update mount table")
+	}
+	restoreTable := func() {
+		if err := c.persistMounts(ctx, c.mounts, nil); err != nil {
+			c.logger.Error("failed to restore mount table", "error", err)
+		}
+	}
+
+	// Revoke the leases of the mounts going away once the new table is
+	// stored, but while they are still routed so that their backends see
+	// the revocations, as unmount does
+	for _, entry := range dropped {
+		if c.expiration == nil {
+			break
+		}
+		rCtx := namespace.ContextWithNamespace(ctx, entry.Namespace())
+		if err := c.expiration.RevokePrefix(rCtx, entry.Path, true); err != nil {
+			cleanupBackends()
+			restoreTable()
+			return errwrap.Wrapf("failed to revoke leases of "+entry.Path+": {{err}}", err)
+		}
+	}
+
+	if err := c.router.replaceMounts(ctx, removed, mounts); err != nil {
+		cleanupBackends()
+		restoreTable()
+		return err
+	}
+	c.mounts = newTable
+
+	for _, entry := range removed {
+		removePathCheckers(c, entry, entry.ViewPath())
+	}
+	for _, m := range mounts {
+		if m.backend != nil {
+			addPathCheckers(c, m.mountEntry, m.backend, m.storageView.prefix)
+		}
+	}
+
+	if c.logger.IsInfo() {
+		c.logger.Info("applied mount table", "mounted", len(added), "unmounted", len(removed))
+	}
+	return nil
+}
+
+// newApplyMount creates the backend and barrier view for an entry being
+// mounted by ApplyMountTable
+func (c *Core) newApplyMount(entry *MountEntry) (routerMount, error) {
+	ctx, err := c.mountEntryNamespaceContext(c.activeContext, entry)
+	if err != nil {
+		return routerMount{}, err
+	}
+
+	view := NewBarrierView(c.barrier, entry.ViewPath())
+	nilMount, err := preprocessMount(c, entry, view)
+	if err != nil {
+		return routerMount{}, err
+	}
+
+	// Mark the view as read-only until the backend is constructed, as
+	// mountInternal does
+	origReadOnlyErr := view.getReadOnlyErr()
+	view.setReadOnlyErr(logical.ErrSetupReadOnly)
+	defer view.setReadOnlyErr(origReadOnlyErr)
+
+	backend, err := c.newLogicalBackend(ctx, entry, c.mountEntrySysView(entry), view)
+	if err != nil {
+		return routerMount{}, err
+	}
+	if backend == nil {
+		return routerMount{}, fmt.Errorf("nil backend of type %q returned from creation function", entry.Type)
+	}
+	if backend.Type() != logical.TypeLogical && entry.Type != "kv" {
+		backend.Cleanup(ctx)
+		return routerMount{}, fmt.Errorf(`unknown backend type: "%s"`, entry.Type)
+	}
+
+	if nilMount {
+		backend.Cleanup(ctx)
+		backend = nil
+	}
+
+	return routerMount{
+		backend:     backend,
+		mountEntry:  entry,
+		storageView: view,
+	}, nil
+}
+
+// diffMountSnapshot matches loaded and snapshot entries by UUID and returns
+// the snapshot entries that need mounting and the loaded entries that need
+// unmounting. A changed entry shows up in both. Singleton and tainted entries
+// are left out.
+func diffMountSnapshot(loaded, snapshot []*MountEntry) (added, removed []*MountEntry) {
+	skip := func(entry *MountEntry) bool {
+		return entry.Tainted || strutil.StrListContains(singletonMounts, entry.Type)
+	}
+
+	loadedByUUID := make(map[string]*MountEntry, len(loaded))
+	for _, entry := range loaded {
+		if !skip(entry) {
+			loadedByUUID[entry.UUID] = entry
+		}
+	}
+	snapshotByUUID := make(map[string]*MountEntry, len(snapshot))
+	for _, entry := range snapshot {
+		if !skip(entry) {
+			snapshotByUUID[entry.UUID] = entry
+		}
+	}
+
+	for _, entry := range loaded {
+		if skip(entry) {
+			continue
+		}
+		if s, ok := snapshotByUUID[entry.UUID]; !ok || !mountEntriesEqual(entry, s) {
+			removed = append(removed, entry)
+		}
+	}
+	for _, entry := range snapshot {
+		if skip(entry) {
+			continue
+		}
+		if l, ok := loadedByUUID[entry.UUID]; !ok || !mountEntriesEqual(entry, l) {
+			added = append(added, entry)
+		}
+	}
+	return added, removed
+}
+
+// mountEntriesEqual compares the persisted fields of two entries
+func mountEntriesEqual(a, b *MountEntry) bool {
+	return a.Table == b.Table &&
+		a.Path == b.Path &&
+		a.Type == b.Type &&
+		a.Description == b.Description &&
+		a.UUID == b.UUID &&
+		a.BackendAwareUUID == b.BackendAwareUUID &&
+		a.Accessor == b.Accessor &&
+		a.Local == b.Local &&
+		a.SealWrap == b.SealWrap &&
+		a.NodeLocal == b.NodeLocal &&
+		a.NamespaceID == b.NamespaceID &&
+		reflect.DeepEqual(a.Config, b.Config) &&
+		reflect.DeepEqual(a.Options, b.Options) &&
+		reflect.DeepEqual(a.Metadata, b.Metadata)
+}
@@ -0,0 +1,178 @@
+package vault
+
+import (
+	"context"
+	"errors"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/helper/consts"
+	"github.com/hashicorp/vault/helper/namespace"
+)
+
+// ErrReloadMountsStandby is returned by ReloadMounts on a standby that isn't
+// a performance standby. Such a node never sets up mounts or a router: it
+// forwards requests to the active node and loads the mount tables from
+// storage only when it becomes active itself, so it always starts out with
+// the current tables and there is nothing to reload.
+var ErrReloadMountsStandby = errors.New("cannot reload mounts on a standby; mount tables are loaded when the node becomes active")
+
+// ReloadMounts re-reads the persisted mount and auth tables and brings the
+// router in line with them: entries that only exist in storage are mounted
+// and entries that are no longer stored are unmounted. Stored data is never
+// touched, since it belongs to whichever node changed the tables. This lets
+// a node that isn't making the changes itself, such as a performance
+// standby, pick them up without a restart. Standbys that only forward
+// requests have no mounts to reload and get ErrReloadMountsStandby.
+func (c *Core) ReloadMounts() error {
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+	if c.Sealed() {
+		return consts.ErrSealed
+	}
+	if c.standby && !c.perfStandby {
+		return ErrReloadMountsStandby
+	}
+
+	ctx := c.activeContext
+
+	stored, err := c.readStoredMountTable(ctx, coreMountConfigPath, coreLocalMountConfigPath)
+	if err != nil {
+		return errwrap.Wrapf("failed to read mount table: {{err}}", err)
+	}
+	c.mountsLock.RLock()
+	loaded := c.mounts.shallowClone().Entries
+	c.mountsLock.RUnlock()
+
+	added, removed := diffMountEntries(loaded, stored)
+	for _, entry := range removed {
+		nsCtx := namespace.ContextWithNamespace(ctx, entry.Namespace())
+		if err := c.unmountInternal(nsCtx, entry.Path, MountTableNoUpdateStorage); err != nil {
+			return errwrap.Wrapf("failed to unmount "+entry.Path+": {{err}}", err)
+		}
+	}
+	for _, entry := range added {
+		nsCtx, err := c.mountEntryNamespaceContext(ctx, entry)
+		if err != nil {
+			return err
+		}
+		if err := c.mountInternal(nsCtx, entry, MountTableNoUpdateStorage); err != nil {
+			return errwrap.Wrapf("failed to mount "+entry.Path+": {{err}}", err)
+		}
+	}
+
+	stored, err = c.readStoredMountTable(ctx, coreAuthConfigPath, coreLocalAuthConfigPath)
+	if err != nil {
+		return errwrap.Wrapf("failed to read auth table: {{err}}", err)
+	}
+	c.authLock.RLock()
+	loaded = c.auth.shallowClone().Entries
+	c.authLock.RUnlock()
+
+	added, removed = diffMountEntries(loaded, stored)
+	for _, entry := range removed {
+		nsCtx := namespace.ContextWithNamespace(ctx, entry.Namespace())
+		if err := c.disableCredentialInternal(nsCtx, entry.Path, MountTableNoUpdateStorage); err != nil {
+			return errwrap.Wrapf("failed to disable auth method "+entry.Path+": {{err}}", err)
+		}
+	}
+	for _, entry := range added {
+		nsCtx, err := c.mountEntryNamespaceContext(ctx, entry)
+		if err != nil {
+			return err
+		}
+		if err := c.enableCredentialInternal(nsCtx, entry, MountTableNoUpdateStorage); err != nil {
+			return errwrap.Wrapf("failed to enable auth method "+entry.Path+": {{err}}", err)
+		}
+	}
+
+	return nil
+}
+
+// readStoredMountTable reads the shared and local tables stored at the given
+// paths and returns their combined entries.
+func (c *Core) readStoredMountTable(ctx context.Context, path, localPath string) ([]*MountEntry, error) {
+	var entries []*MountEntry
+	for _, p := range []string{path, localPath} {
+		raw, err := c.barrier.Get(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		if raw == nil {
+			continue
+		}
+		table, err := c.decodeMountTable(ctx, raw.Value)
+		if err != nil {
+			return nil, err
+		}
+		if table != nil {
+			entries = append(entries, table.Entries...)
+		}
+	}
+	return entries, nil
+}
+
+// mountEntryNamespaceContext returns ctx carrying the namespace a stored
+// entry belongs to.
+func (c *Core) mountEntryNamespaceContext(ctx context.Context, entry *MountEntry) (context.Context, error) {
+	nsID := entry.NamespaceID
+	if nsID == "" {
+		nsID = namespace.RootNamespaceID
+	}
+	ns, err := NamespaceByID(ctx, nsID, c)
+	if err != nil {
+		return nil, err
+	}
+	if ns == nil {
+		return nil, namespace.ErrNoNamespace
+	}
+	return namespace.ContextWithNamespace(ctx, ns), nil
+}
+
+// diffMountEntries matches loaded and stored entries by UUID and returns the
+// stored entries that need mounting and the loaded entries that need
+// unmounting. An entry that moved to a new path shows up in both. Tainted
+// entries are being unmounted, so they are treated as gone from storage and
+// left alone in memory.
+func diffMountEntries(loaded, stored []*MountEntry) (added, removed []*MountEntry) {
+	sameMount := func(a, b *MountEntry) bool {
+		aNS, bNS := a.NamespaceID, b.NamespaceID
+		if aNS == "" {
+			aNS = namespace.RootNamespaceID
+		}
+		if bNS == "" {
+			bNS = namespace.RootNamespaceID
+		}
+		return a.Path == b.Path && aNS == bNS
+	}
+
+	loadedByUUID := make(map[string]*MountEntry, len(loaded))
+	for _, entry := range loaded {
+		loadedByUUID[entry.UUID] = entry
+	}
+	storedByUUID := make(map[string]*MountEntry, len(stored))
+	for _, entry := range stored {
+		if entry.Tainted {
+			continue
+		}
+		storedByUUID[entry.UUID] = entry
+	}
+
+	for _, entry := range loaded {
+		// Already on its way out
+		if entry.Tainted {
+			continue
+		}
+		if s, ok := storedByUUID[entry.UUID]; !ok || !sameMount(entry, s) {
+			removed = append(removed, entry)
+		}
+	}
+	for _, entry := range stored {
+		if entry.Tainted {
+			continue
+		}
+		if l, ok := loadedByUUID[entry.UUID]; !ok || !sameMount(entry, l) {
+			added = append(added, entry)
+		}
+	}
+	return added, removed
+}
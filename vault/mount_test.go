@@ -4,15 +4,20 @@ import (
 	"context"
 	"encoding/json"
 	"reflect"
+	"sort"
 	"strings"
 	"testing"
 	"time"
 
+	log "github.com/hashicorp/go-hclog"
+
 	"github.com/hashicorp/vault/audit"
 	"github.com/hashicorp/vault/helper/compressutil"
 	"github.com/hashicorp/vault/helper/jsonutil"
+	"github.com/hashicorp/vault/helper/logging"
 	"github.com/hashicorp/vault/helper/namespace"
 	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/physical/inmem"
 )
 
 func TestMount_ReadOnlyViewDuringMount(t *testing.T) {
@@ -332,6 +337,236 @@ func TestCore_Unmount_Cleanup(t *testing.T) {
 	}
 }
 
+func TestCore_MountUnmount_Runtime(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+
+	me := &MountEntry{
+		Path: "runtime",
+		Type: "kv",
+	}
+	if err := c.Mount(context.Background(), me); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if match := c.router.MatchingMount(namespace.RootContext(nil), "runtime/foo"); match != "runtime/" {
+		t.Fatalf("missing mount, got: %q", match)
+	}
+	if !testMountTableHasPath(c, "runtime/") {
+		t.Fatalf("missing mount table entry")
+	}
+
+	// Write through the new mount
+	req := logical.TestRequest(t, logical.UpdateOperation, "runtime/foo")
+	req.Data["value"] = "bar"
+	req.ClientToken = root
+	if _, err := c.HandleRequest(namespace.RootContext(nil), req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	view := c.router.MatchingStorageByAPIPath(namespace.RootContext(nil), "runtime/")
+	out, err := logical.CollectKeys(context.Background(), view)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(out) == 0 {
+		t.Fatalf("expected data in mount view")
+	}
+
+	if err := c.Unmount(context.Background(), "runtime"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if match := c.router.MatchingMount(namespace.RootContext(nil), "runtime/foo"); match != "" {
+		t.Fatalf("backend present")
+	}
+	if testMountTableHasPath(c, "runtime/") {
+		t.Fatalf("mount table entry present")
+	}
+
+	// View should be empty
+	out, err = logical.CollectKeys(context.Background(), view)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(out) != 0 {
+		t.Fatalf("bad: %#v", out)
+	}
+
+	if err := c.Unmount(context.Background(), "runtime"); err == nil {
+		t.Fatalf("expected error unmounting missing mount")
+	}
+
+	// Unmounting revokes the leases issued through the mount
+	noop := &NoopBackend{
+		Response: &logical.Response{
+			Secret: &logical.Secret{
+				LeaseOptions: logical.LeaseOptions{
+					TTL: time.Hour,
+				},
+			},
+		},
+	}
+	c.logicalBackends["noop"] = func(context.Context, *logical.BackendConfig) (logical.Backend, error) {
+		return noop, nil
+	}
+	if err := c.Mount(context.Background(), &MountEntry{Path: "leased", Type: "noop"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	req = logical.TestRequest(t, logical.ReadOperation, "leased/foo")
+	req.ClientToken = root
+	resp, err := c.HandleRequest(namespace.RootContext(nil), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp == nil || resp.Secret == nil || resp.Secret.LeaseID == "" {
+		t.Fatalf("expected a lease, got: %#v", resp)
+	}
+	leaseID := resp.Secret.LeaseID
+	if le, err := c.expiration.FetchLeaseTimes(namespace.RootContext(nil), leaseID); err != nil || le == nil {
+		t.Fatalf("expected lease to be registered, got: %#v, %v", le, err)
+	}
+
+	if err := c.Unmount(context.Background(), "leased"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	revoked := false
+	for _, r := range noop.Requests {
+		if r.Operation == logical.RevokeOperation && r.Path == "foo" {
+			revoked = true
+		}
+	}
+	if !revoked {
+		t.Fatalf("expected the lease to be revoked by the backend, got: %#v", noop.Requests)
+	}
+	if le, err := c.expiration.FetchLeaseTimes(namespace.RootContext(nil), leaseID); err != nil || le != nil {
+		t.Fatalf("expected lease to be gone, got: %#v, %v", le, err)
+	}
+}
+
+func TestCore_ResolvePath(t *testing.T) {
+	c, _, _ := TestCoreUnsealed(t)
+
+	for _, path := range []string{"app", "apps"} {
+		me := &MountEntry{
+			Path: path,
+			Type: "kv",
+		}
+		if err := c.Mount(context.Background(), me); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	cases := []struct {
+		path        string
+		mount       string
+		backendType string
+	}{
+		{"auth/token/lookup-self", "auth/token/", "token"},
+		{"auth/token/", "auth/token/", "token"},
+		{"app/foo", "app/", "kv"},
+		{"apps/foo", "apps/", "kv"},
+		{"sys/mounts", "sys/", "system"},
+	}
+	for _, tc := range cases {
+		mount, backendType, err := c.ResolvePath(tc.path)
+		if err != nil {
+			t.Fatalf("%s: err: %v", tc.path, err)
+		}
+		if mount != tc.mount || backendType != tc.backendType {
+			t.Fatalf("%s: bad: mount %q, type %q", tc.path, mount, backendType)
+		}
+	}
+
+	if _, _, err := c.ResolvePath("missing/foo"); err == nil {
+		t.Fatalf("expected error resolving unmounted path")
+	}
+}
+
+func TestCore_ListMounts(t *testing.T) {
+	c, _, _ := TestCoreUnsealed(t)
+
+	me := &MountEntry{
+		Table: mountTableType,
+		Path:  "foo",
+		Type:  "generic",
+	}
+	if err := c.Mount(namespace.RootContext(nil), me); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	paths := func(entries []*MountEntry) []string {
+		var out []string
+		for _, entry := range entries {
+			out = append(out, entry.Table+":"+entry.Path)
+		}
+		sort.Strings(out)
+		return out
+	}
+
+	cases := []struct {
+		filter   MountFilter
+		expected []string
+	}{
+		{
+			MountFilter{},
+			[]string{"auth:token/", "mounts:cubbyhole/", "mounts:foo/", "mounts:identity/", "mounts:secret/", "mounts:sys/"},
+		},
+		{
+			MountFilter{Table: credentialTableType},
+			[]string{"auth:token/"},
+		},
+		{
+			MountFilter{Type: "token"},
+			[]string{"auth:token/"},
+		},
+		{
+			MountFilter{Type: "generic"},
+			[]string{"mounts:foo/"},
+		},
+		{
+			MountFilter{Table: credentialTableType, Type: "generic"},
+			nil,
+		},
+	}
+	for _, tc := range cases {
+		entries, err := c.ListMounts(tc.filter)
+		if err != nil {
+			t.Fatalf("%#v: err: %v", tc.filter, err)
+		}
+		if actual := paths(entries); !reflect.DeepEqual(actual, tc.expected) {
+			t.Fatalf("%#v: bad: expected %v, got %v", tc.filter, tc.expected, actual)
+		}
+	}
+
+	// The entries returned are copies
+	entries, err := c.ListMounts(MountFilter{Type: "generic"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	entries[0].Description = "changed"
+	entries[0].Config.DefaultLeaseTTL = time.Hour
+	me = c.router.MatchingMountEntry(namespace.RootContext(nil), "foo/")
+	if me.Description == "changed" || me.Config.DefaultLeaseTTL == time.Hour {
+		t.Fatal("expected the mount table entry to be left unchanged")
+	}
+	if entries[0].Namespace() == nil || entries[0].APIPath() != "foo/" {
+		t.Fatalf("expected the copy to keep its namespace, got %#v", entries[0].Namespace())
+	}
+
+	if _, err := c.ListMounts(MountFilter{Table: "bogus"}); err == nil {
+		t.Fatal("expected error for an unknown table")
+	}
+}
+
+func testMountTableHasPath(c *Core, path string) bool {
+	c.mountsLock.RLock()
+	defer c.mountsLock.RUnlock()
+	for _, entry := range c.mounts.Entries {
+		if entry.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
 func TestCore_Remount(t *testing.T) {
 	c, keys, _ := TestCoreUnsealed(t)
 	err := c.remount(namespace.RootContext(nil), "secret", "foo")
@@ -695,3 +930,545 @@ func TestSingletonMountTableFunc(t *testing.T) {
 		t.Fatal("unexpected entry type for auth")
 	}
 }
+
+func TestCore_ReloadMounts(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+	ctx := namespace.RootContext(nil)
+
+	// Write new entries to storage behind the router's back, as another
+	// node would
+	mountEntry := &MountEntry{
+		Table:            mountTableType,
+		Path:             "reloaded/",
+		Type:             "kv",
+		UUID:             "3c8ba4b5-d8a0-4a4c-8f3a-6d3c7b2b9b9a",
+		Accessor:         "kv_reloaded",
+		BackendAwareUUID: "f6f0d6c6-6a5e-4d6b-9b1f-2c0c1f0e3a11",
+		NamespaceID:      namespace.RootNamespaceID,
+	}
+	authEntry := &MountEntry{
+		Table:            credentialTableType,
+		Path:             "reloaded/",
+		Type:             "noop",
+		UUID:             "9a1d5c1e-2f43-4b7c-8d6e-0b5c0e4f2d77",
+		Accessor:         "auth_noop_reloaded",
+		BackendAwareUUID: "0e7c2b5d-3a9f-4f1e-b8c2-5d6a7e8f9a01",
+		NamespaceID:      namespace.RootNamespaceID,
+	}
+
+	c.mountsLock.RLock()
+	origMounts := c.mounts.shallowClone()
+	c.mountsLock.RUnlock()
+	c.authLock.RLock()
+	origAuth := c.auth.shallowClone()
+	c.authLock.RUnlock()
+
+	mounts := origMounts.shallowClone()
+	mounts.Entries = append(mounts.Entries, mountEntry)
+	if err := c.persistMounts(ctx, mounts, nil); err != nil {
+		t.Fatal(err)
+	}
+	auth := origAuth.shallowClone()
+	auth.Entries = append(auth.Entries, authEntry)
+	if err := c.persistAuth(ctx, auth, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if match := c.router.MatchingMount(ctx, "reloaded/foo"); match != "" {
+		t.Fatalf("mount should not be routable yet, matched %q", match)
+	}
+
+	if err := c.ReloadMounts(); err != nil {
+		t.Fatal(err)
+	}
+
+	if match := c.router.MatchingMount(ctx, "reloaded/foo"); match != "reloaded/" {
+		t.Fatalf("missing mount, matched %q", match)
+	}
+	if match := c.router.MatchingMount(ctx, "auth/reloaded/login"); match != "auth/reloaded/" {
+		t.Fatalf("missing auth mount, matched %q", match)
+	}
+
+	// The new mount is usable
+	req := &logical.Request{
+		Operation:   logical.UpdateOperation,
+		Path:        "reloaded/foo",
+		ClientToken: root,
+		Data: map[string]interface{}{
+			"bar": "baz",
+		},
+	}
+	if _, err := c.HandleRequest(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	req = &logical.Request{
+		Operation:   logical.ReadOperation,
+		Path:        "reloaded/foo",
+		ClientToken: root,
+	}
+	resp, err := c.HandleRequest(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.Data["bar"] != "baz" {
+		t.Fatalf("bad: %#v", resp)
+	}
+
+	// Reloading again without changes is a no-op
+	if err := c.ReloadMounts(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Removing the entries from storage unmounts them
+	if err := c.persistMounts(ctx, origMounts, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.persistAuth(ctx, origAuth, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ReloadMounts(); err != nil {
+		t.Fatal(err)
+	}
+
+	if match := c.router.MatchingMount(ctx, "reloaded/foo"); match != "" {
+		t.Fatalf("mount should have been removed, matched %q", match)
+	}
+	if match := c.router.MatchingMount(ctx, "auth/reloaded/login"); match != "" {
+		t.Fatalf("auth mount should have been removed, matched %q", match)
+	}
+}
+
+func TestCore_ReloadMounts_Standby(t *testing.T) {
+	cluster := NewTestCluster(t, nil, nil)
+	cluster.Start()
+	defer cluster.Cleanup()
+
+	TestWaitActive(t, cluster.Cores[0].Core)
+
+	if err := cluster.Cores[1].ReloadMounts(); err != ErrReloadMountsStandby {
+		t.Fatalf("expected %v, got %v", ErrReloadMountsStandby, err)
+	}
+	if err := cluster.Cores[0].ReloadMounts(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCore_ApplyMountTable(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+	ctx := namespace.RootContext(nil)
+
+	write := func(path string) {
+		t.Helper()
+		req := &logical.Request{
+			Operation:   logical.UpdateOperation,
+			Path:        path,
+			ClientToken: root,
+			Data: map[string]interface{}{
+				"bar": "baz",
+			},
+		}
+		if _, err := c.HandleRequest(ctx, req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func(path string) *logical.Response {
+		t.Helper()
+		req := &logical.Request{
+			Operation:   logical.ReadOperation,
+			Path:        path,
+			ClientToken: root,
+		}
+		resp, err := c.HandleRequest(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	noop := &NoopBackend{
+		Response: &logical.Response{
+			Secret: &logical.Secret{
+				LeaseOptions: logical.LeaseOptions{
+					TTL: time.Hour,
+				},
+			},
+		},
+	}
+	c.logicalBackends["noop"] = func(context.Context, *logical.BackendConfig) (logical.Backend, error) {
+		return noop, nil
+	}
+
+	for path, typ := range map[string]string{"keep/": "kv", "remove/": "noop", "modify/": "kv"} {
+		if err := c.mount(ctx, &MountEntry{Table: mountTableType, Path: path, Type: typ}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("keep/foo")
+	write("modify/foo")
+	leaseID := testLeaseRevocationRead(t, c, root, "remove/foo")
+
+	snapshot, err := c.ListMounts(MountFilter{Table: mountTableType})
+	if err != nil {
+		t.Fatal(err)
+	}
+	applied := snapshot[:0]
+	for _, entry := range snapshot {
+		switch entry.Path {
+		case "remove/":
+			continue
+		case "modify/":
+			entry.Description = "modified"
+		}
+		applied = append(applied, entry)
+	}
+	applied = append(applied, &MountEntry{
+		Table:            mountTableType,
+		Path:             "added/",
+		Type:             "kv",
+		UUID:             "5b1f0c3e-8d2a-4e6b-9c7d-1a2b3c4d5e6f",
+		Accessor:         "kv_added",
+		BackendAwareUUID: "7e6d5c4b-3a2f-4e1d-8c9b-0a1b2c3d4e5f",
+		NamespaceID:      namespace.RootNamespaceID,
+	})
+
+	// Moving a mount would leave its leases behind, so it is refused
+	var moved []*MountEntry
+	for _, entry := range applied {
+		if entry.Path == "modify/" {
+			cp, err := entry.Clone()
+			if err != nil {
+				t.Fatal(err)
+			}
+			cp.Path = "modified/"
+			entry = cp
+		}
+		moved = append(moved, entry)
+	}
+	if err := c.ApplyMountTable(moved); err == nil {
+		t.Fatal("expected error moving a mount")
+	}
+	if match := c.router.MatchingMount(ctx, "remove/foo"); match != "remove/" {
+		t.Fatalf("mount table changed by a refused snapshot: %q", match)
+	}
+
+	if err := c.ApplyMountTable(applied); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, expected := range map[string]string{
+		"keep/foo":   "keep/",
+		"remove/foo": "",
+		"modify/foo": "modify/",
+		"added/foo":  "added/",
+		"secret/foo": "secret/",
+		"sys/mounts": "sys/",
+	} {
+		if match := c.router.MatchingMount(ctx, path); match != expected {
+			t.Fatalf("expected %q to match %q, matched %q", path, expected, match)
+		}
+	}
+
+	// Data stays with the mount, wherever it is now
+	if resp := read("keep/foo"); resp == nil || resp.Data["bar"] != "baz" {
+		t.Fatalf("bad: %#v", resp)
+	}
+	if resp := read("modify/foo"); resp == nil || resp.Data["bar"] != "baz" {
+		t.Fatalf("bad: %#v", resp)
+	}
+	if entry := c.router.MatchingMountEntry(ctx, "modify/foo"); entry == nil || entry.Description != "modified" {
+		t.Fatalf("bad: %#v", entry)
+	}
+	write("added/foo")
+
+	// The leases of the removed mount were revoked
+	if le, err := c.expiration.loadEntry(ctx, leaseID); err != nil || le != nil {
+		t.Fatalf("lease of removed mount was kept: %#v, %v", le, err)
+	}
+	noop.Lock()
+	var revoked bool
+	for _, req := range noop.Requests {
+		if req.Operation == logical.RevokeOperation {
+			revoked = true
+		}
+	}
+	noop.Unlock()
+	if !revoked {
+		t.Fatal("lease of removed mount was not revoked with its backend")
+	}
+
+	// The new table is persisted
+	stored, err := c.readStoredMountTable(ctx, coreMountConfigPath, coreLocalMountConfigPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.mountsLock.RLock()
+	loaded := c.mounts.shallowClone().Entries
+	c.mountsLock.RUnlock()
+	if added, removed := diffMountSnapshot(loaded, stored); len(added) != 0 || len(removed) != 0 {
+		t.Fatalf("stored table differs: added %d, removed %d", len(added), len(removed))
+	}
+
+	// Applying the same snapshot again is a no-op
+	if err := c.ApplyMountTable(applied); err != nil {
+		t.Fatal(err)
+	}
+
+	// A snapshot that can't be applied in full changes nothing
+	bad := append([]*MountEntry{}, applied[:len(applied)-1]...)
+	bad = append(bad, &MountEntry{
+		Table:    mountTableType,
+		Path:     "bogus/",
+		Type:     "nonexistent",
+		UUID:     "0f1e2d3c-4b5a-4968-8776-5d4c3b2a1f0e",
+		Accessor: "nonexistent_bogus",
+	})
+	if err := c.ApplyMountTable(bad); err == nil {
+		t.Fatal("expected error")
+	}
+	for path, expected := range map[string]string{
+		"added/foo": "added/",
+		"bogus/foo": "",
+	} {
+		if match := c.router.MatchingMount(ctx, path); match != expected {
+			t.Fatalf("expected %q to match %q, matched %q", path, expected, match)
+		}
+	}
+	if resp := read("added/foo"); resp == nil || resp.Data["bar"] != "baz" {
+		t.Fatalf("bad: %#v", resp)
+	}
+}
+
+func TestCore_ApplyMountTable_PersistFailure(t *testing.T) {
+	inm, err := inmem.NewInmem(nil, logging.NewVaultLogger(log.Trace))
+	if err != nil {
+		t.Fatal(err)
+	}
+	noop := &NoopBackend{
+		Response: &logical.Response{
+			Secret: &logical.Secret{
+				LeaseOptions: logical.LeaseOptions{
+					TTL: time.Hour,
+				},
+			},
+		},
+	}
+	c, err := NewCore(&CoreConfig{
+		Physical:     inm,
+		DisableMlock: true,
+		LogicalBackends: map[string]logical.Factory{
+			"noop": func(context.Context, *logical.BackendConfig) (logical.Backend, error) {
+				return noop, nil
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, root := testCoreUnsealed(t, c)
+	ctx := namespace.RootContext(nil)
+
+	if err := c.mount(ctx, &MountEntry{Table: mountTableType, Path: "remove/", Type: "noop"}); err != nil {
+		t.Fatal(err)
+	}
+	leaseID := testLeaseRevocationRead(t, c, root, "remove/foo")
+
+	snapshot, err := c.ListMounts(MountFilter{Table: mountTableType})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var applied []*MountEntry
+	for _, entry := range snapshot {
+		if entry.Path != "remove/" {
+			applied = append(applied, entry)
+		}
+	}
+
+	// If the new table can't be stored nothing changes, and the leases of
+	// the mount that would have been removed are kept
+	inm.(*inmem.InmemBackend).FailPut(true)
+	err = c.ApplyMountTable(applied)
+	inm.(*inmem.InmemBackend).FailPut(false)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	if match := c.router.MatchingMount(ctx, "remove/foo"); match != "remove/" {
+		t.Fatalf("mount removed despite the failure: %q", match)
+	}
+	if le, err := c.expiration.loadEntry(ctx, leaseID); err != nil || le == nil {
+		t.Fatalf("lease revoked despite the failure: %#v, %v", le, err)
+	}
+	noop.Lock()
+	defer noop.Unlock()
+	for _, req := range noop.Requests {
+		if req.Operation == logical.RevokeOperation {
+			t.Fatal("backend saw a revocation despite the failure")
+		}
+	}
+}
+
+func TestCore_MountMetadata(t *testing.T) {
+	c, keys, root := TestCoreUnsealed(t)
+	ctx := namespace.RootContext(nil)
+
+	metadata := map[string]string{
+		"team":        "payments",
+		"environment": "prod",
+	}
+
+	req := logical.TestRequest(t, logical.UpdateOperation, "sys/mounts/labeled")
+	req.ClientToken = root
+	req.Data["type"] = "kv"
+	req.Data["metadata"] = metadata
+	if _, err := c.HandleRequest(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+
+	req = logical.TestRequest(t, logical.UpdateOperation, "sys/auth/labeled")
+	req.ClientToken = root
+	req.Data["type"] = "noop"
+	req.Data["metadata"] = metadata
+	if _, err := c.HandleRequest(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"sys/mounts", "sys/auth"} {
+		req = logical.TestRequest(t, logical.ReadOperation, path)
+		req.ClientToken = root
+		resp, err := c.HandleRequest(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, ok := resp.Data["labeled/"].(map[string]interface{})
+		if !ok {
+			t.Fatalf("%s: missing labeled mount: %#v", path, resp.Data)
+		}
+		if !reflect.DeepEqual(info["metadata"], metadata) {
+			t.Fatalf("%s: bad metadata: %#v", path, info["metadata"])
+		}
+
+		// Mounts without metadata don't report any
+		for mountPath, raw := range resp.Data {
+			if mountPath == "labeled/" {
+				continue
+			}
+			if _, ok := raw.(map[string]interface{})["metadata"]; ok {
+				t.Fatalf("%s: unexpected metadata on %s", path, mountPath)
+			}
+		}
+	}
+
+	// The metadata survives the round trip through the stored tables
+	c2, err := NewCore(&CoreConfig{
+		Physical:           c.physical,
+		LogicalBackends:    c.logicalBackends,
+		CredentialBackends: c.credentialBackends,
+		BuiltinRegistry:    NewMockBuiltinRegistry(),
+		DisableMlock:       true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range keys {
+		if _, err := TestCoreUnseal(c2, TestKeyCopy(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, table := range []*MountTable{c2.mounts, c2.auth} {
+		var found bool
+		for _, entry := range table.Entries {
+			if entry.Path != "labeled/" {
+				continue
+			}
+			found = true
+			if !reflect.DeepEqual(entry.Metadata, metadata) {
+				t.Fatalf("bad metadata in %s table: %#v", table.Type, entry.Metadata)
+			}
+		}
+		if !found {
+			t.Fatalf("missing labeled entry in %s table", table.Type)
+		}
+	}
+}
+
+func TestCore_Mount_NodeLocal(t *testing.T) {
+	c, keys, root := TestCoreUnsealedWithConfig(t, &CoreConfig{
+		NodeID: "node-a",
+	})
+	ctx := namespace.RootContext(nil)
+
+	req := logical.TestRequest(t, logical.UpdateOperation, "sys/mounts/cache")
+	req.ClientToken = root
+	req.Data["type"] = "kv"
+	req.Data["node_local"] = true
+	if _, err := c.HandleRequest(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+
+	entry := c.router.MatchingMountEntry(ctx, "cache/")
+	if entry == nil || !entry.NodeLocal || !entry.Local {
+		t.Fatalf("bad: %#v", entry)
+	}
+	if viewPath := entry.ViewPath(); !strings.HasPrefix(viewPath, nodeBarrierPrefix+"node-a/") {
+		t.Fatalf("bad view path: %s", viewPath)
+	}
+
+	write := func(c *Core, value string) {
+		t.Helper()
+		req := logical.TestRequest(t, logical.UpdateOperation, "cache/foo")
+		req.ClientToken = root
+		req.Data["value"] = value
+		if _, err := c.HandleRequest(ctx, req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func(c *Core) interface{} {
+		t.Helper()
+		req := logical.TestRequest(t, logical.ReadOperation, "cache/foo")
+		req.ClientToken = root
+		resp, err := c.HandleRequest(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp == nil {
+			return nil
+		}
+		return resp.Data["value"]
+	}
+
+	write(c, "from-a")
+
+	// A second node sharing the storage sees the mount but not its data
+	c2, err := NewCore(&CoreConfig{
+		Physical:           c.physical,
+		LogicalBackends:    c.logicalBackends,
+		CredentialBackends: c.credentialBackends,
+		BuiltinRegistry:    NewMockBuiltinRegistry(),
+		DisableMlock:       true,
+		NodeID:             "node-b",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range keys {
+		if _, err := TestCoreUnseal(c2, TestKeyCopy(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if match := c2.router.MatchingMount(ctx, "cache/foo"); match != "cache/" {
+		t.Fatalf("missing mount on second node, matched %q", match)
+	}
+	if value := read(c2); value != nil {
+		t.Fatalf("second node should not see first node's data, got %v", value)
+	}
+
+	write(c2, "from-b")
+	if value := read(c); value != "from-a" {
+		t.Fatalf("bad value on first node: %v", value)
+	}
+	if value := read(c2); value != "from-b" {
+		t.Fatalf("bad value on second node: %v", value)
+	}
+}
@@ -29,6 +29,9 @@ func (e *MountEntry) ViewPath() string {
 
 	switch e.Table {
 	case mountTableType:
+		if e.NodeLocal {
+			return nodeBarrierPrefix + e.nodeID + "/" + backendBarrierPrefix + e.UUID + "/"
+		}
 		return backendBarrierPrefix + e.UUID + "/"
 	case credentialTableType:
 		return credentialBarrierPrefix + e.UUID + "/"
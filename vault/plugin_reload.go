@@ -184,8 +184,8 @@ func (c *Core) reloadBackendCommon(ctx context.Context, entry *MountEntry, isAut
 		// Set paths as well
 		paths := backend.SpecialPaths()
 		if paths != nil {
-			re.rootPaths.Store(pathsToRadix(paths.Root))
-			re.loginPaths.Store(pathsToRadix(paths.Unauthenticated))
+			re.rootPaths.Store(newSpecialPaths(paths.Root))
+			re.loginPaths.Store(newSpecialPaths(paths.Unauthenticated))
 		}
 	}
 
@@ -4,28 +4,81 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
 	math "math"
+	"math/rand"
 	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	cache "github.com/patrickmn/go-cache"
 
+	"github.com/hashicorp/errwrap"
 	uuid "github.com/hashicorp/go-uuid"
 	"github.com/hashicorp/vault/helper/consts"
 	"github.com/hashicorp/vault/helper/forwarding"
+	"github.com/hashicorp/vault/helper/proxyutil"
 	"golang.org/x/net/http2"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 const (
 	clusterListenerAcceptDeadline = 500 * time.Millisecond
 
+	// defaultClusterListenerBindTimeout is how long binding a cluster
+	// listener is retried while its address is in use, if not set in
+	// CoreConfig
+	defaultClusterListenerBindTimeout = 10 * time.Second
+
+	// forwardedRequestIdempotencyWindow is how long the active node
+	// remembers the response to a forwarded request, answering retries of it
+	// with that response rather than handling them again
+	forwardedRequestIdempotencyWindow = time.Minute
+
+	// forwardedRequestIdempotencyMaxEntries is the most forwarded requests
+	// the active node remembers at once. Requests beyond that are handled
+	// without being remembered.
+	forwardedRequestIdempotencyMaxEntries = 4096
+
+	// defaultMaxClusterListeners is the most cluster listeners that are
+	// started, if not set in CoreConfig
+	defaultMaxClusterListeners = 16
+
+	// clusterListenerBindBackoff and clusterListenerBindMaxBackoff are the
+	// first and longest waits between attempts to bind a cluster listener
+	clusterListenerBindBackoff    = 10 * time.Millisecond
+	clusterListenerBindMaxBackoff = time.Second
+
+	// defaultForwardingReconnectBaseDelay and
+	// defaultForwardingReconnectMaxDelay are the first and longest waits
+	// between attempts to reconnect a lost forwarding connection, if not set
+	// in CoreConfig
+	defaultForwardingReconnectBaseDelay = 500 * time.Millisecond
+	defaultForwardingReconnectMaxDelay  = 30 * time.Second
+
+	// forwardingReconnectJitter is the fraction by which each reconnect wait
+	// is randomly lengthened or shortened, so that standbys don't reconnect
+	// in lockstep
+	forwardingReconnectJitter = 0.2
+
+	// forwardingReconnectDialTimeout bounds each reconnect attempt
+	forwardingReconnectDialTimeout = 5 * time.Second
+
+	// untrustedPeerRetryInterval is the least time between reloads of the
+	// cluster TLS information to retry forwarding to an untrusted active node
+	untrustedPeerRetryInterval = 10 * time.Second
+
 	// PerformanceReplicationALPN is the negotiated protocol used for
 	// performance replication.
 	PerformanceReplicationALPN = "replication_v1"
@@ -37,6 +90,14 @@ const (
 	perfStandbyALPN = "perf_standby_v1"
 
 	requestForwardingALPN = "req_fw_sb-act_v1"
+
+	// forwardingClusterIDMetadataKey is the gRPC metadata key carrying the
+	// cluster ID of each end of a forwarded request
+	forwardingClusterIDMetadataKey = "vault-cluster-id"
+
+	// forwardingNodeIDMetadataKey is the gRPC metadata key carrying the node
+	// ID of the node that forwarded a request
+	forwardingNodeIDMetadataKey = "vault-node-id"
 )
 
 var (
@@ -89,7 +150,7 @@ func (c *Core) startForwarding(ctx context.Context) error {
 	}
 
 	// The server supports all of the possible protos
-	tlsConfig.NextProtos = []string{"h2", requestForwardingALPN, perfStandbyALPN, PerformanceReplicationALPN, DRReplicationALPN}
+	tlsConfig.NextProtos = []string{"h2", requestForwardingALPN, upgradeForwardingALPN, healthForwardingALPN, perfStandbyALPN, PerformanceReplicationALPN, DRReplicationALPN}
 
 	if !atomic.CompareAndSwapUint32(c.rpcServerActive, 0, 1) {
 		c.logger.Warn("forwarding rpc server already running")
@@ -125,13 +186,12 @@ func (c *Core) startForwarding(ctx context.Context) error {
 	perfStandbyReplicationRPCServer := perfStandbyRPCServer(c, perfStandbyCache)
 
 	if ha && c.clusterHandler != nil {
-		RegisterRequestForwardingServer(fwRPCServer, &forwardedRequestRPCServer{
-			core:                  c,
-			handler:               c.clusterHandler,
-			perfStandbySlots:      perfStandbySlots,
-			perfStandbyRepCluster: perfStandbyRepCluster,
-			perfStandbyCache:      perfStandbyCache,
-		})
+		fwServer := newForwardedRequestRPCServer(ctx, c, c.clusterHandler)
+		fwServer.perfStandbySlots = perfStandbySlots
+		fwServer.perfStandbyRepCluster = perfStandbyRepCluster
+		fwServer.perfStandbyCache = perfStandbyCache
+		RegisterRequestForwardingServer(fwRPCServer, fwServer)
+		registerLogicalForwardingServer(fwRPCServer, fwServer)
 	}
 
 	// Create the HTTP/2 server that will be shared by both RPC and regular
@@ -148,7 +208,7 @@ func (c *Core) startForwarding(ctx context.Context) error {
 	shutdown := new(uint32)
 	shutdownWg := &sync.WaitGroup{}
 
-	for _, addr := range c.clusterListenerAddrs {
+	for _, addr := range c.clusterBindAddrs() {
 		shutdownWg.Add(1)
 
 		// Force a local resolution to avoid data races
@@ -171,14 +231,25 @@ func (c *Core) startForwarding(ctx context.Context) error {
 
 			// Create a TCP listener. We do this separately and specifically
 			// with TCP so that we can set deadlines.
-			tcpLn, err := net.ListenTCP("tcp", laddr)
+			tcpLn, err := listenTCPWithRetry(laddr, c.clusterListenerBindTimeout)
 			if err != nil {
 				c.logger.Error("error starting listener", "error", err)
 				return
 			}
+			boundAddr := tcpLn.Addr()
+			c.addClusterListenerBoundAddr(boundAddr)
+			defer c.removeClusterListenerBoundAddr(boundAddr)
+
+			// Recover the peer's own address from a PROXY protocol header
+			// sent by an authorized load balancer, if configured, before
+			// the TLS handshake reads anything
+			var ln net.Listener = tcpLn
+			if c.clusterProxyProtocol {
+				ln = proxyutil.NewProxyProtoListener(tcpLn, c.clusterProxyAuthorizedAddrs)
+			}
 
 			// Wrap the listener with TLS
-			tlsLn := tls.NewListener(tcpLn, tlsConfig)
+			tlsLn := tls.NewListener(ln, tlsConfig)
 			defer tlsLn.Close()
 
 			if c.logger.IsInfo() {
@@ -253,7 +324,7 @@ func (c *Core) startForwarding(ctx context.Context) error {
 						continue
 					}
 
-					c.logger.Debug("got request forwarding connection")
+					c.logger.Debug("got request forwarding connection", "remote_addr", tlsConn.RemoteAddr())
 
 					shutdownWg.Add(2)
 					// quitCh is used to close the connection and the second
@@ -270,7 +341,7 @@ func (c *Core) startForwarding(ctx context.Context) error {
 
 					go func() {
 						fws.ServeConn(tlsConn, &http2.ServeConnOpts{
-							Handler: fwRPCServer,
+							Handler: withConnTLSState(fwRPCServer, tlsConn.ConnectionState()),
 							BaseConfig: &http.Server{
 								ErrorLog: c.logger.StandardLogger(nil),
 							},
@@ -281,6 +352,24 @@ func (c *Core) startForwarding(ctx context.Context) error {
 						shutdownWg.Done()
 					}()
 
+				case upgradeForwardingALPN:
+					if !ha || c.clusterHandler == nil {
+						tlsConn.Close()
+						continue
+					}
+
+					c.logger.Debug("got upgrade forwarding connection")
+					c.serveUpgradeConn(tlsConn, shutdownWg, closeCh)
+
+				case healthForwardingALPN:
+					if !ha || c.clusterHandler == nil {
+						tlsConn.Close()
+						continue
+					}
+
+					c.logger.Debug("got health check forwarding connection", "remote_addr", tlsConn.RemoteAddr())
+					c.serveHealthForwardingConn(tlsConn, shutdownWg, closeCh)
+
 				case PerformanceReplicationALPN, DRReplicationALPN, perfStandbyALPN:
 					handleReplicationConn(ctx, c, shutdownWg, closeCh, fws, perfStandbyReplicationRPCServer, perfStandbyCache, tlsConn)
 				default:
@@ -343,6 +432,13 @@ func (c *Core) refreshRequestForwardingConnection(ctx context.Context, clusterAd
 		return nil
 	}
 
+	return c.setupForwardingClients(ctx, clusterAddr)
+}
+
+// setupForwardingClients connects the forwarding client to the given cluster
+// address. It is assumed that the forwarding connection lock is held and
+// that any previous clients have been cleared.
+func (c *Core) setupForwardingClients(ctx context.Context, clusterAddr string) error {
 	clusterURL, err := url.Parse(clusterAddr)
 	if err != nil {
 		c.logger.Error("error parsing cluster address attempting to refresh forwarding connection", "error", err)
@@ -354,8 +450,30 @@ func (c *Core) refreshRequestForwardingConnection(ctx context.Context, clusterAd
 	// ALPN header right. It's just "insecure" because GRPC isn't managing
 	// the TLS state.
 	dctx, cancelFunc := context.WithCancel(ctx)
+	untrusted := new(uint32)
+	otherCluster := new(uint32)
+	dialer := c.getGRPCDialer(ctx, requestForwardingALPN, "", nil, nil, nil)
 	c.rpcClientConn, err = grpc.DialContext(dctx, clusterURL.Host,
-		grpc.WithDialer(c.getGRPCDialer(ctx, requestForwardingALPN, "", nil, nil, nil)),
+		grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
+			// gRPC only reports that the connection is unavailable, so
+			// remember whether it was the active node's cert that failed
+			conn, err := dialer(addr, timeout)
+			switch {
+			case err == nil:
+				atomic.StoreUint32(untrusted, 0)
+				// Nodes of another cluster answer with their own cluster ID
+				atomic.StoreUint32(otherCluster, 0)
+				if tlsConn, ok := conn.(*tls.Conn); ok {
+					if certs := tlsConn.ConnectionState().PeerCertificates; len(certs) > 0 && c.isClusterTrustedPeer(certs[0]) {
+						atomic.StoreUint32(otherCluster, 1)
+					}
+				}
+			case isClusterPeerUntrustedError(err):
+				c.logger.Warn("active node cluster certificate is not trusted", "error", err)
+				atomic.StoreUint32(untrusted, 1)
+			}
+			return conn, err
+		}),
 		grpc.WithInsecure(), // it's not, we handle it in the dialer
 		grpc.WithKeepaliveParams(keepalive.ClientParameters{
 			Time: 2 * HeartbeatInterval,
@@ -371,9 +489,14 @@ func (c *Core) refreshRequestForwardingConnection(ctx context.Context, clusterAd
 	}
 	c.rpcClientConnContext = dctx
 	c.rpcClientConnCancelFunc = cancelFunc
+	c.rpcClientClusterAddr = clusterAddr
 	c.rpcForwardingClient = &forwardingClient{
 		RequestForwardingClient: NewRequestForwardingClient(c.rpcClientConn),
 		core:                    c,
+		conn:                    c.rpcClientConn,
+		cert:                    c.localClusterParsedCert.Load().(*x509.Certificate),
+		untrusted:               untrusted,
+		otherCluster:            otherCluster,
 		echoTicker:              time.NewTicker(HeartbeatInterval),
 		echoContext:             dctx,
 	}
@@ -386,6 +509,10 @@ func (c *Core) clearForwardingClients() {
 	c.logger.Debug("clearing forwarding clients")
 	defer c.logger.Debug("done clearing forwarding clients")
 
+	if c.forwardingReconnectCancelFunc != nil {
+		c.forwardingReconnectCancelFunc()
+		c.forwardingReconnectCancelFunc = nil
+	}
 	if c.rpcClientConnCancelFunc != nil {
 		c.rpcClientConnCancelFunc()
 		c.rpcClientConnCancelFunc = nil
@@ -396,19 +523,203 @@ func (c *Core) clearForwardingClients() {
 	}
 
 	c.rpcClientConnContext = nil
+	c.rpcClientClusterAddr = ""
 	c.rpcForwardingClient = nil
 }
 
-// ForwardRequest forwards a given request to the active node and returns the
-// response.
-func (c *Core) ForwardRequest(req *http.Request) (int, http.Header, []byte, error) {
-	c.requestForwardingConnectionLock.RLock()
-	defer c.requestForwardingConnectionLock.RUnlock()
+// forwardingConnectionLost tears down the given forwarding connection, if it
+// is still the current one, and starts reconnecting it in the background.
+// Until that succeeds ForwardRequest fails fast with ErrCannotForward rather
+// than each request trying to reach the active node itself.
+func (c *Core) forwardingConnectionLost(conn *grpc.ClientConn) {
+	c.requestForwardingConnectionLock.Lock()
+	defer c.requestForwardingConnectionLock.Unlock()
 
-	if c.rpcForwardingClient == nil {
-		return 0, nil, nil, ErrCannotForward
+	// Someone else already handled it, or we've moved on to another node
+	if conn == nil || c.rpcClientConn != conn {
+		return
 	}
 
+	clusterAddr := c.rpcClientClusterAddr
+	c.logger.Warn("lost forwarding connection to active node, reconnecting in the background", "active_cluster_addr", clusterAddr)
+	c.clearForwardingClients()
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	c.forwardingReconnectCancelFunc = cancelFunc
+	go c.reconnectForwarding(ctx, clusterAddr)
+}
+
+// forwardingCertRotated replaces the given forwarding connection, if it is
+// still the current one, with a new connection to the same active node so
+// that the rotated local cluster cert is presented. Forwarded requests hold
+// the connection lock while in flight, so they finish on the old connection
+// and later ones start on the new one without any failing in between.
+func (c *Core) forwardingCertRotated(conn *grpc.ClientConn) {
+	c.requestForwardingConnectionLock.Lock()
+	defer c.requestForwardingConnectionLock.Unlock()
+
+	if conn == nil || c.rpcClientConn != conn {
+		return
+	}
+
+	clusterAddr := c.rpcClientClusterAddr
+	c.logger.Info("local cluster cert rotated, reconnecting forwarding connection", "active_cluster_addr", clusterAddr)
+	c.clearForwardingClients()
+
+	if err := c.setupForwardingClients(context.Background(), clusterAddr); err != nil {
+		c.logger.Warn("failed to reconnect forwarding connection after cert rotation, retrying in the background", "error", err)
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		c.forwardingReconnectCancelFunc = cancelFunc
+		go c.reconnectForwarding(ctx, clusterAddr)
+	}
+}
+
+// reconnectForwarding tries to reach the active node at the given cluster
+// address, waiting longer after each failed attempt, and sets the forwarding
+// client up again once it succeeds. It stops when ctx is canceled, which
+// happens whenever the forwarding clients are cleared.
+func (c *Core) reconnectForwarding(ctx context.Context, clusterAddr string) {
+	clusterURL, err := url.Parse(clusterAddr)
+	if err != nil {
+		c.logger.Error("error parsing cluster address attempting to reconnect forwarding connection", "error", err)
+		return
+	}
+	dialer := c.getGRPCDialer(ctx, requestForwardingALPN, "", nil, nil, nil)
+
+	for attempt := 0; ; attempt++ {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(c.forwardingReconnectDelay(attempt)):
+		}
+
+		// Check that the active node answers before handing the address
+		// back to gRPC
+		conn, err := dialer(clusterURL.Host, forwardingReconnectDialTimeout)
+		if err != nil {
+			c.logger.Debug("failed to reconnect forwarding connection", "attempt", attempt+1, "error", err)
+			continue
+		}
+		conn.Close()
+
+		c.requestForwardingConnectionLock.Lock()
+		if ctx.Err() != nil {
+			c.requestForwardingConnectionLock.Unlock()
+			return
+		}
+		c.forwardingReconnectCancelFunc()
+		c.forwardingReconnectCancelFunc = nil
+		err = c.setupForwardingClients(context.Background(), clusterAddr)
+		c.requestForwardingConnectionLock.Unlock()
+		if err != nil {
+			c.logger.Error("failed to set up forwarding connection after reconnecting", "error", err)
+			return
+		}
+
+		c.logger.Info("reconnected forwarding connection to active node", "active_cluster_addr", clusterAddr, "attempts", attempt+1)
+		return
+	}
+}
+
+// forwardingReconnectDelay returns how long to wait before the given attempt
+// to reconnect the forwarding connection, counting from zero
+func (c *Core) forwardingReconnectDelay(attempt int) time.Duration {
+	c.reloadableConfigLock.RLock()
+	base, max := c.forwardingReconnectBaseDelay, c.forwardingReconnectMaxDelay
+	c.reloadableConfigLock.RUnlock()
+
+	delay := float64(base) * math.Pow(2, float64(attempt))
+	if delay > float64(max) {
+		delay = float64(max)
+	}
+	delay *= 1 + forwardingReconnectJitter*(rand.Float64()*2-1)
+	return time.Duration(delay)
+}
+
+// TestForward sends a request through the forwarding connection to the
+// active node, which echoes it back without handling it, to check that
+// standby-to-active forwarding works end to end: request generation, the
+// mutually-authenticated connection, and the response coming back. It
+// returns nil on success and is only meaningful on a standby.
+func (c *Core) TestForward() error {
+	if c.ha == nil {
+		return ErrHANotEnabled
+	}
+	if c.Sealed() {
+		return consts.ErrSealed
+	}
+	if standby, err := c.Standby(); err != nil {
+		return err
+	} else if !standby {
+		return fmt.Errorf("forwarding can only be tested from a standby")
+	}
+
+	nonce, err := uuid.GenerateUUID()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("PUT", "/", strings.NewReader(nonce))
+	if err != nil {
+		return err
+	}
+	req.Header.Set(IntForwardingTestHeaderName, "true")
+	req = req.WithContext(context.WithValue(req.Context(), "original_request_path", req.URL.Path))
+
+	statusCode, _, body, err := c.ForwardRequest(req)
+	if err != nil {
+		return errwrap.Wrapf("error forwarding test request: {{err}}", err)
+	}
+	if statusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d for forwarded test request", statusCode)
+	}
+	if string(body) != nonce {
+		return fmt.Errorf("forwarded test request was not echoed back by the active node")
+	}
+
+	return nil
+}
+
+// ForwardedRequestRewriteFunc is given the scheme and host of a request that
+// is about to be forwarded to the active node and returns the ones to forward
+// it with instead.
+type ForwardedRequestRewriteFunc func(scheme, host string) (string, string)
+
+// rewriteForwardedRequest replaces the scheme and host of a forwarded request,
+// both in its URL and its Host header, leaving everything else untouched
+func rewriteForwardedRequest(freq *forwarding.Request, rewrite ForwardedRequestRewriteFunc) {
+	host := freq.Host
+	if host == "" {
+		host = freq.Url.Host
+	}
+	scheme, host := rewrite(freq.Url.Scheme, host)
+	freq.Url.Scheme = scheme
+	freq.Url.Host = host
+	freq.Host = host
+}
+
+// withConnTLSState returns a handler that gives requests the TLS state of the
+// connection they arrived on. Forwarding clients speak plain HTTP/2 inside
+// the cluster connection's TLS, so without this the RPC handlers couldn't
+// see the peer's verified cert.
+func withConnTLSState(handler http.Handler, state tls.ConnectionState) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.TLS == nil {
+			req.TLS = &state
+		}
+		handler.ServeHTTP(w, req)
+	})
+}
+
+// NeverForward returns whether a request for the given path, relative to /v1/
+// and to the request's namespace, must be handled by this node rather than
+// forwarded to the active node, per CoreConfig.NeverForwardPaths.
+func (c *Core) NeverForward(path string) bool {
+	return c.neverForwardPaths.HasPath(path)
+}
+
+// ForwardRequest forwards a given request to the active node and returns the
+// response.
+func (c *Core) ForwardRequest(req *http.Request) (int, http.Header, []byte, error) {
 	origPath := req.URL.Path
 	defer func() {
 		req.URL.Path = origPath
@@ -416,7 +727,21 @@ func (c *Core) ForwardRequest(req *http.Request) (int, http.Header, []byte, erro
 
 	req.URL.Path = req.Context().Value("original_request_path").(string)
 
+	// Apply our own limit if it is tighter than the listener's
+	if c.maxRequestSize > 0 {
+		if max, ok := req.Context().Value("max_request_size").(int64); !ok || max <= 0 || max > c.maxRequestSize {
+			req = req.WithContext(context.WithValue(req.Context(), "max_request_size", c.maxRequestSize))
+		}
+	}
+
+	if c.clusterCompression {
+		req = req.WithContext(context.WithValue(req.Context(), "forwarding_compression", true))
+	}
+
 	freq, err := forwarding.GenerateForwardedRequest(req)
+	if err == forwarding.ErrRequestTooLarge {
+		return 0, nil, nil, ErrForwardedRequestTooLarge
+	}
 	if err != nil {
 		c.logger.Error("error creating forwarding RPC request", "error", err)
 		return 0, nil, nil, fmt.Errorf("error creating forwarding RPC request")
@@ -425,10 +750,91 @@ func (c *Core) ForwardRequest(req *http.Request) (int, http.Header, []byte, erro
 		c.logger.Error("got nil forwarding RPC request")
 		return 0, nil, nil, fmt.Errorf("got nil forwarding RPC request")
 	}
-	resp, err := c.rpcForwardingClient.ForwardRequest(c.rpcClientConnContext, freq)
+	if c.forwardedRequestRewrite != nil {
+		rewriteForwardedRequest(freq, c.forwardedRequestRewrite)
+	}
+	if err := setForwardedRequestIdempotencyKey(freq); err != nil {
+		c.logger.Error("error creating forwarding RPC request", "error", err)
+		return 0, nil, nil, fmt.Errorf("error creating forwarding RPC request")
+	}
+	if c.forwardedRequestCapture != nil {
+		c.forwardedRequestCapture.record(freq, c.clock.Now())
+	}
+
+	return c.forwardRequest(req.Context(), freq)
+}
+
+// forwardRequest sends a forwarded request to the active node. If the active
+// node's cert is not trusted and CoreConfig.ClusterRetryUntrustedPeer is set,
+// the cluster TLS information is reloaded from storage and the request is
+// sent once more. Reloading is done at most once per
+// untrustedPeerRetryInterval; other requests failing in between just return
+// ErrClusterPeerUntrusted.
+func (c *Core) forwardRequest(reqCtx context.Context, freq *forwarding.Request) (int, http.Header, []byte, error) {
+	statusCode, header, body, err := c.forwardRequestOnce(reqCtx, freq)
+	if err != ErrClusterPeerUntrusted || !c.clusterRetryUntrustedPeer {
+		return statusCode, header, body, err
+	}
+	if !c.allowUntrustedPeerRetry() {
+		return 0, nil, nil, err
+	}
+
+	c.logger.Info("reloading cluster TLS information to retry forwarding to untrusted active node")
+	if rerr := c.refreshForwarding(true); rerr != nil {
+		c.logger.Error("failed to reload cluster TLS information", "error", rerr)
+		return 0, nil, nil, err
+	}
+	return c.forwardRequestOnce(reqCtx, freq)
+}
+
+// allowUntrustedPeerRetry returns whether the cluster TLS information may be
+// reloaded to retry forwarding to an untrusted active node, and if so
+// records that it is being done now
+func (c *Core) allowUntrustedPeerRetry() bool {
+	c.untrustedPeerRetryLock.Lock()
+	defer c.untrustedPeerRetryLock.Unlock()
+
+	now := c.clock.Now()
+	if !c.untrustedPeerRetryLast.IsZero() && now.Sub(c.untrustedPeerRetryLast) < untrustedPeerRetryInterval {
+		return false
+	}
+	c.untrustedPeerRetryLast = now
+	return true
+}
+
+// forwardRequestOnce sends a forwarded request over the current forwarding
+// connection, if there is one
+func (c *Core) forwardRequestOnce(reqCtx context.Context, freq *forwarding.Request) (int, http.Header, []byte, error) {
+	c.requestForwardingConnectionLock.RLock()
+	defer c.requestForwardingConnectionLock.RUnlock()
+
+	if c.rpcForwardingClient == nil {
+		return 0, nil, nil, ErrCannotForward
+	}
+
+	return c.sendForwardedRequest(reqCtx, freq)
+}
+
+// sendForwardedRequest sends a forwarded request to the active node over the
+// RPC connection and returns the response. The caller must hold
+// requestForwardingConnectionLock for reading.
+func (c *Core) sendForwardedRequest(reqCtx context.Context, freq *forwarding.Request) (int, http.Header, []byte, error) {
+	ctx, clusterID := c.forwardingRPCContext(c.rpcClientConnContext)
+	var respMD metadata.MD
+	resp, err := c.rpcForwardingClient.ForwardRequest(ctx, freq, grpc.Header(&respMD))
 	if err != nil {
-		c.logger.Error("error during forwarded RPC request", "error", err)
-		return 0, nil, nil, fmt.Errorf("error during forwarding RPC request")
+		return 0, nil, nil, c.forwardingRPCError(err)
+	}
+	if err := c.checkForwardingResponseClusterID(clusterID, respMD); err != nil {
+		return 0, nil, nil, err
+	}
+	// Only a response to a request that asked for compression can be
+	// compressed
+	if forwarding.AcceptsCompression(freq) {
+		if err := forwarding.DecompressResponse(resp, 0); err != nil {
+			c.logger.Error("error decompressing forwarded response", "error", err)
+			return 0, nil, nil, fmt.Errorf("error decompressing forwarded response")
+		}
 	}
 
 	var header http.Header
@@ -443,12 +849,111 @@ func (c *Core) ForwardRequest(req *http.Request) (int, http.Header, []byte, erro
 	// we should attempt to wait for the WAL to ship to offer best effort read after
 	// write guarantees
 	if c.perfStandby && resp.LastRemoteWal > 0 {
-		WaitUntilWALShipped(req.Context(), c, resp.LastRemoteWal)
+		WaitUntilWALShipped(reqCtx, c, resp.LastRemoteWal)
 	}
 
 	return int(resp.StatusCode), header, resp.Body, nil
 }
 
+// forwardingRPCContext returns ctx set up for an RPC to the active node. It
+// tells the active node which cluster we expect it to be in, so it can refuse
+// requests from outside its own cluster, and returns that cluster ID to check
+// its answer against.
+func (c *Core) forwardingRPCContext(ctx context.Context) (context.Context, string) {
+	clusterID := c.localClusterID.Load().(string)
+	if clusterID != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, forwardingClusterIDMetadataKey, clusterID)
+	}
+	if c.nodeID != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, forwardingNodeIDMetadataKey, c.nodeID)
+	}
+	return ctx, clusterID
+}
+
+// forwardingRPCError turns an error from an RPC to the active node into the
+// error to return to the caller. The caller must hold
+// requestForwardingConnectionLock for reading.
+func (c *Core) forwardingRPCError(err error) error {
+	switch status.Code(err) {
+	case codes.FailedPrecondition:
+		c.logger.Error("active node refused forwarded request from another cluster", "error", err)
+		return ErrForwardingClusterMismatch
+	case codes.Aborted:
+		c.logger.Debug("node forwarded to is no longer active", "error", err)
+		c.invalidateLeaderLookupCache()
+		return ErrForwardingNodeNotActive
+	case codes.Unavailable:
+		// We hold the connection lock for reading, so hand off the
+		// reconnection
+		go c.forwardingConnectionLost(c.rpcClientConn)
+		if c.rpcForwardingClient.peerUntrusted() {
+			c.logger.Error("cannot forward request; active node cluster certificate is not trusted", "error", err)
+			c.invalidateLeaderLookupCache()
+			return ErrClusterPeerUntrusted
+		}
+	}
+	c.logger.Error("error during forwarded RPC request", "error", err)
+	// The active node may have changed, so look it up again next time
+	c.invalidateLeaderLookupCache()
+	return fmt.Errorf("error during forwarding RPC request")
+}
+
+// checkForwardingResponseClusterID returns ErrForwardingClusterMismatch if
+// the response metadata of an RPC says it was answered by a node of another
+// cluster than clusterID, unless we are connected to a trusted peer of
+// another cluster. The caller must hold requestForwardingConnectionLock for
+// reading.
+func (c *Core) checkForwardingResponseClusterID(clusterID string, respMD metadata.MD) error {
+	if ids := respMD.Get(forwardingClusterIDMetadataKey); clusterID != "" && len(ids) > 0 && ids[0] != clusterID && !c.rpcForwardingClient.peerInOtherCluster() {
+		c.logger.Error("forwarded request was answered by a node from another cluster", "expected_cluster_id", clusterID, "cluster_id", ids[0])
+		return ErrForwardingClusterMismatch
+	}
+	return nil
+}
+
+// listenTCPWithRetry listens on the given address, retrying with backoff for
+// up to timeout while the address is still in use. This covers unsealing
+// again right after sealing, when the previous listener may not have been
+// released yet.
+func listenTCPWithRetry(laddr *net.TCPAddr, timeout time.Duration) (*net.TCPListener, error) {
+	deadline := time.Now().Add(timeout)
+	backoff := clusterListenerBindBackoff
+	for {
+		ln, err := net.ListenTCP("tcp", laddr)
+		if err == nil || !isAddrInUse(err) || time.Now().Add(backoff).After(deadline) {
+			return ln, err
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > clusterListenerBindMaxBackoff {
+			backoff = clusterListenerBindMaxBackoff
+		}
+	}
+}
+
+// isAddrInUse returns whether err reports that an address is already in use
+func isAddrInUse(err error) bool {
+	opErr, ok := err.(*net.OpError)
+	if !ok {
+		return false
+	}
+	sysErr, ok := opErr.Err.(*os.SyscallError)
+	if !ok {
+		return false
+	}
+	return sysErr.Err == syscall.EADDRINUSE
+}
+
+// isClusterPeerUntrustedError returns whether err reports that a cluster
+// peer's certificate failed verification
+func isClusterPeerUntrustedError(err error) bool {
+	var unknownAuthority x509.UnknownAuthorityError
+	var invalid x509.CertificateInvalidError
+	var hostname x509.HostnameError
+	return errors.As(err, &unknownAuthority) || errors.As(err, &invalid) || errors.As(err, &hostname)
+}
+
 // getGRPCDialer is used to return a dialer that has the correct TLS
 // configuration. Otherwise gRPC tries to be helpful and stomps all over our
 // NextProtos.
@@ -468,6 +973,7 @@ func (c *Core) getGRPCDialer(ctx context.Context, alpnProto, serverName string,
 			tlsConfig.RootCAs = pool
 			tlsConfig.ClientCAs = pool
 		}
+		c.allowTrustedPeerServers(tlsConfig)
 		c.logger.Debug("creating rpc dialer", "host", tlsConfig.ServerName)
 
 		tlsConfig.NextProtos = []string{alpnProto}
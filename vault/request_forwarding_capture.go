@@ -0,0 +1,141 @@
+package vault
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	uuid "github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/helper/forwarding"
+)
+
+// CapturedForwardedRequest is a request this node forwarded to the active
+// node, kept for debugging. The request is stored as it was sent, except
+// that its token has been removed and, unless
+// CoreConfig.ForwardedRequestCaptureBodies is set, its body dropped.
+type CapturedForwardedRequest struct {
+	ID      string
+	Time    time.Time
+	Request *forwarding.Request
+	// BodyDropped is set if the request had a body that was not kept
+	BodyDropped bool
+}
+
+// forwardedRequestCapture keeps the latest forwarded requests in a ring
+// buffer
+type forwardedRequestCapture struct {
+	l             sync.Mutex
+	requests      []*CapturedForwardedRequest
+	next          int
+	captureBodies bool
+}
+
+func newForwardedRequestCapture(size int, captureBodies bool) *forwardedRequestCapture {
+	return &forwardedRequestCapture{
+		requests:      make([]*CapturedForwardedRequest, size),
+		captureBodies: captureBodies,
+	}
+}
+
+// record keeps a copy of freq without its token, and without its body unless
+// bodies are captured, replacing the oldest one if the buffer is full
+func (f *forwardedRequestCapture) record(freq *forwarding.Request, now time.Time) {
+	id, err := uuid.GenerateUUID()
+	if err != nil {
+		return
+	}
+	captured := proto.Clone(freq).(*forwarding.Request)
+	for _, h := range denylistHeaders {
+		delete(captured.HeaderEntries, http.CanonicalHeaderKey(h))
+	}
+	bodyDropped := false
+	if !f.captureBodies && len(captured.Body) > 0 {
+		captured.Body = nil
+		bodyDropped = true
+	}
+
+	f.l.Lock()
+	defer f.l.Unlock()
+	f.requests[f.next] = &CapturedForwardedRequest{
+		ID:          id,
+		Time:        now,
+		Request:     captured,
+		BodyDropped: bodyDropped,
+	}
+	f.next = (f.next + 1) % len(f.requests)
+}
+
+// list returns copies of the captured requests, oldest first
+func (f *forwardedRequestCapture) list() []*CapturedForwardedRequest {
+	f.l.Lock()
+	defer f.l.Unlock()
+
+	var out []*CapturedForwardedRequest
+	for i := range f.requests {
+		captured := f.requests[(f.next+i)%len(f.requests)]
+		if captured == nil {
+			continue
+		}
+		out = append(out, &CapturedForwardedRequest{
+			ID:          captured.ID,
+			Time:        captured.Time,
+			Request:     proto.Clone(captured.Request).(*forwarding.Request),
+			BodyDropped: captured.BodyDropped,
+		})
+	}
+	return out
+}
+
+// get returns a copy of the request captured with the given ID, or nil, and
+// whether its body was dropped
+func (f *forwardedRequestCapture) get(id string) (*forwarding.Request, bool) {
+	f.l.Lock()
+	defer f.l.Unlock()
+
+	for _, captured := range f.requests {
+		if captured != nil && captured.ID == id {
+			return proto.Clone(captured.Request).(*forwarding.Request), captured.BodyDropped
+		}
+	}
+	return nil, false
+}
+
+// CapturedForwardedRequests returns the latest requests this node forwarded
+// to the active node, oldest first, if CoreConfig.ForwardedRequestCaptureSize
+// enables capturing them.
+func (c *Core) CapturedForwardedRequests() []*CapturedForwardedRequest {
+	if c.forwardedRequestCapture == nil {
+		return nil
+	}
+	return c.forwardedRequestCapture.list()
+}
+
+// ReplayForwarded sends the captured forwarded request with the given ID to
+// the active node again and returns the response. Captured requests have no
+// token, so the replay reaches the active node without one: only requests to
+// unauthenticated paths are handled the same as the original, while others
+// are refused by the active node. A request whose body was dropped can't be
+// replayed. The replay gets a new idempotency key, so the active node handles
+// it rather than answering it as a retry. This is meant for debugging
+// forwarding only.
+func (c *Core) ReplayForwarded(id string) (int, http.Header, []byte, error) {
+	if c.forwardedRequestCapture == nil {
+		return 0, nil, nil, ErrForwardedRequestNotCaptured
+	}
+	freq, bodyDropped := c.forwardedRequestCapture.get(id)
+	if freq == nil {
+		return 0, nil, nil, ErrForwardedRequestNotCaptured
+	}
+	if bodyDropped {
+		return 0, nil, nil, ErrForwardedRequestBodyNotCaptured
+	}
+
+	if err := setForwardedRequestIdempotencyKey(freq); err != nil {
+		return 0, nil, nil, err
+	}
+
+	c.logger.Debug("replaying captured forwarded request", "id", id)
+	return c.forwardRequest(context.Background(), freq)
+}
@@ -0,0 +1,208 @@
+package vault
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/helper/jsonutil"
+	"github.com/hashicorp/vault/physical"
+)
+
+const (
+	// healthForwardingALPN is the negotiated protocol used by sealed
+	// standbys to forward health checks to the active node. A sealed node
+	// has no cluster key, so the active node doesn't ask for a client cert
+	// when this is the only protocol offered, and in turn answers nothing
+	// but health checks on it.
+	healthForwardingALPN = "req_fw_health_v1"
+
+	// healthForwardingPath is the only path served over healthForwardingALPN
+	healthForwardingPath = "/v1/sys/health"
+
+	// healthForwardingTimeout bounds a forwarded health check from dialing
+	// the active node to reading its response, and how long the active node
+	// waits for the request
+	healthForwardingTimeout = 10 * time.Second
+
+	// healthForwardingMaxResponseSize caps the response read back from the
+	// active node
+	healthForwardingMaxResponseSize = 1024 * 1024
+)
+
+// activeClusterTLS is what the active node stores outside the barrier so that
+// sealed standbys can reach it to forward health checks. It only holds public
+// information: the cluster address and the cluster cert to verify it with.
+type activeClusterTLS struct {
+	ClusterAddr string `json:"cluster_addr"`
+	ClusterCert []byte `json:"cluster_cert"`
+}
+
+// persistActiveClusterTLS stores the active node's cluster address and cert
+// outside the barrier for sealed standbys
+func (c *Core) persistActiveClusterTLS(ctx context.Context) error {
+	cert := c.localClusterCert.Load().([]byte)
+	if c.clusterAddr == "" || len(cert) == 0 {
+		return nil
+	}
+
+	raw, err := json.Marshal(&activeClusterTLS{
+		ClusterAddr: c.clusterAddr,
+		ClusterCert: cert,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.physical.Put(ctx, &physical.Entry{
+		Key:   coreActiveClusterTLSPath,
+		Value: raw,
+	})
+}
+
+// ForwardSealedHealthRequest forwards a health check that arrived at this
+// node while it is sealed to the active node and returns the response, if
+// CoreConfig.ForwardSealedHealthRequests is set. The active node is found
+// and verified using the address and cert it stored outside the barrier, and
+// only the method and query of the request are sent. It returns
+// ErrCannotForward if the request cannot be forwarded.
+func (c *Core) ForwardSealedHealthRequest(req *http.Request) (int, http.Header, []byte, error) {
+	defer metrics.MeasureSince([]string{"ha", "rpc", "client", "forward_sealed_health"}, time.Now())
+
+	if !c.forwardSealedHealthRequests || c.ha == nil || !c.Sealed() {
+		return 0, nil, nil, ErrCannotForward
+	}
+	if req.Method != "GET" && req.Method != "HEAD" {
+		return 0, nil, nil, ErrCannotForward
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), healthForwardingTimeout)
+	defer cancel()
+
+	entry, err := c.physical.Get(ctx, coreActiveClusterTLSPath)
+	if err != nil {
+		return 0, nil, nil, errwrap.Wrapf("failed to read active node cluster information: {{err}}", err)
+	}
+	if entry == nil {
+		return 0, nil, nil, ErrCannotForward
+	}
+	var active activeClusterTLS
+	if err := jsonutil.DecodeJSON(entry.Value, &active); err != nil {
+		return 0, nil, nil, errwrap.Wrapf("failed to decode active node cluster information: {{err}}", err)
+	}
+
+	// The entry may be left over from when this node was active
+	if active.ClusterAddr == "" || active.ClusterAddr == c.clusterAddr {
+		return 0, nil, nil, ErrCannotForward
+	}
+	clusterURL, err := url.Parse(active.ClusterAddr)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(active.ClusterCert)
+	if err != nil {
+		return 0, nil, nil, errwrap.Wrapf("failed to parse active node cluster certificate: {{err}}", err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	tlsConfig := &tls.Config{
+		RootCAs:      pool,
+		ServerName:   cert.Subject.CommonName,
+		NextProtos:   []string{healthForwardingALPN},
+		MinVersion:   tls.VersionTLS12,
+		CipherSuites: c.clusterCipherSuites,
+	}
+	c.setClusterInsecureSkipVerify(tlsConfig)
+
+	dialer := &net.Dialer{
+		Timeout: healthForwardingTimeout,
+	}
+	conn, err := tls.DialWithDialer(dialer, "tcp", clusterURL.Host, tlsConfig)
+	if err != nil {
+		c.logger.Debug("failed to connect to active node to forward health check", "error", err)
+		return 0, nil, nil, ErrCannotForward
+	}
+	defer conn.Close()
+	if conn.ConnectionState().NegotiatedProtocol != healthForwardingALPN {
+		return 0, nil, nil, ErrCannotForward
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// Send nothing but the method and query; in particular no token
+	outReq, err := http.NewRequest(req.Method, "http://"+clusterURL.Host+healthForwardingPath, nil)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	outReq.URL.RawQuery = req.URL.RawQuery
+	outReq.Close = true
+	if err := outReq.Write(conn); err != nil {
+		return 0, nil, nil, err
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), outReq)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, healthForwardingMaxResponseSize))
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	return resp.StatusCode, resp.Header, body, nil
+}
+
+// serveHealthForwardingConn serves health checks forwarded by a sealed
+// standby on the active node. The peer is not authenticated, so only
+// healthForwardingPath is served and request headers are dropped.
+func (c *Core) serveHealthForwardingConn(conn net.Conn, shutdownWg *sync.WaitGroup, closeCh chan struct{}) {
+	ln := newSingleConnListener(conn)
+
+	shutdownWg.Add(2)
+	go func() {
+		defer shutdownWg.Done()
+		select {
+		case <-ln.doneCh:
+		case <-closeCh:
+			conn.Close()
+		}
+	}()
+	go func() {
+		defer shutdownWg.Done()
+		srv := &http.Server{
+			Handler:     healthForwardingHandler(c.clusterHandler),
+			ReadTimeout: healthForwardingTimeout,
+			ErrorLog:    c.logger.StandardLogger(nil),
+		}
+		srv.SetKeepAlivesEnabled(false)
+		srv.Serve(ln)
+	}()
+}
+
+// healthForwardingHandler passes health checks on to handler and refuses
+// anything else
+func healthForwardingHandler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != healthForwardingPath || (r.Method != "GET" && r.Method != "HEAD") {
+			http.NotFound(w, r)
+			return
+		}
+
+		r.Header = make(http.Header)
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,176 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/plugin/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// logicalForwardingMaxMessageSize bounds the size of a forwarded logical
+	// request when no maximum request size is configured
+	logicalForwardingMaxMessageSize = 32 * 1024 * 1024
+
+	// logicalForwardingMethod is the full name of the RPC that forwards a
+	// logical request to the active node
+	logicalForwardingMethod = "/vault.LogicalForwarding/ForwardLogicalRequest"
+)
+
+// logicalForwardingServer is the server API for the LogicalForwarding
+// service, which is served next to RequestForwarding on the forwarding
+// connection
+type logicalForwardingServer interface {
+	ForwardLogicalRequest(context.Context, *pb.Request) (*pb.HandleRequestReply, error)
+}
+
+func registerLogicalForwardingServer(s *grpc.Server, srv logicalForwardingServer) {
+	s.RegisterService(&_LogicalForwarding_serviceDesc, srv)
+}
+
+func _LogicalForwarding_ForwardLogicalRequest_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(pb.Request)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(logicalForwardingServer).ForwardLogicalRequest(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: logicalForwardingMethod,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(logicalForwardingServer).ForwardLogicalRequest(ctx, req.(*pb.Request))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _LogicalForwarding_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "vault.LogicalForwarding",
+	HandlerType: (*logicalForwardingServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ForwardLogicalRequest",
+			Handler:    _LogicalForwarding_ForwardLogicalRequest_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "vault/request_forwarding_logical.go",
+}
+
+// ForwardLogicalRequest sends a logical request to the active node over the
+// forwarding connection and returns the active node's response. The request
+// is handled by the active node in the root namespace, exactly as if it had
+// been made there, so the client token in the request must be allowed to
+// perform it. Well-known errors from handling the request, such as
+// logical.ErrPermissionDenied, are returned as themselves; others keep only
+// their message. It returns ErrCannotForward if there is no forwarding
+// connection, e.g. on the active node.
+func (c *Core) ForwardLogicalRequest(ctx context.Context, req *logical.Request) (*logical.Response, error) {
+	defer metrics.MeasureSince([]string{"ha", "rpc", "client", "forward_logical"}, time.Now())
+
+	if req == nil {
+		return nil, errors.New("nil request to forward")
+	}
+
+	protoReq, err := pb.LogicalRequestToProtoRequest(req)
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to encode forwarded request: {{err}}", err)
+	}
+	if proto.Size(protoReq) > c.logicalForwardingMaxMessageSize() {
+		return nil, ErrForwardedRequestTooLarge
+	}
+
+	c.requestForwardingConnectionLock.RLock()
+	defer c.requestForwardingConnectionLock.RUnlock()
+
+	if c.rpcForwardingClient == nil {
+		return nil, ErrCannotForward
+	}
+
+	rpcCtx, clusterID := c.forwardingRPCContext(ctx)
+	var reply pb.HandleRequestReply
+	var respMD metadata.MD
+	if err := c.rpcForwardingClient.conn.Invoke(rpcCtx, logicalForwardingMethod, protoReq, &reply, grpc.Header(&respMD)); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, c.forwardingRPCError(err)
+	}
+	if err := c.checkForwardingResponseClusterID(clusterID, respMD); err != nil {
+		return nil, err
+	}
+
+	resp, err := pb.ProtoResponseToLogicalResponse(reply.Response)
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to decode forwarded response: {{err}}", err)
+	}
+	return resp, pb.ProtoErrToErr(reply.Err)
+}
+
+// ForwardLogicalRequest handles a logical request forwarded by a standby,
+// after the same checks as ForwardRequest
+func (s *forwardedRequestRPCServer) ForwardLogicalRequest(ctx context.Context, protoReq *pb.Request) (*pb.HandleRequestReply, error) {
+	defer metrics.MeasureSince([]string{"ha", "rpc", "server", "forward_logical"}, time.Now())
+
+	size := proto.Size(protoReq)
+	s.core.clusterPeerStats.record(clusterPeerIDFromContext(ctx), 1, size, s.core.clock.Now())
+
+	if err := s.checkForwardingPeer(ctx); err != nil {
+		return nil, err
+	}
+	if size > s.core.logicalForwardingMaxMessageSize() {
+		return nil, status.Error(codes.ResourceExhausted, "request exceeds the maximum request size")
+	}
+
+	req, err := pb.ProtoRequestToLogicalRequest(protoReq)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, respErr := s.core.HandleRequest(namespace.RootContext(ctx), req)
+
+	// Request handling wraps errors, so pick out the well-known ones to send
+	// on their own; that way the standby can still compare against them
+	for _, known := range []error{
+		logical.ErrPermissionDenied,
+		logical.ErrInvalidRequest,
+		logical.ErrUnsupportedPath,
+		logical.ErrUnsupportedOperation,
+		logical.ErrMultiAuthzPending,
+	} {
+		if respErr != nil && errwrap.Contains(respErr, known.Error()) {
+			respErr = known
+			break
+		}
+	}
+
+	protoResp, err := pb.LogicalResponseToProtoResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.HandleRequestReply{
+		Response: protoResp,
+		Err:      pb.ErrToProtoErr(respErr),
+	}, nil
+}
+
+// logicalForwardingMaxMessageSize returns the largest forwarded logical
+// request the active node accepts.
+func (c *Core) logicalForwardingMaxMessageSize() int {
+	if c.maxRequestSize > 0 && c.maxRequestSize < logicalForwardingMaxMessageSize {
+		return int(c.maxRequestSize)
+	}
+	return logicalForwardingMaxMessageSize
+}
@@ -2,6 +2,11 @@ package vault
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
 	"net/http"
 	"runtime"
 	"sync/atomic"
@@ -9,24 +14,272 @@ import (
 
 	"github.com/hashicorp/vault/helper/consts"
 	"github.com/hashicorp/vault/helper/forwarding"
+	"github.com/hashicorp/vault/helper/jsonutil"
 	cache "github.com/patrickmn/go-cache"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 )
 
 type forwardedRequestRPCServer struct {
 	core                  *Core
+	ctx                   context.Context
 	handler               http.Handler
 	perfStandbySlots      chan struct{}
 	perfStandbyRepCluster *ReplicatedCluster
 	perfStandbyCache      *cache.Cache
+
+	// idempotentResults holds the outcome of recently forwarded requests by
+	// idempotency key, so that retries are answered without being handled
+	// again
+	idempotentResults *cache.Cache
+}
+
+// newForwardedRequestRPCServer returns a forwardedRequestRPCServer that
+// passes requests to handler until ctx is done
+func newForwardedRequestRPCServer(ctx context.Context, c *Core, handler http.Handler) *forwardedRequestRPCServer {
+	return &forwardedRequestRPCServer{
+		core:              c,
+		ctx:               ctx,
+		handler:           handler,
+		idempotentResults: cache.New(forwardedRequestIdempotencyWindow, forwardedRequestIdempotencyWindow),
+	}
+}
+
+// forwardedRequestResult is the outcome of a forwarded request, available
+// once done is closed
+type forwardedRequestResult struct {
+	done chan struct{}
+	resp *forwarding.Response
+	err  error
 }
 
 func (s *forwardedRequestRPCServer) ForwardRequest(ctx context.Context, freq *forwarding.Request) (*forwarding.Response, error) {
-	// Parse an http.Request out of it
-	req, err := forwarding.ParseForwardedRequest(freq)
+	s.core.recordForwardedRequest(ctx, freq)
+
+	if err := s.checkForwardingPeer(ctx); err != nil {
+		return nil, err
+	}
+
+	// Parse an http.Request out of it. The size is checked after parsing, as
+	// the body may have arrived compressed.
+	req, err := forwarding.ParseForwardedRequest(freq, s.core.maxRequestSize)
+	if err == forwarding.ErrDecompressedTooLarge || (err == nil && s.core.maxRequestSize > 0 && req.ContentLength > s.core.maxRequestSize) {
+		return &forwarding.Response{
+			StatusCode: http.StatusRequestEntityTooLarge,
+			HeaderEntries: map[string]*forwarding.HeaderEntry{
+				"Content-Type": &forwarding.HeaderEntry{
+					Values: []string{"application/json"},
+				},
+			},
+			Body: []byte(`{"errors":["request body exceeds the maximum request size"]}`),
+		}, nil
+	}
 	if err != nil {
 		return nil, err
 	}
 
+	// A retry of a request that is being or has been handled gets the
+	// response of the first attempt instead of being handled again
+	key := forwardedRequestDedupeKey(freq, req)
+	if key == "" || s.idempotentResults == nil || s.idempotentResults.ItemCount() >= forwardedRequestIdempotencyMaxEntries {
+		return s.finishForwardedResponse(freq, s.serveForwardedRequest(req))
+	}
+	result, first := s.beginForwardedRequest(key)
+	if !first {
+		select {
+		case <-result.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if result.err != nil {
+			return nil, result.err
+		}
+		s.core.logger.Debug("answering retried forwarded request from the first attempt", "path", req.URL.Path)
+		return &forwarding.Response{
+			StatusCode:    result.resp.StatusCode,
+			Body:          result.resp.Body,
+			HeaderEntries: result.resp.HeaderEntries,
+			LastRemoteWal: LastRemoteWAL(s.core),
+		}, nil
+	}
+
+	resp := s.serveForwardedRequest(req)
+	hasSecrets := forwardedResponseHasSecrets(resp)
+	result.resp, result.err = s.finishForwardedResponse(freq, resp)
+	switch {
+	case result.err != nil || resp.StatusCode >= 400:
+		// The request most likely didn't take effect, so let a retry try
+		// again
+		s.idempotentResults.Delete(key)
+	case hasSecrets:
+		// Don't keep secrets around for a retry to pick up, but don't
+		// handle the request twice either
+		handled := &forwardedRequestResult{
+			done: make(chan struct{}),
+		}
+		handled.resp, handled.err = s.finishForwardedResponse(freq, forwardedRequestAlreadyHandledResponse())
+		close(handled.done)
+		s.idempotentResults.Set(key, handled, cache.DefaultExpiration)
+	}
+	close(result.done)
+	return result.resp, result.err
+}
+
+// checkForwardingPeer returns an error to answer a forwarded request with if
+// this node can't handle it, and tells the caller which cluster answered
+func (s *forwardedRequestRPCServer) checkForwardingPeer(ctx context.Context) error {
+	// The listener outlives active duty for a moment while stepping down or
+	// sealing, so tell the standby to look for the new active node rather
+	// than handling the request half torn down
+	if s.ctx.Err() != nil || s.core.Sealed() || atomic.LoadUint32(s.core.leavingActiveDuty) == 1 {
+		return status.Error(codes.Aborted, "node is not active")
+	}
+
+	// Refuse requests from standbys that believe they are in a different
+	// cluster, and tell the caller which cluster answered. Nodes of other
+	// clusters whose certs are trusted through
+	// CoreConfig.ClusterTrustedPeerCerts are meant to be in a different
+	// cluster.
+	clusterID := s.core.localClusterID.Load().(string)
+	fromTrustedPeer := s.core.isClusterTrustedPeer(clusterPeerCertFromContext(ctx))
+	if md, ok := metadata.FromIncomingContext(ctx); ok && clusterID != "" && !fromTrustedPeer {
+		if ids := md.Get(forwardingClusterIDMetadataKey); len(ids) > 0 && ids[0] != clusterID {
+			s.core.logger.Warn("refusing forwarded request from another cluster", "cluster_id", ids[0])
+			return status.Errorf(codes.FailedPrecondition, "request forwarded from cluster %q, this is cluster %q", ids[0], clusterID)
+		}
+	}
+	if clusterID != "" {
+		if err := grpc.SetHeader(ctx, metadata.Pairs(forwardingClusterIDMetadataKey, clusterID)); err != nil {
+			s.core.logger.Debug("failed to set cluster ID header on forwarded response", "error", err)
+		}
+	}
+	return nil
+}
+
+// beginForwardedRequest returns the result for the given dedupe key, and
+// whether this is the first request with it, in which case the caller must
+// fill in the result and close its done channel
+func (s *forwardedRequestRPCServer) beginForwardedRequest(key string) (*forwardedRequestResult, bool) {
+	result := &forwardedRequestResult{
+		done: make(chan struct{}),
+	}
+	for {
+		if err := s.idempotentResults.Add(key, result, cache.DefaultExpiration); err == nil {
+			return result, true
+		}
+		if existing, ok := s.idempotentResults.Get(key); ok {
+			return existing.(*forwardedRequestResult), false
+		}
+	}
+}
+
+// forwardedRequestIsRead returns whether a forwarded request with the given
+// method only reads, in which case it is safe to handle a retry of it again
+func forwardedRequestIsRead(method string) bool {
+	switch method {
+	case "GET", "HEAD", "LIST", "OPTIONS":
+		return true
+	}
+	return false
+}
+
+// setForwardedRequestIdempotencyKey gives a forwarded write a new
+// idempotency key, so that the active node can recognize this node's retries
+// of it. Reads don't need one.
+func setForwardedRequestIdempotencyKey(freq *forwarding.Request) error {
+	if forwardedRequestIsRead(freq.Method) {
+		delete(freq.HeaderEntries, forwarding.IdempotencyKeyHeaderName)
+		return nil
+	}
+	return forwarding.SetIdempotencyKey(freq)
+}
+
+// forwardedRequestDedupeKey returns the key under which the result of a
+// forwarded request is kept, or an empty string if it has no idempotency key
+// or only reads. The idempotency key is combined with the token, target and
+// body of the request so that it can't be used to obtain the response to
+// someone else's request, or to a different one.
+func forwardedRequestDedupeKey(freq *forwarding.Request, req *http.Request) string {
+	idempotencyKey := forwarding.IdempotencyKey(freq)
+	if idempotencyKey == "" || forwardedRequestIsRead(req.Method) {
+		return ""
+	}
+
+	bodyHash := sha256.Sum256(freq.Body)
+	h := sha256.New()
+	for _, v := range []string{
+		idempotencyKey,
+		req.Header.Get(consts.AuthHeaderName),
+		req.Header.Get("Authorization"),
+		req.Method,
+		req.URL.Path,
+		req.URL.RawQuery,
+		string(bodyHash[:]),
+	} {
+		h.Write([]byte(v))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// forwardedResponseHasSecrets returns whether a response may carry secrets,
+// in which case it isn't kept for retries. Anything other than an empty body
+// or a JSON object without auth, data, wrapping or lease information is
+// assumed to.
+func forwardedResponseHasSecrets(resp *forwarding.Response) bool {
+	if len(resp.Body) == 0 {
+		return false
+	}
+	var fields map[string]json.RawMessage
+	if err := jsonutil.DecodeJSON(resp.Body, &fields); err != nil {
+		return true
+	}
+	for _, k := range []string{"auth", "data", "wrap_info", "lease_id"} {
+		switch string(fields[k]) {
+		case "", "null", `""`, "{}":
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedRequestAlreadyHandledResponse is what a retry of a forwarded
+// request is answered with when the response to the first attempt wasn't
+// kept
+func forwardedRequestAlreadyHandledResponse() *forwarding.Response {
+	return &forwarding.Response{
+		StatusCode: http.StatusConflict,
+		HeaderEntries: map[string]*forwarding.HeaderEntry{
+			"Content-Type": &forwarding.HeaderEntry{
+				Values: []string{"application/json"},
+			},
+		},
+		Body: []byte(`{"errors":["request was already handled by the active node; its response was not kept"]}`),
+	}
+}
+
+// finishForwardedResponse compresses the response if this node is configured
+// to and the sender of the request asked for it, and sets the last remote WAL
+func (s *forwardedRequestRPCServer) finishForwardedResponse(freq *forwarding.Request, resp *forwarding.Response) (*forwarding.Response, error) {
+	if s.core.clusterCompression && forwarding.AcceptsCompression(freq) {
+		if err := forwarding.CompressResponse(resp); err != nil {
+			return nil, err
+		}
+	}
+
+	resp.LastRemoteWal = LastRemoteWAL(s.core)
+
+	return resp, nil
+}
+
+// serveForwardedRequest hands a parsed forwarded request to the handler and
+// builds the response to send back
+func (s *forwardedRequestRPCServer) serveForwardedRequest(req *http.Request) *forwarding.Response {
 	// A very dummy response writer that doesn't follow normal semantics, just
 	// lets you write a status code (last written wins) and a body. But it
 	// meets the interface requirements.
@@ -44,7 +297,11 @@ func (s *forwardedRequestRPCServer) ForwardRequest(ctx context.Context, freq *fo
 				s.core.logger.Error("panic serving forwarded request", "path", req.URL.Path, "error", err, "stacktrace", string(buf))
 			}
 		}()
-		s.handler.ServeHTTP(w, req)
+		handler := s.handler
+		if req.Header.Get(IntForwardingTestHeaderName) != "" {
+			handler = forwardingTestHandler
+		}
+		handler.ServeHTTP(w, req)
 	}
 	runRequest()
 	resp.StatusCode = uint32(w.StatusCode())
@@ -60,11 +317,22 @@ func (s *forwardedRequestRPCServer) ForwardRequest(ctx context.Context, freq *fo
 		}
 	}
 
-	resp.LastRemoteWal = LastRemoteWAL(s.core)
-
-	return resp, nil
+	return resp
 }
 
+// forwardingTestHandler answers requests sent by TestForward by echoing their
+// body back
+var forwardingTestHandler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+})
+
 func (s *forwardedRequestRPCServer) Echo(ctx context.Context, in *EchoRequest) (*EchoReply, error) {
 	if in.ClusterAddr != "" {
 		s.core.clusterPeerClusterAddrsCache.Set(in.ClusterAddr, nil, 0)
@@ -79,16 +347,63 @@ type forwardingClient struct {
 	RequestForwardingClient
 
 	core *Core
+	conn *grpc.ClientConn
+	// The local cluster cert at the time the client connected, which is the
+	// one the connection presents to the active node
+	cert *x509.Certificate
+	// Set while the last attempt to connect failed because the active node's
+	// cert was not trusted
+	untrusted *uint32
+	// Set while connected to a node of another cluster, trusted through
+	// CoreConfig.ClusterTrustedPeerCerts
+	otherCluster *uint32
 
 	echoTicker  *time.Ticker
 	echoContext context.Context
 }
 
+// peerUntrusted returns whether the last attempt to connect failed because
+// the active node's cert was not trusted
+func (c *forwardingClient) peerUntrusted() bool {
+	return c.untrusted != nil && atomic.LoadUint32(c.untrusted) == 1
+}
+
+// peerInOtherCluster returns whether the client is connected to a node of
+// another cluster, trusted through CoreConfig.ClusterTrustedPeerCerts
+func (c *forwardingClient) peerInOtherCluster() bool {
+	return c.otherCluster != nil && atomic.LoadUint32(c.otherCluster) == 1
+}
+
+// clusterPeerCertFromContext returns the verified cert of the peer of an
+// incoming forwarding RPC, if any
+func clusterPeerCertFromContext(ctx context.Context) *x509.Certificate {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return nil
+	}
+	if chains := info.State.VerifiedChains; len(chains) > 0 && len(chains[0]) > 0 {
+		return chains[0][0]
+	}
+	return nil
+}
+
 // NOTE: we also take advantage of gRPC's keepalive bits, but as we send data
 // with these requests it's useful to keep this as well
 func (c *forwardingClient) startHeartbeat() {
 	go func() {
 		tick := func() {
+			// An established connection keeps presenting the cert it was
+			// set up with, so reconnect once the local cert is rotated
+			// rather than waiting for the connection to drop
+			if cert := c.core.localClusterParsedCert.Load().(*x509.Certificate); cert != nil && c.cert != nil && !cert.Equal(c.cert) {
+				go c.core.forwardingCertRotated(c.conn)
+				return
+			}
+
 			c.core.stateLock.RLock()
 			clusterAddr := c.core.clusterAddr
 			c.core.stateLock.RUnlock()
@@ -101,6 +416,9 @@ func (c *forwardingClient) startHeartbeat() {
 			cancel()
 			if err != nil {
 				c.core.logger.Debug("forwarding: error sending echo request to active node", "error", err)
+				if status.Code(err) == codes.Unavailable {
+					go c.core.forwardingConnectionLost(c.conn)
+				}
 				return
 			}
 			if resp == nil {
@@ -0,0 +1,326 @@
+package vault
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+)
+
+const (
+	// upgradeForwardingALPN is the negotiated protocol used to proxy HTTP
+	// upgrade requests, such as WebSockets, from a standby to the active
+	// node. The connection carries plain HTTP/1.1 which, once upgraded, is
+	// copied verbatim in both directions.
+	upgradeForwardingALPN = "req_fw_upgrade_v1"
+
+	// upgradeForwardingDialTimeout bounds how long a standby waits to
+	// connect to the active node's cluster port
+	upgradeForwardingDialTimeout = 10 * time.Second
+
+	// upgradeForwardingReadTimeout bounds how long the active node waits to
+	// read a forwarded upgrade request. It no longer applies once the
+	// connection has been upgraded.
+	upgradeForwardingReadTimeout = 30 * time.Second
+
+	// upgradeForwardingIdleTimeout bounds how long the active node keeps an
+	// upgrade forwarding connection open between requests
+	upgradeForwardingIdleTimeout = 90 * time.Second
+
+	// upgradeForwardingClusterIDHeaderName carries the cluster ID of the
+	// standby on a forwarded upgrade request, and that of the active node on
+	// the response, like the cluster ID metadata of forwarding RPCs
+	upgradeForwardingClusterIDHeaderName = "X-Vault-Forwarding-Cluster-Id"
+
+	// upgradeForwardingRefusedHeaderName is set on the response when the
+	// active node refuses a forwarded upgrade request because it is no
+	// longer active
+	upgradeForwardingRefusedHeaderName = "X-Vault-Forwarding-Refused"
+)
+
+// IsUpgradeRequest returns whether the request asks to switch the connection
+// to another protocol, as WebSocket handshakes do.
+func IsUpgradeRequest(req *http.Request) bool {
+	if req.Header.Get("Upgrade") == "" {
+		return false
+	}
+	for _, v := range req.Header["Connection"] {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ForwardUpgradeRequest proxies an upgrade request to the active node over
+// the cluster port. If the active node switches protocols, the client's
+// connection is hijacked and bytes are copied in both directions until
+// either side closes. Otherwise the active node's response is relayed as-is.
+// Errors are only returned if nothing has been written to w yet.
+func (c *Core) ForwardUpgradeRequest(w http.ResponseWriter, req *http.Request) error {
+	defer metrics.MeasureSince([]string{"ha", "rpc", "client", "forward_upgrade"}, time.Now())
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return errors.New("response writer does not support hijacking")
+	}
+
+	isLeader, _, clusterAddr, err := c.Leader()
+	if err != nil {
+		return err
+	}
+	if isLeader || clusterAddr == "" {
+		return ErrCannotForward
+	}
+	clusterURL, err := url.Parse(clusterAddr)
+	if err != nil {
+		return err
+	}
+
+	dial := c.getGRPCDialer(req.Context(), upgradeForwardingALPN, "", nil, nil, nil)
+	activeConn, err := dial(clusterURL.Host, upgradeForwardingDialTimeout)
+	if err != nil {
+		c.logger.Error("failed to connect to active node for upgrade forwarding", "error", err)
+		return ErrCannotForward
+	}
+	activeState := activeConn.(*tls.Conn).ConnectionState()
+	if activeState.NegotiatedProtocol != upgradeForwardingALPN {
+		activeConn.Close()
+		return ErrCannotForward
+	}
+
+	outReq := req.WithContext(req.Context())
+	if path, ok := req.Context().Value("original_request_path").(string); ok {
+		u := *req.URL
+		u.Path = path
+		outReq.URL = &u
+	}
+	outReq.Header = make(http.Header, len(req.Header)+1)
+	for k, v := range req.Header {
+		outReq.Header[k] = v
+	}
+	clusterID := c.localClusterID.Load().(string)
+	outReq.Header.Del(upgradeForwardingClusterIDHeaderName)
+	if clusterID != "" {
+		outReq.Header.Set(upgradeForwardingClusterIDHeaderName, clusterID)
+	}
+	if err := outReq.Write(activeConn); err != nil {
+		activeConn.Close()
+		return err
+	}
+
+	activeReader := bufio.NewReader(activeConn)
+	resp, err := http.ReadResponse(activeReader, outReq)
+	if err != nil {
+		activeConn.Close()
+		return err
+	}
+
+	// Check the answer came from our cluster and was not a refusal before
+	// passing anything on to the client
+	peerInOtherCluster := len(activeState.PeerCertificates) > 0 && c.isClusterTrustedPeer(activeState.PeerCertificates[0])
+	if id := resp.Header.Get(upgradeForwardingClusterIDHeaderName); clusterID != "" && id != "" && id != clusterID && !peerInOtherCluster {
+		c.logger.Error("forwarded upgrade request was answered by a node from another cluster", "expected_cluster_id", clusterID, "cluster_id", id)
+		resp.Body.Close()
+		activeConn.Close()
+		return ErrForwardingClusterMismatch
+	}
+	if resp.Header.Get(upgradeForwardingRefusedHeaderName) != "" {
+		c.logger.Debug("node forwarded to is no longer active")
+		c.invalidateLeaderLookupCache()
+		resp.Body.Close()
+		activeConn.Close()
+		return ErrForwardingNodeNotActive
+	}
+	resp.Header.Del(upgradeForwardingClusterIDHeaderName)
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		defer activeConn.Close()
+		defer resp.Body.Close()
+		for k, v := range resp.Header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+		return nil
+	}
+
+	clientConn, clientBuf, err := hijacker.Hijack()
+	if err != nil {
+		activeConn.Close()
+		return err
+	}
+
+	// Relay the handshake response, then anything either side sent after it
+	// that is already sitting in a buffer
+	fmt.Fprintf(clientBuf, "HTTP/1.1 %s\r\n", resp.Status)
+	resp.Header.Write(clientBuf)
+	clientBuf.WriteString("\r\n")
+	if n := activeReader.Buffered(); n > 0 {
+		io.CopyN(clientBuf, activeReader, int64(n))
+	}
+	if err := clientBuf.Flush(); err != nil {
+		clientConn.Close()
+		activeConn.Close()
+		return nil
+	}
+	if n := clientBuf.Reader.Buffered(); n > 0 {
+		if _, err := io.CopyN(activeConn, clientBuf.Reader, int64(n)); err != nil {
+			clientConn.Close()
+			activeConn.Close()
+			return nil
+		}
+	}
+
+	proxyConns(clientConn, activeConn)
+	return nil
+}
+
+// proxyConns copies bytes between the two connections until one side is
+// done, then closes both.
+func proxyConns(a, b net.Conn) {
+	var once sync.Once
+	closeBoth := func() {
+		a.Close()
+		b.Close()
+	}
+
+	doneCh := make(chan struct{}, 2)
+	go func() {
+		io.Copy(a, b)
+		once.Do(closeBoth)
+		doneCh <- struct{}{}
+	}()
+	go func() {
+		io.Copy(b, a)
+		once.Do(closeBoth)
+		doneCh <- struct{}{}
+	}()
+	<-doneCh
+	<-doneCh
+}
+
+// serveUpgradeConn serves a single forwarded upgrade connection on the active
+// node with the cluster handler. Handlers that upgrade hijack the connection
+// and own it from then on. The connection is accounted to the peer as one
+// request once it is done, with everything the peer sent over it.
+func (c *Core) serveUpgradeConn(conn *tls.Conn, shutdownWg *sync.WaitGroup, closeCh chan struct{}) {
+	counted := &countingConn{Conn: conn}
+	ln := newSingleConnListener(counted)
+
+	shutdownWg.Add(2)
+	go func() {
+		defer shutdownWg.Done()
+		select {
+		case <-ln.doneCh:
+		case <-closeCh:
+			conn.Close()
+		}
+		c.clusterPeerStats.record(clusterPeerIDFromTLSState(conn.ConnectionState()), 1, int(atomic.LoadUint64(&counted.read)), c.clock.Now())
+	}()
+	go func() {
+		defer shutdownWg.Done()
+		srv := &http.Server{
+			Handler:     c.upgradeForwardingHandler(c.clusterHandler, conn.ConnectionState()),
+			ReadTimeout: upgradeForwardingReadTimeout,
+			IdleTimeout: upgradeForwardingIdleTimeout,
+			ErrorLog:    c.logger.StandardLogger(nil),
+		}
+		srv.Serve(ln)
+	}()
+}
+
+// upgradeForwardingHandler makes the same checks on forwarded upgrade
+// requests as are made on forwarding RPCs before passing them on to handler:
+// the node must still be active, and the standby must not believe it is in
+// another cluster unless its cert is trusted through
+// CoreConfig.ClusterTrustedPeerCerts. The response tells the standby which
+// cluster answered.
+func (c *Core) upgradeForwardingHandler(handler http.Handler, state tls.ConnectionState) http.Handler {
+	var peerCert *x509.Certificate
+	if chains := state.VerifiedChains; len(chains) > 0 && len(chains[0]) > 0 {
+		peerCert = chains[0][0]
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c.Sealed() || atomic.LoadUint32(c.leavingActiveDuty) == 1 {
+			w.Header().Set(upgradeForwardingRefusedHeaderName, "not active")
+			http.Error(w, "node is not active", http.StatusServiceUnavailable)
+			return
+		}
+
+		clusterID := c.localClusterID.Load().(string)
+		peerClusterID := r.Header.Get(upgradeForwardingClusterIDHeaderName)
+		r.Header.Del(upgradeForwardingClusterIDHeaderName)
+		if clusterID != "" {
+			w.Header().Set(upgradeForwardingClusterIDHeaderName, clusterID)
+		}
+		if clusterID != "" && peerClusterID != "" && peerClusterID != clusterID && !c.isClusterTrustedPeer(peerCert) {
+			c.logger.Warn("refusing forwarded upgrade request from another cluster", "cluster_id", peerClusterID)
+			http.Error(w, fmt.Sprintf("request forwarded from cluster %q, this is cluster %q", peerClusterID, clusterID), http.StatusPreconditionFailed)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// singleConnListener is a net.Listener that hands out a single connection
+// and then blocks until that connection has been closed.
+type singleConnListener struct {
+	conn   net.Conn
+	connCh chan net.Conn
+	doneCh chan struct{}
+}
+
+func newSingleConnListener(conn net.Conn) *singleConnListener {
+	l := &singleConnListener{
+		conn:   conn,
+		connCh: make(chan net.Conn, 1),
+		doneCh: make(chan struct{}),
+	}
+	l.connCh <- &notifyCloseConn{Conn: conn, doneCh: l.doneCh}
+	return l
+}
+
+func (l *singleConnListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.connCh:
+		return conn, nil
+	case <-l.doneCh:
+		return nil, errors.New("connection closed")
+	}
+}
+
+func (l *singleConnListener) Close() error {
+	return nil
+}
+
+func (l *singleConnListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+// notifyCloseConn closes doneCh the first time the connection is closed.
+type notifyCloseConn struct {
+	net.Conn
+	once   sync.Once
+	doneCh chan struct{}
+}
+
+func (c *notifyCloseConn) Close() error {
+	c.once.Do(func() { close(c.doneCh) })
+	return c.Conn.Close()
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
 	"strings"
 	"time"
 
@@ -33,6 +34,10 @@ var (
 	// to complete, unless overridden on a per-handler basis
 	DefaultMaxRequestDuration = 90 * time.Second
 
+	// ErrRequestTimeout is returned by HandleRequest when a request runs
+	// longer than the core's configured request timeout
+	ErrRequestTimeout = logical.CodedError(http.StatusGatewayTimeout, "request timed out")
+
 	egpDebugLogging bool
 )
 
@@ -354,7 +359,14 @@ func (c *Core) HandleRequest(httpCtx context.Context, req *logical.Request) (res
 		return nil, consts.ErrStandby
 	}
 
-	ctx, cancel := context.WithCancel(c.activeContext)
+	requestTimeout := c.currentRequestTimeout()
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if requestTimeout > 0 {
+		ctx, cancel = context.WithTimeout(c.activeContext, requestTimeout)
+	} else {
+		ctx, cancel = context.WithCancel(c.activeContext)
+	}
 	go func(ctx context.Context, httpCtx context.Context) {
 		select {
 		case <-ctx.Done():
@@ -371,12 +383,81 @@ func (c *Core) HandleRequest(httpCtx context.Context, req *logical.Request) (res
 	}
 	ctx = namespace.ContextWithNamespace(ctx, ns)
 
-	resp, err = c.handleCancelableRequest(ctx, ns, req)
+	if requestTimeout == 0 {
+		resp, err = c.handleCancelableRequest(ctx, ns, req)
+
+		req.SetTokenEntry(nil)
+		cancel()
+		c.stateLock.RUnlock()
+		return resp, err
+	}
+
+	// Run the request separately so that a backend that ignores its context
+	// can't hold the caller past the timeout. It works on its own copy of the
+	// request, which is copied back once it finishes in time, so that a
+	// backend that keeps running can't race with the caller. The state lock
+	// is owned by the worker and only released once the backend returns, so
+	// such a backend keeps the node from sealing or stepping down while it
+	// may still be writing.
+	type handleResult struct {
+		resp *logical.Response
+		err  error
+	}
+	workerReq := copyRequestForWorker(req)
+	resultCh := make(chan handleResult, 1)
+	go func() {
+		resp, err := c.handleCancelableRequest(ctx, ns, workerReq)
 
+		workerReq.SetTokenEntry(nil)
+		cancel()
+		c.stateLock.RUnlock()
+		resultCh <- handleResult{resp: resp, err: err}
+	}()
+
+	finished := func(r handleResult) (*logical.Response, error) {
+		*req = *workerReq
+		return r.resp, r.err
+	}
+	select {
+	case r := <-resultCh:
+		return finished(r)
+	case <-ctx.Done():
+	}
+
+	// The context may have been cancelled by the caller or by the request
+	// finishing; only a hit deadline is reported as a timeout.
+	if ctx.Err() != context.DeadlineExceeded {
+		return finished(<-resultCh)
+	}
+	select {
+	case r := <-resultCh:
+		return finished(r)
+	default:
+	}
 	req.SetTokenEntry(nil)
-	cancel()
-	c.stateLock.RUnlock()
-	return resp, err
+
+	c.logger.Warn("request timed out", "path", req.Path, "operation", req.Operation, "timeout", requestTimeout)
+	metrics.IncrCounter([]string{"core", "handle_request", "timeout"}, 1)
+	return nil, ErrRequestTimeout
+}
+
+// copyRequestForWorker returns a copy of req for HandleRequest to hand to the
+// goroutine running it, with its own Data and Headers maps
+func copyRequestForWorker(req *logical.Request) *logical.Request {
+	workerReq := *req
+	if req.Data != nil {
+		workerReq.Data = make(map[string]interface{}, len(req.Data))
+		for k, v := range req.Data {
+			workerReq.Data[k] = v
+		}
+	}
+	if req.Headers != nil {
+		workerReq.Headers = make(map[string][]string, len(req.Headers))
+		for k, v := range req.Headers {
+			workerReq.Headers[k] = v
+		}
+	}
+	return &workerReq
 }
 
 func (c *Core) handleCancelableRequest(ctx context.Context, ns *namespace.Namespace, req *logical.Request) (resp *logical.Response, err error) {
@@ -392,6 +473,23 @@ func (c *Core) handleCancelableRequest(ctx context.Context, ns *namespace.Namesp
 		return logical.ErrorResponse("cannot write to a path ending in '/'"), nil
 	}
 
+	if err := c.rejectedForMaintenance(req); err != nil {
+		metrics.IncrCounter([]string{"core", "handle_request", "maintenance"}, 1)
+		return nil, err
+	}
+
+	if err := c.deferredForActiveWriteGrace(req); err != nil {
+		metrics.IncrCounter([]string{"core", "handle_request", "active_write_grace"}, 1)
+		return nil, err
+	}
+
+	if c.requestLimiter != nil {
+		if allowed, retryAfter := c.requestLimiter.Allow(req.ClientToken, req.Path); !allowed {
+			metrics.IncrCounter([]string{"core", "handle_request", "rate_limited"}, 1)
+			return rateLimitedResponse(retryAfter)
+		}
+	}
+
 	err = waitForReplicationState(ctx, c, req)
 	if err != nil {
 		return nil, err
@@ -1,6 +1,7 @@
 package vault
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -144,3 +145,103 @@ func TestRequestHandling_LoginWrapping(t *testing.T) {
 		t.Fatalf("bad: %#v", resp)
 	}
 }
+
+func TestRequestHandling_RequestTimeout(t *testing.T) {
+	releaseCh := make(chan struct{})
+	ctxErrCh := make(chan error, 1)
+	sleepy := func(context.Context, *logical.BackendConfig) (logical.Backend, error) {
+		return &NoopBackend{
+			RequestHandler: func(ctx context.Context, req *logical.Request) (*logical.Response, error) {
+				switch req.Path {
+				case "ignores-context":
+					<-releaseCh
+				case "respects-context":
+					<-ctx.Done()
+					ctxErrCh <- ctx.Err()
+					return nil, ctx.Err()
+				}
+				return &logical.Response{Data: map[string]interface{}{"ok": true}}, nil
+			},
+		}, nil
+	}
+
+	core, _, root := TestCoreUnsealedWithConfig(t, &CoreConfig{
+		RequestTimeout: 100 * time.Millisecond,
+		LogicalBackends: map[string]logical.Factory{
+			"sleepy": sleepy,
+		},
+	})
+
+	meUUID, _ := uuid.GenerateUUID()
+	err := core.mount(namespace.RootContext(nil), &MountEntry{
+		Table: mountTableType,
+		UUID:  meUUID,
+		Path:  "sleepy/",
+		Type:  "sleepy",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read := func(path string) (*logical.Response, error) {
+		return core.HandleRequest(namespace.RootContext(nil), &logical.Request{
+			Path:        "sleepy/" + path,
+			ClientToken: root,
+			Operation:   logical.ReadOperation,
+		})
+	}
+
+	// A backend that ignores its context must not hold the caller past the
+	// timeout
+	start := time.Now()
+	_, err = read("ignores-context")
+	if err != ErrRequestTimeout {
+		t.Fatalf("expected ErrRequestTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("request took %s to time out", elapsed)
+	}
+
+	// The state lock stays held until the backend really returns, so it
+	// can't still be writing once the node has sealed or stepped down
+	lockedCh := make(chan struct{})
+	go func() {
+		core.stateLock.Lock()
+		core.stateLock.Unlock()
+		close(lockedCh)
+	}()
+	select {
+	case <-lockedCh:
+		t.Fatal("state lock released while the backend was still running")
+	case <-time.After(200 * time.Millisecond):
+	}
+	close(releaseCh)
+	select {
+	case <-lockedCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("state lock still held after the backend returned")
+	}
+
+	// A backend that respects its context should see the deadline
+	_, err = read("respects-context")
+	if err != ErrRequestTimeout {
+		t.Fatalf("expected ErrRequestTimeout, got %v", err)
+	}
+	select {
+	case ctxErr := <-ctxErrCh:
+		if ctxErr != context.DeadlineExceeded {
+			t.Fatalf("expected backend to see deadline exceeded, got %v", ctxErr)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("backend context was never cancelled")
+	}
+
+	// Requests that finish in time are unaffected
+	resp, err := read("fast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.Data["ok"] != true {
+		t.Fatalf("bad: %#v", resp)
+	}
+}
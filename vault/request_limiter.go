@@ -0,0 +1,126 @@
+package vault
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+// RequestLimiter is consulted before a request is handled and decides whether
+// it may proceed. When a request is denied, retryAfter is how long the client
+// should wait before trying again. It is not installed unless one is set in
+// CoreConfig.
+type RequestLimiter interface {
+	Allow(token, path string) (allowed bool, retryAfter time.Duration)
+}
+
+// TokenBucketRequestLimiter is a RequestLimiter that keeps a token bucket for
+// every distinct client token and request path.
+type TokenBucketRequestLimiter struct {
+	l       sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[tokenBucketKey]*tokenBucket
+
+	// Used to control time in tests
+	now func() time.Time
+}
+
+var _ RequestLimiter = (*TokenBucketRequestLimiter)(nil)
+
+type tokenBucketKey struct {
+	token string
+	path  string
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucketRequestLimiter returns a limiter that allows, for each client
+// token and path, up to burst requests at once, refilled at rate requests per
+// second.
+func NewTokenBucketRequestLimiter(rate float64, burst int) *TokenBucketRequestLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &TokenBucketRequestLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[tokenBucketKey]*tokenBucket),
+		now:     time.Now,
+	}
+}
+
+// Allow takes a token from the bucket for the given client token and path,
+// if one is available.
+func (t *TokenBucketRequestLimiter) Allow(token, path string) (bool, time.Duration) {
+	t.l.Lock()
+	defer t.l.Unlock()
+
+	now := t.now()
+	t.prune(now)
+
+	key := tokenBucketKey{token: token, path: path}
+	b, ok := t.buckets[key]
+	if !ok {
+		b = &tokenBucket{
+			tokens: t.burst,
+			last:   now,
+		}
+		t.buckets[key] = b
+	}
+
+	b.tokens = math.Min(t.burst, b.tokens+now.Sub(b.last).Seconds()*t.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	if t.rate <= 0 {
+		return false, 0
+	}
+	wait := time.Duration((1 - b.tokens) / t.rate * float64(time.Second))
+	return false, wait
+}
+
+// prune drops buckets that would have refilled completely by now, since a
+// fresh bucket behaves identically. Must be called with the lock held.
+func (t *TokenBucketRequestLimiter) prune(now time.Time) {
+	if t.rate <= 0 || len(t.buckets) < 1024 {
+		return
+	}
+	for key, b := range t.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*t.rate >= t.burst {
+			delete(t.buckets, key)
+		}
+	}
+}
+
+// rateLimitedResponse builds the 429 response returned for a request the
+// limiter denied. The body is shaped like any other error response, plus the
+// number of seconds the client should wait before retrying.
+func rateLimitedResponse(retryAfter time.Duration) (*logical.Response, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"errors":      []string{"request rate limit exceeded"},
+		"retry_after": int64(math.Ceil(retryAfter.Seconds())),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			logical.HTTPContentType: "application/json",
+			logical.HTTPStatusCode:  http.StatusTooManyRequests,
+			logical.HTTPRawBody:     string(body),
+		},
+	}, nil
+}
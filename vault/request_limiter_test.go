@@ -0,0 +1,43 @@
+package vault
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucketRequestLimiter(t *testing.T) {
+	now := time.Now()
+	l := NewTokenBucketRequestLimiter(1, 2)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if allowed, _ := l.Allow("token", "secret/foo"); !allowed {
+			t.Fatalf("request %d should have been allowed", i)
+		}
+	}
+
+	allowed, retryAfter := l.Allow("token", "secret/foo")
+	if allowed {
+		t.Fatal("request over the burst should have been denied")
+	}
+	if retryAfter <= 0 || retryAfter > time.Second {
+		t.Fatalf("bad retry after: %s", retryAfter)
+	}
+
+	// Other tokens and paths have their own buckets
+	if allowed, _ := l.Allow("other", "secret/foo"); !allowed {
+		t.Fatal("other token should have been allowed")
+	}
+	if allowed, _ := l.Allow("token", "secret/bar"); !allowed {
+		t.Fatal("other path should have been allowed")
+	}
+
+	// The bucket refills at the configured rate
+	now = now.Add(retryAfter)
+	if allowed, _ := l.Allow("token", "secret/foo"); !allowed {
+		t.Fatal("request should have been allowed after waiting")
+	}
+	if allowed, _ := l.Allow("token", "secret/foo"); allowed {
+		t.Fatal("request should have been denied again")
+	}
+}
@@ -0,0 +1,42 @@
+package vault
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/helper/salt"
+)
+
+// rootTokenHashPrefix identifies the format of hashes from HashRootToken
+const rootTokenHashPrefix = "sha256:"
+
+// HashRootToken returns a salted SHA-256 hash of the given root token that
+// can be kept in place of the token itself and later checked with
+// VerifyRootToken. The hash has the form "sha256:<salt>:<hex digest>" with a
+// fresh random salt, so hashing the same token twice gives different
+// results. An empty string is returned if no salt could be generated.
+func (c *Core) HashRootToken(token string) string {
+	s, err := uuid.GenerateUUID()
+	if err != nil {
+		c.logger.Error("failed to generate root token hash salt", "error", err)
+		return ""
+	}
+	return rootTokenHashPrefix + s + ":" + salt.SaltID(s, token, salt.SHA256Hash)
+}
+
+// VerifyRootToken reports whether token is the one hashed by HashRootToken to
+// produce hash. The digests are compared in constant time. No lookup of the
+// token is performed, so this does not say whether it is still valid.
+func (c *Core) VerifyRootToken(token, hash string) bool {
+	if !strings.HasPrefix(hash, rootTokenHashPrefix) {
+		return false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(hash, rootTokenHashPrefix), ":", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return false
+	}
+
+	expected := salt.SaltID(parts[0], token, salt.SHA256Hash)
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(parts[1])) == 1
+}
@@ -0,0 +1,274 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/helper/consts"
+	"github.com/hashicorp/vault/helper/jsonutil"
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/logical"
+)
+
+const (
+	// defaultRootTokenRotationGracePeriod is how long a rotated-out root
+	// token stays valid, if not set in CoreConfig
+	defaultRootTokenRotationGracePeriod = 5 * time.Minute
+
+	// rootTokenRotationRetryInterval is how long the active node waits
+	// before trying again after a periodic rotation failed
+	rootTokenRotationRetryInterval = time.Minute
+
+	// rootTokenRotatedOutMetaKey marks a root token that has been rotated
+	// out, with the time it happened
+	rootTokenRotatedOutMetaKey = "root_token_rotated_out"
+
+	// coreRootTokenRotationPath holds the root token that is rotated
+	// periodically
+	coreRootTokenRotationPath = "core/root-token-rotation"
+)
+
+var (
+	// ErrRootTokenRotating is returned by RotateRootToken for a root token
+	// that has already been rotated out and is within its grace period
+	ErrRootTokenRotating = errors.New("root token has already been rotated")
+)
+
+// RootTokenDeliveryFunc hands a root token issued by periodic rotation to
+// whoever is to use it next, e.g. by encrypting it to an operator's key and
+// storing it where they can pick it up. If it returns an error, the new token
+// is revoked and the old one stays in use until the next attempt. It is
+// called with the state lock held, so it must not call back into the core.
+type RootTokenDeliveryFunc func(ctx context.Context, token string) error
+
+// rotatingRootToken is the root token the active node rotates periodically,
+// by accessor so that the token itself isn't stored in one more place
+type rotatingRootToken struct {
+	Accessor  string `json:"accessor"`
+	RotatedAt int64  `json:"rotated_at"`
+}
+
+// RotateRootToken issues a new root token in place of the given one and
+// returns it. The old token keeps working for the configured grace period so
+// that requests already using it can finish, and is then revoked along with
+// any tokens created from it. The revocation is handed to the expiration
+// manager, so it happens even if Vault is sealed and unsealed in between.
+// With CoreConfig.RootTokenRotationPeriod set, the returned token is then
+// rotated by the active node every period, each new token being handed to
+// CoreConfig.RootTokenDelivery. This method errors out when Vault is sealed
+// or in standby.
+func (c *Core) RotateRootToken(ctx context.Context, token string) (string, error) {
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+	if c.Sealed() {
+		return "", consts.ErrSealed
+	}
+	if c.standby {
+		return "", consts.ErrStandby
+	}
+
+	c.rootTokenRotationLock.Lock()
+	defer c.rootTokenRotationLock.Unlock()
+
+	return c.rotateRootToken(namespace.RootContext(ctx), token, nil)
+}
+
+// rotateRootToken replaces the given root token, passing the new one to
+// deliver if set before the old one is rotated out. With periodic rotation
+// configured, the new token is the one rotated next. The caller must hold the
+// state lock for reading and rootTokenRotationLock.
+func (c *Core) rotateRootToken(ctx context.Context, token string, deliver RootTokenDeliveryFunc) (string, error) {
+	te, err := c.tokenStore.Lookup(ctx, token)
+	if err != nil {
+		return "", errwrap.Wrapf("failed to look up root token: {{err}}", err)
+	}
+	if te == nil || len(te.Policies) != 1 || te.Policies[0] != "root" {
+		return "", errors.New("token is not a valid root token")
+	}
+	if _, ok := te.Meta[rootTokenRotatedOutMetaKey]; ok {
+		return "", ErrRootTokenRotating
+	}
+
+	newTE, err := c.tokenStore.rootToken(ctx)
+	if err != nil {
+		c.logger.Error("root token generation failed", "error", err)
+		return "", err
+	}
+	if deliver != nil {
+		if err := deliver(ctx, newTE.ID); err != nil {
+			c.tokenStore.revokeOrphan(ctx, newTE.ID)
+			c.logger.Error("failed to deliver rotated root token", "error", err)
+			return "", errwrap.Wrapf("failed to deliver rotated root token: {{err}}", err)
+		}
+	}
+
+	// Register the lease that revokes the old token before giving the token
+	// a TTL; a token with a TTL but no lease is revoked as soon as it is
+	// looked up, which would cut off requests still using it. A token that
+	// expires within the grace period anyway is left to do so.
+	now := c.clock.Now()
+	grace := c.rootTokenRotationGracePeriod
+	if te.TTL == 0 || time.Unix(te.CreationTime, 0).Add(te.TTL).After(now.Add(grace)) {
+		auth := &logical.Auth{
+			ClientToken:   te.ID,
+			Accessor:      te.Accessor,
+			DisplayName:   te.DisplayName,
+			Policies:      te.Policies,
+			TokenPolicies: te.Policies,
+			TokenType:     te.Type,
+			LeaseOptions: logical.LeaseOptions{
+				TTL:       grace,
+				IssueTime: now,
+			},
+		}
+		if err := c.expiration.RegisterAuth(ctx, te, auth); err != nil {
+			c.tokenStore.revokeOrphan(ctx, newTE.ID)
+			c.logger.Error("failed to register rotated root token lease", "error", err)
+			return "", err
+		}
+		te.TTL = now.Sub(time.Unix(te.CreationTime, 0)) + grace
+	}
+
+	// The old token is revoked once the lease expires whether or not this
+	// succeeds, so the new token is returned regardless; the marker only
+	// keeps the old token from being rotated again.
+	if te.Meta == nil {
+		te.Meta = make(map[string]string)
+	}
+	te.Meta[rootTokenRotatedOutMetaKey] = now.UTC().Format(time.RFC3339)
+	if err := c.tokenStore.store(ctx, te); err != nil {
+		c.logger.Warn("failed to store rotated root token", "error", err)
+	}
+
+	if c.rootTokenRotationPeriod > 0 {
+		if err := c.storeRotatingRootToken(ctx, &rotatingRootToken{
+			Accessor:  newTE.Accessor,
+			RotatedAt: now.Unix(),
+		}); err != nil {
+			c.logger.Warn("failed to store root token for periodic rotation", "error", err)
+		}
+	}
+
+	c.logger.Info("root token rotated", "grace_period", grace)
+	return newTE.ID, nil
+}
+
+func (c *Core) storeRotatingRootToken(ctx context.Context, rotating *rotatingRootToken) error {
+	raw, err := jsonutil.EncodeJSON(rotating)
+	if err != nil {
+		return err
+	}
+	return c.barrier.Put(ctx, &Entry{
+		Key:   coreRootTokenRotationPath,
+		Value: raw,
+	})
+}
+
+func (c *Core) loadRotatingRootToken(ctx context.Context) (*rotatingRootToken, error) {
+	entry, err := c.barrier.Get(ctx, coreRootTokenRotationPath)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+	var rotating rotatingRootToken
+	if err := jsonutil.DecodeJSON(entry.Value, &rotating); err != nil {
+		return nil, err
+	}
+	return &rotating, nil
+}
+
+// startRootTokenRotation starts rotating the root token last returned by
+// RotateRootToken every CoreConfig.RootTokenRotationPeriod, if set. It is
+// assumed that the state lock is held while this is run.
+func (c *Core) startRootTokenRotation() {
+	if c.rootTokenRotationPeriod == 0 || c.IsDRSecondary() {
+		return
+	}
+
+	stopCh := make(chan struct{})
+	c.rootTokenRotationStopCh = stopCh
+	go c.runRootTokenRotation(c.activeContext, stopCh)
+}
+
+// stopRootTokenRotation stops periodic root token rotation. It is assumed
+// that the state lock is held while this is run.
+func (c *Core) stopRootTokenRotation() {
+	if c.rootTokenRotationStopCh != nil {
+		close(c.rootTokenRotationStopCh)
+		c.rootTokenRotationStopCh = nil
+	}
+}
+
+// runRootTokenRotation rotates the root token whenever it is due until stopCh
+// is closed
+func (c *Core) runRootTokenRotation(ctx context.Context, stopCh chan struct{}) {
+	ctx = namespace.RootContext(ctx)
+	wait := time.Duration(0)
+	for {
+		fireCh := make(chan struct{})
+		timer := c.clock.AfterFunc(wait, func() {
+			close(fireCh)
+		})
+		select {
+		case <-fireCh:
+		case <-stopCh:
+			timer.Stop()
+			return
+		}
+
+		var err error
+		wait, err = c.rotateRootTokenIfDue(ctx, stopCh)
+		if err != nil {
+			c.logger.Error("periodic root token rotation failed", "error", err)
+			wait = rootTokenRotationRetryInterval
+		}
+	}
+}
+
+// rotateRootTokenIfDue rotates the root token if its period is over, and
+// returns how long to wait before checking again
+func (c *Core) rotateRootTokenIfDue(ctx context.Context, stopCh chan struct{}) (time.Duration, error) {
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+
+	// Active duty may have been given up while waiting for the lock
+	select {
+	case <-stopCh:
+		return 0, nil
+	default:
+	}
+
+	c.rootTokenRotationLock.Lock()
+	defer c.rootTokenRotationLock.Unlock()
+
+	rotating, err := c.loadRotatingRootToken(ctx)
+	if err != nil {
+		return 0, errwrap.Wrapf("failed to load root token to rotate: {{err}}", err)
+	}
+	if rotating == nil {
+		// Nothing has been rotated yet, so there's no token to track
+		return c.rootTokenRotationPeriod, nil
+	}
+	if wait := time.Unix(rotating.RotatedAt, 0).Add(c.rootTokenRotationPeriod).Sub(c.clock.Now()); wait > 0 {
+		return wait, nil
+	}
+
+	aEntry, err := c.tokenStore.lookupByAccessor(ctx, rotating.Accessor, false, false)
+	if _, ok := err.(*logical.StatusBadRequest); !ok && err != nil {
+		return 0, errwrap.Wrapf("failed to look up root token to rotate: {{err}}", err)
+	}
+	if err != nil || aEntry.TokenID == "" {
+		// The token was revoked, so stop tracking it
+		c.logger.Warn("root token to rotate no longer exists, stopping periodic rotation")
+		if err := c.barrier.Delete(ctx, coreRootTokenRotationPath); err != nil {
+			return 0, err
+		}
+		return c.rootTokenRotationPeriod, nil
+	}
+
+	if _, err := c.rotateRootToken(ctx, aEntry.TokenID, c.rootTokenDelivery); err != nil {
+		return 0, err
+	}
+	return c.rootTokenRotationPeriod, nil
+}
@@ -0,0 +1,172 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestCore_RotateRootToken(t *testing.T) {
+	c, _, root := TestCoreUnsealedWithConfig(t, &CoreConfig{
+		RootTokenRotationGracePeriod: 2 * time.Second,
+	})
+
+	lookupSelf := func(token string) error {
+		req := logical.TestRequest(t, logical.ReadOperation, "auth/token/lookup-self")
+		req.ClientToken = token
+		_, err := c.HandleRequest(namespace.RootContext(nil), req)
+		return err
+	}
+
+	newRoot, err := c.RotateRootToken(context.Background(), root)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if newRoot == "" || newRoot == root {
+		t.Fatalf("bad: %q", newRoot)
+	}
+
+	// Both tokens work during the grace period
+	if err := lookupSelf(root); err != nil {
+		t.Fatalf("old token should still work: %v", err)
+	}
+	if err := lookupSelf(newRoot); err != nil {
+		t.Fatalf("new token should work: %v", err)
+	}
+
+	// The old token can't be rotated a second time
+	if _, err := c.RotateRootToken(context.Background(), root); err != ErrRootTokenRotating {
+		t.Fatalf("expected ErrRootTokenRotating, got: %v", err)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for lookupSelf(root) == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("old token still works after the grace period")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err := lookupSelf(newRoot); err != nil {
+		t.Fatalf("new token should still work: %v", err)
+	}
+
+	// Nor can a revoked one
+	if _, err := c.RotateRootToken(context.Background(), root); err == nil {
+		t.Fatal("expected error rotating a revoked token")
+	}
+}
+
+func TestCore_RotateRootToken_WithTTL(t *testing.T) {
+	c, _, root := TestCoreUnsealed(t)
+
+	// A root token that merely has a TTL hasn't been rotated out
+	req := logical.TestRequest(t, logical.UpdateOperation, "auth/token/create")
+	req.ClientToken = root
+	req.Data = map[string]interface{}{
+		"policies": []string{"root"},
+		"ttl":      "1h",
+	}
+	resp, err := c.HandleRequest(namespace.RootContext(nil), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err: %v, resp: %#v", err, resp)
+	}
+	withTTL := resp.Auth.ClientToken
+
+	newRoot, err := c.RotateRootToken(context.Background(), withTTL)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if newRoot == "" || newRoot == withTTL {
+		t.Fatalf("bad: %q", newRoot)
+	}
+	if _, err := c.RotateRootToken(context.Background(), withTTL); err != ErrRootTokenRotating {
+		t.Fatalf("expected ErrRootTokenRotating, got: %v", err)
+	}
+}
+
+func TestCore_RotateRootToken_Periodic(t *testing.T) {
+	clock := newTestClock(time.Now())
+	delivered := make(chan string, 10)
+	failDelivery := make(chan struct{}, 1)
+	c, _, root := TestCoreUnsealedWithConfig(t, &CoreConfig{
+		Clock:                   clock,
+		RootTokenRotationPeriod: time.Hour,
+		RootTokenDelivery: func(ctx context.Context, token string) error {
+			select {
+			case <-failDelivery:
+				return errors.New("delivery failed")
+			default:
+			}
+			delivered <- token
+			return nil
+		},
+	})
+
+	lookupSelf := func(token string) error {
+		req := logical.TestRequest(t, logical.ReadOperation, "auth/token/lookup-self")
+		req.ClientToken = token
+		_, err := c.HandleRequest(namespace.RootContext(nil), req)
+		return err
+	}
+
+	// Nothing is rotated before a root token has been picked to rotate
+	clock.Advance(2 * time.Hour)
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-delivered:
+		t.Fatal("rotated without a root token to rotate")
+	default:
+	}
+
+	first, err := c.RotateRootToken(context.Background(), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Once the period is over, the active node rotates the token on its own
+	// and delivers the new one. The rotation loop may not have picked up the
+	// clock yet, so keep advancing it until it does.
+	next := func() string {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			clock.Advance(time.Hour)
+			select {
+			case token := <-delivered:
+				return token
+			case <-time.After(50 * time.Millisecond):
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("root token was not rotated")
+			}
+		}
+	}
+	second := next()
+	if second == first {
+		t.Fatal("rotation delivered the same token")
+	}
+	if err := lookupSelf(second); err != nil {
+		t.Fatalf("delivered token should work: %v", err)
+	}
+	if _, err := c.RotateRootToken(context.Background(), first); err != ErrRootTokenRotating {
+		t.Fatalf("expected rotated token to be marked, got: %v", err)
+	}
+
+	// A failed delivery keeps the current token in use; the next attempt
+	// rotates it
+	failDelivery <- struct{}{}
+	third := next()
+	if third == second {
+		t.Fatal("rotation delivered the same token")
+	}
+	if err := lookupSelf(third); err != nil {
+		t.Fatalf("delivered token should work: %v", err)
+	}
+	if _, err := c.RotateRootToken(context.Background(), second); err != ErrRootTokenRotating {
+		t.Fatalf("expected rotated token to be marked, got: %v", err)
+	}
+}
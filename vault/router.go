@@ -111,6 +111,22 @@ func (r *Router) Mount(backend logical.Backend, prefix string, mountEntry *Mount
 		return fmt.Errorf("cannot mount under existing mount %q", existing)
 	}
 
+	if prefix == "" {
+		return fmt.Errorf("missing prefix to be used for router entry; mount_path: %q, mount_type: %q", mountEntry.Path, mountEntry.Type)
+	}
+
+	re, err := newRouteEntry(backend, mountEntry, storageView)
+	if err != nil {
+		return err
+	}
+	r.insertRouteEntry(prefix, re)
+
+	return nil
+}
+
+// newRouteEntry builds the route entry for a backend mounted with the given
+// mount entry and barrier view
+func newRouteEntry(backend logical.Backend, mountEntry *MountEntry, storageView *BarrierView) (*routeEntry, error) {
 	// Build the paths
 	paths := new(logical.Paths)
 	if backend != nil {
@@ -128,28 +144,142 @@ func (r *Router) Mount(backend logical.Backend, prefix string, mountEntry *Mount
 		storagePrefix: storageView.prefix,
 		storageView:   storageView,
 	}
-	re.rootPaths.Store(pathsToRadix(paths.Root))
-	re.loginPaths.Store(pathsToRadix(paths.Unauthenticated))
+	re.rootPaths.Store(newSpecialPaths(paths.Root))
+	re.loginPaths.Store(newSpecialPaths(paths.Unauthenticated))
 
 	switch {
-	case prefix == "":
-		return fmt.Errorf("missing prefix to be used for router entry; mount_path: %q, mount_type: %q", re.mountEntry.Path, re.mountEntry.Type)
 	case re.storagePrefix == "":
-		return fmt.Errorf("missing storage view prefix; mount_path: %q, mount_type: %q", re.mountEntry.Path, re.mountEntry.Type)
+		return nil, fmt.Errorf("missing storage view prefix; mount_path: %q, mount_type: %q", re.mountEntry.Path, re.mountEntry.Type)
 	case re.mountEntry.UUID == "":
-		return fmt.Errorf("missing mount identifier; mount_path: %q, mount_type: %q", re.mountEntry.Path, re.mountEntry.Type)
+		return nil, fmt.Errorf("missing mount identifier; mount_path: %q, mount_type: %q", re.mountEntry.Path, re.mountEntry.Type)
 	case re.mountEntry.Accessor == "":
-		return fmt.Errorf("missing mount accessor; mount_path: %q, mount_type: %q", re.mountEntry.Path, re.mountEntry.Type)
+		return nil, fmt.Errorf("missing mount accessor; mount_path: %q, mount_type: %q", re.mountEntry.Path, re.mountEntry.Type)
 	}
+	return re, nil
+}
 
+// insertRouteEntry adds a route entry to the radix trees; the caller must
+// hold the write lock
+func (r *Router) insertRouteEntry(prefix string, re *routeEntry) {
 	r.root.Insert(prefix, re)
 	r.storagePrefix.Insert(re.storagePrefix, re)
 	r.mountUUIDCache.Insert(re.mountEntry.UUID, re.mountEntry)
 	r.mountAccessorCache.Insert(re.mountEntry.Accessor, re.mountEntry)
+}
+
+// deleteRouteEntry removes a route entry from the radix trees; the caller
+// must hold the write lock
+func (r *Router) deleteRouteEntry(prefix string, re *routeEntry) {
+	r.root.Delete(prefix)
+	r.storagePrefix.Delete(re.storagePrefix)
+	r.mountUUIDCache.Delete(re.mountEntry.UUID)
+	r.mountAccessorCache.Delete(re.mountEntry.Accessor)
+}
+
+// routerMount is a backend to be mounted by replaceMounts
+type routerMount struct {
+	backend     logical.Backend
+	mountEntry  *MountEntry
+	storageView *BarrierView
+}
+
+// replaceMounts removes the mounts of the removed entries and mounts the
+// added backends in a single step, so no request is routed against a
+// partially applied change. If any of the new mounts can't be added the
+// router is left as it was. Cleanup is called on the removed backends once
+// the change is in place.
+func (r *Router) replaceMounts(ctx context.Context, removed []*MountEntry, added []routerMount) error {
+	r.l.Lock()
+	defer r.l.Unlock()
 
+	deleted, err := r.swapMounts(removed, added, false)
+	if err != nil {
+		return err
+	}
+	for _, re := range deleted {
+		if re.backend != nil {
+			re.backend.Cleanup(ctx)
+		}
+	}
 	return nil
 }
 
+// checkReplaceMounts returns the error replaceMounts would return for the
+// same arguments, without changing the router
+func (r *Router) checkReplaceMounts(removed []*MountEntry, added []routerMount) error {
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	_, err := r.swapMounts(removed, added, true)
+	return err
+}
+
+// swapMounts does the work of replaceMounts and returns the route entries it
+// removed. On error, or if dryRun is set, every change is undone before it
+// returns. The caller must hold the write lock.
+func (r *Router) swapMounts(removed []*MountEntry, added []routerMount, dryRun bool) ([]*routeEntry, error) {
+	type route struct {
+		prefix string
+		re     *routeEntry
+	}
+	var deleted, inserted []route
+	rollback := func() {
+		for _, rt := range inserted {
+			r.deleteRouteEntry(rt.prefix, rt.re)
+		}
+		for _, rt := range deleted {
+			r.insertRouteEntry(rt.prefix, rt.re)
+		}
+	}
+
+	for _, entry := range removed {
+		prefix := entry.Namespace().Path + entry.Path
+		raw, ok := r.root.Get(prefix)
+		if !ok {
+			continue
+		}
+		re := raw.(*routeEntry)
+		r.deleteRouteEntry(prefix, re)
+		deleted = append(deleted, route{prefix: prefix, re: re})
+	}
+
+	for _, m := range added {
+		prefix := m.mountEntry.Namespace().Path + m.mountEntry.Path
+		if existing, _, ok := r.root.LongestPrefix(prefix); ok && existing != "" {
+			rollback()
+			return nil, fmt.Errorf("cannot mount under existing mount %q", existing)
+		}
+		var nested string
+		r.root.WalkPrefix(prefix, func(existing string, _ interface{}) bool {
+			nested = existing
+			return true
+		})
+		if nested != "" {
+			rollback()
+			return nil, fmt.Errorf("cannot mount over existing mount %q", nested)
+		}
+
+		re, err := newRouteEntry(m.backend, m.mountEntry, m.storageView)
+		if err != nil {
+			rollback()
+			return nil, err
+		}
+		r.insertRouteEntry(prefix, re)
+		inserted = append(inserted, route{prefix: prefix, re: re})
+	}
+
+	if dryRun {
+		rollback()
+		return nil, nil
+	}
+
+	res := make([]*routeEntry, 0, len(deleted))
+	for _, rt := range deleted {
+		res = append(res, rt.re)
+	}
+	return res, nil
+}
+
 // Unmount is used to remove a logical backend from a given prefix
 func (r *Router) Unmount(ctx context.Context, prefix string) error {
 	ns, err := namespace.FromContext(ctx)
@@ -174,10 +304,7 @@ func (r *Router) Unmount(ctx context.Context, prefix string) error {
 	}
 
 	// Purge from the radix trees
-	r.root.Delete(prefix)
-	r.storagePrefix.Delete(re.storagePrefix)
-	r.mountUUIDCache.Delete(re.mountEntry.UUID)
-	r.mountAccessorCache.Delete(re.mountEntry.Accessor)
+	r.deleteRouteEntry(prefix, re)
 
 	return nil
 }
@@ -717,20 +844,8 @@ func (r *Router) RootPath(ctx context.Context, path string) bool {
 	remain := strings.TrimPrefix(adjustedPath, mount)
 
 	// Check the rootPaths of this backend
-	rootPaths := re.rootPaths.Load().(*radix.Tree)
-	match, raw, ok := rootPaths.LongestPrefix(remain)
-	if !ok {
-		return false
-	}
-	prefixMatch := raw.(bool)
-
-	// Handle the prefix match case
-	if prefixMatch {
-		return strings.HasPrefix(remain, match)
-	}
-
-	// Handle the exact match case
-	return match == remain
+	rootPaths := re.rootPaths.Load().(*specialPaths)
+	return rootPaths.matches(remain)
 }
 
 // LoginPath checks if the given path is used for logins
@@ -754,20 +869,8 @@ func (r *Router) LoginPath(ctx context.Context, path string) bool {
 	remain := strings.TrimPrefix(adjustedPath, mount)
 
 	// Check the loginPaths of this backend
-	loginPaths := re.loginPaths.Load().(*radix.Tree)
-	match, raw, ok := loginPaths.LongestPrefix(remain)
-	if !ok {
-		return false
-	}
-	prefixMatch := raw.(bool)
-
-	// Handle the prefix match case
-	if prefixMatch {
-		return strings.HasPrefix(remain, match)
-	}
-
-	// Handle the exact match case
-	return match == remain
+	loginPaths := re.loginPaths.Load().(*specialPaths)
+	return loginPaths.matches(remain)
 }
 
 // pathsToRadix converts a the mapping of special paths to a mapping
@@ -787,6 +890,93 @@ func pathsToRadix(paths []string) *radix.Tree {
 	return tree
 }
 
+// specialPaths matches paths relative to a mount against one kind of a
+// backend's special paths, such as its unauthenticated paths. Entries are
+// matched as follows:
+//
+//   - "foo" matches "foo" only
+//   - a trailing "*" makes the entry a prefix match, so "foo*" matches "foo",
+//     "foobar" and "foo/bar", and a bare "*" matches every path
+//   - a "+" path segment matches any single segment, so "foo/+/bar" matches
+//     "foo/a/bar" but not "foo/a/b/bar"; it can be combined with a trailing
+//     "*", as in "foo/+/*"
+//
+// A path is special if any entry matches it. There is no precedence between
+// entries beyond that: the order they are given in doesn't matter, and an
+// exact entry never hides a shorter prefix entry that also matches.
+type specialPaths struct {
+	// tree holds the entries without "+" segments, as built by pathsToRadix
+	tree      *radix.Tree
+	wildcards []wildcardPath
+}
+
+// wildcardPath is a special path entry containing "+" segments
+type wildcardPath struct {
+	segments []string
+	isPrefix bool
+}
+
+func newSpecialPaths(paths []string) *specialPaths {
+	sp := new(specialPaths)
+	var plain []string
+	for _, path := range paths {
+		isPrefix := strings.HasSuffix(path, "*")
+		segments := strings.Split(strings.TrimSuffix(path, "*"), "/")
+		if !strutil.StrListContains(segments, "+") {
+			plain = append(plain, path)
+			continue
+		}
+		sp.wildcards = append(sp.wildcards, wildcardPath{
+			segments: segments,
+			isPrefix: isPrefix,
+		})
+	}
+	sp.tree = pathsToRadix(plain)
+	return sp
+}
+
+// matches reports whether path matches any of the entries
+func (sp *specialPaths) matches(path string) bool {
+	// Visit every entry that is a prefix of the path, not just the longest,
+	// so that a longer exact entry doesn't hide a prefix entry
+	var matched bool
+	sp.tree.WalkPath(path, func(key string, raw interface{}) bool {
+		matched = raw.(bool) || key == path
+		return matched
+	})
+	if matched || len(sp.wildcards) == 0 {
+		return matched
+	}
+
+	segments := strings.Split(path, "/")
+	for _, w := range sp.wildcards {
+		if w.matches(segments) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w wildcardPath) matches(segments []string) bool {
+	if len(segments) < len(w.segments) || (!w.isPrefix && len(segments) != len(w.segments)) {
+		return false
+	}
+
+	last := len(w.segments) - 1
+	for i, pattern := range w.segments {
+		switch {
+		case pattern == "+":
+		case i == last && w.isPrefix:
+			if !strings.HasPrefix(segments[i], pattern) {
+				return false
+			}
+		case segments[i] != pattern:
+			return false
+		}
+	}
+	return true
+}
+
 // filteredPassthroughHeaders returns a headers map[string][]string that
 // contains the filtered values contained in passthroughHeaders. Filtering of
 // passthroughHeaders from the origHeaders is done is a case-insensitive manner.
@@ -476,6 +476,56 @@ func TestRouter_LoginPath(t *testing.T) {
 	}
 }
 
+func TestRouter_LoginPath_Wildcards(t *testing.T) {
+	cases := map[string]struct {
+		login  []string
+		path   string
+		expect bool
+	}{
+		"bare wildcard":             {[]string{"*"}, "anything/at/all", true},
+		"bare wildcard, empty path": {[]string{"*"}, "", true},
+		"prefix":                    {[]string{"public/*"}, "public/foo/bar", true},
+		"prefix, parent":            {[]string{"public/*"}, "public", false},
+		"prefix, sibling":           {[]string{"public/*"}, "publicity", false},
+		"partial segment prefix":    {[]string{"pub*"}, "publicity", true},
+		"exact":                     {[]string{"login"}, "login", true},
+		"exact, child":              {[]string{"login"}, "login/foo", false},
+		"exact, parent":             {[]string{"login/foo"}, "login", false},
+		"segment":                   {[]string{"users/+/login"}, "users/alice/login", true},
+		"segment, too deep":         {[]string{"users/+/login"}, "users/alice/bob/login", false},
+		"segment, mismatch":         {[]string{"users/+/login"}, "users/alice/logout", false},
+		"segment prefix":            {[]string{"users/+/*"}, "users/alice/keys/1", true},
+		"segment prefix, too short": {[]string{"users/+/*"}, "users/alice", false},
+		"segment trailing prefix":   {[]string{"users/+/log*"}, "users/alice/login", true},
+		// A longer exact entry doesn't hide a shorter prefix entry
+		"exact beside prefix":  {[]string{"*", "foo"}, "foo/bar", true},
+		"exact beside subtree": {[]string{"public/*", "public/foo"}, "public/foo/bar", true},
+	}
+
+	for name, tc := range cases {
+		r := NewRouter()
+		_, barrier, _ := mockBarrier(t)
+		view := NewBarrierView(barrier, "auth/")
+
+		meUUID, err := uuid.GenerateUUID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := &NoopBackend{
+			Login: tc.login,
+		}
+		err = r.Mount(n, "auth/foo/", &MountEntry{UUID: meUUID, Accessor: "authfooaccessor", NamespaceID: namespace.RootNamespaceID, namespace: namespace.RootNamespace}, view)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+
+		out := r.LoginPath(namespace.RootContext(nil), "auth/foo/"+tc.path)
+		if out != tc.expect {
+			t.Fatalf("%s: bad: login paths: %v path: %s expect: %v got %v", name, tc.login, tc.path, tc.expect, out)
+		}
+	}
+}
+
 func TestRouter_Taint(t *testing.T) {
 	r := NewRouter()
 	_, barrier, _ := mockBarrier(t)
@@ -148,10 +148,9 @@ func (d *defaultSeal) BarrierConfig(ctx context.Context) (*SealConfig, error) {
 		return nil, nil
 	}
 
-	var conf SealConfig
-
 	// Decode the barrier entry
-	if err := jsonutil.DecodeJSON(pe.Value, &conf); err != nil {
+	conf, err := decodeSealConfig(pe.Value)
+	if err != nil {
 		d.core.logger.Error("failed to decode seal configuration", "error", err)
 		return nil, errwrap.Wrapf("failed to decode seal configuration: {{err}}", err)
 	}
@@ -172,7 +171,7 @@ func (d *defaultSeal) BarrierConfig(ctx context.Context) (*SealConfig, error) {
 		return nil, errwrap.Wrapf("seal validation failed: {{err}}", err)
 	}
 
-	d.config.Store(&conf)
+	d.config.Store(conf)
 	return conf.Clone(), nil
 }
 
@@ -191,7 +190,7 @@ func (d *defaultSeal) SetBarrierConfig(ctx context.Context, config *SealConfig)
 	config.Type = d.BarrierType()
 
 	// Encode the seal configuration
-	buf, err := json.Marshal(config)
+	buf, err := encodeSealConfig(config)
 	if err != nil {
 		return errwrap.Wrapf("failed to encode seal configuration: {{err}}", err)
 	}
@@ -312,6 +311,63 @@ type SealConfig struct {
 	VerificationProgress [][]byte `json:"-"`
 }
 
+// sealConfigVersion is the version of the format seal configurations are
+// persisted in. Bump it, and add an upgrade to sealConfigUpgrades, whenever
+// that format changes.
+const sealConfigVersion = 1
+
+// sealConfigUpgrades maps each older persisted version to the function that
+// upgrades a configuration from it to the next version. They are applied in
+// turn when a configuration is read, so older entries are always handed out
+// in the current format.
+var sealConfigUpgrades = map[int]func(*SealConfig) error{
+	// Entries written before versioning was introduced have no version
+	// field but otherwise share the version 1 format
+	0: func(*SealConfig) error { return nil },
+}
+
+// persistedSealConfig is the stored form of a SealConfig, which adds the
+// version of the format it was written in.
+type persistedSealConfig struct {
+	*SealConfig
+	Version int `json:"version"`
+}
+
+// encodeSealConfig serializes conf for storage in the current format.
+func encodeSealConfig(conf *SealConfig) ([]byte, error) {
+	return json.Marshal(&persistedSealConfig{
+		SealConfig: conf,
+		Version:    sealConfigVersion,
+	})
+}
+
+// decodeSealConfig parses a stored seal configuration, upgrading it to the
+// current format if it was written in an older one. Configurations written
+// by a newer version of Vault are refused rather than misread.
+func decodeSealConfig(raw []byte) (*SealConfig, error) {
+	persisted := &persistedSealConfig{
+		SealConfig: new(SealConfig),
+	}
+	if err := jsonutil.DecodeJSON(raw, persisted); err != nil {
+		return nil, err
+	}
+
+	if persisted.Version > sealConfigVersion {
+		return nil, fmt.Errorf("seal configuration version %d is newer than the supported version %d", persisted.Version, sealConfigVersion)
+	}
+	for v := persisted.Version; v < sealConfigVersion; v++ {
+		upgrade, ok := sealConfigUpgrades[v]
+		if !ok {
+			return nil, fmt.Errorf("no upgrade available for seal configuration version %d", v)
+		}
+		if err := upgrade(persisted.SealConfig); err != nil {
+			return nil, errwrap.Wrapf(fmt.Sprintf("failed to upgrade seal configuration from version %d: {{err}}", v), err)
+		}
+	}
+
+	return persisted.SealConfig, nil
+}
+
 // Validate is used to sanity check the seal configuration
 func (s *SealConfig) Validate() error {
 	if s.SecretShares < 1 {
@@ -168,8 +168,7 @@ func (d *autoSeal) BarrierConfig(ctx context.Context) (*SealConfig, error) {
 		return nil, nil
 	}
 
-	conf := &SealConfig{}
-	err = json.Unmarshal(entry.Value, conf)
+	conf, err := decodeSealConfig(entry.Value)
 	if err != nil {
 		d.core.logger.Error("autoseal: failed to decode seal configuration", "seal_type", sealType, "error", err)
 		return nil, errwrap.Wrapf(fmt.Sprintf("failed to decode %q seal configuration: {{err}}", sealType), err)
@@ -205,7 +204,7 @@ func (d *autoSeal) SetBarrierConfig(ctx context.Context, conf *SealConfig) error
 	conf.Type = d.BarrierType()
 
 	// Encode the seal configuration
-	buf, err := json.Marshal(conf)
+	buf, err := encodeSealConfig(conf)
 	if err != nil {
 		return errwrap.Wrapf("failed to encode barrier seal configuration: {{err}}", err)
 	}
@@ -282,8 +281,8 @@ func (d *autoSeal) RecoveryConfig(ctx context.Context) (*SealConfig, error) {
 		}
 	}
 
-	conf := &SealConfig{}
-	if err := json.Unmarshal(entry.Value, conf); err != nil {
+	conf, err := decodeSealConfig(entry.Value)
+	if err != nil {
 		d.core.logger.Error("autoseal: failed to decode seal configuration", "seal_type", sealType, "error", err)
 		return nil, errwrap.Wrapf(fmt.Sprintf("failed to decode %q seal configuration: {{err}}", sealType), err)
 	}
@@ -323,7 +322,7 @@ func (d *autoSeal) SetRecoveryConfig(ctx context.Context, conf *SealConfig) erro
 	conf.Type = d.RecoveryType()
 
 	// Encode the seal configuration
-	buf, err := json.Marshal(conf)
+	buf, err := encodeSealConfig(conf)
 	if err != nil {
 		return errwrap.Wrapf("failed to encode recovery seal configuration: {{err}}", err)
 	}
@@ -2,8 +2,14 @@ package vault
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"reflect"
+	"strconv"
 	"testing"
+
+	"github.com/hashicorp/vault/helper/jsonutil"
+	"github.com/hashicorp/vault/physical"
 )
 
 func TestDefaultSeal_Config(t *testing.T) {
@@ -41,3 +47,75 @@ func TestDefaultSeal_Config(t *testing.T) {
 		t.Fatal("config mismatch")
 	}
 }
+
+func TestDefaultSeal_Config_Versioning(t *testing.T) {
+	core, keys, _ := TestCoreUnsealed(t)
+
+	// Stored configs carry the current format version
+	pe, err := core.physical.Get(context.Background(), barrierSealConfigPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored map[string]interface{}
+	if err := jsonutil.DecodeJSON(pe.Value, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := stored["version"].(json.Number); !ok || v.String() != strconv.Itoa(sealConfigVersion) {
+		t.Fatalf("bad stored version: %#v", stored["version"])
+	}
+
+	// Replace it with an entry from before versioning was introduced
+	current, err := core.seal.BarrierConfig(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	v0 := []byte(fmt.Sprintf(`{"type":"shamir","secret_shares":%d,"secret_threshold":%d,"pgp_keys":null,"nonce":"","backup":false,"stored_shares":0}`, current.SecretShares, current.SecretThreshold))
+	if err := core.physical.Put(context.Background(), &physical.Entry{
+		Key:   barrierSealConfigPath,
+		Value: v0,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	defSeal := NewDefaultSeal()
+	defSeal.SetCore(core)
+	conf, err := defSeal.BarrierConfig(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &SealConfig{
+		Type:            "shamir",
+		SecretShares:    current.SecretShares,
+		SecretThreshold: current.SecretThreshold,
+	}
+	if !reflect.DeepEqual(conf, expected) {
+		t.Fatalf("bad upgraded config: %#v", conf)
+	}
+
+	// The core itself carries on unsealing with the old entry
+	if err := core.sealInternal(); err != nil {
+		t.Fatal(err)
+	}
+	core.seal.SetCachedBarrierConfig(nil)
+	for _, key := range keys {
+		if _, err := TestCoreUnseal(core, TestKeyCopy(key)); err != nil {
+			t.Fatalf("unseal with v0 seal config failed: %v", err)
+		}
+	}
+	if core.Sealed() {
+		t.Fatal("should be unsealed")
+	}
+
+	// Entries from a newer format are refused rather than misread
+	if err := core.physical.Put(context.Background(), &physical.Entry{
+		Key:   barrierSealConfigPath,
+		Value: []byte(`{"type":"shamir","secret_shares":1,"secret_threshold":1,"version":99}`),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defSeal = NewDefaultSeal()
+	defSeal.SetCore(core)
+	if _, err := defSeal.BarrierConfig(context.Background()); err == nil {
+		t.Fatal("expected an error reading a newer seal config version")
+	}
+}
@@ -0,0 +1,85 @@
+package vault
+
+// ServiceRegistration is notified as this node gains and loses active duty,
+// so that the active node can be announced in an external service catalog.
+// Calls are made one at a time, in order, but in the background: the node
+// doesn't wait for them to change state.
+type ServiceRegistration interface {
+	// RegisterActive is called after this node has become active and its
+	// cluster listeners are up, with the address it advertises to clients
+	RegisterActive(addr string) error
+
+	// Deregister is called after this node has started to stop being active
+	Deregister() error
+}
+
+// serviceRegistrationUpdate is a queued call to the ServiceRegistration
+type serviceRegistrationUpdate struct {
+	active bool
+	addr   string
+}
+
+// registerActiveService queues announcing this node as active through the
+// configured ServiceRegistration, if any. It is called with the state lock
+// held, so the call itself is made later by runServiceRegistration; that
+// way a slow registry cannot hold up sealing or unsealing. Failures are
+// logged but do not stop the node from becoming active.
+func (c *Core) registerActiveService() {
+	c.queueServiceRegistration(serviceRegistrationUpdate{
+		active: true,
+		addr:   c.redirectAddr,
+	})
+}
+
+// deregisterActiveService queues withdrawing the announcement made by
+// registerActiveService.
+func (c *Core) deregisterActiveService() {
+	c.queueServiceRegistration(serviceRegistrationUpdate{})
+}
+
+// queueServiceRegistration adds an update to the queue and makes sure it is
+// being worked through
+func (c *Core) queueServiceRegistration(update serviceRegistrationUpdate) {
+	if c.serviceRegistration == nil {
+		return
+	}
+
+	c.serviceRegistrationLock.Lock()
+	defer c.serviceRegistrationLock.Unlock()
+	c.serviceRegistrationQueue = append(c.serviceRegistrationQueue, update)
+	if !c.serviceRegistrationRunning {
+		c.serviceRegistrationRunning = true
+		go c.runServiceRegistration()
+	}
+}
+
+// runServiceRegistration makes the queued ServiceRegistration calls in
+// order until the queue is empty
+func (c *Core) runServiceRegistration() {
+	for {
+		c.serviceRegistrationLock.Lock()
+		if len(c.serviceRegistrationQueue) == 0 {
+			c.serviceRegistrationRunning = false
+			c.serviceRegistrationLock.Unlock()
+			return
+		}
+		update := c.serviceRegistrationQueue[0]
+		c.serviceRegistrationQueue = c.serviceRegistrationQueue[1:]
+		c.serviceRegistrationLock.Unlock()
+
+		switch {
+		case update.active:
+			if err := c.serviceRegistration.RegisterActive(update.addr); err != nil {
+				c.logger.Error("failed to register active node", "redirect_addr", update.addr, "error", err)
+				continue
+			}
+			c.serviceRegistered = true
+
+		case c.serviceRegistered:
+			if err := c.serviceRegistration.Deregister(); err != nil {
+				c.logger.Error("failed to deregister active node", "error", err)
+			}
+			c.serviceRegistered = false
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package vault
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingServiceRegistration records the calls made to it, in order. If
+// block is set, registering waits for it to be closed first.
+type recordingServiceRegistration struct {
+	l      sync.Mutex
+	events []string
+	block  chan struct{}
+}
+
+func (r *recordingServiceRegistration) RegisterActive(addr string) error {
+	if r.block != nil {
+		<-r.block
+	}
+	r.l.Lock()
+	defer r.l.Unlock()
+	r.events = append(r.events, "register "+addr)
+	return nil
+}
+
+func (r *recordingServiceRegistration) Deregister() error {
+	r.l.Lock()
+	defer r.l.Unlock()
+	r.events = append(r.events, "deregister")
+	return nil
+}
+
+// waitEvents waits for n events to have been recorded and returns them
+func (r *recordingServiceRegistration) waitEvents(t *testing.T, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(clusterTestWaitTimeout)
+	for {
+		r.l.Lock()
+		events := append([]string(nil), r.events...)
+		r.l.Unlock()
+		if len(events) >= n || time.Now().After(deadline) {
+			return events
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCore_ServiceRegistration(t *testing.T) {
+	registration := &recordingServiceRegistration{}
+	cluster := NewTestCluster(t, &CoreConfig{
+		ServiceRegistration: registration,
+	}, nil)
+	cluster.Start()
+	defer cluster.Cleanup()
+
+	cores := cluster.Cores
+	TestWaitActive(t, cores[0].Core)
+
+	// Setup may cycle the first core through unseal more than once, but only
+	// it registers and it ends up registered
+	events := registration.waitEvents(t, 1)
+	for i, event := range events {
+		expected := "register " + cores[0].redirectAddr
+		if i%2 == 1 {
+			expected = "deregister"
+		}
+		if event != expected {
+			t.Fatalf("bad events: %v", events)
+		}
+	}
+	if len(events)%2 != 1 {
+		t.Fatalf("expected the active core to be registered, got %v", events)
+	}
+	registration.l.Lock()
+	registration.events = nil
+	registration.l.Unlock()
+
+	// Stepping down deregisters, and the new active node registers itself
+	testCluster_StepDown(t, cores[0], cluster.RootToken)
+	testWaitAnyActive(t, cores[1], cores[2])
+	var active *TestClusterCore
+	for _, core := range cores[1:] {
+		if standby, _ := core.Standby(); !standby {
+			active = core
+		}
+	}
+
+	expected := []string{"deregister", "register " + active.redirectAddr}
+	if events := registration.waitEvents(t, 2); !reflect.DeepEqual(events, expected) {
+		t.Fatalf("bad events: got %v, expected %v", events, expected)
+	}
+}
+
+func TestCore_ServiceRegistration_Slow(t *testing.T) {
+	registration := &recordingServiceRegistration{
+		block: make(chan struct{}),
+	}
+
+	// Neither unsealing nor sealing waits for the registry
+	doneCh := make(chan struct{})
+	var core *Core
+	go func() {
+		defer close(doneCh)
+		var root string
+		core, _, root = TestCoreUnsealedWithConfig(t, &CoreConfig{
+			ServiceRegistration: registration,
+		})
+		if err := core.Seal(root); err != nil {
+			t.Error(err)
+		}
+	}()
+	select {
+	case <-doneCh:
+	case <-time.After(clusterTestWaitTimeout):
+		t.Fatal("unsealing and sealing waited for the service registration")
+	}
+	if core == nil {
+		t.FailNow()
+	}
+
+	// The calls are still made, in order, once the registry catches up.
+	// Initializing may cycle the core through unseal as well.
+	close(registration.block)
+	deadline := time.Now().Add(clusterTestWaitTimeout)
+	for {
+		core.serviceRegistrationLock.Lock()
+		running := core.serviceRegistrationRunning
+		core.serviceRegistrationLock.Unlock()
+		if !running {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("service registration calls were not made")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	events := registration.waitEvents(t, 2)
+	if len(events) == 0 || len(events)%2 != 0 {
+		t.Fatalf("bad events: %v", events)
+	}
+	for i, event := range events {
+		expected := "register " + core.redirectAddr
+		if i%2 == 1 {
+			expected = "deregister"
+		}
+		if event != expected {
+			t.Fatalf("bad events: %v", events)
+		}
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/pem"
 	"errors"
 	"fmt"
@@ -23,6 +24,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -107,7 +109,9 @@ func TestCoreNewSeal(t testing.T) *Core {
 }
 
 // TestCoreWithConfig returns a pure in-memory, uninitialized core with the
-// specified core configurations overridden for testing.
+// specified core configurations overridden for testing. Any LogicalBackends
+// in conf are registered with this core only, on top of the defaults and
+// those added through AddTestLogicalBackend.
 func TestCoreWithConfig(t testing.T, conf *CoreConfig) *Core {
 	return TestCoreWithSealAndUI(t, conf)
 }
@@ -133,7 +137,61 @@ func TestCoreUI(t testing.T, enableUI bool) *Core {
 	return TestCoreWithSealAndUI(t, conf)
 }
 
+// TestPassthrough selects which passthrough backend, if any, a test core
+// registers for the kv mount type.
+type TestPassthrough int
+
+const (
+	// TestPassthroughDefault keeps the helper's usual choice
+	TestPassthroughDefault TestPassthrough = iota
+
+	// TestPassthroughLeased registers LeasedPassthroughBackendFactory
+	TestPassthroughLeased
+
+	// TestPassthroughNonLeased registers PassthroughBackendFactory
+	TestPassthroughNonLeased
+
+	// TestPassthroughNone registers a kv factory that always fails, so kv
+	// mounts, including the default secret/ mount, come up without a backend
+	TestPassthroughNone
+)
+
+// apply sets the kv factory in backends according to p. With
+// TestPassthroughDefault the map is left untouched.
+func (p TestPassthrough) apply(backends map[string]logical.Factory) {
+	switch p {
+	case TestPassthroughLeased:
+		backends["kv"] = LeasedPassthroughBackendFactory
+	case TestPassthroughNonLeased:
+		backends["kv"] = PassthroughBackendFactory
+	case TestPassthroughNone:
+		backends["kv"] = func(context.Context, *logical.BackendConfig) (logical.Backend, error) {
+			return nil, errors.New("kv backend disabled for this test core")
+		}
+	}
+}
+
+// TestCoreOptions holds test-only settings that have no counterpart in
+// CoreConfig. It is shared by TestCoreWithOptions and NewTestCluster (via
+// TestClusterOptions.CoreOptions) so that both entry points can be made to
+// behave the same way.
+type TestCoreOptions struct {
+	// Passthrough selects the kv backend. The defaults intentionally differ
+	// between helpers: a standalone test core uses the leased passthrough so
+	// that lease handling can be exercised, while a test cluster uses the
+	// non-leased passthrough that NewCore registers in production. Set this
+	// explicitly when shared code must not depend on the entry point.
+	Passthrough TestPassthrough
+}
+
 func TestCoreWithSealAndUI(t testing.T, opts *CoreConfig) *Core {
+	return TestCoreWithOptions(t, opts, nil)
+}
+
+// TestCoreWithOptions returns a pure in-memory, uninitialized core with the
+// specified core configurations overridden and the given test-only options
+// applied.
+func TestCoreWithOptions(t testing.T, opts *CoreConfig, testOpts *TestCoreOptions) *Core {
 	logger := logging.NewVaultLogger(log.Trace)
 	physicalBackend, err := physInmem.NewInmem(nil, logger)
 	if err != nil {
@@ -142,6 +200,9 @@ func TestCoreWithSealAndUI(t testing.T, opts *CoreConfig) *Core {
 
 	// Start off with base test core config
 	conf := testCoreConfig(t, physicalBackend, logger)
+	if testOpts != nil {
+		testOpts.Passthrough.apply(conf.LogicalBackends)
+	}
 
 	// Override config values with ones that gets passed in
 	conf.EnableUI = opts.EnableUI
@@ -149,6 +210,25 @@ func TestCoreWithSealAndUI(t testing.T, opts *CoreConfig) *Core {
 	conf.Seal = opts.Seal
 	conf.LicensingConfig = opts.LicensingConfig
 	conf.DisableKeyEncodingChecks = opts.DisableKeyEncodingChecks
+	conf.RequestTimeout = opts.RequestTimeout
+	conf.RequestLimiter = opts.RequestLimiter
+	conf.NodeID = opts.NodeID
+	conf.UnsealFailureThreshold = opts.UnsealFailureThreshold
+	conf.UnsealFailureWindow = opts.UnsealFailureWindow
+	conf.UnsealLockoutPeriod = opts.UnsealLockoutPeriod
+	conf.UnsealAuthorizer = opts.UnsealAuthorizer
+	conf.Clock = opts.Clock
+	conf.CompactLeaseStorage = opts.CompactLeaseStorage
+	conf.RootTokenRotationGracePeriod = opts.RootTokenRotationGracePeriod
+	conf.RootTokenRotationPeriod = opts.RootTokenRotationPeriod
+	conf.RootTokenDelivery = opts.RootTokenDelivery
+	conf.MaxClusterListeners = opts.MaxClusterListeners
+	conf.AuditRequestBodyLimit = opts.AuditRequestBodyLimit
+	conf.ActiveWriteGracePeriod = opts.ActiveWriteGracePeriod
+	conf.ServiceRegistration = opts.ServiceRegistration
+	for backendName, backendFactory := range opts.LogicalBackends {
+		conf.LogicalBackends[backendName] = backendFactory
+	}
 
 	c, err := NewCore(conf)
 	if err != nil {
@@ -532,7 +612,9 @@ func AddTestCredentialBackend(name string, factory logical.Factory) error {
 }
 
 // This adds a logical backend for the test core. This needs to be
-// invoked before the test core is created.
+// invoked before the test core is created. The backend is registered with
+// every test core created afterwards; to register one with a single core,
+// pass it in CoreConfig.LogicalBackends to TestCoreWithConfig instead.
 func AddTestLogicalBackend(name string, factory logical.Factory) error {
 	if name == "" {
 		return fmt.Errorf("missing backend name")
@@ -670,6 +752,27 @@ func GenerateRandBytes(length int) ([]byte, error) {
 	return buf, nil
 }
 
+// GenerateRandString returns length random bytes, as GenerateRandBytes does,
+// encoded as a string. The encoding is either "hex" or "base64", the latter
+// being unpadded base64url so that the result is safe in URLs and paths.
+func GenerateRandString(length int, encoding string) (string, error) {
+	var encode func([]byte) string
+	switch encoding {
+	case "hex":
+		encode = hex.EncodeToString
+	case "base64":
+		encode = base64.RawURLEncoding.EncodeToString
+	default:
+		return "", fmt.Errorf("unsupported encoding %q", encoding)
+	}
+
+	buf, err := GenerateRandBytes(length)
+	if err != nil {
+		return "", err
+	}
+	return encode(buf), nil
+}
+
 func TestWaitActive(t testing.T, core *Core) {
 	t.Helper()
 	if err := TestWaitActiveWithError(core); err != nil {
@@ -696,6 +799,32 @@ func TestWaitActiveWithError(core *Core) error {
 	return nil
 }
 
+// TestWaitStandby waits up to timeout for the core to enter standby mode,
+// e.g. after a step-down.
+func TestWaitStandby(t testing.T, core *Core, timeout time.Duration) {
+	t.Helper()
+	if err := TestWaitStandbyWithError(core, timeout); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWaitStandbyWithError(core *Core, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		standby, err := core.Standby()
+		if err != nil {
+			return err
+		}
+		if standby {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.New("should be in standby mode")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
 type TestCluster struct {
 	BarrierKeys   [][]byte
 	RecoveryKeys  [][]byte
@@ -837,9 +966,6 @@ func (c *TestCluster) Cleanup() {
 	if c.TempDir != "" {
 		os.RemoveAll(c.TempDir)
 	}
-
-	// Give time to actually shut down/clean up before the next test
-	time.Sleep(time.Second)
 }
 
 func (c *TestCluster) ensureCoresSealed() error {
@@ -885,6 +1011,77 @@ func SetReplicationFailureMode(core *TestClusterCore, mode uint32) {
 	atomic.StoreUint32(core.Core.replicationFailure, mode)
 }
 
+// RecordedRequest is a request captured by a RecordingHandler.
+type RecordedRequest struct {
+	Method string
+	Scheme string
+	Host   string
+	Path   string
+	Header http.Header
+	Body   []byte
+}
+
+// RecordingHandler is an http.Handler that records every request it serves
+// and answers each with the same canned response. It's meant to be mounted on
+// a test core's handler so forwarding tests can check exactly what the active
+// node received.
+type RecordingHandler struct {
+	// The response to send. A zero StatusCode means 200.
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+
+	l        sync.Mutex
+	requests []*RecordedRequest
+}
+
+// NewRecordingHandler returns a RecordingHandler that responds with an empty
+// 200.
+func NewRecordingHandler() *RecordingHandler {
+	return &RecordingHandler{
+		Header: make(http.Header),
+	}
+}
+
+func (h *RecordingHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	header := make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		header[k] = append([]string(nil), v...)
+	}
+
+	h.l.Lock()
+	h.requests = append(h.requests, &RecordedRequest{
+		Method: req.Method,
+		Scheme: req.URL.Scheme,
+		Host:   req.Host,
+		Path:   req.URL.Path,
+		Header: header,
+		Body:   body,
+	})
+	h.l.Unlock()
+
+	for k, v := range h.Header {
+		w.Header()[k] = v
+	}
+	if h.StatusCode != 0 {
+		w.WriteHeader(h.StatusCode)
+	}
+	w.Write(h.Body)
+}
+
+// Requests returns the requests served so far, oldest first.
+func (h *RecordingHandler) Requests() []*RecordedRequest {
+	h.l.Lock()
+	defer h.l.Unlock()
+	return append([]*RecordedRequest(nil), h.requests...)
+}
+
 type TestListener struct {
 	net.Listener
 	Address *net.TCPAddr
@@ -904,8 +1101,21 @@ type TestClusterCore struct {
 	ServerCertPEM     []byte
 	ServerKey         *ecdsa.PrivateKey
 	ServerKeyPEM      []byte
-	TLSConfig         *tls.Config
 	UnderlyingStorage physical.Backend
+
+	// TLSConfig is the configuration the core's API listeners are served
+	// with. It trusts the cluster CA, so it also works as the TLS config of
+	// a client connecting to the API.
+	TLSConfig *tls.Config
+
+	// ClusterAddrs are the addresses the core's cluster listeners bind to.
+	// Empty if clustering is disabled.
+	ClusterAddrs []*net.TCPAddr
+	// ClusterTLS is the core's cluster TLS configuration, captured when the
+	// cluster was built. It is only set for cores that were unsealed at that
+	// point and is not refreshed if the cluster cert later changes, e.g.
+	// after a new node becomes active. Callers must set NextProtos.
+	ClusterTLS *tls.Config
 }
 
 type TestClusterOptions struct {
@@ -919,8 +1129,20 @@ type TestClusterOptions struct {
 	TempDir            string
 	CACert             []byte
 	CAKey              *ecdsa.PrivateKey
+
+	// CoreOptions are applied to every core in the cluster
+	CoreOptions *TestCoreOptions
+
+	// PhysicalFactory, if set, creates the storage shared by all cores
+	// instead of the in-memory backends
+	PhysicalFactory TestClusterPhysicalFactory
 }
 
+// TestClusterPhysicalFactory creates the physical and HA backends shared by
+// every core in a test cluster. If the returned HA backend is nil, the
+// physical backend is used for HA when it supports it.
+type TestClusterPhysicalFactory func(logger log.Logger) (physical.Backend, physical.HABackend, error)
+
 var DefaultNumCores = 3
 
 type certInfo struct {
@@ -931,6 +1153,12 @@ type certInfo struct {
 	keyPEM    []byte
 }
 
+// testListenerURL returns the https URL for the given listener address with
+// portOffset added to its port, bracketing IPv6 hosts as needed.
+func testListenerURL(addr *net.TCPAddr, portOffset int) string {
+	return "https://" + net.JoinHostPort(addr.IP.String(), strconv.Itoa(addr.Port+portOffset))
+}
+
 // NewTestCluster creates a new test cluster based on the provided core config
 // and test cluster options.
 //
@@ -1105,8 +1333,11 @@ func NewTestCluster(t testing.T, base *CoreConfig, opts *TestClusterOptions) *Te
 	logger := logging.NewVaultLogger(log.Trace)
 	ports := make([]int, numCores)
 	if baseAddr != nil {
-		for i := 0; i < numCores; i++ {
-			ports[i] = baseAddr.Port + i
+		// A zero base port means each listener gets a random port
+		if baseAddr.Port != 0 {
+			for i := 0; i < numCores; i++ {
+				ports[i] = baseAddr.Port + i
+			}
 		}
 	} else {
 		baseAddr = &net.TCPAddr{
@@ -1180,8 +1411,8 @@ func NewTestCluster(t testing.T, base *CoreConfig, opts *TestClusterOptions) *Te
 		LogicalBackends:    make(map[string]logical.Factory),
 		CredentialBackends: make(map[string]logical.Factory),
 		AuditBackends:      make(map[string]audit.Factory),
-		RedirectAddr:       fmt.Sprintf("https://127.0.0.1:%d", listeners[0][0].Address.Port),
-		ClusterAddr:        fmt.Sprintf("https://127.0.0.1:%d", listeners[0][0].Address.Port+105),
+		RedirectAddr:       testListenerURL(listeners[0][0].Address, 0),
+		ClusterAddr:        testListenerURL(listeners[0][0].Address, 105),
 		DisableMlock:       true,
 		EnableUI:           true,
 		EnableRaw:          true,
@@ -1193,6 +1424,7 @@ func NewTestCluster(t testing.T, base *CoreConfig, opts *TestClusterOptions) *Te
 		coreConfig.EnableUI = base.EnableUI
 		coreConfig.DefaultLeaseTTL = base.DefaultLeaseTTL
 		coreConfig.MaxLeaseTTL = base.MaxLeaseTTL
+		coreConfig.ManualStepDownSleepPeriod = base.ManualStepDownSleepPeriod
 		coreConfig.CacheSize = base.CacheSize
 		coreConfig.PluginDirectory = base.PluginDirectory
 		coreConfig.Seal = base.Seal
@@ -1246,12 +1478,62 @@ func NewTestCluster(t testing.T, base *CoreConfig, opts *TestClusterOptions) *Te
 		}
 
 		coreConfig.ClusterCipherSuites = base.ClusterCipherSuites
+		coreConfig.ClusterRequireClientCert = base.ClusterRequireClientCert
+		coreConfig.ClusterInsecureSkipVerify = base.ClusterInsecureSkipVerify
+		coreConfig.ClusterRetryUntrustedPeer = base.ClusterRetryUntrustedPeer
+		coreConfig.ForwardSealedHealthRequests = base.ForwardSealedHealthRequests
+		coreConfig.ClusterTrustedPeerCerts = base.ClusterTrustedPeerCerts
+		coreConfig.Clock = base.Clock
+		coreConfig.ClusterCertMaxAge = base.ClusterCertMaxAge
+		coreConfig.ServiceRegistration = base.ServiceRegistration
+		coreConfig.ClusterListenAddrs = base.ClusterListenAddrs
+
+		coreConfig.MaxRequestSize = base.MaxRequestSize
+		coreConfig.RequestTimeout = base.RequestTimeout
+		coreConfig.RequestLimiter = base.RequestLimiter
+		coreConfig.ClusterCompression = base.ClusterCompression
+		coreConfig.ClusterProxyProtocol = base.ClusterProxyProtocol
+		coreConfig.ClusterProxyAuthorizedAddrs = base.ClusterProxyAuthorizedAddrs
+		coreConfig.NeverForwardPaths = base.NeverForwardPaths
+		coreConfig.ForwardedRequestRewrite = base.ForwardedRequestRewrite
+		coreConfig.ForwardedRequestCaptureSize = base.ForwardedRequestCaptureSize
+		coreConfig.ForwardedRequestCaptureBodies = base.ForwardedRequestCaptureBodies
+		coreConfig.LeaderLookupCacheTTL = base.LeaderLookupCacheTTL
+		coreConfig.HALockRetryInterval = base.HALockRetryInterval
+		coreConfig.HALockTTL = base.HALockTTL
+		coreConfig.MemberHeartbeatTTL = base.MemberHeartbeatTTL
+		coreConfig.MemberScanInterval = base.MemberScanInterval
+		coreConfig.ClusterListenerBindTimeout = base.ClusterListenerBindTimeout
+		coreConfig.MaxClusterListeners = base.MaxClusterListeners
+		coreConfig.AuditRequestBodyLimit = base.AuditRequestBodyLimit
+		coreConfig.ActiveWriteGracePeriod = base.ActiveWriteGracePeriod
+		coreConfig.ForwardingReconnectBaseDelay = base.ForwardingReconnectBaseDelay
+		coreConfig.ForwardingReconnectMaxDelay = base.ForwardingReconnectMaxDelay
+		coreConfig.OnMemberEvicted = base.OnMemberEvicted
 
 		coreConfig.DisableCache = base.DisableCache
 
 		coreConfig.DevToken = base.DevToken
 	}
 
+	if opts != nil && opts.CoreOptions != nil {
+		opts.CoreOptions.Passthrough.apply(coreConfig.LogicalBackends)
+	}
+
+	if opts != nil && opts.PhysicalFactory != nil {
+		phys, haPhys, err := opts.PhysicalFactory(logger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if haPhys == nil {
+			if ha, ok := phys.(physical.HABackend); ok && ha.HAEnabled() {
+				haPhys = ha
+			}
+		}
+		coreConfig.Physical = phys
+		coreConfig.HAPhysical = haPhys
+	}
+
 	if coreConfig.Physical == nil {
 		coreConfig.Physical, err = physInmem.NewInmem(nil, logger)
 		if err != nil {
@@ -1275,10 +1557,12 @@ func NewTestCluster(t testing.T, base *CoreConfig, opts *TestClusterOptions) *Te
 	coreConfigs := []*CoreConfig{}
 	for i := 0; i < numCores; i++ {
 		localConfig := *coreConfig
-		localConfig.RedirectAddr = fmt.Sprintf("https://127.0.0.1:%d", listeners[i][0].Address.Port)
+		localConfig.RedirectAddr = testListenerURL(listeners[i][0].Address, 0)
 		if localConfig.ClusterAddr != "" {
-			localConfig.ClusterAddr = fmt.Sprintf("https://127.0.0.1:%d", listeners[i][0].Address.Port+105)
+			localConfig.ClusterAddr = testListenerURL(listeners[i][0].Address, 105)
 		}
+		// All cores share a hostname, so give each its own node ID
+		localConfig.NodeID = fmt.Sprintf("core-%d", i)
 
 		// if opts.SealFunc is provided, use that to generate a seal for the config instead
 		if opts != nil && opts.SealFunc != nil {
@@ -1432,7 +1716,7 @@ func NewTestCluster(t testing.T, base *CoreConfig, opts *TestClusterOptions) *Te
 		testCluster.ID = cluster.ID
 	}
 
-	getAPIClient := func(port int, tlsConfig *tls.Config) *api.Client {
+	getAPIClient := func(addr *net.TCPAddr, tlsConfig *tls.Config) *api.Client {
 		transport := cleanhttp.DefaultPooledTransport()
 		transport.TLSClientConfig = tlsConfig.Clone()
 		if err := http2.ConfigureTransport(transport); err != nil {
@@ -1449,7 +1733,7 @@ func NewTestCluster(t testing.T, base *CoreConfig, opts *TestClusterOptions) *Te
 		if config.Error != nil {
 			t.Fatal(config.Error)
 		}
-		config.Address = fmt.Sprintf("https://127.0.0.1:%d", port)
+		config.Address = testListenerURL(addr, 0)
 		config.HttpClient = client
 		config.MaxRetries = 0
 		apiClient, err := api.NewClient(config)
@@ -1476,7 +1760,16 @@ func NewTestCluster(t testing.T, base *CoreConfig, opts *TestClusterOptions) *Te
 			Handler:         handlers[i],
 			Server:          servers[i],
 			TLSConfig:       tlsConfigs[i],
-			Client:          getAPIClient(listeners[i][0].Address.Port, tlsConfigs[i]),
+			Client:          getAPIClient(listeners[i][0].Address, tlsConfigs[i]),
+		}
+		if coreConfigs[i].ClusterAddr != "" {
+			tcc.ClusterAddrs = cores[i].clusterBindAddrs()
+		}
+		if !cores[i].Sealed() {
+			tcc.ClusterTLS, err = cores[i].ClusterTLSConfig(context.Background(), nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
 		}
 		tcc.ReloadFuncs = &cores[i].reloadFuncs
 		tcc.ReloadFuncsLock = &cores[i].reloadFuncsLock
@@ -1496,6 +1789,18 @@ func NewTestCluster(t testing.T, base *CoreConfig, opts *TestClusterOptions) *Te
 	return &testCluster
 }
 
+// NewTestClusterWithPhysical creates a new test cluster whose cores all share
+// the storage created by factory, so that leader election runs against that
+// backend's lock semantics rather than the in-memory approximation.
+func NewTestClusterWithPhysical(t testing.T, base *CoreConfig, opts *TestClusterOptions, factory TestClusterPhysicalFactory) *TestCluster {
+	var localOpts TestClusterOptions
+	if opts != nil {
+		localOpts = *opts
+	}
+	localOpts.PhysicalFactory = factory
+	return NewTestCluster(t, base, &localOpts)
+}
+
 func NewMockBuiltinRegistry() *mockBuiltinRegistry {
 	return &mockBuiltinRegistry{
 		forTesting: map[string]consts.PluginType{
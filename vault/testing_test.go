@@ -0,0 +1,63 @@
+package vault
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+)
+
+func TestGenerateRandString(t *testing.T) {
+	cases := []struct {
+		encoding string
+		decode   func(string) ([]byte, error)
+	}{
+		{"hex", hex.DecodeString},
+		{"base64", base64.RawURLEncoding.DecodeString},
+	}
+	for _, tc := range cases {
+		for _, length := range []int{0, 1, 16, 33} {
+			s, err := GenerateRandString(length, tc.encoding)
+			if err != nil {
+				t.Fatalf("%s, %d: err: %v", tc.encoding, length, err)
+			}
+			raw, err := tc.decode(s)
+			if err != nil {
+				t.Fatalf("%s, %d: failed to decode %q: %v", tc.encoding, length, s, err)
+			}
+			if len(raw) != length {
+				t.Fatalf("%s, %d: decoded %d bytes", tc.encoding, length, len(raw))
+			}
+		}
+
+		// Two draws of any real length differ
+		a, err := GenerateRandString(16, tc.encoding)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := GenerateRandString(16, tc.encoding)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a == b {
+			t.Fatalf("%s: got the same string twice: %q", tc.encoding, a)
+		}
+	}
+
+	// The base64 encoding is safe in URLs and unpadded
+	s, err := GenerateRandString(32, "base64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range s {
+		if r == '+' || r == '/' || r == '=' {
+			t.Fatalf("unexpected character %q in %q", r, s)
+		}
+	}
+
+	if _, err := GenerateRandString(16, "base32"); err == nil {
+		t.Fatal("expected an error for an unsupported encoding")
+	}
+	if _, err := GenerateRandString(-1, "hex"); err == nil {
+		t.Fatal("expected an error for a negative length")
+	}
+}
@@ -2737,9 +2737,9 @@ func (ts *TokenStore) handleLookup(ctx context.Context, req *logical.Request, da
 		}
 		if !leaseTimes.ExpireTime.IsZero() {
 			resp.Data["expire_time"] = leaseTimes.ExpireTime
-			resp.Data["ttl"] = leaseTimes.ttl()
+			resp.Data["ttl"] = leaseTimes.ttl(ts.core.clock.Now())
 		}
-		renewable, _ := leaseTimes.renewable()
+		renewable, _ := leaseTimes.renewable(ts.core.clock.Now())
 		resp.Data["renewable"] = renewable
 		resp.Data["issue_time"] = leaseTimes.IssueTime
 	}
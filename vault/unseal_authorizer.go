@@ -0,0 +1,75 @@
+package vault
+
+import (
+	"context"
+	"fmt"
+)
+
+// UnsealAttempt describes a submitted unseal key share to an
+// UnsealAuthorizerFunc. It never includes the share itself.
+type UnsealAttempt struct {
+	// Metadata is supplied by the caller of UnsealWithMetadata, e.g. the
+	// address the share was submitted from. It is empty for Unseal.
+	Metadata map[string]string
+
+	// RecoveryKey is set when the share is a recovery key share
+	RecoveryKey bool
+
+	// Progress is the number of shares already counted towards the threshold
+	Progress int
+}
+
+// UnsealAuthorizerFunc decides whether a submitted unseal key share may be
+// counted, e.g. based on where it came from or the time of day. Returning an
+// error rejects the attempt. It is called with the state lock held, so it
+// must not call back into the core.
+type UnsealAuthorizerFunc func(ctx context.Context, attempt *UnsealAttempt) error
+
+// ErrUnsealRejected is returned by Unseal when CoreConfig.UnsealAuthorizer
+// rejects the attempt. The share is not counted and does not count as a
+// failed attempt either.
+type ErrUnsealRejected struct {
+	Err error
+}
+
+func (e *ErrUnsealRejected) Error() string {
+	return fmt.Sprintf("unseal attempt rejected: %v", e.Err)
+}
+
+// UnsealWithMetadata is like Unseal, but passes the given metadata about the
+// attempt on to CoreConfig.UnsealAuthorizer.
+func (c *Core) UnsealWithMetadata(key []byte, metadata map[string]string) (bool, error) {
+	return c.unseal(key, false, metadata)
+}
+
+// UnsealWithRecoveryKeysAndMetadata is like UnsealWithRecoveryKeys, but
+// passes the given metadata about the attempt on to
+// CoreConfig.UnsealAuthorizer.
+func (c *Core) UnsealWithRecoveryKeysAndMetadata(key []byte, metadata map[string]string) (bool, error) {
+	return c.unseal(key, true, metadata)
+}
+
+// authorizeUnseal runs the unseal authorizer, if any, on a submitted share.
+// The caller must hold the state lock.
+func (c *Core) authorizeUnseal(ctx context.Context, useRecoveryKeys bool, metadata map[string]string) error {
+	if c.unsealAuthorizer == nil {
+		return nil
+	}
+
+	attempt := &UnsealAttempt{
+		Metadata:    make(map[string]string, len(metadata)),
+		RecoveryKey: useRecoveryKeys,
+	}
+	for k, v := range metadata {
+		attempt.Metadata[k] = v
+	}
+	if c.unlockInfo != nil {
+		attempt.Progress = len(c.unlockInfo.Parts)
+	}
+
+	if err := c.unsealAuthorizer(ctx, attempt); err != nil {
+		c.logger.Warn("unseal attempt rejected", "error", err)
+		return &ErrUnsealRejected{Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package vault
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	// defaultUnsealFailureWindow is the window in which failed unseal
+	// attempts are counted, if a threshold is set but no window is
+	defaultUnsealFailureWindow = time.Minute
+
+	// defaultUnsealLockoutPeriod is how long unsealing is blocked once the
+	// failure threshold is crossed, if a threshold is set but no period is
+	defaultUnsealLockoutPeriod = 5 * time.Minute
+)
+
+var (
+	// ErrUnsealLockedOut is returned by Unseal while unsealing is locked out
+	// after too many failed attempts
+	ErrUnsealLockedOut = errors.New("unseal is locked out after too many failed attempts")
+)
+
+// unsealLockout counts failed unseal attempts and blocks further attempts
+// once too many happen within a window. It is not safe for concurrent use;
+// the core's state lock serializes access.
+type unsealLockout struct {
+	threshold   int
+	window      time.Duration
+	period      time.Duration
+	failures    []time.Time
+	lockedUntil time.Time
+}
+
+// newUnsealLockout returns the lockout tracker for the given settings, or nil
+// if the threshold is zero and lockout is disabled.
+func newUnsealLockout(threshold int, window, period time.Duration) *unsealLockout {
+	if threshold <= 0 {
+		return nil
+	}
+	if window == 0 {
+		window = defaultUnsealFailureWindow
+	}
+	if period == 0 {
+		period = defaultUnsealLockoutPeriod
+	}
+	return &unsealLockout{
+		threshold: threshold,
+		window:    window,
+		period:    period,
+	}
+}
+
+// check returns ErrUnsealLockedOut if unsealing is currently locked out.
+func (l *unsealLockout) check(now time.Time) error {
+	if l == nil {
+		return nil
+	}
+	if now.Before(l.lockedUntil) {
+		return ErrUnsealLockedOut
+	}
+	return nil
+}
+
+// fail records a failed attempt at now and reports whether it triggered a
+// lockout.
+func (l *unsealLockout) fail(now time.Time) bool {
+	if l == nil {
+		return false
+	}
+
+	// Drop failures that have fallen out of the window
+	cutoff := now.Add(-l.window)
+	recent := l.failures[:0]
+	for _, t := range l.failures {
+		if t.After(cutoff) {
+			recent = append(recent, t)
+		}
+	}
+	l.failures = append(recent, now)
+
+	if len(l.failures) < l.threshold {
+		return false
+	}
+
+	l.failures = nil
+	l.lockedUntil = now.Add(l.period)
+	return true
+}
+
+// reset clears the recorded failures, e.g. after a successful unseal.
+func (l *unsealLockout) reset() {
+	if l == nil {
+		return
+	}
+	l.failures = nil
+	l.lockedUntil = time.Time{}
+}
+
+// recordUnsealFailure notes a failed unseal attempt and, if that crosses the
+// configured threshold, locks out unsealing and discards any key shares
+// provided so far. This must be called with the state write lock held.
+func (c *Core) recordUnsealFailure() {
+	if !c.unsealLockout.fail(c.clock.Now()) {
+		return
+	}
+
+	if c.unlockInfo != nil {
+		for i := range c.unlockInfo.Parts {
+			memzero(c.unlockInfo.Parts[i])
+		}
+		c.unlockInfo = nil
+	}
+
+	c.logger.Error("tamper alert: too many failed unseal attempts, locking out unseal", "threshold", c.unsealLockout.threshold, "window", c.unsealLockout.window, "lockout_period", c.unsealLockout.period)
+}